@@ -6,8 +6,16 @@ import (
 	"net/http"
 	"os"
 
+	"medseek/internal/auth"
+	"medseek/internal/contextbuilder"
 	"medseek/internal/handlers"
+	"medseek/internal/llm"
+	"medseek/internal/pricing"
+	"medseek/internal/prompts"
+	"medseek/internal/redflag"
 	"medseek/internal/service"
+	"medseek/internal/specialty"
+	"medseek/internal/store"
 	"medseek/internal/websocket"
 
 	"github.com/joho/godotenv"
@@ -48,32 +56,115 @@ func main() {
 	// Load environment variables
 	godotenv.Load()
 
-	deepseekAPIKey := os.Getenv("DEEPSEEK_API_KEY")
-	if deepseekAPIKey == "" {
-		log.Fatal("DEEPSEEK_API_KEY environment variable is required")
+	// Select the LLM provider from LLM_PROVIDER (deepseek, openai, scripted)
+	provider, err := llm.NewProvider(llm.ConfigFromEnv())
+	if err != nil {
+		log.Fatalf("Failed to configure LLM provider: %v", err)
 	}
+	modelInfo := provider.ModelInfo()
+	log.Printf("Using LLM provider %s (model %s)", modelInfo.Provider, modelInfo.Model)
 
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
 
+	// Open the session store (STORE_DRIVER=sqlite|memory); migrations run on open
+	st, err := store.OpenFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to open store: %v", err)
+	}
+	defer st.Close()
+
+	// Red-flag rules screen every turn for emergencies (REDFLAG_RULES_FILE
+	// replaces the built-in rules)
+	redflags, err := redflag.NewFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to load red-flag rules: %v", err)
+	}
+	log.Printf("Loaded %d red-flag rules", redflags.Len())
+
+	// Specialties patients can consult (SPECIALTIES_FILE overrides the
+	// built-in ones)
+	specialties, err := specialty.NewFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to load specialties: %v", err)
+	}
+
+	// Specialty prompts come from the templates in PROMPTS_DIR, reloaded when
+	// they change or on SIGHUP; specialties without one use the built-in prompt
+	library, err := prompts.NewFromEnv(specialties.IDs(), specialties.Prompt)
+	if err != nil {
+		log.Fatalf("Failed to load prompt templates: %v", err)
+	}
+	library.Watch()
+
+	// Token usage is priced with the table in PRICES_FILE, or the DeepSeek
+	// list prices
+	prices, err := pricing.NewFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to load prices: %v", err)
+	}
+
 	// Initialize services
-	chatService := service.NewChatService(deepseekAPIKey)
-	wsHub := websocket.NewHub(chatService)
+	chatService := service.NewChatService(provider, st, contextbuilder.NewFromEnv(), service.SummaryConfigFromEnv(), redflags, service.TriageConfigFromEnv(), service.RoutingConfigFromEnv(), library, specialties, prices)
+	wsHub := websocket.NewHub(chatService, websocket.ConfigFromEnv())
 
 	// Start WebSocket hub
 	go wsHub.Run()
 
+	// Patient accounts and access tokens (AUTH_SECRET, AUTH_TOKEN_TTL); SMS
+	// login codes go through SMS_PROVIDER (default: written to SMS_LOG_FILE)
+	smsSender, err := auth.NewSMSSenderFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure SMS sender: %v", err)
+	}
+	authService := auth.NewService(st, auth.ConfigFromEnv(), smsSender)
+
 	// Initialize handlers
-	handler := handlers.NewHandler(chatService, wsHub)
+	handler := handlers.NewHandler(chatService, wsHub, authService)
+	requireAuth := authService.Middleware
 
 	// Setup routes
 	http.HandleFunc("/health", handler.Health)
-	http.HandleFunc("/api/session/create", handler.CreateSession)
-	http.HandleFunc("/api/session/messages", handler.GetSessionMessages)
-	http.HandleFunc("/api/session/close", handler.CloseSession)
-	http.HandleFunc("/ws", handler.WebSocket)
+	http.HandleFunc("/api/auth/register", handler.Register)
+	http.HandleFunc("/api/auth/login", handler.Login)
+	http.HandleFunc("/api/auth/otp/request", handler.RequestOTP)
+	http.HandleFunc("/api/auth/otp/verify", handler.VerifyOTP)
+	http.HandleFunc("/api/auth/me", requireAuth(handler.Me))
+
+	// Session routes require a token and check that the caller owns the session
+	// or is the doctor assigned to it
+	http.HandleFunc("/api/specialties", handler.Specialties)
+	http.HandleFunc("/api/specialties/recommend", requireAuth(handler.RecommendSpecialties))
+	http.HandleFunc("/api/session/create", requireAuth(handler.CreateSession))
+	http.HandleFunc("/api/session", requireAuth(handler.GetSession))
+	http.HandleFunc("/api/session/messages", requireAuth(handler.GetSessionMessages))
+	http.HandleFunc("/api/session/close", requireAuth(handler.CloseSession))
+	http.HandleFunc("/api/session/rate", requireAuth(handler.RateSession))
+	http.HandleFunc("/api/session/summary", requireAuth(handler.GetSessionSummary))
+	http.HandleFunc("/api/session/message", requireAuth(handler.SendMessage))
+	http.HandleFunc("/api/session/events", requireAuth(handler.SessionEvents))
+	http.HandleFunc("/ws", requireAuth(handler.WebSocket))
+
+	// Doctor routes; STAFF_ROLES lists the accounts that may use them
+	requireDoctor := authService.RequireRole(auth.RoleDoctor)
+	http.HandleFunc("/api/doctor/profile", requireDoctor(handler.DoctorProfile))
+	http.HandleFunc("/api/doctor/queue", requireDoctor(handler.DoctorQueue))
+	http.HandleFunc("/api/doctor/session", requireDoctor(handler.DoctorSession))
+	http.HandleFunc("/api/doctor/session/claim", requireDoctor(handler.ClaimSession))
+	http.HandleFunc("/api/doctor/session/release", requireDoctor(handler.ReleaseSession))
+	http.HandleFunc("/ws/doctor", requireDoctor(handler.DoctorQueueSocket))
+
+	// Doctor directory: patients see active doctors, admins manage them
+	http.HandleFunc("/api/doctors", requireAuth(handler.Doctors))
+	requireAdmin := authService.RequireRole(auth.RoleAdmin)
+	http.HandleFunc("/api/admin/doctors", requireAdmin(handler.AdminDoctors))
+
+	// Analytics (admins only)
+	http.HandleFunc("/api/admin/triage", requireAdmin(handler.TriageStats))
+	http.HandleFunc("/api/admin/experiments", requireAdmin(handler.ExperimentStats))
+	http.HandleFunc("/api/admin/usage", requireAdmin(handler.UsageStats))
 
 	// Serve static files from frontend
 	// Try multiple possible locations
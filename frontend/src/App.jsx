@@ -3,10 +3,11 @@ import React, { useState, useEffect, useRef } from 'react'
 import ChatWindow from './components/ChatWindow'
 import SessionSetup from './components/SessionSetup'
 import { initializeIOSFixes } from './utils/iosHelper'
+import { getCurrentUser } from './utils/api'
 
 function App() {
   const [sessionId, setSessionId] = useState(null)
-  const [userId, setUserId] = useState(null)
+  const [user, setUser] = useState(null)
   const [specialty, setSpecialty] = useState(null)
   const [connected, setConnected] = useState(false)
 
@@ -15,16 +16,20 @@ function App() {
     initializeIOSFixes()
   }, [])
 
-  const handleSessionCreated = (newSessionId, newUserId, newSpecialty) => {
+  // 恢复上次登录的账号
+  useEffect(() => {
+    getCurrentUser().then(setUser)
+  }, [])
+
+  const handleSessionCreated = (newSessionId, newUser, newSpecialty) => {
     setSessionId(newSessionId)
-    setUserId(newUserId)
+    setUser(newUser)
     setSpecialty(newSpecialty)
     setConnected(true)
   }
 
   const handleSessionClosed = () => {
     setSessionId(null)
-    setUserId(null)
     setSpecialty(null)
     setConnected(false)
   }
@@ -32,12 +37,19 @@ function App() {
   return (
     <div className="app">
       {!connected ? (
-        <SessionSetup onSessionCreated={handleSessionCreated} />
+        <SessionSetup
+          user={user}
+          onLogin={setUser}
+          onLogout={() => setUser(null)}
+          onSessionCreated={handleSessionCreated}
+        />
       ) : (
         <ChatWindow
           sessionId={sessionId}
-          userId={userId}
+          userId={user.id}
+          userName={user.name || user.phone || user.email}
           specialty={specialty}
+          onSpecialtyChanged={setSpecialty}
           onSessionClosed={handleSessionClosed}
         />
       )}
@@ -1,15 +1,28 @@
 import React, { useState, useEffect, useRef } from 'react'
-import { connectWebSocket, closeSession } from '../utils/api'
+import { connectWebSocket, connectEventStream, pollEvents, postClientFrame, closeSession, rateSession, getDoctors, getDoctor, newClientMessageId, reconnectDelay, PROTOCOL_VERSIONS } from '../utils/api'
 import { getSpecialtyInfo } from '../utils/specialties'
 import { scrollToBottom, onIOSKeyboardToggle, isIOSSafari } from '../utils/iosHelper'
+import DoctorCard from './DoctorCard'
 import './ChatWindow.css'
 
-export default function ChatWindow({ sessionId, userId, specialty, onSessionClosed }) {
+const MAX_RECONNECT_ATTEMPTS = 10
+// WebSocket 连续这么多次未能建立时改用 SSE / 长轮询
+const FALLBACK_AFTER_FAILURES = 2
+
+export default function ChatWindow({ sessionId, userId, userName, specialty, onSpecialtyChanged, onSessionClosed }) {
   const [messages, setMessages] = useState([])
   const [inputValue, setInputValue] = useState('')
   const [loading, setLoading] = useState(false)
+  const [generating, setGenerating] = useState(false)
   const [connected, setConnected] = useState(false)
+  const [mode, setMode] = useState('ai') // 'ai' | 'waiting' | 'doctor'
+  const [doctors, setDoctors] = useState([]) // 本科室在岗医生
+  const [doctor, setDoctor] = useState(null) // 接诊的人工医生
+  const [rating, setRating] = useState(null) // 结束前的评分面板：null 为未显示
+  const [ratingComment, setRatingComment] = useState('')
   const ws = useRef(null)
+  const transportRef = useRef('ws') // 'ws' | 'sse' | 'poll'
+  const lastSeqRef = useRef(0)
   const messagesEndRef = useRef(null)
   const inputRef = useRef(null)
   const formRef = useRef(null)
@@ -32,41 +45,241 @@ export default function ChatWindow({ sessionId, userId, specialty, onSessionClos
   }, [])
 
   useEffect(() => {
-    // Connect to WebSocket
-    ws.current = connectWebSocket(sessionId, userId)
+    getDoctors(specialty)
+      .then(setDoctors)
+      .catch((err) => console.error('Failed to load doctors:', err))
+  }, [specialty])
+
+  useEffect(() => {
+    let reconnectTimer = null
+    let reconnectAttempts = 0
+    let failedUpgrades = 0
+    let opened = false
+    let disposed = false
+    let eventSource = null
+    let pollId = null
 
-    ws.current.onopen = () => {
-      setConnected(true)
-      console.log('WebSocket connected')
+    const connectSSE = () => {
+      // EventSource 断线后会自动重连，并通过 Last-Event-ID 补发错过的消息
+      eventSource = connectEventStream(sessionId, lastSeqRef.current)
+      eventSource.onopen = () => setConnected(true)
+      eventSource.onmessage = (event) => {
+        try {
+          handleFrame(JSON.parse(event.data))
+        } catch (err) {
+          console.error('Failed to parse message:', err)
+        }
+      }
+      eventSource.onerror = () => setConnected(false)
     }
 
-    ws.current.onmessage = (event) => {
-      try {
-        const message = JSON.parse(event.data)
-        setMessages((prev) => [...prev, message])
-        setLoading(false)
-      } catch (err) {
-        console.error('Failed to parse message:', err)
+    const poll = async () => {
+      while (!disposed) {
+        try {
+          const result = await pollEvents(sessionId, lastSeqRef.current, pollId)
+          pollId = result.poll_id
+          setConnected(true)
+          result.frames.forEach(handleFrame)
+        } catch (err) {
+          console.error('Long-poll failed:', err)
+          setConnected(false)
+          await new Promise((resolve) => setTimeout(resolve, 2000))
+        }
       }
     }
 
-    ws.current.onerror = (error) => {
-      console.error('WebSocket error:', error)
-      setConnected(false)
+    // WebSocket 升级被代理拦截时改用 HTTP 传输
+    const fallBackToHttp = () => {
+      transportRef.current = window.EventSource ? 'sse' : 'poll'
+      console.log(`WebSocket unavailable, falling back to ${transportRef.current}`)
+      if (transportRef.current === 'sse') {
+        connectSSE()
+      } else {
+        poll()
+      }
+    }
+
+    const connect = () => {
+      // Connect to WebSocket, resuming after the last message we have seen
+      const socket = connectWebSocket(sessionId, lastSeqRef.current)
+      ws.current = socket
+
+      socket.onopen = () => {
+        opened = true
+        reconnectAttempts = 0
+        setConnected(true)
+        // 协议协商：告知服务器客户端支持的协议版本
+        socket.send(JSON.stringify({ type: 'hello', versions: PROTOCOL_VERSIONS }))
+        console.log('WebSocket connected')
+      }
+
+      socket.onmessage = (event) => {
+        try {
+          handleFrame(JSON.parse(event.data))
+        } catch (err) {
+          console.error('Failed to parse message:', err)
+        }
+      }
+
+      socket.onerror = (error) => {
+        console.error('WebSocket error:', error)
+        setConnected(false)
+      }
+
+      socket.onclose = (event) => {
+        setConnected(false)
+        console.log('WebSocket disconnected')
+        if (!opened && !disposed && ++failedUpgrades >= FALLBACK_AFTER_FAILURES) {
+          fallBackToHttp()
+          return
+        }
+        // 非正常断开（如iOS Safari切到后台）时自动重连并补发错过的消息
+        if (!disposed && !event.wasClean && reconnectAttempts < MAX_RECONNECT_ATTEMPTS) {
+          const delay = reconnectDelay(reconnectAttempts)
+          reconnectAttempts++
+          console.log(`WebSocket reconnecting in ${delay}ms...`)
+          reconnectTimer = setTimeout(connect, delay)
+        }
+      }
     }
 
-    ws.current.onclose = () => {
-      setConnected(false)
-      console.log('WebSocket disconnected')
+    // 页面回到前台时若连接已断开，立即重连
+    const handleVisibility = () => {
+      if (document.visibilityState !== 'visible' || disposed || transportRef.current !== 'ws') {
+        return
+      }
+      const state = ws.current ? ws.current.readyState : WebSocket.CLOSED
+      if (state === WebSocket.CLOSED || state === WebSocket.CLOSING) {
+        clearTimeout(reconnectTimer)
+        reconnectAttempts = 0
+        connect()
+      }
     }
 
+    connect()
+    document.addEventListener('visibilitychange', handleVisibility)
+
     return () => {
+      disposed = true
+      clearTimeout(reconnectTimer)
+      document.removeEventListener('visibilitychange', handleVisibility)
       if (ws.current && ws.current.readyState === WebSocket.OPEN) {
         ws.current.close()
       }
+      if (eventSource) {
+        eventSource.close()
+      }
     }
   }, [sessionId, userId])
 
+  // 发送客户端帧：WebSocket 直接发送，SSE/长轮询通过 HTTP 提交
+  const sendClientFrame = (frame) => {
+    if (transportRef.current === 'ws') {
+      if (ws.current && ws.current.readyState === WebSocket.OPEN) {
+        ws.current.send(JSON.stringify(frame))
+      }
+      return
+    }
+    postClientFrame(sessionId, frame)
+      .then((reply) => {
+        if (reply && reply.type === 'error') {
+          handleFrame(reply)
+        }
+      })
+      .catch((err) => {
+        console.error('Failed to send message:', err)
+        setLoading(false)
+        setGenerating(false)
+      })
+  }
+
+  // 记录已收到的最大消息序号；重连时据此跳过重复消息
+  const seenSeq = (message) => {
+    if (!message.seq) {
+      return false
+    }
+    if (message.seq <= lastSeqRef.current) {
+      return true
+    }
+    lastSeqRef.current = message.seq
+    return false
+  }
+
+  const handleFrame = (message) => {
+    if (message.type === 'system' || message.type === 'ack') {
+      return
+    }
+    if (message.type === 'handoff') {
+      // 转接人工医生后 AI 暂停回复，正在生成的回复会被中止
+      setMode(message.mode)
+      if (message.mode !== 'ai') {
+        setLoading(false)
+        setGenerating(false)
+      }
+      if (message.mode === 'doctor' && message.doctor_id) {
+        getDoctor(message.doctor_id)
+          .then(setDoctor)
+          .catch((err) => console.error('Failed to load doctor:', err))
+      } else {
+        setDoctor(null)
+      }
+      setMessages((prev) => [...prev, message])
+      return
+    }
+    if (message.type === 'transfer') {
+      // 转科后保留对话记录，后续由新科室的医生助手回复
+      onSpecialtyChanged(message.specialty)
+      setMessages((prev) => [...prev.filter((m) => m.type !== 'route'), message])
+      return
+    }
+    if (message.type === 'typing') {
+      if (message.user_id === 'assistant') {
+        setLoading(true)
+      }
+      return
+    }
+    if (message.type === 'delta') {
+      // 流式输出：将增量内容追加到正在生成的医生回复上
+      setMessages((prev) => {
+        const last = prev[prev.length - 1]
+        if (last && last.streaming) {
+          return [...prev.slice(0, -1), { ...last, content: last.content + message.content }]
+        }
+        return [...prev, { ...message, type: 'message', streaming: true }]
+      })
+      setLoading(false)
+      return
+    }
+    // 补发的医生回复与 done 帧处理方式相同（回复可能在断线期间完成）
+    if (message.type === 'done' || (message.type === 'message' && message.user_id === 'assistant')) {
+      const duplicate = seenSeq(message)
+      // 生成结束：用完整回复替换流式内容
+      setMessages((prev) => {
+        const last = prev[prev.length - 1]
+        const final = { ...message, type: 'message' }
+        if (last && last.streaming) {
+          return duplicate ? prev.slice(0, -1) : [...prev.slice(0, -1), final]
+        }
+        // 停止生成且没有任何内容时不显示空消息
+        if (!final.content || duplicate) {
+          return prev
+        }
+        return [...prev, final]
+      })
+      setLoading(false)
+      setGenerating(false)
+      return
+    }
+    if (message.type === 'message' && seenSeq(message)) {
+      return
+    }
+    setMessages((prev) => [...prev, message])
+    if (message.type === 'error') {
+      setLoading(false)
+      setGenerating(false)
+    }
+  }
+
   // Auto-scroll to bottom
   useEffect(() => {
     const container = document.querySelector('.messages-container')
@@ -82,14 +295,19 @@ export default function ChatWindow({ sessionId, userId, specialty, onSessionClos
 
     const message = {
       type: 'message',
+      client_msg_id: newClientMessageId(),
       content: inputValue,
     }
 
-    // Send to WebSocket (server will echo it back)
-    ws.current.send(JSON.stringify(message))
+    // Send to the server (it will echo the stored message back)
+    sendClientFrame(message)
 
     setInputValue('')
-    setLoading(true)
+    // 人工医生接诊期间没有 AI 回复
+    if (mode === 'ai') {
+      setLoading(true)
+      setGenerating(true)
+    }
 
     // iOS修复：保持焦点在输入框上
     if (inputRef.current && isIOSSafari()) {
@@ -100,6 +318,27 @@ export default function ChatWindow({ sessionId, userId, specialty, onSessionClos
     }
   }
 
+  const handleStopGenerating = () => {
+    sendClientFrame({ type: 'stop' })
+  }
+
+  const handleRequestDoctor = () => {
+    sendClientFrame({ type: 'handoff', client_msg_id: newClientMessageId() })
+  }
+
+  const handleTransfer = (target) => {
+    sendClientFrame({ type: 'transfer', specialty: target, client_msg_id: newClientMessageId() })
+  }
+
+  const handleSubmitRating = async () => {
+    try {
+      await rateSession(sessionId, rating, ratingComment)
+    } catch (err) {
+      console.error('Failed to rate session:', err)
+    }
+    handleCloseSession()
+  }
+
   const handleCloseSession = async () => {
     try {
       await closeSession(sessionId)
@@ -117,18 +356,56 @@ export default function ChatWindow({ sessionId, userId, specialty, onSessionClos
       <div className="chat-header">
         <div className="header-content">
           <h1>{info.emoji} {info.title}</h1>
-          <p className="user-info">当前咨询：{userId}</p>
+          <p className="user-info">当前咨询：{userName}</p>
         </div>
         <div className="header-status">
           <span className={`status-indicator ${connected ? 'connected' : 'disconnected'}`}>
             {connected ? '● 在线' : '● 已断开'}
           </span>
-          <button onClick={handleCloseSession} className="close-button">
+          {mode === 'ai' && (
+            <button onClick={handleRequestDoctor} disabled={!connected} className="close-button handoff-button">
+              转人工医生
+            </button>
+          )}
+          {mode === 'waiting' && <span className="handoff-status">等待医生接诊...</span>}
+          {mode === 'doctor' && <span className="handoff-status">👨‍⚕️ 人工医生接诊中</span>}
+          <button onClick={() => setRating(0)} disabled={rating !== null} className="close-button">
             结束咨询
           </button>
         </div>
       </div>
 
+      <DoctorCard doctor={doctor} doctors={doctors} info={info} />
+
+      {rating !== null && (
+        <div className="rating-panel">
+          <p>请为本次咨询评分</p>
+          <div className="rating-stars">
+            {[1, 2, 3, 4, 5].map((n) => (
+              <button key={n} type="button" onClick={() => setRating(n)} className={`rating-star ${n <= rating ? 'active' : ''}`}>
+                ★
+              </button>
+            ))}
+          </div>
+          <input
+            type="text"
+            value={ratingComment}
+            onChange={(e) => setRatingComment(e.target.value)}
+            maxLength={500}
+            placeholder="其他意见（选填）"
+            className="rating-comment"
+          />
+          <div className="rating-actions">
+            <button type="button" onClick={handleSubmitRating} disabled={rating === 0} className="route-button">
+              提交并结束
+            </button>
+            <button type="button" onClick={handleCloseSession} className="rating-skip">
+              跳过
+            </button>
+          </div>
+        </div>
+      )}
+
       <div className="messages-container">
         {messages.length === 0 && (
           <div className="welcome-message">
@@ -145,15 +422,38 @@ export default function ChatWindow({ sessionId, userId, specialty, onSessionClos
           </div>
         )}
 
-        {messages.map((msg, idx) => (
+        {messages.map((msg, idx) => msg.type === 'emergency' ? (
+          <div key={idx} className="emergency-alert">
+            <div className="emergency-title">⚠️ 紧急提醒</div>
+            {msg.content.split('\n').map((line, i) => (
+              <p key={i}>{line}</p>
+            ))}
+            <a href="tel:120" className="emergency-call">📞 拨打120</a>
+          </div>
+        ) : msg.type === 'route' ? (
+          <div key={idx} className="route-suggestion">
+            <p>{msg.content}</p>
+            <button onClick={() => handleTransfer(msg.specialty)} disabled={!connected} className="route-button">
+              转至{getSpecialtyInfo(msg.specialty).name}
+            </button>
+          </div>
+        ) : msg.type === 'status' || msg.type === 'error' || msg.type === 'handoff' || msg.type === 'transfer' ? (
+          <div key={idx} className={`system-message ${msg.type === 'error' ? 'system-error' : ''}`}>
+            {msg.content}
+          </div>
+        ) : (
           <div
             key={idx}
-            className={`message ${msg.user_id === 'assistant' ? 'assistant-message' : 'user-message'}`}
+            className={`message ${msg.role === 'doctor' ? 'assistant-message doctor-message' : msg.user_id === 'assistant' ? 'assistant-message' : 'user-message'}`}
           >
             <div className="message-content">
-              {msg.user_id === 'assistant' && <span className="message-role">{info.emoji} {info.doctorRole}</span>}
-              {msg.user_id !== 'assistant' && <span className="message-role">👤 患者</span>}
+              {msg.role === 'doctor' && (
+                <span className="message-role">👨‍⚕️ {doctor && doctor.id === msg.user_id ? doctor.name : '人工医生'}</span>
+              )}
+              {msg.role !== 'doctor' && msg.user_id === 'assistant' && <span className="message-role">{info.emoji} {info.doctor_role}</span>}
+              {msg.role !== 'doctor' && msg.user_id !== 'assistant' && <span className="message-role">👤 患者</span>}
               <p>{msg.content}</p>
+              {msg.truncated && <span className="truncated-note">（已停止生成）</span>}
             </div>
           </div>
         ))}
@@ -191,12 +491,18 @@ export default function ChatWindow({ sessionId, userId, specialty, onSessionClos
             }
           }}
           placeholder="请描述您的症状或咨询的问题..."
-          disabled={!connected || loading}
+          disabled={!connected || loading || generating}
           className="message-input"
         />
-        <button type="submit" disabled={!connected || loading} className="send-button">
-          {loading ? '发送中...' : '发送'}
-        </button>
+        {generating ? (
+          <button type="button" onClick={handleStopGenerating} disabled={!connected} className="send-button stop-button">
+            停止
+          </button>
+        ) : (
+          <button type="submit" disabled={!connected || loading} className="send-button">
+            {loading ? '发送中...' : '发送'}
+          </button>
+        )}
       </form>
     </div>
   )
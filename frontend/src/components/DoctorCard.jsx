@@ -0,0 +1,44 @@
+import React from 'react'
+import './DoctorCard.css'
+
+// 会话页面的医生卡片：人工医生接诊时显示接诊医生，否则显示本科室的在岗医生
+export default function DoctorCard({ doctor, doctors, info }) {
+  const onDuty = doctors.filter((d) => d.available)
+  const shown = doctor || onDuty[0] || doctors[0]
+
+  if (!shown) {
+    return (
+      <div className="doctor-card">
+        <div className="doctor-avatar">{info.emoji}</div>
+        <div className="doctor-details">
+          <div className="doctor-name">AI医生助手</div>
+          <div className="doctor-meta">{info.name} · 暂无人工医生在岗</div>
+        </div>
+      </div>
+    )
+  }
+
+  return (
+    <div className={`doctor-card ${doctor ? 'doctor-card-assigned' : ''}`}>
+      <div className="doctor-avatar">👨‍⚕️</div>
+      <div className="doctor-details">
+        <div className="doctor-name">
+          {shown.name}
+          <span className="doctor-badge">{doctor ? '接诊医生' : shown.available ? '在线' : '离线'}</span>
+        </div>
+        <div className="doctor-meta">
+          {info.name} · 执业证号 {shown.license_no}
+          {!doctor && doctors.length > 1 && ` · 本科室${doctors.length}位医生，${onDuty.length}位在线`}
+        </div>
+        {shown.bio && <div className="doctor-bio">{shown.bio}</div>}
+        {shown.qualifications && shown.qualifications.length > 0 && (
+          <div className="doctor-tags">
+            {shown.qualifications.map((q, idx) => (
+              <span key={idx} className="doctor-tag">{q}</span>
+            ))}
+          </div>
+        )}
+      </div>
+    </div>
+  )
+}
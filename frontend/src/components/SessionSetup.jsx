@@ -1,26 +1,105 @@
-import React, { useState } from 'react'
-import { createSession } from '../utils/api'
+import React, { useState, useEffect } from 'react'
+import { createSession, login, register, logout, recommendSpecialties, requestOtp, verifyOtp } from '../utils/api'
+import { loadSpecialties } from '../utils/specialties'
 import './SessionSetup.css'
 
-export default function SessionSetup({ onSessionCreated }) {
+const OTP_RESEND_SECONDS = 60
+
+export default function SessionSetup({ user, onLogin, onLogout, onSessionCreated }) {
+  const [mode, setMode] = useState('phone') // 'phone' | 'login' | 'register'
+  const [phone, setPhone] = useState('')
+  const [code, setCode] = useState('')
+  const [resendIn, setResendIn] = useState(0)
   const [userEmail, setUserEmail] = useState('')
   const [userName, setUserName] = useState('')
-  const [specialty, setSpecialty] = useState('obstetrics')
+  const [password, setPassword] = useState('')
+  const [specialties, setSpecialties] = useState([])
+  const [specialty, setSpecialty] = useState('')
+  const [patientAge, setPatientAge] = useState('')
+  const [complaint, setComplaint] = useState('')
+  const [recommendations, setRecommendations] = useState(null)
   const [loading, setLoading] = useState(false)
   const [error, setError] = useState('')
 
+  // 科室列表来自后端，默认选中第一个
+  useEffect(() => {
+    loadSpecialties()
+      .then((list) => {
+        setSpecialties(list)
+        if (list.length > 0) {
+          setSpecialty((current) => current || list[0].id)
+        }
+      })
+      .catch((err) => setError(err.message))
+  }, [])
+
+  // 重新发送验证码倒计时
+  useEffect(() => {
+    if (resendIn <= 0) {
+      return
+    }
+    const timer = setTimeout(() => setResendIn(resendIn - 1), 1000)
+    return () => clearTimeout(timer)
+  }, [resendIn])
+
+  const handleSendCode = async () => {
+    setError('')
+    if (!phone) {
+      setError('请输入手机号')
+      return
+    }
+    try {
+      await requestOtp(phone)
+      setResendIn(OTP_RESEND_SECONDS)
+    } catch (err) {
+      setError(err.message)
+    }
+  }
+
+  // 根据主诉推荐科室，最匹配的科室自动选中
+  const handleRecommend = async () => {
+    setError('')
+    if (!complaint.trim()) {
+      setError('请先简单描述您的主要症状')
+      return
+    }
+    try {
+      const recs = await recommendSpecialties(complaint)
+      setRecommendations(recs)
+      if (recs.length > 0) {
+        setSpecialty(recs[0].specialty)
+      }
+    } catch (err) {
+      setError(err.message)
+    }
+  }
+
   const handleSubmit = async (e) => {
     e.preventDefault()
     setError('')
     setLoading(true)
 
     try {
-      if (!userEmail || !userName) {
-        throw new Error('请填写所有必填项')
+      let current = user
+      if (!current) {
+        if (mode === 'phone') {
+          if (!phone || !code) {
+            throw new Error('请填写手机号和验证码')
+          }
+          current = await verifyOtp(phone, code, userName)
+        } else {
+          if (!userEmail || !password || (mode === 'register' && !userName)) {
+            throw new Error('请填写所有必填项')
+          }
+          current = mode === 'register'
+            ? await register(userEmail, userName, password)
+            : await login(userEmail, password)
+        }
+        onLogin(current)
       }
 
-      const { session_id } = await createSession(userEmail, specialty)
-      onSessionCreated(session_id, userEmail, specialty)
+      const { session_id } = await createSession(specialty, patientAge)
+      onSessionCreated(session_id, current, specialty)
     } catch (err) {
       setError(err.message)
     } finally {
@@ -36,29 +115,139 @@ export default function SessionSetup({ onSessionCreated }) {
           <p className="subtitle">在线医生咨询服务</p>
 
           <form onSubmit={handleSubmit}>
-            <div className="form-group">
-              <label htmlFor="name">您的姓名</label>
-              <input
-                type="text"
-                id="name"
-                value={userName}
-                onChange={(e) => setUserName(e.target.value)}
-                placeholder="请输入您的姓名"
-                disabled={loading}
-              />
-            </div>
+            {user ? (
+              <div className="account-bar">
+                <span>您好，{user.name || user.phone || user.email}</span>
+                <button type="button" className="link-button" onClick={() => { logout(); onLogout() }}>
+                  退出登录
+                </button>
+              </div>
+            ) : mode === 'phone' ? (
+              <>
+                <div className="form-group">
+                  <label htmlFor="name">您的姓名</label>
+                  <input
+                    type="text"
+                    id="name"
+                    value={userName}
+                    onChange={(e) => setUserName(e.target.value)}
+                    placeholder="首次登录请填写姓名"
+                    disabled={loading}
+                  />
+                </div>
 
-            <div className="form-group">
-              <label htmlFor="email">手机号/邮箱</label>
-              <input
-                type="email"
-                id="email"
-                value={userEmail}
-                onChange={(e) => setUserEmail(e.target.value)}
-                placeholder="请输入手机号或邮箱"
-                disabled={loading}
-              />
-            </div>
+                <div className="form-group">
+                  <label htmlFor="phone">手机号</label>
+                  <input
+                    type="tel"
+                    id="phone"
+                    value={phone}
+                    onChange={(e) => setPhone(e.target.value)}
+                    placeholder="请输入手机号"
+                    disabled={loading}
+                  />
+                </div>
+
+                <div className="form-group">
+                  <label htmlFor="code">验证码</label>
+                  <div className="code-row">
+                    <input
+                      type="text"
+                      id="code"
+                      inputMode="numeric"
+                      maxLength={6}
+                      value={code}
+                      onChange={(e) => setCode(e.target.value)}
+                      placeholder="6位验证码"
+                      disabled={loading}
+                    />
+                    <button
+                      type="button"
+                      className="code-button"
+                      onClick={handleSendCode}
+                      disabled={loading || resendIn > 0}
+                    >
+                      {resendIn > 0 ? `${resendIn}秒后重发` : '获取验证码'}
+                    </button>
+                  </div>
+                </div>
+              </>
+            ) : (
+              <>
+                {mode === 'register' && (
+                  <div className="form-group">
+                    <label htmlFor="name">您的姓名</label>
+                    <input
+                      type="text"
+                      id="name"
+                      value={userName}
+                      onChange={(e) => setUserName(e.target.value)}
+                      placeholder="请输入您的姓名"
+                      disabled={loading}
+                    />
+                  </div>
+                )}
+
+                <div className="form-group">
+                  <label htmlFor="email">邮箱</label>
+                  <input
+                    type="email"
+                    id="email"
+                    value={userEmail}
+                    onChange={(e) => setUserEmail(e.target.value)}
+                    placeholder="请输入邮箱"
+                    disabled={loading}
+                  />
+                </div>
+
+                <div className="form-group">
+                  <label htmlFor="password">密码</label>
+                  <input
+                    type="password"
+                    id="password"
+                    value={password}
+                    onChange={(e) => setPassword(e.target.value)}
+                    placeholder={mode === 'register' ? '至少8位' : '请输入密码'}
+                    disabled={loading}
+                  />
+                </div>
+              </>
+            )}
+
+            {user && (
+              <div className="form-group">
+                <label htmlFor="complaint">主要症状（可选）</label>
+                <textarea
+                  id="complaint"
+                  value={complaint}
+                  onChange={(e) => setComplaint(e.target.value)}
+                  placeholder="例如：孩子发烧两天，咳嗽有痰"
+                  rows={2}
+                  disabled={loading}
+                />
+                <button type="button" className="link-button" onClick={handleRecommend} disabled={loading}>
+                  帮我推荐科室
+                </button>
+                {recommendations && (recommendations.length === 0 ? (
+                  <p className="recommend-empty">未能判断合适的科室，请自行选择</p>
+                ) : (
+                  <div className="recommend-list">
+                    {recommendations.map((rec) => (
+                      <button
+                        type="button"
+                        key={rec.specialty}
+                        className={`recommend-chip ${rec.specialty === specialty ? 'selected' : ''}`}
+                        onClick={() => setSpecialty(rec.specialty)}
+                        title={rec.reason}
+                        disabled={loading}
+                      >
+                        {rec.name} {Math.round(rec.confidence * 100)}%
+                      </button>
+                    ))}
+                  </div>
+                ))}
+              </div>
+            )}
 
             <div className="form-group">
               <label htmlFor="specialty">选择医生科室</label>
@@ -69,25 +258,58 @@ export default function SessionSetup({ onSessionCreated }) {
                 disabled={loading}
                 className="specialty-select"
               >
-                <option value="obstetrics">👩‍⚕️ 妇产科 - 妇科、产科、产后恢复</option>
-                <option value="pediatrics">👶 儿科 - 儿童疾病、生长发育</option>
-                <option value="internal_medicine">🫀 内科 - 内脏器官、代谢、感染</option>
-                <option value="dermatology">🩹 皮肤科 - 皮肤病、痤疮、湿疹</option>
-                <option value="ent">👂 耳鼻喉科 - 鼻炎、喉咙痛、耳痛</option>
-                <option value="cardiology">🫀 心脑血管科 - 胸痛、心悸、头晕、脑卒中</option>
-                <option value="respiratory">💨 呼吸科 - 咳嗽、哮喘、呼吸困难</option>
+                {specialties.map((s) => (
+                  <option key={s.id} value={s.id}>
+                    {s.emoji} {s.name}{s.summary ? ` - ${s.summary}` : ''}
+                  </option>
+                ))}
               </select>
             </div>
 
+            <div className="form-group">
+              <label htmlFor="patientAge">患者年龄（可选）</label>
+              <input
+                type="text"
+                id="patientAge"
+                value={patientAge}
+                onChange={(e) => setPatientAge(e.target.value)}
+                placeholder="例如：30岁、8个月"
+                maxLength={10}
+                disabled={loading}
+              />
+            </div>
+
             {error && <div className="error-message">{error}</div>}
 
             <button
               type="submit"
-              disabled={loading}
+              disabled={loading || !specialty}
               className="start-button"
             >
-              {loading ? '正在创建会话...' : '开始咨询'}
+              {loading ? '正在创建会话...' : user ? '开始咨询' : mode === 'register' ? '注册并开始咨询' : '登录并开始咨询'}
             </button>
+
+            {!user && mode !== 'phone' && (
+              <button
+                type="button"
+                className="link-button"
+                onClick={() => setMode(mode === 'register' ? 'login' : 'register')}
+                disabled={loading}
+              >
+                {mode === 'register' ? '已有账号？直接登录' : '没有账号？立即注册'}
+              </button>
+            )}
+
+            {!user && (
+              <button
+                type="button"
+                className="link-button"
+                onClick={() => setMode(mode === 'phone' ? 'login' : 'phone')}
+                disabled={loading}
+              >
+                {mode === 'phone' ? '使用邮箱密码登录' : '使用手机验证码登录'}
+              </button>
+            )}
           </form>
 
           <div className="info-box">
@@ -1,12 +1,116 @@
 import axios from 'axios'
 
 const API_BASE_URL = '/api'
+const TOKEN_KEY = 'medseek_token'
 
-export const createSession = async (userId, specialty = 'obstetrics') => {
+// 登录凭证保存在本地，所有请求通过 Authorization 头携带
+export const getToken = () => window.localStorage.getItem(TOKEN_KEY)
+
+export const setToken = (token) => {
+  if (token) {
+    window.localStorage.setItem(TOKEN_KEY, token)
+  } else {
+    window.localStorage.removeItem(TOKEN_KEY)
+  }
+}
+
+axios.interceptors.request.use((config) => {
+  const token = getToken()
+  if (token) {
+    config.headers.Authorization = `Bearer ${token}`
+  }
+  return config
+})
+
+// 凭证失效时清除，页面回到登录状态
+axios.interceptors.response.use(
+  (response) => response,
+  (error) => {
+    if (error.response && error.response.status === 401) {
+      setToken(null)
+    }
+    return Promise.reject(error)
+  }
+)
+
+// 服务器返回的错误信息是纯文本
+const errorText = (error) => (error.response && typeof error.response.data === 'string' && error.response.data.trim()) || error.message
+
+export const register = async (email, name, password) => {
+  try {
+    const response = await axios.post(`${API_BASE_URL}/auth/register`, { email, name, password })
+    setToken(response.data.token)
+    return response.data.user
+  } catch (error) {
+    throw new Error(`注册失败：${errorText(error)}`)
+  }
+}
+
+export const login = async (email, password) => {
+  try {
+    const response = await axios.post(`${API_BASE_URL}/auth/login`, { email, password })
+    setToken(response.data.token)
+    return response.data.user
+  } catch (error) {
+    throw new Error(`登录失败：${errorText(error)}`)
+  }
+}
+
+// 发送短信验证码；被限流时错误信息包含需要等待的时间
+export const requestOtp = async (phone) => {
+  try {
+    await axios.post(`${API_BASE_URL}/auth/otp/request`, { phone })
+  } catch (error) {
+    if (error.response && error.response.status === 429) {
+      const wait = error.response.headers['retry-after'] || 60
+      throw new Error(`验证码发送过于频繁，请${wait}秒后再试`)
+    }
+    throw new Error(`验证码发送失败：${errorText(error)}`)
+  }
+}
+
+// 验证码登录，手机号首次登录时自动注册
+export const verifyOtp = async (phone, code, name) => {
+  try {
+    const response = await axios.post(`${API_BASE_URL}/auth/otp/verify`, { phone, code, name })
+    setToken(response.data.token)
+    return response.data.user
+  } catch (error) {
+    throw new Error(`登录失败：${errorText(error)}`)
+  }
+}
+
+// 返回当前登录的用户，未登录或凭证失效时返回 null
+export const getCurrentUser = async () => {
+  if (!getToken()) {
+    return null
+  }
+  try {
+    const response = await axios.get(`${API_BASE_URL}/auth/me`)
+    return response.data
+  } catch (error) {
+    return null
+  }
+}
+
+export const logout = () => setToken(null)
+
+// WebSocket协议版本，连接后通过 hello 帧与服务器协商
+export const PROTOCOL_VERSIONS = [1]
+
+// 生成客户端消息ID，用于将服务器的 ack/delta/done 帧与发送的消息对应
+export const newClientMessageId = () => {
+  if (window.crypto && window.crypto.randomUUID) {
+    return window.crypto.randomUUID()
+  }
+  return `${Date.now().toString(36)}-${Math.random().toString(36).slice(2, 10)}`
+}
+
+export const createSession = async (specialty, patientAge = '') => {
   try {
     const response = await axios.post(`${API_BASE_URL}/session/create`, {
-      user_id: userId,
       specialty: specialty,
+      patient_age: patientAge,
     })
     return response.data
   } catch (error) {
@@ -14,6 +118,26 @@ export const createSession = async (userId, specialty = 'obstetrics') => {
   }
 }
 
+// 可咨询的科室列表（已停用的科室不返回），无需登录
+export const getSpecialties = async () => {
+  try {
+    const response = await axios.get(`${API_BASE_URL}/specialties`)
+    return response.data || []
+  } catch (error) {
+    throw new Error(`Failed to fetch specialties: ${error.message}`)
+  }
+}
+
+// 根据主诉推荐就诊科室，按置信度从高到低排列
+export const recommendSpecialties = async (complaint) => {
+  try {
+    const response = await axios.post(`${API_BASE_URL}/specialties/recommend`, { complaint })
+    return response.data || []
+  } catch (error) {
+    throw new Error(`Failed to recommend specialties: ${error.message}`)
+  }
+}
+
 export const getSessionMessages = async (sessionId) => {
   try {
     const response = await axios.get(`${API_BASE_URL}/session/messages`, {
@@ -36,31 +160,92 @@ export const closeSession = async (sessionId) => {
   }
 }
 
-export const connectWebSocket = (sessionId, userId) => {
+// 患者对本次咨询的评分（1-5）及可选评价
+export const rateSession = async (sessionId, rating, comment) => {
+  try {
+    const response = await axios.post(`${API_BASE_URL}/session/rate`, { rating, comment }, {
+      params: { session_id: sessionId },
+    })
+    return response.data
+  } catch (error) {
+    throw new Error(`Failed to rate session: ${error.message}`)
+  }
+}
+
+// 科室在岗医生列表（仅含未停用的医生），用于会话页面的医生卡片
+export const getDoctors = async (specialty) => {
+  try {
+    const response = await axios.get(`${API_BASE_URL}/doctors`, {
+      params: { specialty },
+    })
+    return response.data || []
+  } catch (error) {
+    throw new Error(`Failed to fetch doctors: ${error.message}`)
+  }
+}
+
+export const getDoctor = async (doctorId) => {
+  try {
+    const response = await axios.get(`${API_BASE_URL}/doctors`, {
+      params: { id: doctorId },
+    })
+    return response.data
+  } catch (error) {
+    throw new Error(`Failed to fetch doctor: ${error.message}`)
+  }
+}
+
+// 浏览器无法为 WebSocket/EventSource 设置请求头，凭证通过 access_token 参数传递
+export const connectWebSocket = (sessionId, lastSeq = 0) => {
   const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:'
-  const wsUrl = `${protocol}//${window.location.host}/ws?session_id=${sessionId}&user_id=${userId}`
-  
-  const ws = new WebSocket(wsUrl)
-  
-  // iOS Safari specific handling
-  if (/iPad|iPhone|iPod/.test(navigator.userAgent)) {
-    // Set a longer timeout for iOS Safari
-    ws._iosReconnectAttempts = 0
-    ws._iosMaxReconnectAttempts = 5
-    
-    ws.addEventListener('close', function handleIOSClose(event) {
-      // Auto-reconnect for iOS Safari (except on normal close)
-      if (!event.wasClean && this._iosReconnectAttempts < this._iosMaxReconnectAttempts) {
-        const delay = Math.min(1000 * Math.pow(2, this._iosReconnectAttempts), 10000)
-        console.log(`iOS WebSocket disconnected, attempting reconnect in ${delay}ms...`)
-        
-        setTimeout(() => {
-          // Note: This is a simple retry, full reconnection logic should be in the component
-          this._iosReconnectAttempts++
-        }, delay)
-      }
+  const params = new URLSearchParams({
+    session_id: sessionId,
+    access_token: getToken() || '',
+    // 服务器会补发 last_seq 之后的所有消息（包括断线期间完成的回复）
+    last_seq: String(lastSeq),
+  })
+  return new WebSocket(`${protocol}//${window.location.host}/ws?${params}`)
+}
+
+// WebSocket 无法建立时（部分医院Wi-Fi代理、旧版微信内置浏览器）改用 SSE 接收消息
+export const connectEventStream = (sessionId, lastSeq = 0) => {
+  const params = new URLSearchParams({
+    session_id: sessionId,
+    access_token: getToken() || '',
+    last_seq: String(lastSeq),
+  })
+  return new EventSource(`${API_BASE_URL}/session/events?${params}`)
+}
+
+// 不支持 EventSource 的浏览器使用长轮询，返回 { poll_id, frames }
+export const pollEvents = async (sessionId, lastSeq, pollId) => {
+  const response = await axios.get(`${API_BASE_URL}/session/events`, {
+    params: {
+      session_id: sessionId,
+      last_seq: lastSeq,
+      poll_id: pollId || undefined,
+      transport: 'poll',
+    },
+    timeout: 60000,
+  })
+  return response.data
+}
+
+// 通过 HTTP 发送客户端帧（message/stop/typing），返回 ack 或 error 帧
+export const postClientFrame = async (sessionId, frame) => {
+  try {
+    const response = await axios.post(`${API_BASE_URL}/session/message`, {
+      ...frame,
+      session_id: sessionId,
     })
+    return response.data
+  } catch (error) {
+    if (error.response && error.response.data && error.response.data.type === 'error') {
+      return error.response.data
+    }
+    throw new Error(`Failed to send message: ${error.message}`)
   }
-  
-  return ws
 }
+
+// 断线重连的退避时间：1s, 2s, 4s ... 最长10s
+export const reconnectDelay = (attempt) => Math.min(1000 * Math.pow(2, attempt), 10000)
@@ -1,111 +1,26 @@
-export const specialtyInfo = {
-  obstetrics: {
-    name: '妇产科',
-    emoji: '👩‍⚕️',
-    title: '妇产科在线咨询',
-    doctorRole: '妇产科医生',
-    welcome: '欢迎来到妇产科在线咨询',
-    description: '请描述您的症状或健康问题，医生将为您提供专业建议',
-    guidelines: [
-      '详细描述您的症状、持续时间',
-      '提供相关病史、月经史、婚育史',
-      '如实告知身体状况',
-      '严重症状请及时到医院急诊',
-      '本服务提供初步诊疗建议，不能替代面诊',
-    ],
-  },
-  pediatrics: {
-    name: '儿科',
-    emoji: '👶',
-    title: '儿科在线咨询',
-    doctorRole: '儿科医生',
-    welcome: '欢迎来到儿科在线咨询',
-    description: '请描述孩子的症状或健康问题，医生将为您提供专业建议',
-    guidelines: [
-      '详细描述孩子的症状、发病时间',
-      '提供孩子的年龄、体重信息',
-      '说明既往病史和预防接种情况',
-      '严重症状请及时到医院急诊',
-      '本服务提供初步诊疗建议，不能替代面诊',
-    ],
-  },
-  internal_medicine: {
-    name: '内科',
-    emoji: '🫀',
-    title: '内科在线咨询',
-    doctorRole: '内科医生',
-    welcome: '欢迎来到内科在线咨询',
-    description: '请描述您的症状或健康问题，医生将为您提供专业建议',
-    guidelines: [
-      '详细描述您的症状、持续时间',
-      '提供既往病史和用药情况',
-      '告知最近的检查结果（如有）',
-      '如实告知身体状况',
-      '本服务提供初步诊疗建议，不能替代面诊',
-    ],
-  },
-  dermatology: {
-    name: '皮肤科',
-    emoji: '🩹',
-    title: '皮肤科在线咨询',
-    doctorRole: '皮肤科医生',
-    welcome: '欢迎来到皮肤科在线咨询',
-    description: '请描述您的皮肤问题，医生将为您提供专业建议',
-    guidelines: [
-      '详细描述皮肤病变的位置、大小、颜色',
-      '说明发病时间和发展过程',
-      '提供既往皮肤病史',
-      '如有可能，上传患处照片（可通过其他方式）',
-      '本服务提供初步诊疗建议，不能替代面诊',
-    ],
-  },
-  ent: {
-    name: '耳鼻喉科',
-    emoji: '👂',
-    title: '耳鼻喉科在线咨询',
-    doctorRole: '耳鼻喉医生',
-    welcome: '欢迎来到耳鼻喉科在线咨询',
-    description: '请描述您的耳鼻喉症状，医生将为您提供专业建议',
-    guidelines: [
-      '详细描述症状的具体位置和表现',
-      '说明发病时间和诱发因素',
-      '提供既往耳鼻喉疾病史',
-      '如实告知用药和过敏情况',
-      '本服务提供初步诊疗建议，不能替代面诊',
-    ],
-  },
-  cardiology: {
-    name: '心脑血管科',
-    emoji: '🧠❤️',
-    title: '心脑血管科在线咨询',
-    doctorRole: '心脑血管医生',
-    welcome: '欢迎来到心脑血管科在线咨询',
-    description: '请描述您的心脑血管症状，医生将为您提供专业建议',
-    guidelines: [
-      '详细描述胸部或头部症状的位置和性质',
-      '说明发病时间和诱发因素',
-      '提供既往心脑血管病史、家族史',
-      '告知血压、血糖等指标',
-      '本服务提供初步诊疗建议，不能替代面诊。如有严重症状请立即就医',
-    ],
-  },
-  respiratory: {
-    name: '呼吸科',
-    emoji: '💨',
-    title: '呼吸科在线咨询',
-    doctorRole: '呼吸科医生',
-    welcome: '欢迎来到呼吸科在线咨询',
-    description: '请描述您的呼吸系统症状，医生将为您提供专业建议',
-    guidelines: [
-      '详细描述咳嗽或呼吸困难的具体表现',
-      '说明发病时间和发展过程',
-      '描述咳痰情况（颜色、性质、量）',
-      '提供既往呼吸病史和吸烟史',
-      '本服务提供初步诊疗建议，不能替代面诊',
-    ],
-  },
+import { getSpecialties } from './api'
+
+// 科室信息由后端科室注册表提供（GET /api/specialties），加载后缓存在这里
+let specialties = []
+
+// 未知科室（例如已停用）的兜底展示信息
+const fallbackInfo = (id) => ({
+  id,
+  name: id,
+  emoji: '🩺',
+  summary: '',
+  title: '在线咨询',
+  doctor_role: '医生',
+  welcome: '欢迎来到在线咨询',
+  description: '请描述您的症状或健康问题，医生将为您提供专业建议',
+  guidelines: ['本服务提供初步诊疗建议，不能替代面诊'],
+})
+
+export const loadSpecialties = async () => {
+  specialties = await getSpecialties()
+  return specialties
 }
 
 export const getSpecialtyInfo = (specialty) => {
-  return specialtyInfo[specialty] || specialtyInfo.obstetrics
+  return specialties.find((s) => s.id === specialty) || fallbackInfo(specialty)
 }
@@ -0,0 +1,311 @@
+package auth
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"medseek/internal/models"
+	"medseek/internal/store"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MinPasswordLength is the shortest password accepted at registration
+const MinPasswordLength = 8
+
+// User roles
+const (
+	RolePatient = "patient"
+	RoleDoctor  = "doctor"
+	RoleAdmin   = "admin"
+)
+
+var (
+	// ErrInvalidInput is returned when registration data is malformed
+	ErrInvalidInput = errors.New("invalid input")
+	// ErrEmailTaken is returned when registering an email that already has an account
+	ErrEmailTaken = errors.New("email already registered")
+	// ErrInvalidCredentials is returned when a login email or password is wrong
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	// ErrInvalidToken is returned for malformed, forged or unknown tokens
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrTokenExpired is returned for tokens past their expiry
+	ErrTokenExpired = errors.New("token expired")
+)
+
+// Config holds the token and SMS login settings
+type Config struct {
+	Secret   []byte
+	TokenTTL time.Duration
+	OTP      OTPConfig
+	// StaffRoles maps a phone number to the role its account gets at SMS
+	// login; every other account is a patient
+	StaffRoles map[string]string
+	// TrustedProxies are the addresses whose X-Real-IP header gives the
+	// client address; requests from anywhere else are limited by their own
+	TrustedProxies []netip.Prefix
+}
+
+// ConfigFromEnv reads AUTH_SECRET and AUTH_TOKEN_TTL (default 7 days), the
+// SMS login limits from OTP_TTL, OTP_MAX_ATTEMPTS, OTP_PHONE_INTERVAL,
+// OTP_PHONE_HOURLY and OTP_IP_HOURLY, staff accounts from STAFF_ROLES
+// ("13800000000=doctor,13900000000=admin") and the reverse proxies
+// trusted to report client addresses from TRUSTED_PROXIES (addresses or CIDR
+// ranges, default loopback only). Without a secret a random one is
+// generated, so tokens do not survive a restart.
+func ConfigFromEnv() Config {
+	cfg := Config{
+		Secret:     []byte(os.Getenv("AUTH_SECRET")),
+		TokenTTL:   7 * 24 * time.Hour,
+		OTP:        DefaultOTPConfig(),
+		StaffRoles: parseStaffRoles(os.Getenv("STAFF_ROLES")),
+	}
+	proxies, ok := os.LookupEnv("TRUSTED_PROXIES")
+	if !ok {
+		proxies = "127.0.0.1,::1"
+	}
+	cfg.TrustedProxies = parsePrefixes(proxies)
+	if d, err := time.ParseDuration(os.Getenv("AUTH_TOKEN_TTL")); err == nil && d > 0 {
+		cfg.TokenTTL = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("OTP_TTL")); err == nil && d > 0 {
+		cfg.OTP.CodeTTL = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("OTP_PHONE_INTERVAL")); err == nil && d >= 0 {
+		cfg.OTP.PhoneInterval = d
+	}
+	if n, err := strconv.Atoi(os.Getenv("OTP_MAX_ATTEMPTS")); err == nil && n > 0 {
+		cfg.OTP.MaxAttempts = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("OTP_PHONE_HOURLY")); err == nil && n >= 0 {
+		cfg.OTP.PhoneHourly = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("OTP_IP_HOURLY")); err == nil && n >= 0 {
+		cfg.OTP.IPHourly = n
+	}
+	if len(cfg.Secret) == 0 {
+		log.Printf("Warning: AUTH_SECRET not set, using a random secret; patients must log in again after a restart")
+		cfg.Secret = make([]byte, 32)
+		rand.Read(cfg.Secret)
+	}
+	return cfg
+}
+
+// parseStaffRoles reads a comma-separated list of phone=role pairs. Emails
+// are not verified, so whoever registered one first would get its role;
+// they are skipped, and email staff are added through the admin API.
+func parseStaffRoles(v string) map[string]string {
+	roles := make(map[string]string)
+	for _, pair := range strings.Split(v, ",") {
+		account, role, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+		account = strings.TrimSpace(account)
+		phone, err := NormalizePhone(account)
+		if err != nil {
+			log.Printf("Warning: ignoring %s in STAFF_ROLES, only phone numbers confirmed by SMS login get staff roles", account)
+			continue
+		}
+		switch role = strings.TrimSpace(role); role {
+		case RoleDoctor, RoleAdmin:
+			roles[phone] = role
+		default:
+			log.Printf("Warning: ignoring unknown role %q for %s in STAFF_ROLES", role, maskPhone(phone))
+		}
+	}
+	return roles
+}
+
+// parsePrefixes reads a comma-separated list of addresses and CIDR ranges
+func parsePrefixes(v string) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(v, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+		} else if addr, err := netip.ParseAddr(entry); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+		} else {
+			log.Printf("Warning: ignoring invalid address %q in TRUSTED_PROXIES", entry)
+		}
+	}
+	return prefixes
+}
+
+// Token is returned by registration and login
+type Token struct {
+	AccessToken string       `json:"token"`
+	ExpiresAt   time.Time    `json:"expires_at"`
+	User        *models.User `json:"user"`
+}
+
+// Service manages patient accounts, SMS login codes and access tokens
+type Service struct {
+	store      store.Store
+	signer     *Signer
+	sms        SMSSender
+	otp        *otpState
+	staffRoles map[string]string
+	proxies    []netip.Prefix
+}
+
+// NewService creates an auth service that sends login codes through sms
+func NewService(st store.Store, cfg Config, sms SMSSender) *Service {
+	return &Service{
+		store:      st,
+		signer:     NewSigner(cfg.Secret, cfg.TokenTTL),
+		sms:        sms,
+		otp:        newOTPState(cfg.OTP),
+		staffRoles: cfg.StaffRoles,
+		proxies:    cfg.TrustedProxies,
+	}
+}
+
+// Register creates an account and logs it in
+func (s *Service) Register(email, name, password string) (*Token, error) {
+	addr, err := mail.ParseAddress(strings.TrimSpace(email))
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid email", ErrInvalidInput)
+	}
+	if len(password) < MinPasswordLength {
+		return nil, fmt.Errorf("%w: password must be at least %d characters", ErrInvalidInput, MinPasswordLength)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	user := &models.User{
+		ID:           uuid.New().String(),
+		Email:        strings.ToLower(addr.Address),
+		Name:         strings.TrimSpace(name),
+		Role:         RolePatient,
+		PasswordHash: string(hash),
+		CreatedAt:    time.Now(),
+	}
+	if err := s.store.CreateUser(user); err != nil {
+		if errors.Is(err, store.ErrConflict) {
+			return nil, ErrEmailTaken
+		}
+		return nil, err
+	}
+
+	return s.issue(user)
+}
+
+// Login checks an email and password and issues a token
+func (s *Service) Login(email, password string) (*Token, error) {
+	user, err := s.store.GetUserByEmail(strings.ToLower(strings.TrimSpace(email)))
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	return s.issue(user)
+}
+
+// Authenticate verifies a token and returns the user it was issued to
+func (s *Service) Authenticate(token string) (*models.User, error) {
+	claims, err := s.signer.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.store.GetUser(claims.Subject)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrInvalidToken
+	}
+	return user, err
+}
+
+func (s *Service) issue(user *models.User) (*Token, error) {
+	token, expires, err := s.signer.Issue(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &Token{AccessToken: token, ExpiresAt: expires, User: user}, nil
+}
+
+// applyStaffRole gives an account the role STAFF_ROLES lists for its phone
+// number. It is called only once an SMS code has proved the caller owns the
+// number. Unlisted accounts keep their role, so doctors added through the
+// admin API stay doctors, and listed doctors an admin has deactivated stay
+// deactivated.
+func (s *Service) applyStaffRole(user *models.User) error {
+	role, ok := s.staffRoles[user.Phone]
+	if !ok || user.Phone == "" {
+		return nil
+	}
+	if user.Role == role {
+		return nil
+	}
+	if role == RoleDoctor {
+		profile, err := s.store.GetDoctor(user.ID)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			return fmt.Errorf("failed to load doctor profile: %w", err)
+		}
+		if profile != nil && !profile.Active {
+			return nil
+		}
+	}
+	if err := s.SetRole(user.ID, role); err != nil {
+		return err
+	}
+	user.Role = role
+	return nil
+}
+
+// SetRole changes the role of an account
+func (s *Service) SetRole(userID, role string) error {
+	if err := s.store.SetUserRole(userID, role); err != nil {
+		return fmt.Errorf("failed to update role: %w", err)
+	}
+	log.Printf("Account %s now has role %s", userID, role)
+	return nil
+}
+
+// SetDoctorAccess gives an account the doctor role while its doctor profile
+// is active and takes it away once the profile is deactivated. Admins keep
+// their role either way.
+func (s *Service) SetDoctorAccess(userID string, active bool) error {
+	user, err := s.store.GetUser(userID)
+	if err != nil {
+		return fmt.Errorf("failed to load account: %w", err)
+	}
+	role := RolePatient
+	if active {
+		role = RoleDoctor
+	}
+	if user.Role == RoleAdmin || user.Role == role {
+		return nil
+	}
+	return s.SetRole(userID, role)
+}
+
+// AccountByPhone returns the account for a phone number, creating it if
+// needed, so staff can be set up before their first SMS login
+func (s *Service) AccountByPhone(phone, name string) (*models.User, error) {
+	phone, err := NormalizePhone(phone)
+	if err != nil {
+		return nil, err
+	}
+	return s.userByPhone(phone, name)
+}
@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"testing"
+
+	"medseek/internal/models"
+)
+
+func TestParseStaffRoles(t *testing.T) {
+	roles := parseStaffRoles("doctor@example.com=doctor, +86 138-0000-0001=doctor,13800000002=admin,13800000003=nurse,bad")
+	if len(roles) != 2 || roles["13800000001"] != RoleDoctor || roles["13800000002"] != RoleAdmin {
+		t.Errorf("parseStaffRoles = %v, want only the phone entries with known roles", roles)
+	}
+}
+
+func TestStaffRolesNeedAVerifiedPhone(t *testing.T) {
+	s, sms := newTestService(t, Config{StaffRoles: map[string]string{
+		"doctor@example.com": RoleDoctor,
+		testPhone:            RoleDoctor,
+	}})
+
+	// Registering or logging in with a listed but unverified email grants nothing
+	token, err := s.Register("doctor@example.com", "冒充者", "password123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.User.Role != RolePatient {
+		t.Errorf("registered role = %s, want patient", token.User.Role)
+	}
+	if token, err = s.Login("doctor@example.com", "password123"); err != nil || token.User.Role != RolePatient {
+		t.Errorf("login = %+v, %v, want a patient", token, err)
+	}
+
+	// Creating the phone account ahead of its first login grants nothing either
+	user, err := s.AccountByPhone(testPhone, "李医生")
+	if err != nil || user.Role != RolePatient {
+		t.Fatalf("AccountByPhone = %+v, %v", user, err)
+	}
+
+	// The SMS login proves the number and applies its role
+	if err := s.RequestCode(testPhone, "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	token, err = s.VerifyCode(testPhone, sms.code(testPhone), "")
+	if err != nil || token.User.Role != RoleDoctor {
+		t.Fatalf("VerifyCode = %+v, %v, want a doctor", token, err)
+	}
+	if stored, _ := s.store.GetUser(token.User.ID); stored.Role != RoleDoctor {
+		t.Errorf("stored role = %s", stored.Role)
+	}
+}
+
+// loginByPhone logs a phone number in with an SMS code
+func loginByPhone(t *testing.T, s *Service, sms *recordingSMS, phone string) *models.User {
+	t.Helper()
+	// Lift the one-code-per-interval limit so a test can log in repeatedly
+	s.otp.phoneBurst = newWindow(0, 0)
+	if err := s.RequestCode(phone, "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	token, err := s.VerifyCode(phone, sms.code(phone), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token.User
+}
+
+func TestStaffRolesKeepDeactivatedDoctorsOut(t *testing.T) {
+	s, sms := newTestService(t, Config{StaffRoles: map[string]string{testPhone: RoleDoctor}})
+	user := loginByPhone(t, s, sms, testPhone)
+	if user.Role != RoleDoctor {
+		t.Fatalf("role = %s, want doctor", user.Role)
+	}
+
+	// An admin deactivates the doctor
+	if err := s.store.SaveDoctor(&models.DoctorProfile{ID: user.ID, Name: "李医生", Active: false}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetDoctorAccess(user.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	if user = loginByPhone(t, s, sms, testPhone); user.Role != RolePatient {
+		t.Errorf("role after deactivation and a new login = %s, want patient", user.Role)
+	}
+
+	// Reactivating restores the role
+	if err := s.store.SaveDoctor(&models.DoctorProfile{ID: user.ID, Name: "李医生", Active: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetDoctorAccess(user.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if user = loginByPhone(t, s, sms, testPhone); user.Role != RoleDoctor {
+		t.Errorf("role after reactivation = %s, want doctor", user.Role)
+	}
+}
+
+func TestSetDoctorAccessKeepsAdmins(t *testing.T) {
+	s, sms := newTestService(t, Config{StaffRoles: map[string]string{testPhone: RoleAdmin}})
+	user := loginByPhone(t, s, sms, testPhone)
+
+	for _, active := range []bool{true, false} {
+		if err := s.SetDoctorAccess(user.ID, active); err != nil {
+			t.Fatal(err)
+		}
+		if stored, _ := s.store.GetUser(user.ID); stored.Role != RoleAdmin {
+			t.Errorf("active=%v: role = %s, want admin", active, stored.Role)
+		}
+	}
+}
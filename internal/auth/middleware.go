@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"medseek/internal/models"
+)
+
+type contextKey struct{}
+
+// Middleware rejects requests without a valid access token and stores the
+// authenticated user in the request context. Browsers cannot set headers on
+// WebSocket or EventSource requests, so the token may also be passed as the
+// access_token query parameter.
+func (s *Service) Middleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := tokenFromRequest(r)
+		if token == "" {
+			http.Error(w, "Missing access token", http.StatusUnauthorized)
+			return
+		}
+
+		user, err := s.Authenticate(token)
+		if err != nil {
+			if !errors.Is(err, ErrInvalidToken) && !errors.Is(err, ErrTokenExpired) {
+				log.Printf("Failed to authenticate request: %v", err)
+				http.Error(w, "Authentication failed", http.StatusInternalServerError)
+				return
+			}
+			http.Error(w, "Invalid or expired access token", http.StatusUnauthorized)
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, user)))
+	}
+}
+
+// RequireRole wraps Middleware and additionally rejects users whose role is
+// not one of roles with 403
+func (s *Service) RequireRole(roles ...string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return s.Middleware(func(w http.ResponseWriter, r *http.Request) {
+			user, _ := UserFromContext(r.Context())
+			if !slices.Contains(roles, user.Role) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next(w, r)
+		})
+	}
+}
+
+// ClientIP returns the caller's address. nginx (see nginx.conf.template) puts
+// the real address in X-Real-IP, which is believed only when the connection
+// comes from a trusted proxy; anyone else could set it to dodge the per-IP
+// limits.
+func (s *Service) ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return host
+	}
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" && s.trustedProxy(addr.Unmap()) {
+		return ip
+	}
+	return host
+}
+
+func (s *Service) trustedProxy(addr netip.Addr) bool {
+	for _, prefix := range s.proxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// UserFromContext returns the user authenticated by Middleware
+func UserFromContext(ctx context.Context) (*models.User, bool) {
+	user, ok := ctx.Value(contextKey{}).(*models.User)
+	return user, ok
+}
+
+// tokenFromRequest reads a bearer token from the Authorization header or the
+// access_token query parameter
+func tokenFromRequest(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); header != "" {
+		if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
+		return ""
+	}
+	return r.URL.Query().Get("access_token")
+}
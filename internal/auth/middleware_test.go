@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	s, _ := newTestService(t, Config{TrustedProxies: parsePrefixes("127.0.0.1, 10.1.0.0/16")})
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		realIP     string
+		want       string
+	}{
+		{"direct", "203.0.113.7:5000", "", "203.0.113.7"},
+		{"spoofed header", "203.0.113.7:5000", "198.51.100.1", "203.0.113.7"},
+		{"trusted proxy", "127.0.0.1:5000", "198.51.100.1", "198.51.100.1"},
+		{"trusted range", "10.1.2.3:5000", "198.51.100.1", "198.51.100.1"},
+		{"mapped trusted proxy", "[::ffff:127.0.0.1]:5000", "198.51.100.1", "198.51.100.1"},
+		{"untrusted ipv6", "[2001:db8::1]:5000", "198.51.100.1", "2001:db8::1"},
+		{"proxy without header", "127.0.0.1:5000", "", "127.0.0.1"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/api/auth/otp/request", nil)
+		r.RemoteAddr = tt.remoteAddr
+		if tt.realIP != "" {
+			r.Header.Set("X-Real-IP", tt.realIP)
+		}
+		if got := s.ClientIP(r); got != tt.want {
+			t.Errorf("%s: ClientIP = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParsePrefixes(t *testing.T) {
+	got := parsePrefixes("127.0.0.1, ::1,10.0.0.0/8,bogus,")
+	if len(got) != 3 || got[0].String() != "127.0.0.1/32" || got[1].String() != "::1/128" || got[2].String() != "10.0.0.0/8" {
+		t.Errorf("parsePrefixes = %v", got)
+	}
+}
@@ -0,0 +1,300 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"medseek/internal/models"
+	"medseek/internal/store"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrInvalidPhone is returned for numbers that are not mainland mobile numbers
+	ErrInvalidPhone = errors.New("invalid phone number")
+	// ErrInvalidCode is returned for wrong, expired or used login codes
+	ErrInvalidCode = errors.New("invalid or expired code")
+	// ErrTooManyRequests is returned when a phone number or IP is rate limited
+	ErrTooManyRequests = errors.New("too many requests")
+)
+
+// RateLimitError is returned when a code request is rate limited
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("too many requests, retry in %v", e.RetryAfter.Round(time.Second))
+}
+
+// Unwrap returns ErrTooManyRequests, so errors.Is works
+func (e *RateLimitError) Unwrap() error {
+	return ErrTooManyRequests
+}
+
+// OTPConfig holds the SMS login limits
+type OTPConfig struct {
+	CodeTTL       time.Duration // how long a code stays valid
+	MaxAttempts   int           // wrong guesses before a code is discarded
+	PhoneInterval time.Duration // minimum time between codes to one number
+	PhoneHourly   int           // codes per number per hour
+	IPHourly      int           // codes per client IP per hour
+}
+
+// DefaultOTPConfig returns the limits used unless overridden by env
+func DefaultOTPConfig() OTPConfig {
+	return OTPConfig{
+		CodeTTL:       5 * time.Minute,
+		MaxAttempts:   5,
+		PhoneInterval: time.Minute,
+		PhoneHourly:   5,
+		IPHourly:      20,
+	}
+}
+
+// mobilePattern matches mainland China mobile numbers
+var mobilePattern = regexp.MustCompile(`^1[3-9]\d{9}$`)
+
+// NormalizePhone strips separators and the +86 prefix and validates the number
+func NormalizePhone(phone string) (string, error) {
+	phone = strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(phone))
+	phone = strings.TrimPrefix(phone, "+86")
+	if len(phone) == 13 {
+		phone = strings.TrimPrefix(phone, "86")
+	}
+	if !mobilePattern.MatchString(phone) {
+		return "", ErrInvalidPhone
+	}
+	return phone, nil
+}
+
+type otpCode struct {
+	code     string
+	expires  time.Time
+	attempts int
+}
+
+// otpState keeps issued codes and rate limit windows in memory; codes are
+// short-lived, so losing them on restart only means asking for a new one
+type otpState struct {
+	cfg         OTPConfig
+	codes       map[string]*otpCode // phone -> outstanding code
+	phoneBurst  *window
+	phoneHourly *window
+	ipHourly    *window
+	lastSweep   time.Time
+	mu          sync.Mutex
+}
+
+func newOTPState(cfg OTPConfig) *otpState {
+	return &otpState{
+		cfg:         cfg,
+		codes:       make(map[string]*otpCode),
+		phoneBurst:  newWindow(1, cfg.PhoneInterval),
+		phoneHourly: newWindow(cfg.PhoneHourly, time.Hour),
+		ipHourly:    newWindow(cfg.IPHourly, time.Hour),
+	}
+}
+
+// RequestCode sends a login code to a phone number, subject to the per-number
+// and per-IP limits
+func (s *Service) RequestCode(phone, clientIP string) error {
+	phone, err := NormalizePhone(phone)
+	if err != nil {
+		return err
+	}
+	code, err := randomCode()
+	if err != nil {
+		return err
+	}
+
+	o := s.otp
+	now := time.Now()
+
+	o.mu.Lock()
+	o.sweep(now)
+	var wait time.Duration
+	for _, check := range []time.Duration{
+		o.phoneBurst.check(phone, now),
+		o.phoneHourly.check(phone, now),
+		o.ipHourly.check(clientIP, now),
+	} {
+		wait = max(wait, check)
+	}
+	if wait > 0 {
+		o.mu.Unlock()
+		return &RateLimitError{RetryAfter: wait}
+	}
+	o.phoneBurst.record(phone, now)
+	o.phoneHourly.record(phone, now)
+	o.ipHourly.record(clientIP, now)
+	// A new code replaces any outstanding one
+	o.codes[phone] = &otpCode{code: code, expires: now.Add(o.cfg.CodeTTL)}
+	o.mu.Unlock()
+
+	if err := s.sms.SendCode(phone, code, o.cfg.CodeTTL); err != nil {
+		o.mu.Lock()
+		delete(o.codes, phone)
+		o.mu.Unlock()
+		return fmt.Errorf("failed to send code: %w", err)
+	}
+	log.Printf("Sent login code to %s", maskPhone(phone))
+	return nil
+}
+
+// VerifyCode checks a login code and logs the phone number in, creating an
+// account on first login
+func (s *Service) VerifyCode(phone, code, name string) (*Token, error) {
+	phone, err := NormalizePhone(phone)
+	if err != nil {
+		return nil, err
+	}
+
+	o := s.otp
+	o.mu.Lock()
+	entry, ok := o.codes[phone]
+	switch {
+	case !ok:
+		o.mu.Unlock()
+		return nil, ErrInvalidCode
+	case time.Now().After(entry.expires):
+		delete(o.codes, phone)
+		o.mu.Unlock()
+		return nil, ErrInvalidCode
+	case subtle.ConstantTimeCompare([]byte(entry.code), []byte(strings.TrimSpace(code))) != 1:
+		entry.attempts++
+		if entry.attempts >= o.cfg.MaxAttempts {
+			delete(o.codes, phone)
+		}
+		o.mu.Unlock()
+		return nil, ErrInvalidCode
+	}
+	// Codes are single use
+	delete(o.codes, phone)
+	o.mu.Unlock()
+
+	user, err := s.userByPhone(phone, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.applyStaffRole(user); err != nil {
+		return nil, err
+	}
+	return s.issue(user)
+}
+
+// userByPhone returns the account for a phone number, creating it if needed
+func (s *Service) userByPhone(phone, name string) (*models.User, error) {
+	user, err := s.store.GetUserByPhone(phone)
+	if !errors.Is(err, store.ErrNotFound) {
+		return user, err
+	}
+
+	user = &models.User{
+		ID:        uuid.New().String(),
+		Phone:     phone,
+		Name:      strings.TrimSpace(name),
+		Role:      RolePatient,
+		CreatedAt: time.Now(),
+	}
+	err = s.store.CreateUser(user)
+	if errors.Is(err, store.ErrConflict) {
+		// Created by a concurrent login
+		return s.store.GetUserByPhone(phone)
+	}
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Created account %s for phone %s", user.ID, maskPhone(phone))
+	return user, nil
+}
+
+// sweep drops expired codes and idle rate limit entries once a minute
+func (o *otpState) sweep(now time.Time) {
+	if now.Sub(o.lastSweep) < time.Minute {
+		return
+	}
+	o.lastSweep = now
+	for phone, entry := range o.codes {
+		if now.After(entry.expires) {
+			delete(o.codes, phone)
+		}
+	}
+	o.phoneBurst.sweep(now)
+	o.phoneHourly.sweep(now)
+	o.ipHourly.sweep(now)
+}
+
+// randomCode returns a six digit code
+func randomCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", fmt.Errorf("failed to generate code: %w", err)
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// maskPhone hides the middle digits of a number for logs
+func maskPhone(phone string) string {
+	if len(phone) != 11 {
+		return "***"
+	}
+	return phone[:3] + "****" + phone[7:]
+}
+
+// window limits events per key within a sliding period
+type window struct {
+	limit  int
+	period time.Duration
+	events map[string][]time.Time
+}
+
+func newWindow(limit int, period time.Duration) *window {
+	return &window{limit: limit, period: period, events: make(map[string][]time.Time)}
+}
+
+// check returns how long to wait before the key may have another event, or
+// zero if it may have one now
+func (w *window) check(key string, now time.Time) time.Duration {
+	recent := w.prune(key, now)
+	if w.limit <= 0 || len(recent) < w.limit {
+		return 0
+	}
+	// The oldest event in the window must age out first
+	return recent[len(recent)-w.limit].Add(w.period).Sub(now)
+}
+
+func (w *window) record(key string, now time.Time) {
+	w.events[key] = append(w.events[key], now)
+}
+
+// prune drops events older than the period and returns the rest
+func (w *window) prune(key string, now time.Time) []time.Time {
+	events := w.events[key]
+	i := 0
+	for i < len(events) && now.Sub(events[i]) >= w.period {
+		i++
+	}
+	events = events[i:]
+	if len(events) == 0 {
+		delete(w.events, key)
+	} else {
+		w.events[key] = events
+	}
+	return events
+}
+
+func (w *window) sweep(now time.Time) {
+	for key := range w.events {
+		w.prune(key, now)
+	}
+}
@@ -0,0 +1,258 @@
+package auth
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"medseek/internal/store"
+)
+
+const testPhone = "13800000001"
+
+// recordingSMS keeps the last code sent to each number
+type recordingSMS struct {
+	codes map[string]string
+	mu    sync.Mutex
+}
+
+func (r *recordingSMS) SendCode(phone, code string, ttl time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.codes[phone] = code
+	return nil
+}
+
+func (r *recordingSMS) code(phone string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.codes[phone]
+}
+
+// newTestService creates an auth service on an in-memory store that records
+// the codes it sends
+func newTestService(t *testing.T, cfg Config) (*Service, *recordingSMS) {
+	t.Helper()
+	if cfg.Secret == nil {
+		cfg.Secret = []byte("secret")
+	}
+	if cfg.TokenTTL == 0 {
+		cfg.TokenTTL = time.Hour
+	}
+	if cfg.OTP == (OTPConfig{}) {
+		cfg.OTP = DefaultOTPConfig()
+	}
+	sms := &recordingSMS{codes: make(map[string]string)}
+	return NewService(store.NewMemoryStore(), cfg, sms), sms
+}
+
+func TestNormalizePhone(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"13800000001", "13800000001"},
+		{"+86 138-0000-0001", "13800000001"},
+		{"8613800000001", "13800000001"},
+		{"12800000001", ""},
+		{"1380000000", ""},
+		{"abc", ""},
+	}
+	for _, tt := range tests {
+		got, err := NormalizePhone(tt.in)
+		if tt.want == "" {
+			if !errors.Is(err, ErrInvalidPhone) {
+				t.Errorf("NormalizePhone(%q) = %q, %v, want ErrInvalidPhone", tt.in, got, err)
+			}
+		} else if got != tt.want || err != nil {
+			t.Errorf("NormalizePhone(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestVerifyCode(t *testing.T) {
+	s, sms := newTestService(t, Config{})
+	if err := s.RequestCode("+86 138 0000 0001", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	token, err := s.VerifyCode(testPhone, sms.code(testPhone), "张三")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.User.Phone != testPhone || token.User.Name != "张三" || token.User.Role != RolePatient {
+		t.Errorf("user = %+v", token.User)
+	}
+	if user, err := s.Authenticate(token.AccessToken); err != nil || user.ID != token.User.ID {
+		t.Errorf("Authenticate = %+v, %v", user, err)
+	}
+}
+
+func TestVerifyCodeRejectsWrongCodes(t *testing.T) {
+	cfg := Config{OTP: DefaultOTPConfig()}
+	cfg.OTP.MaxAttempts = 2
+	s, sms := newTestService(t, cfg)
+	if err := s.RequestCode(testPhone, "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	code := sms.code(testPhone)
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+
+	if _, err := s.VerifyCode(testPhone, wrong, ""); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("wrong code: err = %v, want ErrInvalidCode", err)
+	}
+	// The last allowed wrong guess discards the code, so the right one fails too
+	if _, err := s.VerifyCode(testPhone, wrong, ""); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("second wrong code: err = %v, want ErrInvalidCode", err)
+	}
+	if _, err := s.VerifyCode(testPhone, code, ""); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("code after too many attempts: err = %v, want ErrInvalidCode", err)
+	}
+}
+
+func TestVerifyCodeRejectsExpiredCodes(t *testing.T) {
+	s, sms := newTestService(t, Config{})
+	if err := s.RequestCode(testPhone, "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	s.otp.mu.Lock()
+	s.otp.codes[testPhone].expires = time.Now().Add(-time.Second)
+	s.otp.mu.Unlock()
+
+	if _, err := s.VerifyCode(testPhone, sms.code(testPhone), ""); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("err = %v, want ErrInvalidCode", err)
+	}
+}
+
+func TestVerifyCodeRejectsReusedCodes(t *testing.T) {
+	s, sms := newTestService(t, Config{})
+	if err := s.RequestCode(testPhone, "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	code := sms.code(testPhone)
+	if _, err := s.VerifyCode(testPhone, code, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.VerifyCode(testPhone, code, ""); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("reused code: err = %v, want ErrInvalidCode", err)
+	}
+}
+
+func TestVerifyCodeWithoutRequest(t *testing.T) {
+	s, _ := newTestService(t, Config{})
+	if _, err := s.VerifyCode(testPhone, "123456", ""); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("err = %v, want ErrInvalidCode", err)
+	}
+}
+
+func TestWindow(t *testing.T) {
+	start := time.Now()
+	w := newWindow(2, time.Hour)
+	w.record("k", start)
+	w.record("k", start.Add(10*time.Minute))
+
+	if wait := w.check("k", start.Add(20*time.Minute)); wait != 40*time.Minute {
+		t.Errorf("full window: wait = %v, want 40m until the oldest event ages out", wait)
+	}
+	if wait := w.check("other", start); wait != 0 {
+		t.Errorf("other key: wait = %v", wait)
+	}
+	// The window slides: once the first event is an hour old one slot frees up
+	if wait := w.check("k", start.Add(time.Hour)); wait != 0 {
+		t.Errorf("after the oldest event aged out: wait = %v", wait)
+	}
+	w.record("k", start.Add(time.Hour))
+	if wait := w.check("k", start.Add(time.Hour)); wait != 10*time.Minute {
+		t.Errorf("full again: wait = %v, want 10m", wait)
+	}
+
+	w.sweep(start.Add(3 * time.Hour))
+	if len(w.events) != 0 {
+		t.Errorf("sweep kept %d keys", len(w.events))
+	}
+}
+
+func TestWindowWithoutLimit(t *testing.T) {
+	w := newWindow(0, time.Hour)
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		w.record("k", now)
+	}
+	if wait := w.check("k", now); wait != 0 {
+		t.Errorf("wait = %v, want no limit", wait)
+	}
+}
+
+// requestCode asks for a code and returns how long it was told to wait
+func requestCode(t *testing.T, s *Service, phone, ip string) time.Duration {
+	t.Helper()
+	err := s.RequestCode(phone, ip)
+	var limited *RateLimitError
+	if errors.As(err, &limited) {
+		if !errors.Is(err, ErrTooManyRequests) {
+			t.Errorf("%v does not match ErrTooManyRequests", err)
+		}
+		return limited.RetryAfter
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return 0
+}
+
+func TestRequestCodePhoneLimits(t *testing.T) {
+	cfg := Config{OTP: DefaultOTPConfig()}
+	cfg.OTP.PhoneHourly = 2
+	s, _ := newTestService(t, cfg)
+
+	if wait := requestCode(t, s, testPhone, "10.0.0.1"); wait != 0 {
+		t.Fatalf("first code limited for %v", wait)
+	}
+	// One code per number per interval, even from another IP
+	if wait := requestCode(t, s, testPhone, "10.0.0.2"); wait <= 0 || wait > time.Minute {
+		t.Errorf("second code at once: wait = %v, want up to a minute", wait)
+	}
+	// Other numbers are not affected
+	if wait := requestCode(t, s, "13800000002", "10.0.0.1"); wait != 0 {
+		t.Errorf("other number limited for %v", wait)
+	}
+
+	// Age the first code past the interval; the hourly limit still allows one more
+	age := func(d time.Duration) {
+		s.otp.mu.Lock()
+		defer s.otp.mu.Unlock()
+		for _, w := range []*window{s.otp.phoneBurst, s.otp.phoneHourly} {
+			for i := range w.events[testPhone] {
+				w.events[testPhone][i] = w.events[testPhone][i].Add(-d)
+			}
+		}
+	}
+	age(2 * time.Minute)
+	if wait := requestCode(t, s, testPhone, "10.0.0.1"); wait != 0 {
+		t.Errorf("code after the interval limited for %v", wait)
+	}
+	age(2 * time.Minute)
+	if wait := requestCode(t, s, testPhone, "10.0.0.1"); wait < 50*time.Minute {
+		t.Errorf("third code in an hour: wait = %v, want the rest of the hour", wait)
+	}
+}
+
+func TestRequestCodeIPLimit(t *testing.T) {
+	cfg := Config{OTP: DefaultOTPConfig()}
+	cfg.OTP.IPHourly = 2
+	s, _ := newTestService(t, cfg)
+
+	for _, phone := range []string{"13800000001", "13800000002"} {
+		if wait := requestCode(t, s, phone, "10.0.0.1"); wait != 0 {
+			t.Fatalf("%s limited for %v", phone, wait)
+		}
+	}
+	if wait := requestCode(t, s, "13800000003", "10.0.0.1"); wait <= 0 {
+		t.Error("third number from the same IP was not limited")
+	}
+	if wait := requestCode(t, s, "13800000003", "10.0.0.2"); wait != 0 {
+		t.Errorf("other IP limited for %v", wait)
+	}
+}
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// SMSSender delivers one-time login codes by text message
+type SMSSender interface {
+	// SendCode sends a login code to a phone number
+	SendCode(phone, code string, ttl time.Duration) error
+}
+
+// FileSMSSender appends codes to a local file instead of sending them, so
+// phone login works in development and tests without an SMS provider account
+type FileSMSSender struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileSMSSender creates a sender that writes codes to path
+func NewFileSMSSender(path string) *FileSMSSender {
+	return &FileSMSSender{path: path}
+}
+
+// SendCode appends a line with the phone number and code to the file
+func (s *FileSMSSender) SendCode(phone, code string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open SMS log: %w", err)
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintf(f, "%s\t%s\t%s\t【信臣健康】您的登录验证码是%s，%d分钟内有效。\n",
+		time.Now().Format(time.RFC3339), phone, code, code, int(ttl.Minutes()))
+	if err != nil {
+		return fmt.Errorf("failed to write SMS log: %w", err)
+	}
+	return nil
+}
+
+// NewSMSSenderFromEnv selects the sender named by SMS_PROVIDER. Only "file"
+// (the default, writing to SMS_LOG_FILE) is built in; Aliyun or Tencent Cloud
+// senders implement SMSSender and are added here.
+func NewSMSSenderFromEnv() (SMSSender, error) {
+	switch provider := os.Getenv("SMS_PROVIDER"); provider {
+	case "file", "":
+		path := os.Getenv("SMS_LOG_FILE")
+		if path == "" {
+			path = "sms_codes.log"
+		}
+		log.Printf("SMS codes are written to %s, not sent", path)
+		return NewFileSMSSender(path), nil
+	default:
+		return nil, fmt.Errorf("unknown SMS provider: %s", provider)
+	}
+}
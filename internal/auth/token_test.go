@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignerRoundTrip(t *testing.T) {
+	s := NewSigner([]byte("secret"), time.Hour)
+	token, expires, err := s.Issue("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := s.Verify(token)
+	if err != nil || claims.Subject != "u1" || claims.ExpiresAt != expires.Unix() {
+		t.Errorf("Verify = %+v, %v", claims, err)
+	}
+}
+
+func TestSignerRejectsTamperedTokens(t *testing.T) {
+	s := NewSigner([]byte("secret"), time.Hour)
+	token, _, err := s.Issue("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(token, ".")
+	encode := base64.RawURLEncoding.EncodeToString
+
+	// flip changes the last character of a part
+	flip := func(part string) string {
+		last := part[len(part)-1]
+		if last == 'A' {
+			return part[:len(part)-1] + "B"
+		}
+		return part[:len(part)-1] + "A"
+	}
+	other, _, _ := NewSigner([]byte("other"), time.Hour).Issue("u1")
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"empty", ""},
+		{"missing signature", parts[0] + "." + parts[1]},
+		{"extra part", token + ".x"},
+		{"alg none header", encode([]byte(`{"alg":"none","typ":"JWT"}`)) + "." + parts[1] + "." + parts[2]},
+		{"changed header", flip(parts[0]) + "." + parts[1] + "." + parts[2]},
+		{"changed subject", parts[0] + "." + encode([]byte(`{"sub":"admin","iat":1,"exp":9999999999}`)) + "." + parts[2]},
+		{"changed signature", parts[0] + "." + parts[1] + "." + flip(parts[2])},
+		{"no signature", parts[0] + "." + parts[1] + "."},
+		{"other secret", other},
+	}
+	for _, tt := range tests {
+		if claims, err := s.Verify(tt.token); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: Verify = %+v, %v, want ErrInvalidToken", tt.name, claims, err)
+		}
+	}
+}
+
+func TestSignerRejectsExpiredTokens(t *testing.T) {
+	s := NewSigner([]byte("secret"), -time.Second)
+	token, _, err := s.Issue("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Verify(token); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("Verify = %v, want ErrTokenExpired", err)
+	}
+}
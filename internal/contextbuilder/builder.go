@@ -0,0 +1,124 @@
+package contextbuilder
+
+import (
+	"os"
+	"strconv"
+
+	"medseek/internal/models"
+)
+
+// Defaults sized for deepseek-chat's 64K context window
+const (
+	DefaultMaxTokens     = 64000
+	DefaultReserveTokens = 4096
+)
+
+// SummaryPrefix introduces the running summary of earlier turns to the model
+const SummaryPrefix = "【既往问诊摘要】以下是本次咨询较早对话的摘要，请结合摘要和后续对话继续问诊:\n"
+
+// Builder assembles the messages sent to the model for one turn: system
+// prompt, as much recent history as fits the token budget, and the new turn
+type Builder struct {
+	// MaxTokens is the model's context window
+	MaxTokens int
+	// ReserveTokens is kept free for the model's reply
+	ReserveTokens int
+	// Estimate returns the token cost of a message's content
+	Estimate func(string) int
+}
+
+// Result is the assembled context for one model call
+type Result struct {
+	Messages []models.DeepSeekMsg
+	// Tokens is the estimated prompt size
+	Tokens int
+	// Dropped is the number of history messages left out to fit the budget
+	Dropped int
+}
+
+// New creates a builder with the given budget
+func New(maxTokens, reserveTokens int) *Builder {
+	return &Builder{
+		MaxTokens:     maxTokens,
+		ReserveTokens: reserveTokens,
+		Estimate:      estimateMessage,
+	}
+}
+
+// NewFromEnv creates a builder configured by CONTEXT_MAX_TOKENS and CONTEXT_RESERVE_TOKENS
+func NewFromEnv() *Builder {
+	return New(
+		envInt("CONTEXT_MAX_TOKENS", DefaultMaxTokens),
+		envInt("CONTEXT_RESERVE_TOKENS", DefaultReserveTokens),
+	)
+}
+
+// Budget returns the number of tokens available for the prompt
+func (b *Builder) Budget() int {
+	return b.MaxTokens - b.ReserveTokens
+}
+
+// Build assembles the context. The system prompt, the summary of earlier turns
+// (if any) and the new turn are always included; history is added newest first
+// until the budget is spent, so the oldest turns are dropped. History never
+// starts with an assistant reply.
+func (b *Builder) Build(systemPrompt, summary string, history []*models.Message, newTurn string) Result {
+	summaryPrompt := ""
+	if summary != "" {
+		summaryPrompt = SummaryPrefix + summary
+	}
+	used := b.Estimate(systemPrompt) + b.Estimate(newTurn)
+	if summaryPrompt != "" {
+		used += b.Estimate(summaryPrompt)
+	}
+
+	start := len(history)
+	for i := len(history) - 1; i >= 0; i-- {
+		cost := b.Estimate(history[i].Content)
+		if used+cost > b.Budget() {
+			break
+		}
+		used += cost
+		start = i
+	}
+
+	// Don't open the conversation with an orphaned assistant reply
+	for start < len(history) && history[start].Role != "user" {
+		used -= b.Estimate(history[start].Content)
+		start++
+	}
+
+	messages := make([]models.DeepSeekMsg, 0, len(history)-start+3)
+	messages = append(messages, models.DeepSeekMsg{Role: "system", Content: systemPrompt})
+	if summaryPrompt != "" {
+		messages = append(messages, models.DeepSeekMsg{Role: "system", Content: summaryPrompt})
+	}
+	for _, msg := range history[start:] {
+		messages = append(messages, chatMessage(msg))
+	}
+	messages = append(messages, models.DeepSeekMsg{Role: "user", Content: newTurn})
+
+	return Result{
+		Messages: messages,
+		Tokens:   used,
+		Dropped:  start,
+	}
+}
+
+// chatMessage converts a stored message to a chat completion message. The
+// model only knows user and assistant turns, so replies from a human doctor
+// become assistant turns marked as such.
+func chatMessage(msg *models.Message) models.DeepSeekMsg {
+	if msg.Role == "doctor" {
+		return models.DeepSeekMsg{Role: "assistant", Content: "【人工医生】" + msg.Content}
+	}
+	return models.DeepSeekMsg{Role: msg.Role, Content: msg.Content}
+}
+
+// envInt reads a positive integer from the environment
+func envInt(name string, fallback int) int {
+	if v, err := strconv.Atoi(os.Getenv(name)); err == nil && v > 0 {
+		return v
+	}
+	return fallback
+}
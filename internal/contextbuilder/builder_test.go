@@ -0,0 +1,143 @@
+package contextbuilder
+
+import (
+	"strings"
+	"testing"
+
+	"medseek/internal/models"
+)
+
+// wordCost estimates one token per byte, so budgets are easy to reason about
+func wordCost(s string) int { return len(s) }
+
+func testBuilder(maxTokens int) *Builder {
+	return &Builder{MaxTokens: maxTokens, Estimate: wordCost}
+}
+
+func history(contents ...string) []*models.Message {
+	msgs := make([]*models.Message, len(contents))
+	for i, c := range contents {
+		role := "user"
+		if i%2 == 1 {
+			role = "assistant"
+		}
+		msgs[i] = &models.Message{Role: role, Content: c}
+	}
+	return msgs
+}
+
+func roles(msgs []models.DeepSeekMsg) string {
+	var b strings.Builder
+	for _, m := range msgs {
+		b.WriteString(m.Role[:1])
+	}
+	return b.String()
+}
+
+func TestBuildKeepsEverythingWithinBudget(t *testing.T) {
+	h := history("u1", "a1", "u2", "a2")
+	res := testBuilder(100).Build("sys", "", h, "new")
+
+	if res.Dropped != 0 {
+		t.Errorf("Dropped = %d, want 0", res.Dropped)
+	}
+	if got := roles(res.Messages); got != "suauau" {
+		t.Errorf("roles = %q, want %q", got, "suauau")
+	}
+	if want := len("sys") + len("new") + 8; res.Tokens != want {
+		t.Errorf("Tokens = %d, want %d", res.Tokens, want)
+	}
+}
+
+func TestBuildDropsOldestTurns(t *testing.T) {
+	h := history("u1", "a1", "u2", "a2", "u3", "a3")
+	// sys + new = 6, leaving room for the last four history messages
+	res := testBuilder(14).Build("sys", "", h, "new")
+
+	if res.Dropped != 2 {
+		t.Errorf("Dropped = %d, want 2", res.Dropped)
+	}
+	if res.Messages[1].Content != "u2" {
+		t.Errorf("first history message = %q, want u2", res.Messages[1].Content)
+	}
+	if res.Tokens > 14 {
+		t.Errorf("Tokens = %d over the budget of 14", res.Tokens)
+	}
+}
+
+func TestBuildNeverStartsWithAssistant(t *testing.T) {
+	h := history("u1", "a1", "u2", "a2")
+	// Room for three history messages: a1 fits but is an orphaned reply
+	res := testBuilder(12).Build("sys", "", h, "new")
+
+	if res.Dropped != 2 {
+		t.Errorf("Dropped = %d, want 2", res.Dropped)
+	}
+	if got := roles(res.Messages); got != "suau" {
+		t.Errorf("roles = %q, want %q", got, "suau")
+	}
+	if want := 6 + 4; res.Tokens != want {
+		t.Errorf("Tokens = %d, want %d", res.Tokens, want)
+	}
+}
+
+func TestBuildKeepsSystemPromptAndSummary(t *testing.T) {
+	h := history("u1", "a1")
+	// The budget is smaller than the fixed parts: history goes, they stay
+	res := testBuilder(1).Build("sys", "earlier", h, "new")
+
+	if res.Dropped != 2 {
+		t.Errorf("Dropped = %d, want 2", res.Dropped)
+	}
+	if got := roles(res.Messages); got != "ssu" {
+		t.Fatalf("roles = %q, want %q", got, "ssu")
+	}
+	if res.Messages[0].Content != "sys" {
+		t.Errorf("system prompt = %q", res.Messages[0].Content)
+	}
+	if res.Messages[1].Content != SummaryPrefix+"earlier" {
+		t.Errorf("summary = %q", res.Messages[1].Content)
+	}
+	if res.Messages[2].Content != "new" {
+		t.Errorf("new turn = %q", res.Messages[2].Content)
+	}
+}
+
+func TestBuildMarksDoctorReplies(t *testing.T) {
+	h := []*models.Message{
+		{Role: "user", Content: "u1"},
+		{Role: "doctor", Content: "d1"},
+	}
+	res := testBuilder(100).Build("sys", "", h, "new")
+
+	if got := res.Messages[2]; got.Role != "assistant" || got.Content != "【人工医生】d1" {
+		t.Errorf("doctor reply = %+v", got)
+	}
+}
+
+func TestEstimateTokens(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"a", 1},          // 0.3 rounds up
+		{"hello", 2},      // 1.5
+		{"0123456789", 3}, // 3.0
+		{"发烧", 2},         // 1.2
+		{"孩子发烧三天了", 5},    // 4.2
+		{"发烧39度", 3},      // 1.2 + 0.6 + 0.6
+		{"é", 1},          // Latin-1 counts as ASCII
+	}
+	for _, tt := range tests {
+		if got := EstimateTokens(tt.text); got != tt.want {
+			t.Errorf("EstimateTokens(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestEstimateMessageAddsOverhead(t *testing.T) {
+	if got, want := estimateMessage("发烧"), 2+messageOverhead; got != want {
+		t.Errorf("estimateMessage = %d, want %d", got, want)
+	}
+}
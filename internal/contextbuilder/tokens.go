@@ -0,0 +1,27 @@
+package contextbuilder
+
+import "unicode"
+
+// messageOverhead approximates the tokens the chat format adds per message
+const messageOverhead = 4
+
+// EstimateTokens approximates the token count of text for DeepSeek's tokenizer.
+// DeepSeek documents roughly 0.6 tokens per Chinese character and 0.3 tokens
+// per English character; we round up so the budget errs on the safe side.
+func EstimateTokens(text string) int {
+	tenths := 0
+	for _, r := range text {
+		// Chinese and other non-Latin characters cost more than ASCII
+		if r > unicode.MaxLatin1 {
+			tenths += 6
+		} else {
+			tenths += 3
+		}
+	}
+	return (tenths + 9) / 10
+}
+
+// estimateMessage approximates the tokens a single chat message costs
+func estimateMessage(content string) int {
+	return EstimateTokens(content) + messageOverhead
+}
@@ -3,11 +3,17 @@ package deepseek
 import (
 	"bufio"
 	"bytes"
+	"cmp"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
+	"math/rand"
 	"net/http"
 	"strings"
+	"time"
 
 	"medseek/internal/models"
 )
@@ -17,99 +23,148 @@ const (
 	DeepSeekModel       = "deepseek-chat"
 )
 
+// Options configures a Client. Zero values fall back to the defaults.
+type Options struct {
+	Endpoint string
+	Model    string
+	// Provider is reported by ModelInfo; defaults to "deepseek"
+	Provider string
+	// Headers are added to every request
+	Headers map[string]string
+	// Timeout bounds a non-streaming request, including retries
+	Timeout time.Duration
+	// StreamTimeout bounds a streaming request from send to last chunk
+	StreamTimeout time.Duration
+	// MaxRetries is how many times a rate-limited or failed request is retried;
+	// negative disables retries
+	MaxRetries int
+	// BaseBackoff is the first retry delay; it doubles on every attempt
+	BaseBackoff time.Duration
+	// MaxBackoff caps a single retry delay
+	MaxBackoff time.Duration
+}
+
+// Default request limits
+const (
+	DefaultTimeout       = 60 * time.Second
+	DefaultStreamTimeout = 3 * time.Minute
+	DefaultMaxRetries    = 3
+	DefaultBaseBackoff   = 500 * time.Millisecond
+	DefaultMaxBackoff    = 10 * time.Second
+)
+
 type Client struct {
 	apiKey     string
+	opts       Options
 	httpClient *http.Client
 }
 
-// NewClient creates a new DeepSeek API client
+// NewClient creates a new DeepSeek API client using the default endpoint and model
 func NewClient(apiKey string) *Client {
+	return NewClientWithOptions(apiKey, Options{})
+}
+
+// NewClientWithModel creates a new DeepSeek API client for a specific endpoint and model
+func NewClientWithModel(apiKey, endpoint, model string) *Client {
+	return NewClientWithOptions(apiKey, Options{Endpoint: endpoint, Model: model})
+}
+
+// NewClientWithOptions creates a new client for any DeepSeek-compatible chat completions API
+func NewClientWithOptions(apiKey string, opts Options) *Client {
+	if opts.Endpoint == "" {
+		opts.Endpoint = DeepSeekAPIEndpoint
+	}
+	if opts.Model == "" {
+		opts.Model = DeepSeekModel
+	}
+	if opts.Provider == "" {
+		opts.Provider = "deepseek"
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.StreamTimeout <= 0 {
+		opts.StreamTimeout = DefaultStreamTimeout
+	}
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = DefaultMaxRetries
+	} else if opts.MaxRetries < 0 {
+		opts.MaxRetries = 0
+	}
+	if opts.BaseBackoff <= 0 {
+		opts.BaseBackoff = DefaultBaseBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = DefaultMaxBackoff
+	}
 	return &Client{
 		apiKey:     apiKey,
+		opts:       opts,
 		httpClient: &http.Client{},
 	}
 }
 
-// ChatCompletion sends a chat request to DeepSeek and returns the response
-func (c *Client) ChatCompletion(messages []models.DeepSeekMsg) (string, error) {
-	req := models.DeepSeekRequest{
-		Model:    DeepSeekModel,
-		Messages: messages,
-		Stream:   false,
-	}
-
-	reqBody, err := json.Marshal(req)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
-	}
-
-	httpReq, err := http.NewRequest("POST", DeepSeekAPIEndpoint, bytes.NewBuffer(reqBody))
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+// ModelInfo returns the provider and model used by this client
+func (c *Client) ModelInfo() models.ModelInfo {
+	return models.ModelInfo{
+		Provider: c.opts.Provider,
+		Model:    c.opts.Model,
+		Endpoint: c.opts.Endpoint,
 	}
+}
 
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.apiKey))
+// ChatCompletion sends a chat request to DeepSeek and returns the response
+func (c *Client) ChatCompletion(ctx context.Context, messages []models.DeepSeekMsg) (models.Completion, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.opts.Timeout)
+	defer cancel()
 
-	resp, err := c.httpClient.Do(httpReq)
+	resp, err := c.send(ctx, messages, false)
 	if err != nil {
-		return "", fmt.Errorf("failed to send request: %w", err)
+		return models.Completion{}, err
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response body: %w", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("api error: status %d, body: %s", resp.StatusCode, string(body))
+		return models.Completion{}, fmt.Errorf("failed to read response body: %w", err)
 	}
 
 	var deepseekResp models.DeepSeekResponse
 	if err := json.Unmarshal(body, &deepseekResp); err != nil {
-		return "", fmt.Errorf("failed to unmarshal response: %w", err)
+		return models.Completion{}, fmt.Errorf("failed to unmarshal response: %w", err)
 	}
 
 	if len(deepseekResp.Choices) == 0 {
-		return "", fmt.Errorf("no choices in response")
-	}
-
-	return deepseekResp.Choices[0].Message.Content, nil
-}
-
-// ChatCompletionStream sends a streaming chat request to DeepSeek
-func (c *Client) ChatCompletionStream(messages []models.DeepSeekMsg, callback func(string) error) error {
-	req := models.DeepSeekRequest{
-		Model:    DeepSeekModel,
-		Messages: messages,
-		Stream:   true,
+		return models.Completion{}, fmt.Errorf("no choices in response")
 	}
 
-	reqBody, err := json.Marshal(req)
-	if err != nil {
-		return fmt.Errorf("failed to marshal request: %w", err)
+	completion := models.Completion{
+		Content:      deepseekResp.Choices[0].Message.Content,
+		Model:        cmp.Or(deepseekResp.Model, c.opts.Model),
+		FinishReason: deepseekResp.Choices[0].FinishReason,
 	}
-
-	httpReq, err := http.NewRequest("POST", DeepSeekAPIEndpoint, bytes.NewBuffer(reqBody))
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+	if deepseekResp.Usage != nil {
+		completion.Usage = deepseekResp.Usage.Usage()
 	}
+	return completion, nil
+}
 
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.apiKey))
+// ChatCompletionStream sends a streaming chat request to DeepSeek. Retries
+// only happen before the first chunk is delivered to callback. The returned
+// completion holds the text streamed so far even when an error cuts it short;
+// usage comes with the last chunk, so it is zero then.
+func (c *Client) ChatCompletionStream(ctx context.Context, messages []models.DeepSeekMsg, callback func(string) error) (models.Completion, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.opts.StreamTimeout)
+	defer cancel()
 
-	resp, err := c.httpClient.Do(httpReq)
+	completion := models.Completion{Model: c.opts.Model}
+	resp, err := c.send(ctx, messages, true)
 	if err != nil {
-		return fmt.Errorf("failed to send request: %w", err)
+		return completion, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("api error: status %d, body: %s", resp.StatusCode, string(body))
-	}
-
+	var content strings.Builder
 	scanner := bufio.NewScanner(resp.Body)
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -120,51 +175,138 @@ func (c *Client) ChatCompletionStream(messages []models.DeepSeekMsg, callback fu
 			}
 
 			var streamResp struct {
+				Model   string `json:"model"`
 				Choices []struct {
 					Delta struct {
 						Content string `json:"content"`
 					} `json:"delta"`
+					FinishReason string `json:"finish_reason"`
 				} `json:"choices"`
+				Usage *models.DeepSeekUsage `json:"usage"`
 			}
 
 			if err := json.Unmarshal([]byte(data), &streamResp); err != nil {
 				continue
 			}
 
-			if len(streamResp.Choices) > 0 && streamResp.Choices[0].Delta.Content != "" {
-				if err := callback(streamResp.Choices[0].Delta.Content); err != nil {
-					return err
+			if streamResp.Model != "" {
+				completion.Model = streamResp.Model
+			}
+			if streamResp.Usage != nil {
+				completion.Usage = streamResp.Usage.Usage()
+			}
+			if len(streamResp.Choices) == 0 {
+				continue
+			}
+			if reason := streamResp.Choices[0].FinishReason; reason != "" {
+				completion.FinishReason = reason
+			}
+			if delta := streamResp.Choices[0].Delta.Content; delta != "" {
+				content.WriteString(delta)
+				if err := callback(delta); err != nil {
+					completion.Content = content.String()
+					return completion, err
 				}
 			}
 		}
 	}
 
-	return scanner.Err()
+	completion.Content = content.String()
+	if err := scanner.Err(); err != nil {
+		if ctx.Err() != nil {
+			return completion, ctx.Err()
+		}
+		return completion, fmt.Errorf("%w: stream interrupted: %w", ErrUpstreamDown, err)
+	}
+	return completion, nil
+}
+
+// send posts the request, retrying rate limits, server errors and network
+// failures with exponential backoff. It gives up at once when ctx is done or
+// the request cannot succeed as sent. On success the caller owns resp.Body.
+func (c *Client) send(ctx context.Context, messages []models.DeepSeekMsg, stream bool) (*http.Response, error) {
+	req := models.DeepSeekRequest{
+		Model:    c.opts.Model,
+		Messages: messages,
+		Stream:   stream,
+	}
+	if stream {
+		req.StreamOptions = &models.StreamOptions{IncludeUsage: true}
+	}
+	reqBody, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := c.sendOnce(ctx, reqBody)
+		if err == nil {
+			return resp, nil
+		}
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if !retryable(err) || attempt >= c.opts.MaxRetries {
+			return nil, err
+		}
+
+		delay := c.backoff(attempt)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > delay {
+			delay = apiErr.RetryAfter
+		}
+		log.Printf("%s request failed (attempt %d/%d), retrying in %v: %v", c.opts.Provider, attempt+1, c.opts.MaxRetries+1, delay, err)
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// sendOnce performs a single HTTP request
+func (c *Client) sendOnce(ctx context.Context, reqBody []byte) (*http.Response, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.opts.Endpoint, bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+	if c.apiKey != "" {
+		httpReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.apiKey))
+	}
+	for name, value := range c.opts.Headers {
+		httpReq.Header.Set(name, value)
+	}
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to send request: %w", ErrUpstreamDown, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, newAPIError(resp, body)
+	}
+
+	return resp, nil
 }
 
-// GetDoctorConsultationPrompt returns a system prompt for doctor consultation with specified specialty
-func GetDoctorConsultationPrompt(specialty string) string {
-	switch specialty {
-	case "pediatrics":
-		return getPediatricsPrompt()
-	case "internal_medicine":
-		return getInternalMedicinePrompt()
-	case "dermatology":
-		return getDermatologyPrompt()
-	case "ent":
-		return getENTPrompt()
-	case "cardiology":
-		return getCardiologyPrompt()
-	case "respiratory":
-		return getRespiratoryPrompt()
-	default:
-		// Default to obstetrics and gynecology
-		return getObstetricsPrompt()
+// backoff returns the delay before retry number attempt: exponential growth
+// with jitter over the upper half so concurrent sessions do not retry in lockstep
+func (c *Client) backoff(attempt int) time.Duration {
+	delay := c.opts.BaseBackoff << attempt
+	if delay <= 0 || delay > c.opts.MaxBackoff {
+		delay = c.opts.MaxBackoff
 	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
 }
 
-// getObstetricsPrompt returns prompt for OB-GYN doctor
-func getObstetricsPrompt() string {
+// ObstetricsPrompt returns prompt for OB-GYN doctor
+func ObstetricsPrompt() string {
 	return `你是一位经验丰富、专业且富有同情心的妇产科在线值班医生。你在信臣健康互联网医院为患者提供专业的妇产科咨询服务。
 
 【角色定位】
@@ -233,8 +375,8 @@ func getObstetricsPrompt() string {
 现在请以温和专业的态度开始咨询，询问患者今天想咨询什么问题。`
 }
 
-// getPediatricsPrompt returns prompt for pediatrics doctor
-func getPediatricsPrompt() string {
+// PediatricsPrompt returns prompt for pediatrics doctor
+func PediatricsPrompt() string {
 	return `你是一位经验丰富、专业且充满爱心的儿科在线值班医生。你在信臣健康互联网医院为家长和儿童患者提供专业的儿科咨询服务。
 
 【角色定位】
@@ -314,8 +456,8 @@ func getPediatricsPrompt() string {
 现在请以温暖亲切的态度开始咨询，询问患儿的年龄和家长想咨询的问题。`
 }
 
-// getInternalMedicinePrompt returns prompt for internal medicine doctor
-func getInternalMedicinePrompt() string {
+// InternalMedicinePrompt returns prompt for internal medicine doctor
+func InternalMedicinePrompt() string {
 	return `你是一位经验丰富、专业的内科在线值班医生。你在信臣健康互联网医院为患者提供专业的内科咨询服务。
 
 你的角色定位:
@@ -354,8 +496,8 @@ func getInternalMedicinePrompt() string {
 现在请以专业沉稳的态度开始咨询，询问患者的主要症状。`
 }
 
-// getDermatologyPrompt returns prompt for dermatology doctor
-func getDermatologyPrompt() string {
+// DermatologyPrompt returns prompt for dermatology doctor
+func DermatologyPrompt() string {
 	return `你是一位经验丰富、专业的皮肤科在线值班医生。你在信臣健康互联网医院为患者提供专业的皮肤科咨询服务。
 
 你的角色定位:
@@ -398,8 +540,8 @@ func getDermatologyPrompt() string {
 现在请以温和耐心的态度开始咨询，询问患者皮肤问题的具体情况。`
 }
 
-// getENTPrompt returns prompt for ENT (ear, nose, throat) doctor
-func getENTPrompt() string {
+// ENTPrompt returns prompt for ENT (ear, nose, throat) doctor
+func ENTPrompt() string {
 	return `你是一位经验丰富、专业的耳鼻喉科在线值班医生。你在信臣健康互联网医院为患者提供专业的耳鼻喉科咨询服务。
 
 你的角色定位:
@@ -442,8 +584,8 @@ func getENTPrompt() string {
 现在请以专业温和的态度开始咨询，询问患者具体的耳鼻喉症状。`
 }
 
-// getCardiologyPrompt returns prompt for cardiology doctor
-func getCardiologyPrompt() string {
+// CardiologyPrompt returns prompt for cardiology doctor
+func CardiologyPrompt() string {
 	return `你是一位经验丰富、专业的心脑血管科在线值班医生。你在信臣健康互联网医院为患者提供专业的心脑血管疾病咨询服务。
 
 你的角色定位:
@@ -487,8 +629,8 @@ func getCardiologyPrompt() string {
 现在请以专业沉稳的态度开始咨询，询问患者的心脑血管症状。`
 }
 
-// getRespiratoryPrompt returns prompt for respiratory medicine doctor
-func getRespiratoryPrompt() string {
+// RespiratoryPrompt returns prompt for respiratory medicine doctor
+func RespiratoryPrompt() string {
 	return `你是一位经验丰富、专业的呼吸科在线值班医生。你在信臣健康互联网医院为患者提供专业的呼吸科咨询服务。
 
 你的角色定位:
@@ -530,3 +672,51 @@ func getRespiratoryPrompt() string {
 
 现在请以专业耐心的态度开始咨询，询问患者的呼吸系统症状。`
 }
+
+// GetSummaryPrompt returns the system prompt for summarizing earlier turns of a consultation
+func GetSummaryPrompt() string {
+	return `你是信臣健康互联网医院的病历整理助手。请根据已有摘要和新增的医患对话，生成一份更新后的临床摘要，供接诊医生继续问诊时参考。
+
+要求:
+1. 只记录对话中明确出现的信息，不要推测或补充
+2. 按以下条目整理(没有的信息写"未提及"):
+   - 主诉
+   - 现病史(症状、部位、持续时间、严重程度、变化过程)
+   - 既往史、用药史、过敏史
+   - 专科相关信息(如月经史、孕周、患儿年龄体重等)
+   - 医生已给出的判断和建议
+   - 尚待明确的问题
+3. 出现任何危险征象时，单独列出"危险征象"一项
+4. 使用简洁的中文条目，总长度不超过500字
+5. 只输出摘要本身，不要任何开场白`
+}
+
+// GetTriagePrompt returns the system prompt for classifying the urgency of a consultation
+func GetTriagePrompt() string {
+	return `你是信臣健康互联网医院的分诊助手。请根据问诊对话评估患者目前病情的紧急程度，只输出一个JSON对象，格式为 {"level": "...", "reason": "..."}，不要输出其他内容。
+
+level 取值:
+- emergency: 可能危及生命，需要立即拨打120或前往急诊
+- urgent: 需要在24小时内到医院就诊
+- routine: 需要门诊就诊，可以预约常规时间
+- self-care: 症状轻微，可以居家观察和自我护理
+
+要求:
+1. 只依据对话中明确出现的信息评估，信息不足时按已知症状中最严重的可能评估
+2. reason 用一句中文说明依据，不超过50字`
+}
+
+// GetRoutingPrompt returns the system prompt for recommending specialties
+// from a chief complaint; specialties lists "id: name" lines
+func GetRoutingPrompt(specialties string) string {
+	return `你是信臣健康互联网医院的导诊助手。请根据患者的主诉推荐最合适的就诊科室，只输出一个JSON数组，不要输出其他内容，格式为:
+[{"specialty": "科室id", "confidence": 0.8, "reason": "推荐理由"}]
+
+可选科室(id: 名称):
+` + specialties + `
+
+要求:
+1. 按推荐程度从高到低列出1到3个科室，confidence 为0到1之间的数字
+2. 患者为儿童时优先推荐儿科，与妊娠、月经相关的问题优先推荐妇产科
+3. reason 用一句中文说明，不超过30字`
+}
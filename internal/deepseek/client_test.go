@@ -0,0 +1,140 @@
+package deepseek
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"medseek/internal/models"
+)
+
+var hello = []models.DeepSeekMsg{{Role: "user", Content: "hi"}}
+
+// testClient talks to url with fast retries
+func testClient(url string) *Client {
+	return NewClientWithOptions("key", Options{
+		Endpoint:    url,
+		MaxRetries:  2,
+		BaseBackoff: time.Millisecond,
+		MaxBackoff:  time.Millisecond,
+	})
+}
+
+// failingServer answers the first failures requests with status and then
+// succeeds; it counts the requests it gets
+func failingServer(t *testing.T, status, failures int) (*httptest.Server, *atomic.Int32) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if int(calls.Add(1)) <= failures {
+			http.Error(w, `{"error":"busy"}`, status)
+			return
+		}
+		fmt.Fprint(w, `{"model":"deepseek-chat","choices":[{"message":{"content":"ok"},"finish_reason":"stop"}],
+			"usage":{"prompt_tokens":5,"completion_tokens":1,"total_tokens":6,"prompt_cache_hit_tokens":3}}`)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func TestRetriesServerErrors(t *testing.T) {
+	srv, calls := failingServer(t, http.StatusServiceUnavailable, 2)
+	got, err := testClient(srv.URL).ChatCompletion(context.Background(), hello)
+	if err != nil || got.Content != "ok" {
+		t.Fatalf("ChatCompletion = %+v, %v", got, err)
+	}
+	if got.Usage.CacheHitTokens != 3 || got.Usage.TotalTokens != 6 {
+		t.Errorf("usage = %+v", got.Usage)
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("requests = %d, want 3", n)
+	}
+}
+
+func TestGivesUpAfterMaxRetries(t *testing.T) {
+	srv, calls := failingServer(t, http.StatusTooManyRequests, 10)
+	_, err := testClient(srv.URL).ChatCompletion(context.Background(), hello)
+	if !errors.Is(err, ErrRateLimited) {
+		t.Errorf("err = %v, want ErrRateLimited", err)
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("requests = %d, want 3", n)
+	}
+}
+
+func TestDoesNotRetryFinalErrors(t *testing.T) {
+	for _, status := range []int{http.StatusBadRequest, http.StatusUnauthorized} {
+		srv, calls := failingServer(t, status, 10)
+		_, err := testClient(srv.URL).ChatCompletion(context.Background(), hello)
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != status {
+			t.Errorf("status %d: err = %v", status, err)
+		}
+		if n := calls.Load(); n != 1 {
+			t.Errorf("status %d: requests = %d, want 1", status, n)
+		}
+	}
+}
+
+func TestDoesNotRetryInvalidRequests(t *testing.T) {
+	c := testClient("http://[::1]:namedport")
+	_, err := c.ChatCompletion(context.Background(), hello)
+	if err == nil || errors.Is(err, ErrUpstreamDown) {
+		t.Errorf("err = %v, want a request error", err)
+	}
+}
+
+func TestStopsWhenCancelled(t *testing.T) {
+	var calls atomic.Int32
+	ctx, cancel := context.WithCancel(context.Background())
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		cancel()
+		http.Error(w, "busy", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	_, err := testClient(srv.URL).ChatCompletion(ctx, hello)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("requests = %d, want 1", n)
+	}
+}
+
+func TestTransportErrorsKeepTheirCause(t *testing.T) {
+	// A closed port refuses the connection on every attempt
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+
+	_, err := testClient(srv.URL).ChatCompletion(context.Background(), hello)
+	var netErr net.Error
+	if !errors.Is(err, ErrUpstreamDown) || !errors.As(err, &netErr) {
+		t.Errorf("err = %v, want ErrUpstreamDown wrapping a net.Error", err)
+	}
+}
+
+func TestStreamCollectsUsage(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "data: {\"model\":\"m1\",\"choices\":[{\"delta\":{\"content\":\"a\"}}]}\n\n")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"b\"},\"finish_reason\":\"stop\"}]}\n\n")
+		fmt.Fprint(w, "data: {\"choices\":[],\"usage\":{\"prompt_tokens\":7,\"completion_tokens\":2,\"total_tokens\":9}}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	var deltas string
+	got, err := testClient(srv.URL).ChatCompletionStream(context.Background(), hello, func(d string) error {
+		deltas += d
+		return nil
+	})
+	if err != nil || got.Content != "ab" || deltas != "ab" || got.Model != "m1" || got.FinishReason != "stop" || got.Usage.TotalTokens != 9 {
+		t.Errorf("stream = %+v (deltas %q), %v", got, deltas, err)
+	}
+}
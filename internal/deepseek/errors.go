@@ -0,0 +1,85 @@
+package deepseek
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error categories returned by the client. Use errors.Is to test for them.
+var (
+	ErrRateLimited    = errors.New("rate limited")
+	ErrAuthFailed     = errors.New("authentication failed")
+	ErrContextTooLong = errors.New("context too long")
+	ErrUpstreamDown   = errors.New("upstream unavailable")
+)
+
+// APIError is returned when the API responds with a non-200 status
+type APIError struct {
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration
+	kind       error
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("api error: status %d, body: %s", e.StatusCode, e.Body)
+}
+
+// Unwrap returns the error category, so errors.Is(err, ErrRateLimited) works
+func (e *APIError) Unwrap() error {
+	return e.kind
+}
+
+// newAPIError classifies a failed response
+func newAPIError(resp *http.Response, body []byte) *APIError {
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		Body:       string(body),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		apiErr.kind = ErrRateLimited
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		apiErr.kind = ErrAuthFailed
+	case resp.StatusCode == http.StatusBadRequest && isContextLengthError(apiErr.Body):
+		apiErr.kind = ErrContextTooLong
+	case resp.StatusCode >= 500:
+		apiErr.kind = ErrUpstreamDown
+	}
+
+	return apiErr
+}
+
+// retryable reports whether a failed request may succeed if sent again: a
+// rate limit, a server error or a network failure. Errors building the
+// request and other API errors are final.
+func retryable(err error) bool {
+	return errors.Is(err, ErrRateLimited) || errors.Is(err, ErrUpstreamDown)
+}
+
+func isContextLengthError(body string) bool {
+	body = strings.ToLower(body)
+	return strings.Contains(body, "context length") || strings.Contains(body, "context_length")
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"medseek/internal/auth"
+	"medseek/internal/models"
+	"medseek/internal/service"
+	"medseek/internal/store"
+)
+
+// CreateDoctorRequest adds a doctor. The doctor logs in by SMS with phone;
+// an account is created for the number if it has none.
+type CreateDoctorRequest struct {
+	Phone          string   `json:"phone"`
+	Name           string   `json:"name"`
+	Specialty      string   `json:"specialty"`
+	LicenseNo      string   `json:"license_no"`
+	Bio            string   `json:"bio"`
+	Qualifications []string `json:"qualifications"`
+}
+
+// AdminDoctors manages the doctor directory: GET lists doctors
+// (?specialty=, ?include_inactive=1), POST creates one, PUT ?id= updates one
+// and DELETE ?id= deactivates one
+func (h *Handler) AdminDoctors(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query()
+		doctors, err := h.chatSvc.ListDoctors(query.Get("specialty"), query.Get("include_inactive") == "1")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(doctors)
+
+	case http.MethodPost:
+		h.createDoctor(w, r)
+
+	case http.MethodPut, http.MethodDelete:
+		doctorID := r.URL.Query().Get("id")
+		if doctorID == "" {
+			http.Error(w, "Missing id", http.StatusBadRequest)
+			return
+		}
+
+		var update service.DoctorUpdate
+		if r.Method == http.MethodDelete {
+			inactive := false
+			update.Active = &inactive
+		} else if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		profile, activeChanged, err := h.chatSvc.UpdateDoctor(doctorID, update)
+		if err != nil {
+			writeDoctorError(w, err)
+			return
+		}
+		// Deactivated doctors lose access to the doctor routes and get it back
+		// when reactivated; other edits leave the role alone
+		if activeChanged {
+			if err := h.auth.SetDoctorAccess(profile.ID, profile.Active); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(profile)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// createDoctor adds a doctor profile and gives its account the doctor role,
+// unless it is an admin
+func (h *Handler) createDoctor(w http.ResponseWriter, r *http.Request) {
+	var req CreateDoctorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	profile := &models.DoctorProfile{
+		Name:           req.Name,
+		Specialty:      req.Specialty,
+		LicenseNo:      req.LicenseNo,
+		Bio:            req.Bio,
+		Qualifications: req.Qualifications,
+	}
+	// Check the profile before creating an account for the phone number
+	if err := h.chatSvc.ValidateDoctor(profile); err != nil {
+		writeDoctorError(w, err)
+		return
+	}
+
+	user, err := h.auth.AccountByPhone(req.Phone, profile.Name)
+	if errors.Is(err, auth.ErrInvalidPhone) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	profile.ID = user.ID
+	if err := h.chatSvc.CreateDoctor(profile); err != nil {
+		writeDoctorError(w, err)
+		return
+	}
+	if err := h.auth.SetDoctorAccess(user.ID, profile.Active); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(profile)
+}
+
+// Doctors lists the active doctors of a specialty for patients, or with ?id=
+// returns one doctor's card. Deactivated doctors are only shown to admins.
+func (h *Handler) Doctors(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	if doctorID := query.Get("id"); doctorID != "" {
+		profile, err := h.chatSvc.GetDoctor(doctorID)
+		if err != nil {
+			writeDoctorError(w, err)
+			return
+		}
+		if user, _ := auth.UserFromContext(r.Context()); !profile.Active && user.Role != auth.RoleAdmin {
+			http.Error(w, "Doctor not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(profile)
+		return
+	}
+
+	doctors, err := h.chatSvc.ListDoctors(query.Get("specialty"), false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(doctors)
+}
+
+// writeDoctorError answers a failed doctor directory operation
+func writeDoctorError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		http.Error(w, "Doctor not found", http.StatusNotFound)
+	case errors.Is(err, service.ErrInvalidDoctor):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, service.ErrDoctorExists), errors.Is(err, service.ErrLicenseTaken):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// TriageStats counts the sessions started in the last ?days= days (default 7)
+// by specialty and triage level
+func (h *Handler) TriageStats(w http.ResponseWriter, r *http.Request) {
+	days := 7
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid days", http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+
+	stats, err := h.chatSvc.TriageStats(time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// ExperimentStats compares the variants of each prompt experiment on the
+// sessions started in the last ?days= days (default 30): escalation rate
+// and patient rating
+func (h *Handler) ExperimentStats(w http.ResponseWriter, r *http.Request) {
+	days := 30
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid days", http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+
+	stats, err := h.chatSvc.ExperimentStats(time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// UsageStats reports the token usage and estimated cost of the model calls
+// made in the last ?days= days (default 30), grouped by ?group_by= session,
+// user, specialty or day (the default), optionally narrowed to one
+// ?session_id=, ?user_id= or ?specialty=
+func (h *Handler) UsageStats(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	days := 30
+	if v := query.Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid days", http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+	groupBy := query.Get("group_by")
+	if groupBy == "" {
+		groupBy = service.GroupByDay
+	}
+
+	report, err := h.chatSvc.UsageStats(service.UsageFilter{
+		Since:     time.Now().AddDate(0, 0, -days),
+		SessionID: query.Get("session_id"),
+		UserID:    query.Get("user_id"),
+		Specialty: query.Get("specialty"),
+	}, groupBy)
+	if errors.Is(err, service.ErrInvalidGrouping) {
+		http.Error(w, "Invalid group_by", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
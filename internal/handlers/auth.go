@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"medseek/internal/auth"
+	"medseek/internal/models"
+	"medseek/internal/store"
+)
+
+// RegisterRequest represents the request to create a patient account
+type RegisterRequest struct {
+	Email    string `json:"email"`
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+// LoginRequest represents the request to log in with email and password
+type LoginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// Register creates a patient account and returns an access token
+func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req RegisterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	token, err := h.auth.Register(req.Email, req.Name, req.Password)
+	switch {
+	case errors.Is(err, auth.ErrInvalidInput):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, auth.ErrEmailTaken):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(token)
+}
+
+// Login checks email and password and returns an access token
+func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	token, err := h.auth.Login(req.Email, req.Password)
+	if errors.Is(err, auth.ErrInvalidCredentials) {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(token)
+}
+
+// OTPRequest asks for an SMS login code
+type OTPRequest struct {
+	Phone string `json:"phone"`
+}
+
+// OTPVerifyRequest logs in with an SMS code; name is used when the phone
+// number has no account yet
+type OTPVerifyRequest struct {
+	Phone string `json:"phone"`
+	Code  string `json:"code"`
+	Name  string `json:"name"`
+}
+
+// RequestOTP sends a login code to a phone number
+func (h *Handler) RequestOTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req OTPRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	err := h.auth.RequestCode(req.Phone, h.auth.ClientIP(r))
+	var limited *auth.RateLimitError
+	switch {
+	case errors.Is(err, auth.ErrInvalidPhone):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.As(err, &limited):
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(limited.RetryAfter.Seconds()))))
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "sent",
+	})
+}
+
+// VerifyOTP checks an SMS login code and returns an access token
+func (h *Handler) VerifyOTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req OTPVerifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	token, err := h.auth.VerifyCode(req.Phone, req.Code, req.Name)
+	switch {
+	case errors.Is(err, auth.ErrInvalidPhone):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, auth.ErrInvalidCode):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(token)
+}
+
+// Me returns the authenticated user
+func (h *Handler) Me(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// Session access levels. The patient and the assigned doctor send messages
+// with their access level as the message role; observers are other doctors
+// reading along.
+const (
+	accessPatient  = "user"
+	accessDoctor   = "doctor"
+	accessObserver = "observer"
+)
+
+// authorizeSession loads a session and checks that the authenticated caller
+// has one of the allowed access levels to it, returning that level. It writes
+// the error response and returns false otherwise.
+func (h *Handler) authorizeSession(w http.ResponseWriter, r *http.Request, sessionID string, allowed ...string) (*models.User, *models.ChatSession, string, bool) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, nil, "", false
+	}
+
+	session, err := h.chatSvc.GetSession(sessionID)
+	if err != nil {
+		writeSessionError(w, err)
+		return nil, nil, "", false
+	}
+	access := sessionAccess(user, session)
+	if access == accessObserver {
+		canObserve, err := h.chatSvc.CanObserve(user, session)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return nil, nil, "", false
+		}
+		if !canObserve {
+			access = ""
+		}
+	}
+	if access == "" || !slices.Contains(allowed, access) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil, nil, "", false
+	}
+	return user, session, access, true
+}
+
+// sessionAccess returns the caller's access level to a session, or "" for
+// none. Doctors get observer access to sessions they do not hold, which
+// authorizeSession narrows with ChatService.CanObserve.
+func sessionAccess(user *models.User, session *models.ChatSession) string {
+	switch {
+	case session.UserID == user.ID:
+		return accessPatient
+	case user.Role != auth.RoleDoctor:
+		return ""
+	case session.DoctorID == user.ID:
+		return accessDoctor
+	default:
+		return accessObserver
+	}
+}
+
+// writeSessionError answers a failed session lookup
+func writeSessionError(w http.ResponseWriter, err error) {
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
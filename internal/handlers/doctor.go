@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"medseek/internal/auth"
+	"medseek/internal/models"
+	"medseek/internal/service"
+	"medseek/internal/store"
+	"medseek/internal/triage"
+)
+
+// DoctorQueue lists the active sessions the calling doctor may see for the
+// doctor workstation, patients waiting for a doctor first and the most
+// urgent first within each mode. ?specialty= and ?triage= narrow the list.
+func (h *Handler) DoctorQueue(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+	query := r.URL.Query()
+	if level := query.Get("triage"); level != "" && !triage.Valid(level) {
+		http.Error(w, "Unknown triage level", http.StatusBadRequest)
+		return
+	}
+
+	queue, err := h.chatSvc.DoctorQueue(user, query.Get("specialty"), query.Get("triage"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(queue)
+}
+
+// DoctorSessionResponse is a session with its full history
+type DoctorSessionResponse struct {
+	Session  *models.ChatSession `json:"session"`
+	Messages []*models.Message   `json:"messages"`
+}
+
+// DoctorSession returns a session and every message in it, so a doctor can
+// read the case before claiming it
+func (h *Handler) DoctorSession(w http.ResponseWriter, r *http.Request) {
+	sessionID := r.URL.Query().Get("session_id")
+
+	if sessionID == "" {
+		http.Error(w, "Missing session_id", http.StatusBadRequest)
+		return
+	}
+
+	_, session, _, ok := h.authorizeSession(w, r, sessionID, accessDoctor, accessObserver)
+	if !ok {
+		return
+	}
+
+	messages, err := h.chatSvc.GetSessionMessages(sessionID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(DoctorSessionResponse{
+		Session:  session,
+		Messages: messages,
+	})
+}
+
+// DoctorQueueSocket streams queue frames to a doctor workstation over
+// WebSocket, or over Server-Sent Events with transport=sse. Only doctors with
+// an active profile may subscribe, and they only hear of sessions they hold
+// or may observe. Frames from the workstation other than hello are rejected
+// as read only.
+func (h *Handler) DoctorQueueSocket(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+
+	profile, err := h.chatSvc.GetDoctor(user.ID)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		http.Error(w, "Doctor profile required", http.StatusForbidden)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	case !profile.Active:
+		http.Error(w, "Doctor is deactivated", http.StatusForbidden)
+		return
+	}
+
+	if r.URL.Query().Get("transport") == "sse" {
+		h.hub.ServeQueueEvents(w, r, profile)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		http.Error(w, "Could not upgrade connection", http.StatusInternalServerError)
+		return
+	}
+
+	h.hub.HandleQueueConnection(conn, profile)
+}
+
+// AvailabilityRequest sets whether a doctor is taking patients
+type AvailabilityRequest struct {
+	Available bool `json:"available"`
+}
+
+// DoctorProfile returns the calling doctor's profile, or on POST sets their
+// availability. Doctors an admin has not onboarded have no profile.
+func (h *Handler) DoctorProfile(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+
+	var profile *models.DoctorProfile
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		profile, err = h.chatSvc.GetDoctorProfile(user)
+	case http.MethodPost:
+		var req AvailabilityRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		profile, err = h.chatSvc.SetDoctorAvailable(user, req.Available)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		http.Error(w, "Doctor profile not found", http.StatusNotFound)
+		return
+	case errors.Is(err, service.ErrDoctorUnavailable), errors.Is(err, service.ErrInvalidDoctor):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profile)
+}
+
+// ClaimSession assigns a session to the calling doctor and pauses AI replies
+func (h *Handler) ClaimSession(w http.ResponseWriter, r *http.Request) {
+	h.changeHandoff(w, r, h.chatSvc.AssignDoctor)
+}
+
+// ReleaseSession hands a session the calling doctor holds back to AI
+func (h *Handler) ReleaseSession(w http.ResponseWriter, r *http.Request) {
+	h.changeHandoff(w, r, h.chatSvc.ReturnToAI)
+}
+
+// changeHandoff applies a doctor's mode change to the session named by
+// session_id and announces it to the session's clients
+func (h *Handler) changeHandoff(w http.ResponseWriter, r *http.Request, change func(sessionID, doctorID string) (*models.ChatSession, error)) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID := r.URL.Query().Get("session_id")
+
+	if sessionID == "" {
+		http.Error(w, "Missing session_id", http.StatusBadRequest)
+		return
+	}
+
+	// Doctors may only act on sessions they hold or may observe
+	user, _, _, ok := h.authorizeSession(w, r, sessionID, accessDoctor, accessObserver)
+	if !ok {
+		return
+	}
+
+	session, err := change(sessionID, user.ID)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	case errors.Is(err, service.ErrAlreadyAssigned), errors.Is(err, service.ErrSessionClosed):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, service.ErrNotAssigned), errors.Is(err, service.ErrDoctorUnavailable):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.hub.AnnounceHandoff(session)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(session)
+}
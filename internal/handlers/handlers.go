@@ -2,59 +2,88 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 
+	"medseek/internal/auth"
+	"medseek/internal/models"
 	"medseek/internal/service"
+	"medseek/internal/store"
 	wshub "medseek/internal/websocket"
 )
 
 type Handler struct {
 	chatSvc *service.ChatService
 	hub     *wshub.Hub
+	auth    *auth.Service
 }
 
 // NewHandler creates a new handler
-func NewHandler(chatSvc *service.ChatService, hub *wshub.Hub) *Handler {
+func NewHandler(chatSvc *service.ChatService, hub *wshub.Hub, authSvc *auth.Service) *Handler {
 	return &Handler{
 		chatSvc: chatSvc,
 		hub:     hub,
+		auth:    authSvc,
 	}
 }
 
 // CreateSessionRequest represents the request to create a new session
 type CreateSessionRequest struct {
-	UserID    string `json:"user_id"`
-	Specialty string `json:"specialty"` // "obstetrics" or "pediatrics"
+	Specialty  string `json:"specialty"`             // an enabled id from GET /api/specialties
+	PatientAge string `json:"patient_age,omitempty"` // optional, such as "3岁"
 }
 
+// maxPatientAgeLength caps the patient age given at session start, in characters
+const maxPatientAgeLength = 20
+
 // CreateSessionResponse represents the response when creating a session
 type CreateSessionResponse struct {
 	SessionID string `json:"session_id"`
 	Status    string `json:"status"`
 }
 
-// CreateSession creates a new chat session
+// CreateSession creates a new chat session owned by the authenticated user
 func (h *Handler) CreateSession(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
+	user, _ := auth.UserFromContext(r.Context())
+
 	var req CreateSessionRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
+	// The age goes into the system prompt, so it must stay a short phrase
+	if utf8.RuneCountInString(req.PatientAge) > maxPatientAgeLength {
+		http.Error(w, "Invalid patient_age", http.StatusBadRequest)
+		return
+	}
+
 	sessionID := uuid.New().String()
-	if req.Specialty == "" {
-		req.Specialty = "obstetrics" // default specialty
+	session, err := h.chatSvc.CreateSession(sessionID, user.ID, req.Specialty, req.PatientAge)
+	switch {
+	case errors.Is(err, service.ErrUnknownSpecialty):
+		http.Error(w, "Unknown specialty", http.StatusBadRequest)
+		return
+	case errors.Is(err, service.ErrSpecialtyDisabled):
+		http.Error(w, "Specialty is not taking patients", http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	session := h.chatSvc.CreateSession(sessionID, req.UserID, req.Specialty)
+	h.hub.NotifyQueue(session)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(CreateSessionResponse{
@@ -74,13 +103,24 @@ var upgrader = websocket.Upgrader{
 	HandshakeTimeout: 45 * time.Second,
 }
 
-// WebSocket handles WebSocket connections
+// WebSocket handles WebSocket connections to a session the caller owns
 func (h *Handler) WebSocket(w http.ResponseWriter, r *http.Request) {
 	sessionID := r.URL.Query().Get("session_id")
-	userID := r.URL.Query().Get("user_id")
 
-	if sessionID == "" || userID == "" {
-		http.Error(w, "Missing session_id or user_id", http.StatusBadRequest)
+	if sessionID == "" {
+		http.Error(w, "Missing session_id", http.StatusBadRequest)
+		return
+	}
+
+	// last_seq asks for replay of every message after that sequence number
+	lastSeq, err := parseLastSeq(r.URL.Query().Get("last_seq"))
+	if err != nil {
+		http.Error(w, "Invalid last_seq", http.StatusBadRequest)
+		return
+	}
+
+	user, _, access, ok := h.authorizeSession(w, r, sessionID, accessPatient, accessDoctor, accessObserver)
+	if !ok {
 		return
 	}
 
@@ -90,7 +130,198 @@ func (h *Handler) WebSocket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.hub.HandleConnection(conn, userID, sessionID)
+	h.hub.HandleConnection(conn, user.ID, access, sessionID, lastSeq)
+}
+
+// parseLastSeq reads an optional last_seq value; -1 means no replay
+func parseLastSeq(v string) (int, error) {
+	if v == "" {
+		return -1, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, errors.New("invalid last_seq")
+	}
+	return n, nil
+}
+
+// SendMessageRequest is a client frame submitted over HTTP instead of WebSocket
+type SendMessageRequest struct {
+	SessionID   string `json:"session_id"`
+	Type        string `json:"type"` // "message" (default), "stop", "typing", "handoff" or "transfer"
+	ClientMsgID string `json:"client_msg_id"`
+	Content     string `json:"content"`
+	Specialty   string `json:"specialty,omitempty"` // target of a transfer
+}
+
+// SendMessage submits a patient turn for clients on the SSE or long-poll
+// transport. The response is the ack or error frame a WebSocket client would
+// get; everything else arrives on the session's event stream.
+func (h *Handler) SendMessage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req SendMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.SessionID == "" {
+		http.Error(w, "Missing session_id", http.StatusBadRequest)
+		return
+	}
+	if req.Type == "" {
+		req.Type = wshub.FrameMessage
+	}
+
+	user, _, access, ok := h.authorizeSession(w, r, req.SessionID, accessPatient, accessDoctor)
+	if !ok {
+		return
+	}
+
+	reply := h.hub.Submit(user.ID, access, req.SessionID, models.WebSocketMessage{
+		Type:        req.Type,
+		ClientMsgID: req.ClientMsgID,
+		Content:     req.Content,
+		Specialty:   req.Specialty,
+		UserID:      user.ID,
+		SessionID:   req.SessionID,
+	})
+
+	// stop, typing, handoff and transfer have no reply of their own
+	if reply.Type == "" {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	status := http.StatusOK
+	switch reply.Code {
+	case wshub.ErrCodeEmptyMessage, wshub.ErrCodeUnknownType, wshub.ErrCodeUnknownSpecialty:
+		status = http.StatusBadRequest
+	case wshub.ErrCodeQueueFull:
+		status = http.StatusTooManyRequests
+	case wshub.ErrCodeNotAssigned:
+		status = http.StatusForbidden
+	case wshub.ErrCodeSessionClosed:
+		status = http.StatusConflict
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(wshub.StampFrame(reply))
+}
+
+// Specialties lists the specialties patients can consult, in display order,
+// with the texts the frontend shows for each. System prompts and routing
+// clues are not included.
+func (h *Handler) Specialties(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	list := h.chatSvc.Specialties(false)
+	for i := range list {
+		list[i].Prompt = ""
+		list[i].Clues = nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// RecommendSpecialtiesRequest carries a patient's chief complaint
+type RecommendSpecialtiesRequest struct {
+	Complaint string `json:"complaint"`
+}
+
+// RecommendSpecialties suggests the specialties to consult for a chief
+// complaint, most confident first, before a session is created
+func (h *Handler) RecommendSpecialties(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req RecommendSpecialtiesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Complaint) == "" {
+		http.Error(w, "Missing complaint", http.StatusBadRequest)
+		return
+	}
+
+	user, _ := auth.UserFromContext(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.chatSvc.RecommendSpecialties(r.Context(), user.ID, req.Complaint))
+}
+
+// SessionEvents streams a session's frames over Server-Sent Events, or answers
+// a single long-poll with transport=poll. Both share the hub's session fan-out
+// with WebSocket clients.
+func (h *Handler) SessionEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	sessionID := query.Get("session_id")
+
+	if sessionID == "" {
+		http.Error(w, "Missing session_id", http.StatusBadRequest)
+		return
+	}
+
+	// A reconnecting EventSource sends the last event id instead of last_seq
+	seqParam := query.Get("last_seq")
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		seqParam = id
+	}
+	lastSeq, err := parseLastSeq(seqParam)
+	if err != nil {
+		http.Error(w, "Invalid last_seq", http.StatusBadRequest)
+		return
+	}
+
+	user, _, _, ok := h.authorizeSession(w, r, sessionID, accessPatient, accessDoctor, accessObserver)
+	if !ok {
+		return
+	}
+
+	if query.Get("transport") == "poll" {
+		resp, err := h.hub.Poll(r, query.Get("poll_id"), user.ID, sessionID, lastSeq)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	h.hub.ServeEvents(w, r, user.ID, sessionID, lastSeq)
+}
+
+// GetSession returns a session, including its mode, red flags and triage level
+func (h *Handler) GetSession(w http.ResponseWriter, r *http.Request) {
+	sessionID := r.URL.Query().Get("session_id")
+
+	if sessionID == "" {
+		http.Error(w, "Missing session_id", http.StatusBadRequest)
+		return
+	}
+
+	_, session, _, ok := h.authorizeSession(w, r, sessionID, accessPatient, accessDoctor, accessObserver)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(session)
 }
 
 // GetSessionMessages returns messages for a session
@@ -102,13 +333,45 @@ func (h *Handler) GetSessionMessages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	messages := h.chatSvc.GetSessionMessages(sessionID)
+	if _, _, _, ok := h.authorizeSession(w, r, sessionID, accessPatient, accessDoctor, accessObserver); !ok {
+		return
+	}
+
+	messages, err := h.chatSvc.GetSessionMessages(sessionID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(messages)
 }
 
-// CloseSession closes a chat session
+// GetSessionSummary returns the running clinical summary of a session
+func (h *Handler) GetSessionSummary(w http.ResponseWriter, r *http.Request) {
+	sessionID := r.URL.Query().Get("session_id")
+
+	if sessionID == "" {
+		http.Error(w, "Missing session_id", http.StatusBadRequest)
+		return
+	}
+
+	if _, _, _, ok := h.authorizeSession(w, r, sessionID, accessPatient, accessDoctor, accessObserver); !ok {
+		return
+	}
+
+	summary, err := h.chatSvc.GetSummary(sessionID)
+	if err != nil {
+		writeSessionError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
+
+// CloseSession closes a chat session, stopping the reply being generated and
+// discarding queued messages
 func (h *Handler) CloseSession(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -122,22 +385,77 @@ func (h *Handler) CloseSession(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := h.chatSvc.CloseSession(sessionID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+	_, session, _, ok := h.authorizeSession(w, r, sessionID, accessPatient, accessDoctor)
+	if !ok {
 		return
 	}
 
+	if err := h.chatSvc.CloseSession(sessionID); err != nil {
+		writeSessionError(w, err)
+		return
+	}
+	session.Status = store.StatusClosed
+	// Stop paying for replies nobody may read
+	h.hub.DropTurns(sessionID)
+	h.hub.NotifyQueue(session)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
 		"status": "closed",
 	})
 }
 
-// Health check endpoint
+type RateSessionRequest struct {
+	Rating  int    `json:"rating"` // 1-5
+	Comment string `json:"comment,omitempty"`
+}
+
+// RateSession stores the patient's rating of a consultation, before or
+// after closing it
+func (h *Handler) RateSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID := r.URL.Query().Get("session_id")
+	if sessionID == "" {
+		http.Error(w, "Missing session_id", http.StatusBadRequest)
+		return
+	}
+
+	var req RateSessionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if _, _, _, ok := h.authorizeSession(w, r, sessionID, accessPatient); !ok {
+		return
+	}
+
+	session, err := h.chatSvc.RateSession(sessionID, req.Rating, req.Comment)
+	switch {
+	case errors.Is(err, service.ErrInvalidRating):
+		http.Error(w, "Invalid rating", http.StatusBadRequest)
+		return
+	case errors.Is(err, service.ErrSessionClosed):
+		http.Error(w, "Session is archived", http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(session)
+}
+
+// Health check endpoint, including WebSocket connection counters
 func (h *Handler) Health(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"status": "ok",
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    "ok",
+		"websocket": h.hub.Stats(),
 	})
 }
@@ -0,0 +1,28 @@
+package llm
+
+import (
+	"strings"
+
+	"medseek/internal/deepseek"
+)
+
+// OpenAIClient talks to any OpenAI-compatible chat completions API, such as
+// self-hosted vLLM or Ollama gateways. It is the DeepSeek client pointed at
+// another endpoint, so it behaves as DeepSeek does: it asks for usage in the
+// last stream chunk, reads cache hits from DeepSeek's prompt_cache_hit_tokens
+// before OpenAI's prompt_tokens_details.cached_tokens, and classifies errors
+// by status code, spotting context overflows by "context length" in the body.
+// Backends that differ from DeepSeek there need a client of their own.
+type OpenAIClient struct {
+	*deepseek.Client
+}
+
+// NewOpenAIClient creates a new client for an OpenAI-compatible API
+func NewOpenAIClient(baseURL, apiKey, model string, headers map[string]string, limits deepseek.Options) *OpenAIClient {
+	opts := limits
+	opts.Endpoint = strings.TrimSuffix(baseURL, "/") + "/chat/completions"
+	opts.Model = model
+	opts.Provider = ProviderOpenAI
+	opts.Headers = headers
+	return &OpenAIClient{Client: deepseek.NewClientWithOptions(apiKey, opts)}
+}
@@ -0,0 +1,83 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"medseek/internal/deepseek"
+)
+
+// openAIServer answers like the OpenAI API: cache hits only under
+// prompt_tokens_details and errors in OpenAI's shape
+func openAIServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" || r.Header.Get("Authorization") != "Bearer key" || r.Header.Get("X-Tenant") != "medseek" {
+			http.Error(w, `{"error":{"message":"Incorrect API key provided","type":"invalid_request_error","code":"invalid_api_key"}}`, http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("X-Too-Long") != "" {
+			http.Error(w, `{"error":{"message":"This model's maximum context length is 8192 tokens.","type":"invalid_request_error","code":"context_length_exceeded"}}`, http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get("X-Stream") != "" {
+			fmt.Fprint(w, "data: {\"id\":\"c1\",\"object\":\"chat.completion.chunk\",\"model\":\"gpt-4o-mini-2024-07-18\",\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\",\"content\":\"\"},\"finish_reason\":null}]}\n\n")
+			fmt.Fprint(w, "data: {\"id\":\"c1\",\"object\":\"chat.completion.chunk\",\"model\":\"gpt-4o-mini-2024-07-18\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"你好\"},\"finish_reason\":null}]}\n\n")
+			fmt.Fprint(w, "data: {\"id\":\"c1\",\"object\":\"chat.completion.chunk\",\"model\":\"gpt-4o-mini-2024-07-18\",\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}]}\n\n")
+			fmt.Fprint(w, "data: {\"id\":\"c1\",\"object\":\"chat.completion.chunk\",\"model\":\"gpt-4o-mini-2024-07-18\",\"choices\":[],\"usage\":{\"prompt_tokens\":1200,\"completion_tokens\":2,\"total_tokens\":1202,\"prompt_tokens_details\":{\"cached_tokens\":1024,\"audio_tokens\":0},\"completion_tokens_details\":{\"reasoning_tokens\":0}}}\n\n")
+			fmt.Fprint(w, "data: [DONE]\n\n")
+			return
+		}
+		fmt.Fprint(w, `{"id":"c2","object":"chat.completion","model":"gpt-4o-mini-2024-07-18",
+			"choices":[{"index":0,"message":{"role":"assistant","content":"你好","refusal":null},"logprobs":null,"finish_reason":"stop"}],
+			"usage":{"prompt_tokens":1200,"completion_tokens":2,"total_tokens":1202,
+				"prompt_tokens_details":{"cached_tokens":1024,"audio_tokens":0},
+				"completion_tokens_details":{"reasoning_tokens":0,"audio_tokens":0}}}`)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestOpenAIResponses(t *testing.T) {
+	srv := openAIServer(t)
+	limits := deepseek.Options{MaxRetries: -1}
+	c := NewOpenAIClient(srv.URL+"/v1/", "key", "gpt-4o-mini", map[string]string{"X-Tenant": "medseek"}, limits)
+
+	got, err := c.ChatCompletion(context.Background(), userTurn("hi"))
+	if err != nil || got.Content != "你好" || got.Model != "gpt-4o-mini-2024-07-18" || got.FinishReason != "stop" {
+		t.Fatalf("ChatCompletion = %+v, %v", got, err)
+	}
+	if got.Usage.PromptTokens != 1200 || got.Usage.CacheHitTokens != 1024 || got.Usage.TotalTokens != 1202 {
+		t.Errorf("usage = %+v, want 1024 cached of 1200 prompt tokens", got.Usage)
+	}
+
+	streaming := NewOpenAIClient(srv.URL+"/v1", "key", "gpt-4o-mini", map[string]string{"X-Tenant": "medseek", "X-Stream": "1"}, limits)
+	got, err = streaming.ChatCompletionStream(context.Background(), userTurn("hi"), func(string) error { return nil })
+	if err != nil || got.Content != "你好" || got.FinishReason != "stop" || got.Usage.CacheHitTokens != 1024 || got.Usage.CompletionTokens != 2 {
+		t.Errorf("ChatCompletionStream = %+v, %v", got, err)
+	}
+
+	if info := c.ModelInfo(); info.Provider != ProviderOpenAI || info.Endpoint != srv.URL+"/v1/chat/completions" {
+		t.Errorf("ModelInfo = %+v", info)
+	}
+}
+
+func TestOpenAIErrors(t *testing.T) {
+	srv := openAIServer(t)
+	limits := deepseek.Options{MaxRetries: -1}
+
+	_, err := NewOpenAIClient(srv.URL+"/v1", "wrong", "gpt-4o-mini", map[string]string{"X-Tenant": "medseek"}, limits).
+		ChatCompletion(context.Background(), userTurn("hi"))
+	if !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("bad key: err = %v, want ErrAuthFailed", err)
+	}
+
+	_, err = NewOpenAIClient(srv.URL+"/v1", "key", "gpt-4o-mini", map[string]string{"X-Tenant": "medseek", "X-Too-Long": "1"}, limits).
+		ChatCompletion(context.Background(), userTurn("hi"))
+	if !errors.Is(err, ErrContextTooLong) {
+		t.Errorf("long context: err = %v, want ErrContextTooLong", err)
+	}
+}
@@ -0,0 +1,145 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"medseek/internal/deepseek"
+	"medseek/internal/models"
+)
+
+// Provider is implemented by every LLM backend the chat service can talk to
+type Provider interface {
+	// ChatCompletion sends a chat request and returns the full response
+	ChatCompletion(ctx context.Context, messages []models.DeepSeekMsg) (models.Completion, error)
+	// ChatCompletionStream sends a chat request and calls callback for each
+	// streamed chunk. The completion holds whatever was streamed, even on error.
+	ChatCompletionStream(ctx context.Context, messages []models.DeepSeekMsg, callback func(string) error) (models.Completion, error)
+	// ModelInfo describes the provider and model in use
+	ModelInfo() models.ModelInfo
+}
+
+// Error categories shared by all providers. Use errors.Is to test for them.
+var (
+	ErrRateLimited    = deepseek.ErrRateLimited
+	ErrAuthFailed     = deepseek.ErrAuthFailed
+	ErrContextTooLong = deepseek.ErrContextTooLong
+	ErrUpstreamDown   = deepseek.ErrUpstreamDown
+)
+
+var (
+	_ Provider = (*deepseek.Client)(nil)
+	_ Provider = (*OpenAIClient)(nil)
+	_ Provider = (*ScriptedProvider)(nil)
+)
+
+// Provider names accepted in Config.Provider
+const (
+	ProviderDeepSeek = "deepseek"
+	ProviderOpenAI   = "openai"
+	ProviderScripted = "scripted"
+)
+
+// Config selects and configures an LLM provider
+type Config struct {
+	Provider   string
+	APIKey     string
+	BaseURL    string
+	Model      string
+	Headers    map[string]string
+	ScriptFile string
+	// Limits holds timeouts and retry settings for HTTP providers
+	Limits deepseek.Options
+}
+
+// ConfigFromEnv reads the provider configuration from environment variables.
+// DEEPSEEK_API_KEY is used as the API key when LLM_API_KEY is not set.
+func ConfigFromEnv() Config {
+	cfg := Config{
+		Provider:   strings.ToLower(os.Getenv("LLM_PROVIDER")),
+		APIKey:     os.Getenv("LLM_API_KEY"),
+		BaseURL:    os.Getenv("LLM_BASE_URL"),
+		Model:      os.Getenv("LLM_MODEL"),
+		Headers:    parseHeaders(os.Getenv("LLM_HEADERS")),
+		ScriptFile: os.Getenv("LLM_SCRIPT_FILE"),
+		Limits: deepseek.Options{
+			Timeout:       envDuration("LLM_TIMEOUT"),
+			StreamTimeout: envDuration("LLM_STREAM_TIMEOUT"),
+		},
+	}
+	if v, err := strconv.Atoi(os.Getenv("LLM_MAX_RETRIES")); err == nil {
+		cfg.Limits.MaxRetries = v
+		if v == 0 {
+			cfg.Limits.MaxRetries = -1
+		}
+	}
+	if cfg.Provider == "" {
+		cfg.Provider = ProviderDeepSeek
+	}
+	if cfg.APIKey == "" {
+		cfg.APIKey = os.Getenv("DEEPSEEK_API_KEY")
+	}
+	return cfg
+}
+
+// NewProvider creates the provider named in the configuration
+func NewProvider(cfg Config) (Provider, error) {
+	switch cfg.Provider {
+	case ProviderDeepSeek, "":
+		if cfg.APIKey == "" {
+			return nil, fmt.Errorf("DEEPSEEK_API_KEY environment variable is required")
+		}
+		opts := cfg.Limits
+		if cfg.BaseURL != "" {
+			opts.Endpoint = strings.TrimSuffix(cfg.BaseURL, "/") + "/chat/completions"
+		}
+		opts.Model = cfg.Model
+		opts.Headers = cfg.Headers
+		return deepseek.NewClientWithOptions(cfg.APIKey, opts), nil
+	case ProviderOpenAI:
+		if cfg.BaseURL == "" {
+			return nil, fmt.Errorf("LLM_BASE_URL is required for the openai provider")
+		}
+		if cfg.Model == "" {
+			return nil, fmt.Errorf("LLM_MODEL is required for the openai provider")
+		}
+		return NewOpenAIClient(cfg.BaseURL, cfg.APIKey, cfg.Model, cfg.Headers, cfg.Limits), nil
+	case ProviderScripted:
+		if cfg.ScriptFile == "" {
+			return NewScriptedProvider(), nil
+		}
+		return NewScriptedProviderFromFile(cfg.ScriptFile)
+	default:
+		return nil, fmt.Errorf("unknown LLM provider: %s", cfg.Provider)
+	}
+}
+
+// envDuration reads a duration such as "90s"; invalid or missing values yield zero (the default)
+func envDuration(name string) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(name))
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
+// parseHeaders parses "Name: value" pairs separated by semicolons
+func parseHeaders(raw string) map[string]string {
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(raw, ";") {
+		name, value, ok := strings.Cut(pair, ":")
+		if !ok {
+			continue
+		}
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		headers[name] = strings.TrimSpace(value)
+	}
+	return headers
+}
@@ -0,0 +1,128 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"medseek/internal/models"
+)
+
+// ScriptedProvider returns canned responses in order. It makes no network
+// calls, so it is useful for tests and local development without an API key.
+type ScriptedProvider struct {
+	responses []string
+	next      int
+	requests  [][]models.DeepSeekMsg
+	mu        sync.Mutex
+}
+
+// NewScriptedProvider creates a provider that replies with the given responses
+// in order, repeating the last one once the script is exhausted. With no
+// responses it echoes the latest user message.
+func NewScriptedProvider(responses ...string) *ScriptedProvider {
+	return &ScriptedProvider{responses: responses}
+}
+
+// NewScriptedProviderFromFile loads responses from a file, separated by lines
+// containing only "---"
+func NewScriptedProviderFromFile(path string) (*ScriptedProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read script file: %w", err)
+	}
+
+	var responses []string
+	for _, part := range strings.Split(string(data), "\n---\n") {
+		if part = strings.TrimSpace(part); part != "" {
+			responses = append(responses, part)
+		}
+	}
+
+	return NewScriptedProvider(responses...), nil
+}
+
+// ModelInfo returns the provider and model used by this provider
+func (p *ScriptedProvider) ModelInfo() models.ModelInfo {
+	return models.ModelInfo{
+		Provider: ProviderScripted,
+		Model:    "scripted",
+	}
+}
+
+// Requests returns every message list the provider has received
+func (p *ScriptedProvider) Requests() [][]models.DeepSeekMsg {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([][]models.DeepSeekMsg(nil), p.requests...)
+}
+
+// ChatCompletion returns the next scripted response. Usage counts characters
+// instead of tokens.
+func (p *ScriptedProvider) ChatCompletion(ctx context.Context, messages []models.DeepSeekMsg) (models.Completion, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Completion{}, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.requests = append(p.requests, messages)
+
+	var response string
+	if len(p.responses) == 0 {
+		for i := len(messages) - 1; i >= 0; i-- {
+			if messages[i].Role == "user" {
+				response = messages[i].Content
+				break
+			}
+		}
+	} else {
+		response = p.responses[p.next]
+		if p.next < len(p.responses)-1 {
+			p.next++
+		}
+	}
+
+	prompt := 0
+	for _, msg := range messages {
+		prompt += utf8.RuneCountInString(msg.Content)
+	}
+	completion := utf8.RuneCountInString(response)
+	return models.Completion{
+		Content:      response,
+		Model:        "scripted",
+		FinishReason: "stop",
+		Usage: models.Usage{
+			PromptTokens:     prompt,
+			CompletionTokens: completion,
+			TotalTokens:      prompt + completion,
+		},
+	}, nil
+}
+
+// ChatCompletionStream streams the next scripted response one rune at a time.
+// A stopped stream reports no usage or finish reason, like a real one.
+func (p *ScriptedProvider) ChatCompletionStream(ctx context.Context, messages []models.DeepSeekMsg, callback func(string) error) (models.Completion, error) {
+	completion, err := p.ChatCompletion(ctx, messages)
+	if err != nil {
+		return models.Completion{Model: "scripted"}, err
+	}
+
+	var streamed strings.Builder
+	for _, r := range completion.Content {
+		if err := ctx.Err(); err != nil {
+			return models.Completion{Content: streamed.String(), Model: completion.Model}, err
+		}
+		streamed.WriteRune(r)
+		if err := callback(string(r)); err != nil {
+			return models.Completion{Content: streamed.String(), Model: completion.Model}, err
+		}
+	}
+
+	return completion, nil
+}
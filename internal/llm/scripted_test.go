@@ -0,0 +1,111 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"medseek/internal/models"
+)
+
+func userTurn(content string) []models.DeepSeekMsg {
+	return []models.DeepSeekMsg{
+		{Role: "system", Content: "sys"},
+		{Role: "user", Content: content},
+	}
+}
+
+func TestScriptedRepliesInOrder(t *testing.T) {
+	p := NewScriptedProvider("one", "two")
+	for _, want := range []string{"one", "two", "two"} {
+		got, err := p.ChatCompletion(context.Background(), userTurn("hi"))
+		if err != nil || got.Content != want {
+			t.Errorf("ChatCompletion = %q, %v, want %q", got.Content, err, want)
+		}
+	}
+	if n := len(p.Requests()); n != 3 {
+		t.Errorf("Requests = %d, want 3", n)
+	}
+}
+
+func TestScriptedEchoesWithoutScript(t *testing.T) {
+	p := NewScriptedProvider()
+	got, err := p.ChatCompletion(context.Background(), userTurn("孩子发烧"))
+	if err != nil || got.Content != "孩子发烧" {
+		t.Fatalf("ChatCompletion = %q, %v", got.Content, err)
+	}
+	// Usage counts runes: "sys" and "孩子发烧" in, "孩子发烧" out
+	want := models.Usage{PromptTokens: 7, CompletionTokens: 4, TotalTokens: 11}
+	if got.Usage != want || got.Model != "scripted" || got.FinishReason != "stop" {
+		t.Errorf("completion = %+v, want usage %+v", got, want)
+	}
+}
+
+func TestScriptedStream(t *testing.T) {
+	p := NewScriptedProvider("你好")
+	var deltas []string
+	got, err := p.ChatCompletionStream(context.Background(), userTurn("hi"), func(d string) error {
+		deltas = append(deltas, d)
+		return nil
+	})
+	if err != nil || got.Content != "你好" || got.FinishReason != "stop" {
+		t.Fatalf("stream = %+v, %v", got, err)
+	}
+	if len(deltas) != 2 || deltas[0] != "你" || deltas[1] != "好" {
+		t.Errorf("deltas = %q", deltas)
+	}
+}
+
+func TestScriptedStreamStopsOnCallbackError(t *testing.T) {
+	p := NewScriptedProvider("abcdef")
+	stop := errors.New("stop")
+	n := 0
+	got, err := p.ChatCompletionStream(context.Background(), userTurn("hi"), func(string) error {
+		if n++; n == 3 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) {
+		t.Fatalf("err = %v, want the callback's error", err)
+	}
+	// The partial reply is kept, without usage, as a stopped stream reports none
+	if got.Content != "abc" || got.Usage.TotalTokens != 0 || got.FinishReason != "" {
+		t.Errorf("partial completion = %+v", got)
+	}
+}
+
+func TestScriptedHonoursCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p := NewScriptedProvider("x")
+	if _, err := p.ChatCompletion(ctx, userTurn("hi")); !errors.Is(err, context.Canceled) {
+		t.Errorf("ChatCompletion err = %v, want context.Canceled", err)
+	}
+	if len(p.Requests()) != 0 {
+		t.Error("a cancelled call was recorded")
+	}
+}
+
+func TestScriptedProviderFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "script.txt")
+	script := "first reply\n---\n\n---\nsecond\nreply\n"
+	if err := os.WriteFile(path, []byte(script), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewScriptedProviderFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"first reply", "second\nreply"} {
+		if got, _ := p.ChatCompletion(context.Background(), userTurn("hi")); got.Content != want {
+			t.Errorf("reply = %q, want %q", got.Content, want)
+		}
+	}
+
+	if _, err := NewScriptedProviderFromFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("missing script file: no error")
+	}
+}
@@ -6,37 +6,139 @@ import (
 
 // User represents a user in the system
 type User struct {
-	ID        string    `json:"id"`
-	Email     string    `json:"email"`
-	Name      string    `json:"name"`
-	CreatedAt time.Time `json:"created_at"`
+	ID           string    `json:"id"`
+	Email        string    `json:"email,omitempty"`
+	Phone        string    `json:"phone,omitempty"` // mainland mobile number, for SMS login
+	Name         string    `json:"name"`
+	Role         string    `json:"role"` // patient, doctor, admin
+	PasswordHash string    `json:"-"`    // bcrypt hash, never sent to clients
+	CreatedAt    time.Time `json:"created_at"`
 }
 
 // ChatSession represents a doctor chat session
 type ChatSession struct {
-	ID        string     `json:"id"`
-	UserID    string     `json:"user_id"`
-	DoctorID  string     `json:"doctor_id,omitempty"`
-	StartTime time.Time  `json:"start_time"`
-	EndTime   *time.Time `json:"end_time,omitempty"`
-	Status    string     `json:"status"` // active, closed, archived
+	ID        string `json:"id"`
+	UserID    string `json:"user_id"`
+	DoctorID  string `json:"doctor_id,omitempty"`
+	Specialty string `json:"specialty"`
+	// PatientAge is the age the patient gave when starting, such as "3岁"
+	PatientAge string     `json:"patient_age,omitempty"`
+	StartTime  time.Time  `json:"start_time"`
+	EndTime    *time.Time `json:"end_time,omitempty"`
+	Status     string     `json:"status"` // active, closed, archived
+	Mode       string     `json:"mode"`   // ai, waiting (for a human doctor), doctor
+	// HandoffReason says who asked for a human doctor: "patient" or a red-flag rule
+	HandoffReason      string     `json:"handoff_reason,omitempty"`
+	HandoffRequestedAt *time.Time `json:"handoff_requested_at,omitempty"`
+	// EscalatedAt is when a human doctor was first requested. Unlike the
+	// handoff fields it is kept after the session returns to AI, and a doctor
+	// taking over unasked does not set it.
+	EscalatedAt *time.Time `json:"escalated_at,omitempty"`
+	// RedFlags names the red-flag rules that have fired in the session
+	RedFlags []string `json:"red_flags,omitempty"`
+	// Triage is the urgency of the case: emergency, urgent, routine or
+	// self-care; empty until the first patient turn is assessed
+	Triage       string     `json:"triage,omitempty"`
+	TriageReason string     `json:"triage_reason,omitempty"`
+	TriagedAt    *time.Time `json:"triaged_at,omitempty"`
+	// PromptVersion identifies the system prompt text of the latest reply
+	PromptVersion string `json:"prompt_version,omitempty"`
+	// Experiment and Variant name the prompt experiment arm the session was
+	// assigned to, if any
+	Experiment string `json:"experiment,omitempty"`
+	Variant    string `json:"variant,omitempty"`
+	// Rating is the patient's 1-5 rating of the consultation; 0 until rated
+	Rating        int        `json:"rating,omitempty"`
+	RatingComment string     `json:"rating_comment,omitempty"`
+	RatedAt       *time.Time `json:"rated_at,omitempty"`
 }
 
-// Message represents a message in a chat
+// Message represents a message in a chat. ID, Seq and CreatedAt are set by
+// the server when the message is stored.
 type Message struct {
 	ID        string    `json:"id"`
 	SessionID string    `json:"session_id"`
+	Seq       int       `json:"seq"` // 1-based position within the session
 	UserID    string    `json:"user_id"`
-	Role      string    `json:"role"` // user, assistant
+	Role      string    `json:"role"` // user, assistant, doctor
 	Content   string    `json:"content"`
 	CreatedAt time.Time `json:"created_at"`
+	Truncated bool      `json:"truncated,omitempty"` // generation stopped before the reply finished
+	// Meta describes how an AI reply was generated; nil on other messages
+	Meta *MessageMeta `json:"meta,omitempty"`
+}
+
+// MessageMeta describes the model call behind an AI reply
+type MessageMeta struct {
+	Model string `json:"model"` // as reported by the API
+	// PromptVersion identifies the system prompt the reply was generated with
+	PromptVersion string `json:"prompt_version,omitempty"`
+	Usage         Usage  `json:"usage"`
+	// LatencyMs is the time from sending the request to the end of the reply
+	LatencyMs int64 `json:"latency_ms"`
+	// FinishReason is why the model stopped, such as stop or length; empty
+	// if the reply was cut short
+	FinishReason string `json:"finish_reason,omitempty"`
+	// Error is the error code of a reply that failed part way, as sent in
+	// the error frame
+	Error string `json:"error,omitempty"`
+}
+
+// Usage counts the tokens of one model call, as reported by the API. It is
+// zero if the API reported none, as when a stream is stopped.
+type Usage struct {
+	PromptTokens int `json:"prompt_tokens"`
+	// CacheHitTokens are the prompt tokens served from the API's context
+	// cache, billed at a lower price; they are part of PromptTokens
+	CacheHitTokens   int `json:"cache_hit_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// UsageRecord is the token usage of one model call, kept for cost accounting
+type UsageRecord struct {
+	// SessionID, UserID and Specialty are empty for calls outside a session,
+	// such as routing a complaint before one starts
+	SessionID string `json:"session_id,omitempty"`
+	UserID    string `json:"user_id,omitempty"`
+	Specialty string `json:"specialty,omitempty"`
+	Purpose   string `json:"purpose"` // reply, summary, triage or routing
+	Model     string `json:"model"`
+	Usage
+	// Estimated is set when the API reported no usage, as for a stopped
+	// stream, and the tokens were estimated from the text
+	Estimated bool      `json:"estimated,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Completion is the result of one model call
+type Completion struct {
+	Content      string
+	Model        string
+	FinishReason string
+	Usage        Usage
+}
+
+// SessionSummary is a running clinical summary of the older turns of a session
+type SessionSummary struct {
+	SessionID string `json:"session_id"`
+	Content   string `json:"content"`
+	// CoveredMessages is how many of the session's earliest messages the summary covers
+	CoveredMessages int       `json:"covered_messages"`
+	UpdatedAt       time.Time `json:"updated_at"`
 }
 
 // DeepSeekRequest represents a request to DeepSeek API
 type DeepSeekRequest struct {
-	Model    string        `json:"model"`
-	Messages []DeepSeekMsg `json:"messages"`
-	Stream   bool          `json:"stream,omitempty"`
+	Model         string         `json:"model"`
+	Messages      []DeepSeekMsg  `json:"messages"`
+	Stream        bool           `json:"stream,omitempty"`
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"`
+}
+
+// StreamOptions asks for token usage in the last chunk of a stream
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
 }
 
 // DeepSeekMsg represents a message in DeepSeek format
@@ -47,29 +149,98 @@ type DeepSeekMsg struct {
 
 // DeepSeekResponse represents the response from DeepSeek API
 type DeepSeekResponse struct {
+	Model   string `json:"model"`
 	Choices []struct {
 		Message struct {
 			Role    string `json:"role"`
 			Content string `json:"content"`
 		} `json:"message"`
+		FinishReason string `json:"finish_reason"`
 	} `json:"choices"`
+	Usage *DeepSeekUsage `json:"usage"`
+}
+
+// DeepSeekUsage is the usage block of a response. DeepSeek reports context
+// cache hits as prompt_cache_hit_tokens, OpenAI-compatible APIs as
+// prompt_tokens_details.cached_tokens.
+type DeepSeekUsage struct {
+	PromptTokens         int `json:"prompt_tokens"`
+	CompletionTokens     int `json:"completion_tokens"`
+	TotalTokens          int `json:"total_tokens"`
+	PromptCacheHitTokens int `json:"prompt_cache_hit_tokens"`
+	PromptTokensDetails  *struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"prompt_tokens_details"`
+}
+
+// Usage converts the usage block
+func (u *DeepSeekUsage) Usage() Usage {
+	usage := Usage{
+		PromptTokens:     u.PromptTokens,
+		CacheHitTokens:   u.PromptCacheHitTokens,
+		CompletionTokens: u.CompletionTokens,
+		TotalTokens:      u.TotalTokens,
+	}
+	if usage.CacheHitTokens == 0 && u.PromptTokensDetails != nil {
+		usage.CacheHitTokens = u.PromptTokensDetails.CachedTokens
+	}
+	return usage
+}
+
+// ModelInfo describes the model behind an LLM provider
+type ModelInfo struct {
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
+	Endpoint string `json:"endpoint"`
 }
 
-// WebSocketMessage represents a WebSocket message
+// WebSocketMessage is the versioned envelope of every WebSocket frame
 type WebSocketMessage struct {
-	Type      string `json:"type"` // message, status, error
+	Version int    `json:"v,omitempty"`
+	Type    string `json:"type"` // see internal/websocket/protocol.go for frame types
+	// ID is assigned by the server; for stored messages it is the message ID
+	ID string `json:"id,omitempty"`
+	// ClientMsgID is generated by the client and echoed on every frame about that message
+	ClientMsgID string `json:"client_msg_id,omitempty"`
+	// Seq is the position of a stored message within its session
+	Seq       int    `json:"seq,omitempty"`
 	Content   string `json:"content"`
 	UserID    string `json:"user_id,omitempty"`
 	SessionID string `json:"session_id,omitempty"`
+	// Code identifies the error on error frames
+	Code string `json:"code,omitempty"`
+	// Versions lists the protocol versions a client supports in its hello frame
+	Versions  []int `json:"versions,omitempty"`
+	Truncated bool  `json:"truncated,omitempty"`
+	// QueuePosition is set on ack and status frames for messages waiting behind another reply
+	QueuePosition int `json:"queue_position,omitempty"`
+	// Role is the message role (user, assistant, doctor) on message frames
+	Role string `json:"role,omitempty"`
+	// Mode and DoctorID describe the session on handoff and queue frames
+	Mode     string `json:"mode,omitempty"`
+	DoctorID string `json:"doctor_id,omitempty"`
+	// Specialty and Triage are set on queue frames; Specialty on emergency,
+	// route and transfer frames too
+	Specialty string `json:"specialty,omitempty"`
+	Triage    string `json:"triage,omitempty"`
+	// RedFlags names the rules that fired, on emergency frames
+	RedFlags []string `json:"red_flags,omitempty"`
+	// Confidence of a suggested specialty, on route frames
+	Confidence float64 `json:"confidence,omitempty"`
+	// CreatedAt and Meta are those of the stored message, on message and
+	// done frames
+	CreatedAt *time.Time   `json:"created_at,omitempty"`
+	Meta      *MessageMeta `json:"meta,omitempty"`
 }
 
-// DoctorProfile represents a doctor's profile
+// DoctorProfile represents a doctor's profile; ID is the doctor's user ID
 type DoctorProfile struct {
 	ID             string   `json:"id"`
 	Name           string   `json:"name"`
 	Specialty      string   `json:"specialty"`
-	LicenseNo      string   `json:"license_no"`
+	LicenseNo      string   `json:"license_no"` // 15-digit practising certificate number
 	Bio            string   `json:"bio"`
 	Qualifications []string `json:"qualifications"`
-	Available      bool     `json:"available"`
+	Available      bool     `json:"available"` // taking patients right now
+	Active         bool     `json:"active"`    // false once deactivated by an admin
 }
@@ -0,0 +1,116 @@
+// Package pricing turns token usage into cost estimates with a price table
+// per model.
+package pricing
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"medseek/internal/models"
+)
+
+// Price is what a model charges per million tokens
+type Price struct {
+	// Input is the price of prompt tokens that miss the context cache
+	Input float64 `json:"input"`
+	// CacheHitInput is the price of prompt tokens served from the cache;
+	// it defaults to Input when missing, while 0 makes cache hits free
+	CacheHitInput *float64 `json:"cache_hit_input,omitempty"`
+	Output        float64  `json:"output"`
+}
+
+func perMillion(v float64) *float64 {
+	return &v
+}
+
+// cacheHit returns the price of cached prompt tokens
+func (p Price) cacheHit() float64 {
+	if p.CacheHitInput == nil {
+		return p.Input
+	}
+	return *p.CacheHitInput
+}
+
+// Table holds the prices of each model in one currency. It is not changed
+// after it is built, so it is safe for concurrent use.
+type Table struct {
+	Currency string           `json:"currency"`
+	Models   map[string]Price `json:"models"`
+}
+
+// Defaults returns the DeepSeek list prices in CNY at the time of writing
+func Defaults() *Table {
+	return &Table{
+		Currency: "CNY",
+		Models: map[string]Price{
+			"deepseek-chat":     {Input: 2, CacheHitInput: perMillion(0.2), Output: 3},
+			"deepseek-reasoner": {Input: 2, CacheHitInput: perMillion(0.2), Output: 3},
+		},
+	}
+}
+
+// Load reads a price table from a JSON file
+func Load(path string) (*Table, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read prices: %w", err)
+	}
+	var t Table
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("failed to parse prices: %w", err)
+	}
+	if t.Currency == "" {
+		return nil, fmt.Errorf("price table has no currency")
+	}
+	for model, p := range t.Models {
+		if p.Input < 0 || p.cacheHit() < 0 || p.Output < 0 {
+			return nil, fmt.Errorf("model %s has a negative price", model)
+		}
+		if p.CacheHitInput == nil {
+			p.CacheHitInput = perMillion(p.Input)
+			t.Models[model] = p
+		}
+	}
+	return &t, nil
+}
+
+// NewFromEnv loads the price table in PRICES_FILE, or the defaults if it is
+// unset
+func NewFromEnv() (*Table, error) {
+	if path := os.Getenv("PRICES_FILE"); path != "" {
+		return Load(path)
+	}
+	return Defaults(), nil
+}
+
+// price returns the price of a model: an exact match, or else the longest
+// entry the model name starts with, so "gpt-4o" prices "gpt-4o-2024-08-06"
+func (t *Table) price(model string) (Price, bool) {
+	if p, ok := t.Models[model]; ok {
+		return p, true
+	}
+	var best string
+	for name := range t.Models {
+		if strings.HasPrefix(model, name) && len(name) > len(best) {
+			best = name
+		}
+	}
+	if best == "" {
+		return Price{}, false
+	}
+	return t.Models[best], true
+}
+
+// Cost estimates what a call with the given usage cost. It returns false if
+// the model has no price.
+func (t *Table) Cost(model string, u models.Usage) (float64, bool) {
+	p, ok := t.price(model)
+	if !ok {
+		return 0, false
+	}
+	missed := u.PromptTokens - u.CacheHitTokens
+	cost := float64(missed)*p.Input + float64(u.CacheHitTokens)*p.cacheHit() + float64(u.CompletionTokens)*p.Output
+	return cost / 1e6, true
+}
@@ -0,0 +1,77 @@
+package pricing
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"medseek/internal/models"
+)
+
+func writePrices(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "prices.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadCacheHitPrice(t *testing.T) {
+	table, err := Load(writePrices(t, `{"currency": "USD", "models": {
+		"missing": {"input": 1, "output": 2},
+		"free":    {"input": 1, "cache_hit_input": 0, "output": 2},
+		"cheap":   {"input": 1, "cache_hit_input": 0.25, "output": 2}
+	}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usage := models.Usage{PromptTokens: 1_000_000, CacheHitTokens: 400_000, CompletionTokens: 500_000}
+	tests := []struct {
+		model string
+		want  float64
+	}{
+		{"missing", 0.6 + 0.4 + 1}, // cache hits at the input price
+		{"free", 0.6 + 0 + 1},
+		{"cheap", 0.6 + 0.1 + 1},
+	}
+	for _, tt := range tests {
+		if got, ok := table.Cost(tt.model, usage); !ok || got != tt.want {
+			t.Errorf("%s: Cost = %v, %v, want %v", tt.model, got, ok, tt.want)
+		}
+	}
+}
+
+func TestLoadRejectsInvalidTables(t *testing.T) {
+	for name, content := range map[string]string{
+		"no currency":    `{"models": {"m": {"input": 1, "output": 1}}}`,
+		"negative":       `{"currency": "CNY", "models": {"m": {"input": -1, "output": 1}}}`,
+		"negative cache": `{"currency": "CNY", "models": {"m": {"input": 1, "cache_hit_input": -1, "output": 1}}}`,
+		"not json":       `{`,
+	} {
+		if _, err := Load(writePrices(t, content)); err == nil {
+			t.Errorf("%s: Load succeeded", name)
+		}
+	}
+}
+
+func TestCostMatchesModelPrefixes(t *testing.T) {
+	table := &Table{Currency: "USD", Models: map[string]Price{
+		"gpt-4o":      {Input: 2.5, Output: 10},
+		"gpt-4o-mini": {Input: 0.15, Output: 0.6},
+	}}
+	usage := models.Usage{PromptTokens: 1_000_000}
+	if got, _ := table.Cost("gpt-4o-mini-2024-07-18", usage); got != 0.15 {
+		t.Errorf("gpt-4o-mini snapshot costs %v, want the gpt-4o-mini price", got)
+	}
+	if got, _ := table.Cost("gpt-4o-2024-08-06", usage); got != 2.5 {
+		t.Errorf("gpt-4o snapshot costs %v, want the gpt-4o price", got)
+	}
+	if _, ok := table.Cost("claude", usage); ok {
+		t.Error("unknown model was priced")
+	}
+	if got, _ := Defaults().Cost("deepseek-chat", models.Usage{PromptTokens: 1_000_000, CacheHitTokens: 1_000_000}); got != 0.2 {
+		t.Errorf("default cache hit price = %v, want 0.2", got)
+	}
+}
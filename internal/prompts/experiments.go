@@ -0,0 +1,171 @@
+package prompts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+// Version identifies a prompt text: the first 12 hex digits of its SHA-256
+func Version(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// templateVersion identifies a template rendered for a hospital. The hospital
+// is hashed with the template, since changing it changes every prompt; the
+// per-session variables are not, so one template is one version whatever
+// the patient.
+func templateVersion(text, hospital string) string {
+	return Version(text + "\x00" + hospital)
+}
+
+// Experiment splits the new sessions of one specialty between prompt
+// variants by weight
+type Experiment struct {
+	Name      string `json:"name"`
+	Specialty string `json:"specialty"`
+	// Active experiments assign new sessions; sessions already assigned keep
+	// their variant either way
+	Active   bool      `json:"active"`
+	Variants []Variant `json:"variants"`
+}
+
+// Variant is one arm of an experiment. A variant with neither Prompt nor
+// File is the control: it gets the specialty's usual prompt.
+type Variant struct {
+	Name   string `json:"name"`
+	Weight int    `json:"weight"`
+	// Prompt is the template text; File a template file, relative to the
+	// experiments file
+	Prompt string `json:"prompt,omitempty"`
+	File   string `json:"file,omitempty"`
+
+	tmpl    *template.Template
+	version string
+}
+
+// loadExperiments reads and checks the experiments in path: unique names,
+// known specialties, at most one active experiment per specialty, at least
+// two variants with positive weights and templates that render. Variant
+// versions are for prompts rendered for hospital.
+func loadExperiments(path string, known []string, hospital string) ([]Experiment, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read experiments: %w", err)
+	}
+	var experiments []Experiment
+	if err := json.Unmarshal(data, &experiments); err != nil {
+		return nil, fmt.Errorf("failed to parse experiments: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	active := make(map[string]string)
+	for i := range experiments {
+		e := &experiments[i]
+		switch {
+		case e.Name == "":
+			return nil, fmt.Errorf("experiment without a name")
+		case seen[e.Name]:
+			return nil, fmt.Errorf("duplicate experiment %s", e.Name)
+		case !slices.Contains(known, e.Specialty):
+			return nil, fmt.Errorf("experiment %s: unknown specialty %q", e.Name, e.Specialty)
+		case len(e.Variants) < 2:
+			return nil, fmt.Errorf("experiment %s needs at least two variants", e.Name)
+		}
+		seen[e.Name] = true
+		if e.Active {
+			if other, ok := active[e.Specialty]; ok {
+				return nil, fmt.Errorf("experiments %s and %s both run on %s", other, e.Name, e.Specialty)
+			}
+			active[e.Specialty] = e.Name
+		}
+
+		names := make(map[string]bool)
+		for j := range e.Variants {
+			v := &e.Variants[j]
+			switch {
+			case v.Name == "":
+				return nil, fmt.Errorf("experiment %s: variant without a name", e.Name)
+			case names[v.Name]:
+				return nil, fmt.Errorf("experiment %s: duplicate variant %s", e.Name, v.Name)
+			case v.Weight <= 0:
+				return nil, fmt.Errorf("experiment %s: variant %s needs a positive weight", e.Name, v.Name)
+			case v.Prompt != "" && v.File != "":
+				return nil, fmt.Errorf("experiment %s: variant %s has both a prompt and a file", e.Name, v.Name)
+			}
+			names[v.Name] = true
+
+			text := v.Prompt
+			if v.File != "" {
+				data, err := os.ReadFile(filepath.Join(filepath.Dir(path), v.File))
+				if err != nil {
+					return nil, fmt.Errorf("experiment %s: %w", e.Name, err)
+				}
+				text = string(data)
+			}
+			if text == "" {
+				continue
+			}
+			tmpl, err := parse(e.Name+"/"+v.Name, text, e.Specialty)
+			if err != nil {
+				return nil, fmt.Errorf("experiment %s: %w", e.Name, err)
+			}
+			v.tmpl = tmpl
+			v.version = templateVersion(text, hospital)
+		}
+	}
+	return experiments, nil
+}
+
+// parse parses a prompt template and checks that it renders a prompt with
+// sample values
+func parse(name, text, specialty string) (*template.Template, error) {
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid prompt %w", err)
+	}
+	vars := sampleVars
+	vars.Specialty = specialty
+	var out strings.Builder
+	if err := tmpl.Execute(&out, vars); err != nil {
+		return nil, fmt.Errorf("invalid prompt %w", err)
+	}
+	if strings.TrimSpace(out.String()) == "" {
+		return nil, fmt.Errorf("prompt template %s renders an empty prompt", name)
+	}
+	return tmpl, nil
+}
+
+// pick chooses a variant at random in proportion to the weights
+func (e *Experiment) pick() *Variant {
+	total := 0
+	for _, v := range e.Variants {
+		total += v.Weight
+	}
+	n := rand.Intn(total)
+	for i := range e.Variants {
+		if n < e.Variants[i].Weight {
+			return &e.Variants[i]
+		}
+		n -= e.Variants[i].Weight
+	}
+	return &e.Variants[len(e.Variants)-1]
+}
+
+// variant returns the named variant, or nil
+func (e *Experiment) variant(name string) *Variant {
+	for i := range e.Variants {
+		if e.Variants[i].Name == name {
+			return &e.Variants[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package prompts
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testSpecialties = []string{"pediatrics", "dermatology"}
+
+// builtin is the built-in prompt of every test specialty
+func builtin(specialty string) string { return "内置提示词: " + specialty }
+
+// writeFile writes a file in dir and returns its path
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadExperimentsValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want string // part of the error
+	}{
+		{"no name", `[{"specialty":"pediatrics","variants":[{"name":"a","weight":1},{"name":"b","weight":1}]}]`, "without a name"},
+		{"duplicate", `[{"name":"e","specialty":"pediatrics","variants":[{"name":"a","weight":1},{"name":"b","weight":1}]},
+			{"name":"e","specialty":"dermatology","variants":[{"name":"a","weight":1},{"name":"b","weight":1}]}]`, "duplicate experiment"},
+		{"unknown specialty", `[{"name":"e","specialty":"neurology","variants":[{"name":"a","weight":1},{"name":"b","weight":1}]}]`, "unknown specialty"},
+		{"one variant", `[{"name":"e","specialty":"pediatrics","variants":[{"name":"a","weight":1}]}]`, "at least two variants"},
+		{"two active", `[{"name":"e1","specialty":"pediatrics","active":true,"variants":[{"name":"a","weight":1},{"name":"b","weight":1}]},
+			{"name":"e2","specialty":"pediatrics","active":true,"variants":[{"name":"a","weight":1},{"name":"b","weight":1}]}]`, "both run on"},
+		{"zero weight", `[{"name":"e","specialty":"pediatrics","variants":[{"name":"a","weight":1},{"name":"b","weight":0}]}]`, "positive weight"},
+		{"duplicate variant", `[{"name":"e","specialty":"pediatrics","variants":[{"name":"a","weight":1},{"name":"a","weight":1}]}]`, "duplicate variant"},
+		{"prompt and file", `[{"name":"e","specialty":"pediatrics","variants":[{"name":"a","weight":1},{"name":"b","weight":1,"prompt":"x","file":"b.tmpl"}]}]`, "both a prompt and a file"},
+		{"bad template", `[{"name":"e","specialty":"pediatrics","variants":[{"name":"a","weight":1},{"name":"b","weight":1,"prompt":"{{.Hospital"}]}]`, "invalid prompt"},
+		{"empty render", `[{"name":"e","specialty":"pediatrics","variants":[{"name":"a","weight":1},{"name":"b","weight":1,"prompt":"{{if false}}x{{end}}"}]}]`, "empty prompt"},
+		{"missing file", `[{"name":"e","specialty":"pediatrics","variants":[{"name":"a","weight":1},{"name":"b","weight":1,"file":"missing.tmpl"}]}]`, "missing.tmpl"},
+		{"not JSON", `{`, "failed to parse"},
+	}
+	for _, tt := range tests {
+		path := writeFile(t, t.TempDir(), "experiments.json", tt.json)
+		_, err := loadExperiments(path, testSpecialties, DefaultHospital)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestPickFollowsWeights(t *testing.T) {
+	e := Experiment{Variants: []Variant{{Name: "a", Weight: 3}, {Name: "b", Weight: 1}}}
+	counts := make(map[string]int)
+	const n = 8000
+	for i := 0; i < n; i++ {
+		counts[e.pick().Name]++
+	}
+	// 3:1 expects 6000 a; allow a wide margin so the test never flakes
+	if counts["a"] < 5600 || counts["a"] > 6400 || counts["a"]+counts["b"] != n {
+		t.Errorf("picks = %v, want about 6000 a and 2000 b", counts)
+	}
+}
+
+func TestRenderVariants(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "brief.tmpl", "{{.Hospital}}{{.Specialty}}简短版")
+	experiments := writeFile(t, dir, "experiments.json", `[
+		{"name":"tone","specialty":"pediatrics","active":true,"variants":[
+			{"name":"control","weight":1},
+			{"name":"warm","weight":1,"prompt":"温和版{{with .PatientAge}}，患者{{.}}{{end}}"},
+			{"name":"brief","weight":1,"file":"brief.tmpl"}]},
+		{"name":"old","specialty":"dermatology","variants":[
+			{"name":"a","weight":1,"prompt":"旧版"},{"name":"b","weight":1}]}]`)
+	l, err := New("", experiments, "测试医院", testSpecialties, builtin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usual, usualVersion := l.Render("pediatrics", "", "", Vars{})
+	if usual != builtin("pediatrics") || usualVersion != Version(usual) {
+		t.Fatalf("usual prompt = %q (%s)", usual, usualVersion)
+	}
+	tests := []struct {
+		name, specialty, experiment, variant string
+		want                                 string
+	}{
+		{"control", "pediatrics", "tone", "control", usual},
+		{"inline prompt", "pediatrics", "tone", "warm", "温和版，患者3岁"},
+		{"file prompt", "pediatrics", "tone", "brief", "测试医院pediatrics简短版"},
+		{"unknown variant", "pediatrics", "tone", "gone", usual},
+		{"experiment no longer configured", "pediatrics", "removed", "warm", usual},
+		{"experiment of another specialty", "pediatrics", "old", "a", usual},
+		{"inactive experiment keeps its sessions", "dermatology", "old", "a", "旧版"},
+	}
+	for _, tt := range tests {
+		got, version := l.Render(tt.specialty, tt.experiment, tt.variant, Vars{PatientAge: "3岁"})
+		if got != tt.want {
+			t.Errorf("%s: Render = %q, want %q", tt.name, got, tt.want)
+		}
+		if version == "" || (got != usual && version == usualVersion) {
+			t.Errorf("%s: version %q does not identify the variant", tt.name, version)
+		}
+	}
+
+	// Only the active experiment assigns sessions, to one of its variants
+	for i := 0; i < 20; i++ {
+		experiment, variant := l.Assign("pediatrics")
+		if experiment != "tone" || (variant != "control" && variant != "warm" && variant != "brief") {
+			t.Fatalf("Assign = %s, %s", experiment, variant)
+		}
+	}
+	if experiment, variant := l.Assign("dermatology"); experiment != "" || variant != "" {
+		t.Errorf("inactive experiment assigned %s, %s", experiment, variant)
+	}
+}
@@ -0,0 +1,303 @@
+// Package prompts loads the specialty system prompts from a directory of
+// template files, so prompts can be edited without a rebuild, and reloads
+// them when the files change. It also runs prompt experiments, which split
+// new sessions between prompt variants, and versions every prompt text.
+package prompts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"text/template"
+	"time"
+)
+
+// DefaultHospital is the hospital name prompts are rendered with unless
+// PROMPT_HOSPITAL is set
+const DefaultHospital = "信臣健康互联网医院"
+
+// ReloadInterval is how often Watch checks the directory for changes
+const ReloadInterval = 5 * time.Second
+
+// Ext is the extension of template files; the file name without it is the
+// specialty id, as in pediatrics.tmpl
+const Ext = ".tmpl"
+
+// Vars are the values a prompt template can use, as {{.Hospital}},
+// {{.DoctorName}}, {{.PatientAge}} and {{.Specialty}}. DoctorName and
+// PatientAge may be empty, so templates should test them with {{with}}.
+type Vars struct {
+	Hospital   string
+	DoctorName string // the doctor who held the session, if any
+	PatientAge string // as the patient gave it, such as "3岁" or "8个月"
+	Specialty  string
+}
+
+// sampleVars fill every variable when templates are checked at load
+var sampleVars = Vars{
+	Hospital:   DefaultHospital,
+	DoctorName: "王医生",
+	PatientAge: "30岁",
+}
+
+// Library renders the system prompt of each specialty from its template, or
+// from the built-in prompt when the directory has none, and assigns sessions
+// to experiment variants. It is safe for concurrent use.
+type Library struct {
+	dir             string
+	experimentsFile string
+	hospital        string
+	known           []string
+	builtin         func(specialty string) string
+
+	mu          sync.RWMutex
+	templates   map[string]*template.Template
+	versions    map[string]string // specialty -> version of its template
+	experiments []Experiment
+	fingerprint string
+}
+
+// New loads the templates in dir and the experiments in experimentsFile for
+// the known specialties. builtin returns the default prompt of a specialty
+// without a template. An empty dir uses the built-in prompts only; an empty
+// experimentsFile runs no experiments.
+func New(dir, experimentsFile, hospital string, known []string, builtin func(string) string) (*Library, error) {
+	if hospital == "" {
+		hospital = DefaultHospital
+	}
+	l := &Library{
+		dir:             dir,
+		experimentsFile: experimentsFile,
+		hospital:        hospital,
+		known:           known,
+		builtin:         builtin,
+		templates:       make(map[string]*template.Template),
+		versions:        make(map[string]string),
+	}
+	if err := l.Reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// NewFromEnv loads the templates in PROMPTS_DIR and the experiments in
+// EXPERIMENTS_FILE, rendered for PROMPT_HOSPITAL
+func NewFromEnv(known []string, builtin func(string) string) (*Library, error) {
+	return New(os.Getenv("PROMPTS_DIR"), os.Getenv("EXPERIMENTS_FILE"), os.Getenv("PROMPT_HOSPITAL"), known, builtin)
+}
+
+// Reload reads every template and the experiments again. Templates are
+// parsed and rendered with sample values first; if any fails, the templates
+// and experiments in use are kept and the error returned.
+func (l *Library) Reload() error {
+	var fingerprint string
+	templates := make(map[string]*template.Template)
+	versions := make(map[string]string)
+	var err error
+	if l.dir != "" {
+		if fingerprint, err = l.scan(); err != nil {
+			return err
+		}
+		templates, versions, err = l.load()
+	}
+	var experiments []Experiment
+	if err == nil && l.experimentsFile != "" {
+		experiments, err = loadExperiments(l.experimentsFile, l.known, l.hospital)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// Only a successful load is remembered, so a broken file is retried
+	// until it loads
+	l.fingerprint = fingerprint
+	l.templates = templates
+	l.versions = versions
+	l.experiments = experiments
+	if l.dir != "" {
+		log.Printf("Loaded %d prompt templates from %s", len(templates), l.dir)
+	}
+	if l.experimentsFile != "" {
+		log.Printf("Loaded %d prompt experiments from %s", len(experiments), l.experimentsFile)
+	}
+	return nil
+}
+
+// load parses and checks every template file in the directory, and returns
+// them with their versions
+func (l *Library) load() (map[string]*template.Template, map[string]string, error) {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read prompt directory: %w", err)
+	}
+	templates := make(map[string]*template.Template)
+	versions := make(map[string]string)
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != Ext {
+			continue
+		}
+		specialty := strings.TrimSuffix(entry.Name(), Ext)
+		if !slices.Contains(l.known, specialty) {
+			return nil, nil, fmt.Errorf("prompt template %s: unknown specialty %q", entry.Name(), specialty)
+		}
+		data, err := os.ReadFile(filepath.Join(l.dir, entry.Name()))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read prompt template: %w", err)
+		}
+		tmpl, err := parse(entry.Name(), string(data), specialty)
+		if err != nil {
+			return nil, nil, err
+		}
+		templates[specialty] = tmpl
+		versions[specialty] = templateVersion(string(data), l.hospital)
+	}
+	return templates, versions, nil
+}
+
+// scan returns a value that changes whenever a template file is added,
+// removed or modified
+func (l *Library) scan() (string, error) {
+	h := sha256.New()
+	err := filepath.WalkDir(l.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != l.dir && d.IsDir() {
+			return filepath.SkipDir
+		}
+		if filepath.Ext(path) != Ext {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00%d\n", d.Name(), info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to read prompt directory: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Watch reloads the templates when a file in the directory changes, checked
+// every ReloadInterval, and the templates and experiments when the process
+// receives SIGHUP. A failed reload is logged and the previous templates stay
+// in use. It returns at once if there is neither a directory nor an
+// experiments file.
+func (l *Library) Watch() {
+	if l.dir == "" && l.experimentsFile == "" {
+		return
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	ticker := time.NewTicker(ReloadInterval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-hup:
+				log.Printf("SIGHUP: reloading prompt templates")
+			case <-ticker.C:
+				changed, err := l.changed()
+				if err != nil {
+					log.Printf("Failed to check prompt templates: %v", err)
+				}
+				if !changed {
+					continue
+				}
+			}
+			if err := l.Reload(); err != nil {
+				log.Printf("Failed to reload prompt templates, keeping the previous ones: %v", err)
+			}
+		}
+	}()
+}
+
+// changed reports whether the template files differ from the last successful
+// load
+func (l *Library) changed() (bool, error) {
+	if l.dir == "" {
+		return false, nil
+	}
+	fingerprint, err := l.scan()
+	if err != nil {
+		return false, err
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return fingerprint != l.fingerprint, nil
+}
+
+// Assign picks a variant of the active experiment on a specialty for a new
+// session, by weight. It returns empty names if none runs.
+func (l *Library) Assign(specialty string) (experiment, variant string) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for i := range l.experiments {
+		e := &l.experiments[i]
+		if e.Active && e.Specialty == specialty {
+			return e.Name, e.pick().Name
+		}
+	}
+	return "", ""
+}
+
+// Experiments returns the configured experiments
+func (l *Library) Experiments() []Experiment {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return slices.Clone(l.experiments)
+}
+
+// Render returns the system prompt of a specialty and its version. A session
+// in an experiment on the specialty gets its variant's prompt; the control
+// variant, and sessions of experiments no longer configured, get the usual
+// one. The hospital name is filled in by the library. A template that fails
+// to render falls back to the built-in prompt.
+func (l *Library) Render(specialty, experiment, variant string, vars Vars) (prompt, version string) {
+	l.mu.RLock()
+	tmpl, version := l.templates[specialty], l.versions[specialty]
+	for i := range l.experiments {
+		e := &l.experiments[i]
+		if e.Name != experiment || e.Specialty != specialty {
+			continue
+		}
+		if v := e.variant(variant); v != nil && v.tmpl != nil {
+			tmpl, version = v.tmpl, v.version
+		}
+	}
+	l.mu.RUnlock()
+	if tmpl == nil {
+		return l.builtinPrompt(specialty)
+	}
+
+	vars.Hospital = l.hospital
+	vars.Specialty = specialty
+	var out strings.Builder
+	if err := tmpl.Execute(&out, vars); err != nil {
+		log.Printf("Failed to render prompt template for %s, using the built-in prompt: %v", specialty, err)
+		return l.builtinPrompt(specialty)
+	}
+	return strings.TrimSpace(out.String()), version
+}
+
+// builtinPrompt returns the built-in prompt of a specialty and its version
+func (l *Library) builtinPrompt(specialty string) (string, string) {
+	prompt := l.builtin(specialty)
+	return prompt, Version(prompt)
+}
@@ -0,0 +1,85 @@
+package prompts
+
+import "testing"
+
+func TestReloadPicksUpTemplateChanges(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "pediatrics.tmpl", "{{.Hospital}}儿科第一版")
+	l, err := New(dir, "", "测试医院", testSpecialties, builtin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, firstVersion := l.Render("pediatrics", "", "", Vars{})
+	if first != "测试医院儿科第一版" {
+		t.Fatalf("Render = %q", first)
+	}
+	if got, _ := l.Render("dermatology", "", "", Vars{}); got != builtin("dermatology") {
+		t.Errorf("specialty without a template got %q", got)
+	}
+	if changed, err := l.changed(); err != nil || changed {
+		t.Errorf("changed = %v, %v right after loading", changed, err)
+	}
+
+	writeFile(t, dir, "pediatrics.tmpl", "{{.Hospital}}儿科第二版，更新")
+	if changed, err := l.changed(); err != nil || !changed {
+		t.Fatalf("changed = %v, %v after editing a template", changed, err)
+	}
+	if err := l.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	second, secondVersion := l.Render("pediatrics", "", "", Vars{})
+	if second != "测试医院儿科第二版，更新" || secondVersion == firstVersion {
+		t.Errorf("after reload Render = %q (%s), want the new template with a new version", second, secondVersion)
+	}
+}
+
+func TestFailedReloadKeepsTemplatesAndRetries(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "pediatrics.tmpl", "儿科提示词")
+	l, err := New(dir, "", "", testSpecialties, builtin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range map[string]string{
+		"pediatrics.tmpl": "{{.Hospital",
+		"neurology.tmpl":  "神经科",
+	} {
+		writeFile(t, dir, name, content)
+		if err := l.Reload(); err == nil {
+			t.Errorf("%s: Reload of a broken directory succeeded", name)
+		}
+		if got, _ := l.Render("pediatrics", "", "", Vars{}); got != "儿科提示词" {
+			t.Errorf("%s: after a failed reload Render = %q, want the previous template", name, got)
+		}
+		// The broken directory is still unloaded, so Watch keeps trying
+		if changed, _ := l.changed(); !changed {
+			t.Errorf("%s: failed reload was remembered as loaded", name)
+		}
+		if name == "pediatrics.tmpl" {
+			writeFile(t, dir, name, "儿科提示词")
+		}
+	}
+}
+
+func TestVersionCoversTheHospital(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "pediatrics.tmpl", "欢迎来到{{.Hospital}}")
+	versions := make(map[string]string)
+	for _, hospital := range []string{"甲医院", "乙医院"} {
+		l, err := New(dir, "", hospital, testSpecialties, builtin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, versions[hospital] = l.Render("pediatrics", "", "", Vars{})
+
+		// The patient's values do not make a new version
+		if _, v := l.Render("pediatrics", "", "", Vars{PatientAge: "3岁", DoctorName: "王医生"}); v != versions[hospital] {
+			t.Errorf("%s: version %s changed with the patient to %s", hospital, versions[hospital], v)
+		}
+	}
+	if versions["甲医院"] == versions["乙医院"] {
+		t.Errorf("both hospitals render version %s", versions["甲医院"])
+	}
+}
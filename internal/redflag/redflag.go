@@ -0,0 +1,268 @@
+// Package redflag screens consultation turns for emergency symptoms with
+// deterministic rules, so an emergency is caught whatever the model answers.
+package redflag
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"medseek/internal/triage"
+)
+
+// Scopes say which text of a turn a rule screens
+const (
+	ScopePatient = "patient" // the patient's message, before the model call
+	ScopeReply   = "reply"   // the model's reply, after the call
+)
+
+// EmergencyNotice is appended to the guidance of every match
+const EmergencyNotice = "如情况危急，请立即拨打120急救电话或尽快前往最近医院急诊就医。"
+
+// Threshold fires when a number captured from the text crosses a limit, such
+// as a temperature or a gestational week
+type Threshold struct {
+	// Pattern is a regular expression whose first group captures the number
+	Pattern string `json:"pattern"`
+	// Above fires for values greater than it; Below for values less than it
+	Above *float64 `json:"above,omitempty"`
+	Below *float64 `json:"below,omitempty"`
+}
+
+// Rule is one red flag. It fires when any keyword, pattern or threshold
+// matches outside a negation ("没有出血"), and, if Requires is set, one of
+// those words also appears in the text.
+type Rule struct {
+	Name string `json:"name"`
+	// Specialties limits the rule to sessions of these specialties; empty means all
+	Specialties []string    `json:"specialties,omitempty"`
+	Scope       string      `json:"scope,omitempty"` // patient (default) or reply
+	Keywords    []string    `json:"keywords,omitempty"`
+	Patterns    []string    `json:"patterns,omitempty"`
+	Thresholds  []Threshold `json:"thresholds,omitempty"`
+	Requires    []string    `json:"requires,omitempty"`
+	// Guidance is shown to the patient when the rule fires
+	Guidance string `json:"guidance"`
+	// Handoff asks for a human doctor once the current reply is done
+	Handoff bool `json:"handoff"`
+	// Level is the triage level of a session the rule fired in; default emergency
+	Level string `json:"level,omitempty"`
+}
+
+// Match is a rule that fired on a text
+type Match struct {
+	Rule     string `json:"rule"`
+	Evidence string `json:"evidence"` // the text that matched
+	Guidance string `json:"guidance"`
+	Handoff  bool   `json:"handoff"`
+	Level    string `json:"level"`
+}
+
+type threshold struct {
+	re    *regexp.Regexp
+	above *float64
+	below *float64
+}
+
+type compiledRule struct {
+	Rule
+	patterns   []*regexp.Regexp
+	thresholds []threshold
+}
+
+// Engine checks texts against a fixed set of rules. It is safe for
+// concurrent use.
+type Engine struct {
+	rules []compiledRule
+}
+
+// New compiles rules into an engine, rejecting rules without a name, without
+// anything to match or with invalid patterns
+func New(rules []Rule) (*Engine, error) {
+	e := &Engine{}
+	for _, rule := range rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("red-flag rule without a name")
+		}
+		if len(rule.Keywords)+len(rule.Patterns)+len(rule.Thresholds) == 0 {
+			return nil, fmt.Errorf("red-flag rule %s matches nothing", rule.Name)
+		}
+		switch rule.Scope {
+		case "":
+			rule.Scope = ScopePatient
+		case ScopePatient, ScopeReply:
+		default:
+			return nil, fmt.Errorf("red-flag rule %s: unknown scope %q", rule.Name, rule.Scope)
+		}
+		if rule.Level == "" {
+			rule.Level = triage.Emergency
+		} else if !triage.Valid(rule.Level) {
+			return nil, fmt.Errorf("red-flag rule %s: unknown level %q", rule.Name, rule.Level)
+		}
+
+		compiled := compiledRule{Rule: rule}
+		for _, p := range rule.Patterns {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return nil, fmt.Errorf("red-flag rule %s: %w", rule.Name, err)
+			}
+			compiled.patterns = append(compiled.patterns, re)
+		}
+		for _, t := range rule.Thresholds {
+			re, err := regexp.Compile(t.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("red-flag rule %s: %w", rule.Name, err)
+			}
+			if re.NumSubexp() < 1 {
+				return nil, fmt.Errorf("red-flag rule %s: threshold pattern %q captures no number", rule.Name, t.Pattern)
+			}
+			if t.Above == nil && t.Below == nil {
+				return nil, fmt.Errorf("red-flag rule %s: threshold %q has no limit", rule.Name, t.Pattern)
+			}
+			compiled.thresholds = append(compiled.thresholds, threshold{re: re, above: t.Above, below: t.Below})
+		}
+		e.rules = append(e.rules, compiled)
+	}
+	return e, nil
+}
+
+// Load reads a JSON array of rules from path
+func Load(path string) (*Engine, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read red-flag rules: %w", err)
+	}
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse red-flag rules: %w", err)
+	}
+	return New(rules)
+}
+
+// NewFromEnv loads the rules in REDFLAG_RULES_FILE, or the built-in rules if
+// it is unset
+func NewFromEnv() (*Engine, error) {
+	if path := os.Getenv("REDFLAG_RULES_FILE"); path != "" {
+		return Load(path)
+	}
+	return New(DefaultRules())
+}
+
+// Len returns the number of rules
+func (e *Engine) Len() int {
+	return len(e.rules)
+}
+
+// Level returns the triage level of the named rule, or "" if there is no
+// such rule
+func (e *Engine) Level(name string) string {
+	for _, rule := range e.rules {
+		if rule.Name == name {
+			return rule.Level
+		}
+	}
+	return ""
+}
+
+// Check returns the rules of scope that fire on text in a session of specialty
+func (e *Engine) Check(specialty, scope, text string) []Match {
+	var matches []Match
+	for _, rule := range e.rules {
+		if rule.Scope != scope {
+			continue
+		}
+		if len(rule.Specialties) > 0 && !slices.Contains(rule.Specialties, specialty) {
+			continue
+		}
+		if len(rule.Requires) > 0 && !containsAny(text, rule.Requires) {
+			continue
+		}
+		if evidence, ok := rule.match(text); ok {
+			matches = append(matches, Match{
+				Rule:     rule.Name,
+				Evidence: evidence,
+				Guidance: rule.Guidance,
+				Handoff:  rule.Handoff,
+				Level:    rule.Level,
+			})
+		}
+	}
+	return matches
+}
+
+// match returns the first keyword, pattern or threshold hit in text
+func (r *compiledRule) match(text string) (string, bool) {
+	for _, keyword := range r.Keywords {
+		for offset := 0; ; {
+			i := strings.Index(text[offset:], keyword)
+			if i < 0 {
+				break
+			}
+			start := offset + i
+			if !negated(text, start) {
+				return keyword, true
+			}
+			offset = start + len(keyword)
+		}
+	}
+	for _, re := range r.patterns {
+		for _, loc := range re.FindAllStringIndex(text, -1) {
+			if !negated(text, loc[0]) {
+				return text[loc[0]:loc[1]], true
+			}
+		}
+	}
+	for _, t := range r.thresholds {
+		for _, groups := range t.re.FindAllStringSubmatch(text, -1) {
+			value, err := strconv.ParseFloat(groups[1], 64)
+			if err != nil {
+				continue
+			}
+			if (t.above != nil && value > *t.above) || (t.below != nil && value < *t.below) {
+				return groups[0], true
+			}
+		}
+	}
+	return "", false
+}
+
+// negationWindow is how many characters before a hit are searched for a negation
+const negationWindow = 4
+
+var (
+	negations = []string{"没有", "没", "无", "未", "否认", "不是", "并非"}
+	// Questions like "是不是宫外孕" are not denials and still count
+	questions = []string{"是不是", "有没有", "会不会", "是否"}
+	// notNegations are words that contain a negation but describe a symptom,
+	// as in "无法呼吸" or "浑身无力"; they are removed before looking for one
+	notNegations = strings.NewReplacer("无法", "", "没法", "", "无力", "", "无缘无故", "")
+)
+
+// negated reports whether the clause before text[start:] denies the symptom,
+// as in "没有阴道出血". It looks back a few characters, stopping at punctuation.
+func negated(text string, start int) bool {
+	before := []rune(text[:start])
+	from := len(before)
+	for from > 0 && len(before)-from < negationWindow && !isClauseBreak(before[from-1]) {
+		from--
+	}
+	window := notNegations.Replace(string(before[from:]))
+	return containsAny(window, negations) && !containsAny(window, questions)
+}
+
+func isClauseBreak(r rune) bool {
+	return strings.ContainsRune("，。！？；、,.!?;\n", r)
+}
+
+func containsAny(text string, words []string) bool {
+	for _, word := range words {
+		if strings.Contains(text, word) {
+			return true
+		}
+	}
+	return false
+}
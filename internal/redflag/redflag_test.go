@@ -0,0 +1,91 @@
+package redflag
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDefaultRules(t *testing.T) {
+	e, err := New(DefaultRules())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		specialty string
+		text      string
+		want      []string // fired rule names; nil for none
+	}{
+		// Life-threatening signs fire in every specialty
+		{"dermatology", "胸口痛得厉害，一直出冷汗", []string{"chest_pain"}},
+		{"obstetrics", "我爸突然嘴歪，说话不清", []string{"stroke"}},
+		{"ent", "老人昏倒了", []string{"syncope_headache"}},
+		{"pediatrics", "爷爷喘不上气", []string{"pediatrics_breathing", "severe_dyspnea"}},
+
+		// Words containing a negation still describe the symptom
+		{"internal_medicine", "他突然无法呼吸", []string{"severe_dyspnea"}},
+		{"internal_medicine", "突然没法说话嘴歪了", []string{"stroke"}},
+		{"internal_medicine", "意识无法唤醒", []string{"syncope_headache"}},
+		{"internal_medicine", "浑身无力说话不清", []string{"stroke"}},
+		{"internal_medicine", "无法站立嘴歪了", []string{"stroke"}},
+		{"respiratory", "没法躺下喘不上气", []string{"severe_dyspnea"}},
+		{"cardiology", "无缘无故晕厥", []string{"syncope_headache"}},
+
+		// Denials do not fire; questions do
+		{"internal_medicine", "没有胸痛，也无晕厥", nil},
+		{"internal_medicine", "否认口眼歪斜", nil},
+		{"internal_medicine", "没嘴歪", nil},
+		{"obstetrics", "是不是宫外孕？肚子痛", []string{"obstetrics_ectopic"}},
+
+		// Specialty-specific signs stay in their specialty
+		{"obstetrics", "胎动减少了", []string{"obstetrics_fetal_movement"}},
+		{"dermatology", "胎动减少了", nil},
+		{"pediatrics", "孩子体温39.8度", []string{"pediatrics_high_fever"}},
+		{"pediatrics", "孩子体温39.2度", nil},
+		{"respiratory", "发烧39.5℃", []string{"adult_high_fever"}},
+		{"obstetrics", "孕32周，见红了", []string{"obstetrics_preterm_labor"}},
+		{"obstetrics", "孕38周，见红了", nil},
+
+		{"ent", "有点鼻塞", nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, m := range e.Check(tt.specialty, ScopePatient, tt.text) {
+			got = append(got, m.Rule)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s %q fired %v, want %v", tt.specialty, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestReplyScope(t *testing.T) {
+	e, err := New(DefaultRules())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := e.Check("pediatrics", ScopePatient, "请立即拨打120"); len(got) != 0 {
+		t.Errorf("reply rule fired on a patient message: %+v", got)
+	}
+	got := e.Check("pediatrics", ScopeReply, "建议立即拨打120")
+	if len(got) != 1 || got[0].Rule != "model_emergency_advice" || got[0].Handoff {
+		t.Errorf("reply matches = %+v", got)
+	}
+}
+
+func TestNewRejectsInvalidRules(t *testing.T) {
+	tests := map[string]Rule{
+		"no name":             {Keywords: []string{"x"}},
+		"matches nothing":     {Name: "r"},
+		"bad scope":           {Name: "r", Keywords: []string{"x"}, Scope: "other"},
+		"bad level":           {Name: "r", Keywords: []string{"x"}, Level: "panic"},
+		"bad pattern":         {Name: "r", Patterns: []string{"("}},
+		"no capture":          {Name: "r", Thresholds: []Threshold{{Pattern: `\d+`, Above: limit(1)}}},
+		"threshold limitless": {Name: "r", Thresholds: []Threshold{{Pattern: `(\d+)`}}},
+	}
+	for name, rule := range tests {
+		if _, err := New([]Rule{rule}); err == nil {
+			t.Errorf("%s: New accepted %+v", name, rule)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package redflag
+
+import "medseek/internal/triage"
+
+// Number patterns shared by the built-in rules
+const (
+	// temperatureUnitPattern captures a body temperature written with a unit,
+	// as in "39.8度" or "40℃"
+	temperatureUnitPattern = `((?:3\d|4[0-3])(?:\.\d+)?)\s*(?:°C|℃|度)`
+	// temperatureReadingPattern captures a bare reading, as in "体温39.6"
+	temperatureReadingPattern = `(?:体温|烧到|发烧)\s*((?:3\d|4[0-3])(?:\.\d+)?)`
+	// gestationalWeekPattern captures the week of pregnancy, as in "孕32周" or "怀孕 12 周"
+	gestationalWeekPattern = `孕\s*(\d{1,2})\s*[周w+]`
+)
+
+func limit(v float64) *float64 {
+	return &v
+}
+
+// feverAbove fires for body temperatures above celsius
+func feverAbove(celsius float64) []Threshold {
+	return []Threshold{
+		{Pattern: temperatureUnitPattern, Above: limit(celsius)},
+		{Pattern: temperatureReadingPattern, Above: limit(celsius)},
+	}
+}
+
+// DefaultRules returns the built-in red flags. They follow the emergency
+// sections of the specialty prompts in internal/deepseek.
+func DefaultRules() []Rule {
+	return []Rule{
+		// Obstetrics and gynaecology
+		{
+			Name:        "obstetrics_heavy_bleeding",
+			Specialties: []string{"obstetrics"},
+			Keywords:    []string{"大量出血", "大出血", "出血不止", "血流不止"},
+			Patterns:    []string{`阴道.{0,4}(大量|很多|不停|止不住).{0,2}(出血|流血)`},
+			Guidance:    "阴道大量出血可能危及生命，请立即平卧休息，不要自行服药。",
+			Handoff:     true,
+		},
+		{
+			Name:        "obstetrics_pregnancy_bleeding_pain",
+			Specialties: []string{"obstetrics"},
+			Keywords:    []string{"剧烈腹痛", "腹痛剧烈", "肚子剧痛", "下腹剧痛", "急性下腹痛"},
+			Requires:    []string{"孕", "怀孕", "妊娠", "停经"},
+			Guidance:    "妊娠期剧烈腹痛需警惕宫外孕、流产或胎盘早剥，请立即就医。",
+			Handoff:     true,
+		},
+		{
+			Name:        "obstetrics_ectopic",
+			Specialties: []string{"obstetrics"},
+			Keywords:    []string{"宫外孕", "异位妊娠", "肛门坠胀"},
+			Requires:    []string{"痛", "出血", "流血", "晕"},
+			Guidance:    "疑似宫外孕破裂可导致腹腔大出血，请立即前往医院急诊。",
+			Handoff:     true,
+		},
+		{
+			Name:        "obstetrics_preeclampsia",
+			Specialties: []string{"obstetrics"},
+			Keywords:    []string{"视力模糊", "眼前发黑", "看东西模糊", "抽搐"},
+			Requires:    []string{"孕", "怀孕", "妊娠"},
+			Guidance:    "妊娠期头痛、视物模糊或抽搐可能是子痫前期的表现，请立即测量血压并就医。",
+			Handoff:     true,
+		},
+		{
+			Name:        "obstetrics_preterm_labor",
+			Specialties: []string{"obstetrics"},
+			Thresholds:  []Threshold{{Pattern: gestationalWeekPattern, Below: limit(37)}},
+			Requires:    []string{"规律宫缩", "破水", "阴道流液", "羊水", "见红"},
+			Guidance:    "未满37周出现宫缩、破水或见红，需警惕早产，请立即前往产科急诊。",
+			Handoff:     true,
+		},
+		{
+			Name:        "obstetrics_fetal_movement",
+			Specialties: []string{"obstetrics"},
+			Keywords:    []string{"胎动减少", "胎动消失", "感觉不到胎动", "没有胎动"},
+			Guidance:    "胎动明显减少或消失可能提示胎儿缺氧，请立即前往医院行胎心监护。",
+			Handoff:     true,
+		},
+		{
+			Name:        "obstetrics_fever_abdominal_pain",
+			Specialties: []string{"obstetrics"},
+			Thresholds:  feverAbove(38.5),
+			Requires:    []string{"下腹痛", "腹痛", "肚子痛"},
+			Guidance:    "高热伴下腹痛可能是严重感染，请尽快就医。",
+			Handoff:     true,
+			Level:       triage.Urgent,
+		},
+
+		// Pediatrics
+		{
+			Name:        "pediatrics_high_fever",
+			Specialties: []string{"pediatrics"},
+			Keywords:    []string{"高热不退", "高烧不退", "热性惊厥", "抽搐", "惊厥"},
+			Thresholds:  feverAbove(39.5),
+			Guidance:    "儿童体温超过39.5°C或出现惊厥，请立即就医；惊厥时让孩子侧卧，不要往嘴里塞东西。",
+			Handoff:     true,
+		},
+		{
+			Name:        "pediatrics_breathing",
+			Specialties: []string{"pediatrics"},
+			Keywords:    []string{"呼吸困难", "喘不上气", "呼吸急促", "口唇发紫", "嘴唇发紫", "口唇发绀", "异物吸入", "卡住喉咙"},
+			Guidance:    "孩子呼吸困难或口唇发紫，请立即拨打120或前往急诊。",
+			Handoff:     true,
+		},
+		{
+			Name:        "pediatrics_consciousness",
+			Specialties: []string{"pediatrics"},
+			Keywords:    []string{"精神萎靡", "嗜睡", "叫不醒", "意识不清", "颈项强直", "脖子发硬"},
+			Guidance:    "孩子精神差、嗜睡或颈部发硬可能提示严重感染，请立即就医。",
+			Handoff:     true,
+		},
+		{
+			Name:        "pediatrics_bleeding_dehydration",
+			Specialties: []string{"pediatrics"},
+			Keywords:    []string{"便血", "黑便", "尿少", "没有尿", "频繁呕吐"},
+			Guidance:    "便血、频繁呕吐或尿量明显减少提示病情较重或脱水，请尽快就医。",
+			Handoff:     true,
+			Level:       triage.Urgent,
+		},
+		{
+			Name:        "pediatrics_poisoning_allergy",
+			Specialties: []string{"pediatrics"},
+			Keywords:    []string{"误服", "中毒", "严重过敏", "喉头水肿"},
+			Guidance:    "疑似中毒或严重过敏，请立即拨打120，并带上可疑物品或药品包装。",
+			Handoff:     true,
+		},
+
+		// Internal medicine and respiratory
+		{
+			Name:        "hemoptysis",
+			Specialties: []string{"respiratory", "internal_medicine"},
+			Keywords:    []string{"咳血", "咯血", "痰中带血"},
+			Guidance:    "咳血需尽快就医，大量咳血时请侧卧并拨打120。",
+			Handoff:     true,
+			Level:       triage.Urgent,
+		},
+		{
+			Name:        "adult_high_fever",
+			Specialties: []string{"internal_medicine", "respiratory"},
+			Keywords:    []string{"高热不退", "高烧不退"},
+			Thresholds:  feverAbove(39),
+			Guidance:    "持续高热超过39°C请尽快就医。",
+			Handoff:     true,
+			Level:       triage.Urgent,
+		},
+
+		// Dermatology and ENT
+		{
+			Name:        "skin_spreading",
+			Specialties: []string{"dermatology"},
+			Keywords:    []string{"迅速扩散", "快速扩散", "大面积", "全身起疱", "皮肤脱落"},
+			Guidance:    "皮疹快速扩散或大面积皮肤受损可能是严重药疹或感染，请立即就医。",
+			Handoff:     true,
+			Level:       triage.Urgent,
+		},
+		{
+			Name:        "ent_bleeding_airway",
+			Specialties: []string{"ent"},
+			Keywords:    []string{"鼻血不止", "流鼻血止不住", "吞咽困难", "呼吸困难", "喉咙肿得"},
+			Guidance:    "鼻出血不止或吞咽、呼吸困难，请立即就医。",
+			Handoff:     true,
+			Level:       triage.Urgent,
+		},
+
+		// Any specialty: life-threatening signs a patient may report whichever
+		// specialty they chose
+		{
+			Name:     "chest_pain",
+			Keywords: []string{"胸痛", "胸口痛", "胸口压榨", "胸闷气短"},
+			Requires: []string{"剧烈", "严重", "出冷汗", "大汗", "持续", "压榨", "气短", "喘不上气"},
+			Guidance: "严重胸痛需警惕心肌梗死，请立即停止活动、休息，并拨打120。",
+			Handoff:  true,
+		},
+		{
+			Name:     "stroke",
+			Keywords: []string{"口眼歪斜", "嘴歪", "口角歪斜", "言语不清", "说话不清", "说不出话", "没法说话", "半身无力", "肢体无力", "一侧麻木"},
+			Guidance: "口眼歪斜、言语不清或一侧肢体无力可能是脑卒中，请记下发病时间并立即拨打120。",
+			Handoff:  true,
+		},
+		{
+			Name:     "syncope_headache",
+			Keywords: []string{"晕厥", "昏倒", "昏迷", "意识模糊", "意识不清", "叫不醒", "无法唤醒", "剧烈头痛"},
+			Guidance: "晕厥、意识模糊或突发剧烈头痛需立即就医排查心脑血管急症。",
+			Handoff:  true,
+		},
+		{
+			Name:     "severe_dyspnea",
+			Keywords: []string{"严重呼吸困难", "喘不上气", "憋气", "无法呼吸", "没法呼吸", "嘴唇发紫", "口唇发紫"},
+			Guidance: "严重呼吸困难或口唇发紫，请立即拨打120。",
+			Handoff:  true,
+		},
+		{
+			Name:     "self_harm",
+			Keywords: []string{"自杀", "不想活", "轻生", "结束生命", "割腕"},
+			Guidance: "您的安全最重要。请立即联系身边的人，或拨打心理援助热线 400-161-9995；有生命危险时请拨打120或110。",
+			Handoff:  true,
+		},
+
+		// The model's own reply: it told the patient to seek emergency care
+		{
+			Name:     "model_emergency_advice",
+			Scope:    ScopeReply,
+			Keywords: []string{"立即拨打120", "马上拨打120", "尽快拨打120"},
+			Guidance: "AI医生助手建议您尽快就医。",
+		},
+	}
+}
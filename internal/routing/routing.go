@@ -0,0 +1,117 @@
+// Package routing recommends the specialty a patient should consult from a
+// free-text chief complaint.
+package routing
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"medseek/internal/specialty"
+)
+
+// Recommendation is a specialty suggested for a complaint. Confidence is
+// between 0 and 1. Name is left for the caller to fill from the specialty
+// registry.
+type Recommendation struct {
+	Specialty  string  `json:"specialty"`
+	Name       string  `json:"name"`
+	Confidence float64 `json:"confidence"`
+	Reason     string  `json:"reason,omitempty"`
+}
+
+// Recommend scores every specialty by its clue words found in complaint and
+// returns those with any, most confident first. Confidence is a specialty's
+// share of the total score, damped when there is little evidence overall, so
+// a single weak word never yields a confident answer.
+func Recommend(complaint string, specialties []specialty.Specialty) []Recommendation {
+	type scored struct {
+		id      string
+		score   float64
+		matched []string
+	}
+
+	var hits []scored
+	total := 0.0
+	for _, s := range specialties {
+		// Match longer words first so they cover their parts ("高血压" covers "血压")
+		clues := slices.Clone(s.Clues)
+		slices.SortStableFunc(clues, func(a, b specialty.Clue) int {
+			return cmp.Compare(len(b.Word), len(a.Word))
+		})
+
+		hit := scored{id: s.ID}
+		for _, c := range clues {
+			if strings.Contains(complaint, c.Word) && !coveredBy(c.Word, hit.matched) {
+				hit.score += c.Weight
+				hit.matched = append(hit.matched, c.Word)
+			}
+		}
+		if hit.score > 0 {
+			hits = append(hits, hit)
+			total += hit.score
+		}
+	}
+
+	recs := make([]Recommendation, 0, len(hits))
+	for _, hit := range hits {
+		confidence := hit.score / (total + 1)
+		recs = append(recs, Recommendation{
+			Specialty:  hit.id,
+			Confidence: math.Round(confidence*100) / 100,
+			Reason:     "提及" + strings.Join(hit.matched, "、"),
+		})
+	}
+	slices.SortStableFunc(recs, byConfidence)
+	return recs
+}
+
+// byConfidence sorts recommendations most confident first
+func byConfidence(a, b Recommendation) int {
+	return cmp.Compare(b.Confidence, a.Confidence)
+}
+
+func coveredBy(word string, matched []string) bool {
+	for _, m := range matched {
+		if strings.Contains(m, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrInvalidRecommendation is returned when the model's answer is not a list
+// of recommendations
+var ErrInvalidRecommendation = errors.New("invalid specialty recommendation")
+
+// ParseRecommendations reads the JSON array in a model response, keeping
+// the known specialties only, most confident first
+func ParseRecommendations(response string, known []string) ([]Recommendation, error) {
+	start := strings.Index(response, "[")
+	end := strings.LastIndex(response, "]")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("%w: no JSON array", ErrInvalidRecommendation)
+	}
+	var parsed []Recommendation
+	if err := json.Unmarshal([]byte(response[start:end+1]), &parsed); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRecommendation, err)
+	}
+
+	recs := make([]Recommendation, 0, len(parsed))
+	for _, rec := range parsed {
+		if !slices.Contains(known, rec.Specialty) || slices.ContainsFunc(recs, func(r Recommendation) bool { return r.Specialty == rec.Specialty }) {
+			continue
+		}
+		rec.Confidence = math.Max(0, math.Min(1, rec.Confidence))
+		recs = append(recs, rec)
+	}
+	if len(recs) == 0 {
+		return nil, fmt.Errorf("%w: no known specialty", ErrInvalidRecommendation)
+	}
+	slices.SortStableFunc(recs, byConfidence)
+	return recs, nil
+}
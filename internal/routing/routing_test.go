@@ -0,0 +1,55 @@
+package routing
+
+import (
+	"testing"
+
+	"medseek/internal/specialty"
+)
+
+func TestRecommend(t *testing.T) {
+	specialties := specialty.Defaults()
+
+	tests := []struct {
+		complaint string
+		want      string // best specialty; "" for none
+		reason    string
+	}{
+		{"孩子咳嗽三天了", "pediatrics", "提及孩子"},
+		{"怀孕8周，有点咳嗽", "obstetrics", "提及怀孕"},
+		{"高血压头痛", "cardiology", "提及高血压、头痛"},
+		{"脸上长痘，很痒", "dermatology", "提及痒、痘"},
+		{"耳鸣好几天", "ent", "提及耳鸣"},
+		{"今天天气不错", "", ""},
+	}
+	for _, tt := range tests {
+		recs := Recommend(tt.complaint, specialties)
+		if tt.want == "" {
+			if len(recs) != 0 {
+				t.Errorf("%q: got %+v, want none", tt.complaint, recs)
+			}
+			continue
+		}
+		if len(recs) == 0 || recs[0].Specialty != tt.want || recs[0].Reason != tt.reason {
+			t.Errorf("%q: got %+v, want %s (%s)", tt.complaint, recs, tt.want, tt.reason)
+		}
+	}
+}
+
+func TestRecommendIsDamped(t *testing.T) {
+	// A single weak word is never a confident answer
+	recs := Recommend("有点痒", specialty.Defaults())
+	if len(recs) != 1 || recs[0].Confidence != 0.5 {
+		t.Errorf("got %+v, want dermatology at 0.5", recs)
+	}
+}
+
+func TestRecommendUsesRegistryClues(t *testing.T) {
+	specialties := []specialty.Specialty{
+		{ID: "ophthalmology", Clues: []specialty.Clue{{Word: "近视", Weight: 3}, {Word: "眼", Weight: 1}}},
+		{ID: "internal_medicine"},
+	}
+	recs := Recommend("孩子近视，眼睛干", specialties)
+	if len(recs) != 1 || recs[0].Specialty != "ophthalmology" || recs[0].Reason != "提及近视、眼" {
+		t.Errorf("got %+v", recs)
+	}
+}
@@ -1,140 +1,295 @@
 package service
 
 import (
+	"context"
 	"fmt"
+	"log"
+	"strings"
 	"sync"
+	"time"
 
-	"medseek/internal/deepseek"
+	"github.com/google/uuid"
+
+	"medseek/internal/contextbuilder"
+	"medseek/internal/llm"
 	"medseek/internal/models"
+	"medseek/internal/pricing"
+	"medseek/internal/prompts"
+	"medseek/internal/redflag"
+	"medseek/internal/specialty"
+	"medseek/internal/store"
 )
 
 type ChatService struct {
-	deepseekClient *deepseek.Client
-	sessions       map[string]*models.ChatSession
-	messages       map[string][]*models.Message
-	specialties    map[string]string // session_id -> specialty
-	mu             sync.RWMutex
+	provider       llm.Provider
+	store          store.Store
+	contextBuilder *contextbuilder.Builder
+	summaryCfg     SummaryConfig
+	redflags       *redflag.Engine
+	triageCfg      TriageConfig
+	routingCfg     RoutingConfig
+	routingQuota   *userQuota // model recommendations per user before a session
+	prompts        *prompts.Library
+	specialties    *specialty.Registry
+	prices         *pricing.Table
+	summarizing    sync.Map   // session_id -> summary refresh in progress
+	handoffMu      sync.Mutex // serializes mode changes, so two doctors cannot claim one session
+	triageMu       sync.Mutex
+	triaging       map[string]bool // session_id -> assessment running; true if another turn arrived meanwhile
 }
 
-// NewChatService creates a new chat service
-func NewChatService(deepseekAPIKey string) *ChatService {
+// NewChatService creates a new chat service backed by the given LLM provider
+// and store, screening and triaging turns with the given red-flag rules,
+// routing patients among the registry's specialties, prompting with the
+// given templates and pricing token usage with the given table
+func NewChatService(provider llm.Provider, st store.Store, builder *contextbuilder.Builder, summaryCfg SummaryConfig, redflags *redflag.Engine, triageCfg TriageConfig, routingCfg RoutingConfig, library *prompts.Library, specialties *specialty.Registry, prices *pricing.Table) *ChatService {
 	return &ChatService{
-		deepseekClient: deepseek.NewClient(deepseekAPIKey),
-		sessions:       make(map[string]*models.ChatSession),
-		messages:       make(map[string][]*models.Message),
-		specialties:    make(map[string]string),
+		provider:       provider,
+		store:          st,
+		contextBuilder: builder,
+		summaryCfg:     summaryCfg,
+		redflags:       redflags,
+		triageCfg:      triageCfg,
+		routingCfg:     routingCfg,
+		routingQuota:   newUserQuota(routingCfg.UserHourly),
+		prompts:        library,
+		specialties:    specialties,
+		prices:         prices,
+		triaging:       make(map[string]bool),
 	}
 }
 
-// CreateSession creates a new chat session with specialty
-func (cs *ChatService) CreateSession(sessionID, userID, specialty string) *models.ChatSession {
-	cs.mu.Lock()
-	defer cs.mu.Unlock()
+// ModelInfo returns the model behind the chat service
+func (cs *ChatService) ModelInfo() models.ModelInfo {
+	return cs.provider.ModelInfo()
+}
+
+// CreateSession creates a new chat session with an enabled specialty and
+// assigns it to the prompt experiment running on it, if any. patientAge is
+// optional and only used in prompts.
+func (cs *ChatService) CreateSession(sessionID, userID, specialty, patientAge string) (*models.ChatSession, error) {
+	if err := cs.checkSpecialty(specialty); err != nil {
+		return nil, err
+	}
 
 	session := &models.ChatSession{
-		ID:     sessionID,
-		UserID: userID,
-		Status: "active",
+		ID:         sessionID,
+		UserID:     userID,
+		Specialty:  specialty,
+		PatientAge: strings.TrimSpace(patientAge),
+		StartTime:  time.Now(),
+		Status:     store.StatusActive,
+		Mode:       store.ModeAI,
 	}
+	cs.assignExperiment(session)
 
-	cs.sessions[sessionID] = session
-	cs.messages[sessionID] = make([]*models.Message, 0)
-	// Default to obstetrics if specialty not specified
-	if specialty == "" {
-		specialty = "obstetrics"
+	if err := cs.store.CreateSession(session); err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
 	}
-	cs.specialties[sessionID] = specialty
 
-	return session
+	return session, nil
 }
 
 // AddMessage adds a message to a session
-func (cs *ChatService) AddMessage(sessionID, userID, role, content string) *models.Message {
-	cs.mu.Lock()
-	defer cs.mu.Unlock()
-
-	msg := &models.Message{
-		ID:        fmt.Sprintf("%s-%d", sessionID, len(cs.messages[sessionID])),
+func (cs *ChatService) AddMessage(sessionID, userID, role, content string) (*models.Message, error) {
+	return cs.addMessage(&models.Message{
 		SessionID: sessionID,
 		UserID:    userID,
 		Role:      role,
 		Content:   content,
+	})
+}
+
+// AddReply adds an AI reply with the metadata of the model call. truncated
+// marks a reply whose generation was stopped or failed part way.
+func (cs *ChatService) AddReply(sessionID, content string, truncated bool, meta *models.MessageMeta) (*models.Message, error) {
+	return cs.addMessage(&models.Message{
+		SessionID: sessionID,
+		UserID:    "assistant",
+		Role:      "assistant",
+		Content:   content,
+		Truncated: truncated,
+		Meta:      meta,
+	})
+}
+
+// addMessage assigns an ID and the creation time to msg and stores it; the
+// store numbers it
+func (cs *ChatService) addMessage(msg *models.Message) (*models.Message, error) {
+	msg.ID = uuid.New().String()
+	msg.CreatedAt = time.Now()
+
+	if err := cs.store.AddMessage(msg); err != nil {
+		return nil, fmt.Errorf("failed to add message: %w", err)
 	}
 
-	if _, ok := cs.messages[sessionID]; !ok {
-		cs.messages[sessionID] = make([]*models.Message, 0)
+	// A finished AI or doctor reply may push older turns out of the recent window
+	if msg.Role != "user" {
+		cs.scheduleSummary(msg.SessionID)
 	}
 
-	cs.messages[sessionID] = append(cs.messages[sessionID], msg)
-	return msg
+	return msg, nil
 }
 
 // GetSessionMessages returns all messages for a session
-func (cs *ChatService) GetSessionMessages(sessionID string) []*models.Message {
-	cs.mu.RLock()
-	defer cs.mu.RUnlock()
+func (cs *ChatService) GetSessionMessages(sessionID string) ([]*models.Message, error) {
+	msgs, err := cs.store.ListMessages(sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load messages: %w", err)
+	}
+	return msgs, nil
+}
 
-	if msgs, ok := cs.messages[sessionID]; ok {
-		return msgs
+// GetMessagesAfter returns the messages of a session with a sequence number
+// greater than afterSeq, for clients resuming after a disconnect
+func (cs *ChatService) GetMessagesAfter(sessionID string, afterSeq int) ([]*models.Message, error) {
+	msgs, err := cs.GetSessionMessages(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	for i, msg := range msgs {
+		if msg.Seq > afterSeq {
+			return msgs[i:], nil
+		}
 	}
-	return []*models.Message{}
+	return []*models.Message{}, nil
 }
 
-// ProcessMessage sends a message to DeepSeek and returns the response
-func (cs *ChatService) ProcessMessage(sessionID string, userMessage string) (string, error) {
-	cs.mu.RLock()
-	sessionMsgs := cs.messages[sessionID]
-	specialty := cs.specialties[sessionID]
-	cs.mu.RUnlock()
+// ProcessMessage sends a stored user message to the LLM provider and returns
+// the response with the metadata of the call
+func (cs *ChatService) ProcessMessage(ctx context.Context, userMsg *models.Message) (string, *models.MessageMeta, error) {
+	messages, version, err := cs.buildMessages(userMsg)
+	if err != nil {
+		return "", nil, err
+	}
 
-	// Build DeepSeek messages with appropriate system prompt based on specialty
-	messages := []models.DeepSeekMsg{
-		{
-			Role:    "system",
-			Content: deepseek.GetDoctorConsultationPrompt(specialty),
-		},
+	// Get response from the LLM provider
+	start := time.Now()
+	completion, err := cs.complete(ctx, PurposeReply, caller{sessionID: userMsg.SessionID}, messages)
+	meta := replyMeta(completion, version, start)
+	if err != nil {
+		return "", meta, fmt.Errorf("failed to get model response: %w", err)
 	}
 
-	// Add conversation history
-	for _, msg := range sessionMsgs {
-		messages = append(messages, models.DeepSeekMsg{
-			Role:    msg.Role,
-			Content: msg.Content,
-		})
+	return completion.Content, meta, nil
+}
+
+// ProcessMessageStream sends a stored user message to the LLM provider and calls
+// onDelta for each streamed chunk. It returns the fully assembled response once
+// the stream ends, or as much as was streamed if it fails, with the metadata
+// of the call.
+func (cs *ChatService) ProcessMessageStream(ctx context.Context, userMsg *models.Message, onDelta func(string) error) (string, *models.MessageMeta, error) {
+	messages, version, err := cs.buildMessages(userMsg)
+	if err != nil {
+		return "", nil, err
 	}
 
-	// Add user message
-	messages = append(messages, models.DeepSeekMsg{
-		Role:    "user",
-		Content: userMessage,
-	})
+	start := time.Now()
+	completion, err := cs.provider.ChatCompletionStream(ctx, messages, onDelta)
+	meta := replyMeta(completion, version, start)
+	// A stream stopped part way is billed for what it produced
+	if err == nil || completion.Content != "" {
+		cs.recordUsage(PurposeReply, caller{sessionID: userMsg.SessionID}, messages, completion)
+	}
+	if err != nil {
+		return completion.Content, meta, fmt.Errorf("failed to stream model response: %w", err)
+	}
+
+	return completion.Content, meta, nil
+}
+
+// replyMeta describes a model call that started at start
+func replyMeta(completion models.Completion, promptVersion string, start time.Time) *models.MessageMeta {
+	return &models.MessageMeta{
+		Model:         completion.Model,
+		PromptVersion: promptVersion,
+		Usage:         completion.Usage,
+		LatencyMs:     time.Since(start).Milliseconds(),
+		FinishReason:  completion.FinishReason,
+	}
+}
+
+// buildMessages assembles the chat completion messages for a user turn and
+// returns them with the version of the system prompt. The turn itself is
+// already stored, so only the messages before it are history.
+func (cs *ChatService) buildMessages(userMsg *models.Message) ([]models.DeepSeekMsg, string, error) {
+	session, err := cs.store.GetSession(userMsg.SessionID)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load session %s: %w", userMsg.SessionID, err)
+	}
+	sessionMsgs, err := cs.store.ListMessages(userMsg.SessionID)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load messages: %w", err)
+	}
 
-	// Get response from DeepSeek
-	response, err := cs.deepseekClient.ChatCompletion(messages)
+	summary, err := cs.loadSummary(userMsg.SessionID)
 	if err != nil {
-		return "", fmt.Errorf("failed to get deepseek response: %w", err)
+		return nil, "", fmt.Errorf("failed to load summary: %w", err)
 	}
 
-	return response, nil
+	history := sessionMsgs
+	for i, msg := range sessionMsgs {
+		if msg.ID == userMsg.ID {
+			history = sessionMsgs[:i]
+			break
+		}
+	}
+	// Turns already folded into the summary are not sent verbatim. A queued
+	// turn may run after the summary has moved past it.
+	if n := min(summary.CoveredMessages, len(history)); n > 0 {
+		history = history[n:]
+	}
+
+	// Build messages with the system prompt for the session's specialty
+	prompt, version := cs.systemPrompt(session)
+	if version != session.PromptVersion {
+		session.PromptVersion = version
+		if err := cs.store.SavePrompt(session); err != nil {
+			return nil, "", fmt.Errorf("failed to save prompt version: %w", err)
+		}
+	}
+	result := cs.contextBuilder.Build(
+		prompt,
+		summary.Content,
+		history,
+		userMsg.Content,
+	)
+	if result.Dropped > 0 {
+		log.Printf("Context for session %s: dropped %d oldest messages to fit token budget", userMsg.SessionID, result.Dropped)
+	}
+
+	return result.Messages, version, nil
+}
+
+// systemPrompt renders the system prompt of a session's specialty, or of its
+// experiment variant, and returns it with its version
+func (cs *ChatService) systemPrompt(session *models.ChatSession) (prompt, version string) {
+	vars := prompts.Vars{PatientAge: session.PatientAge}
+	if session.DoctorID != "" {
+		if doctor, err := cs.store.GetDoctor(session.DoctorID); err == nil {
+			vars.DoctorName = doctor.Name
+		}
+	}
+	return cs.prompts.Render(session.Specialty, session.Experiment, session.Variant, vars)
 }
 
 // CloseSession closes a chat session
 func (cs *ChatService) CloseSession(sessionID string) error {
-	cs.mu.Lock()
-	defer cs.mu.Unlock()
+	session, err := cs.store.GetSession(sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to load session %s: %w", sessionID, err)
+	}
 
-	if session, ok := cs.sessions[sessionID]; ok {
-		session.Status = "closed"
+	// Closing an already closed session is a no-op
+	if session.Status == store.StatusClosed {
 		return nil
 	}
 
-	return fmt.Errorf("session not found: %s", sessionID)
+	_, err = cs.store.UpdateStatus(sessionID, store.StatusClosed, time.Now())
+	return err
 }
 
 // GetSession returns a session by ID
-func (cs *ChatService) GetSession(sessionID string) *models.ChatSession {
-	cs.mu.RLock()
-	defer cs.mu.RUnlock()
-
-	return cs.sessions[sessionID]
+func (cs *ChatService) GetSession(sessionID string) (*models.ChatSession, error) {
+	return cs.store.GetSession(sessionID)
 }
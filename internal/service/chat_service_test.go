@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"medseek/internal/llm"
+	"medseek/internal/store"
+)
+
+func TestProcessMessage(t *testing.T) {
+	provider := llm.NewScriptedProvider("发烧几天了？", "还有其他症状吗？")
+	cs, st := newTestService(t, provider)
+	session := newTestSession(t, cs, "pediatrics")
+
+	first, _ := cs.AddMessage(session.ID, session.UserID, "user", "孩子发烧")
+	reply, meta, err := cs.ProcessMessage(context.Background(), first)
+	if err != nil || reply != "发烧几天了？" {
+		t.Fatalf("ProcessMessage = %q, %v", reply, err)
+	}
+	if meta.Model != "scripted" || meta.FinishReason != "stop" || meta.Usage.TotalTokens == 0 || meta.PromptVersion == "" {
+		t.Errorf("meta = %+v", meta)
+	}
+	cs.AddReply(session.ID, reply, false, meta)
+
+	second, _ := cs.AddMessage(session.ID, session.UserID, "user", "两天")
+	if _, _, err := cs.ProcessMessage(context.Background(), second); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second call carries the system prompt, the first exchange and the
+	// new turn, which is not repeated from history
+	requests := provider.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("requests = %d, want 2", len(requests))
+	}
+	var got []string
+	for _, msg := range requests[1] {
+		got = append(got, msg.Role+":"+msg.Content)
+	}
+	want := []string{"user:孩子发烧", "assistant:发烧几天了？", "user:两天"}
+	if requests[1][0].Role != "system" || strings.Join(got[1:], "|") != strings.Join(want, "|") {
+		t.Errorf("second request = %q", got)
+	}
+
+	records, _ := st.ListUsage(time.Time{})
+	if len(records) != 2 || records[0].Purpose != PurposeReply || records[0].UserID != session.UserID ||
+		records[0].Specialty != "pediatrics" || records[0].Estimated {
+		t.Errorf("usage records = %+v", records)
+	}
+}
+
+func TestProcessMessageStream(t *testing.T) {
+	cs, st := newTestService(t, llm.NewScriptedProvider("请多喝水"))
+	session := newTestSession(t, cs, "pediatrics")
+	msg, _ := cs.AddMessage(session.ID, session.UserID, "user", "孩子咳嗽")
+
+	var streamed strings.Builder
+	reply, meta, err := cs.ProcessMessageStream(context.Background(), msg, func(d string) error {
+		streamed.WriteString(d)
+		return nil
+	})
+	if err != nil || reply != "请多喝水" || streamed.String() != reply {
+		t.Fatalf("ProcessMessageStream = %q (streamed %q), %v", reply, streamed.String(), err)
+	}
+	if meta.FinishReason != "stop" {
+		t.Errorf("meta = %+v", meta)
+	}
+	if records, _ := st.ListUsage(time.Time{}); len(records) != 1 || records[0].Estimated {
+		t.Errorf("usage records = %+v", records)
+	}
+}
+
+func TestProcessMessageStreamStopped(t *testing.T) {
+	cs, st := newTestService(t, llm.NewScriptedProvider("请多喝水，注意休息"))
+	session := newTestSession(t, cs, "pediatrics")
+	msg, _ := cs.AddMessage(session.ID, session.UserID, "user", "孩子咳嗽")
+
+	stop := errors.New("stopped")
+	n := 0
+	reply, meta, err := cs.ProcessMessageStream(context.Background(), msg, func(string) error {
+		if n++; n == 2 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) || reply != "请多" {
+		t.Fatalf("ProcessMessageStream = %q, %v", reply, err)
+	}
+	if meta.FinishReason != "" || meta.Usage.TotalTokens != 0 {
+		t.Errorf("meta of a stopped reply = %+v", meta)
+	}
+
+	// The provider reported no usage, so it is estimated from the text
+	records, _ := st.ListUsage(time.Time{})
+	if len(records) != 1 || !records[0].Estimated || records[0].CompletionTokens == 0 {
+		t.Errorf("usage records = %+v", records)
+	}
+}
+
+func TestCloseSession(t *testing.T) {
+	cs, _ := newTestService(t, llm.NewScriptedProvider())
+	session := newTestSession(t, cs, "pediatrics")
+
+	if err := cs.CloseSession(session.ID); err != nil {
+		t.Fatal(err)
+	}
+	// Closing again is a no-op
+	if err := cs.CloseSession(session.ID); err != nil {
+		t.Errorf("second close: %v", err)
+	}
+	if err := cs.CloseSession("missing"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("unknown session: err = %v, want store.ErrNotFound", err)
+	}
+}
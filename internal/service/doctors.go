@@ -0,0 +1,267 @@
+package service
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"medseek/internal/models"
+	"medseek/internal/store"
+	"medseek/internal/triage"
+)
+
+// QueueEntry is an active session as shown on the doctor workstation
+type QueueEntry struct {
+	Session *models.ChatSession `json:"session"`
+	// WaitSeconds is how long the patient has waited for a doctor, or for
+	// sessions nobody asked to hand off, how long the session has been open
+	WaitSeconds  int `json:"wait_seconds"`
+	MessageCount int `json:"message_count"`
+}
+
+// queueOrder ranks modes on the workstation: patients waiting for a doctor
+// first, then sessions a doctor holds, then AI sessions
+var queueOrder = map[string]int{
+	store.ModeWaiting: 0,
+	store.ModeDoctor:  1,
+	store.ModeAI:      2,
+}
+
+// DoctorQueue lists the active sessions of a specialty and triage level
+// (empty matches all) that a doctor holds or may observe. Within each mode
+// the most urgent sessions come first, then the longest waiting.
+func (cs *ChatService) DoctorQueue(doctor *models.User, specialty, level string) ([]QueueEntry, error) {
+	sessions, err := cs.store.ListSessions(store.SessionFilter{
+		Status:    store.StatusActive,
+		Specialty: specialty,
+		Triage:    level,
+	})
+	if err != nil {
+		return nil, err
+	}
+	profile, err := cs.savedProfile(doctor.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	queue := make([]QueueEntry, 0, len(sessions))
+	for _, session := range sessions {
+		if session.DoctorID != doctor.ID && !MayObserve(profile, session) {
+			continue
+		}
+		count, err := cs.store.CountMessages(session.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count messages: %w", err)
+		}
+		since := session.StartTime
+		if session.HandoffRequestedAt != nil {
+			since = *session.HandoffRequestedAt
+		}
+		queue = append(queue, QueueEntry{
+			Session:      session,
+			WaitSeconds:  int(now.Sub(since).Seconds()),
+			MessageCount: count,
+		})
+	}
+
+	slices.SortStableFunc(queue, func(a, b QueueEntry) int {
+		return cmp.Or(
+			cmp.Compare(queueOrder[a.Session.Mode], queueOrder[b.Session.Mode]),
+			cmp.Compare(triage.Rank(a.Session.Triage), triage.Rank(b.Session.Triage)),
+			cmp.Compare(b.WaitSeconds, a.WaitSeconds),
+		)
+	})
+	return queue, nil
+}
+
+// CanObserve reports whether a doctor may read a session they have not been
+// assigned
+func (cs *ChatService) CanObserve(doctor *models.User, session *models.ChatSession) (bool, error) {
+	profile, err := cs.savedProfile(doctor.ID)
+	if err != nil {
+		return false, err
+	}
+	return MayObserve(profile, session), nil
+}
+
+// MayObserve allows sessions of the doctor's own specialty and sessions
+// waiting for a doctor, which any doctor on duty may pick up. Doctors
+// without an active profile see only the sessions assigned to them.
+func MayObserve(profile *models.DoctorProfile, session *models.ChatSession) bool {
+	if profile == nil || !profile.Active {
+		return false
+	}
+	return session.Specialty == profile.Specialty || session.Mode == store.ModeWaiting
+}
+
+// savedProfile returns a doctor's stored profile, or nil if they have none
+func (cs *ChatService) savedProfile(doctorID string) (*models.DoctorProfile, error) {
+	profile, err := cs.store.GetDoctor(doctorID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load doctor %s: %w", doctorID, err)
+	}
+	return profile, nil
+}
+
+// GetDoctorProfile returns a doctor's profile, or store.ErrNotFound if an
+// admin has not onboarded them
+func (cs *ChatService) GetDoctorProfile(doctor *models.User) (*models.DoctorProfile, error) {
+	return cs.store.GetDoctor(doctor.ID)
+}
+
+// SetDoctorAvailable records whether a doctor is taking patients. Only
+// doctors with a valid, active profile may set themselves available.
+func (cs *ChatService) SetDoctorAvailable(doctor *models.User, available bool) (*models.DoctorProfile, error) {
+	profile, err := cs.store.GetDoctor(doctor.ID)
+	if err != nil {
+		return nil, err
+	}
+	if available && !profile.Active {
+		return nil, fmt.Errorf("%w: deactivated", ErrDoctorUnavailable)
+	}
+	if err := cs.ValidateDoctor(profile); err != nil {
+		return nil, err
+	}
+	profile.Available = available
+	if err := cs.saveDoctor(profile); err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+// licensePattern matches the 15-digit practising certificate number (医师执业证书编码)
+var licensePattern = regexp.MustCompile(`^\d{15}$`)
+
+var (
+	// ErrInvalidDoctor is returned for doctor profiles with missing or malformed fields
+	ErrInvalidDoctor = errors.New("invalid doctor profile")
+	// ErrLicenseTaken is returned when another doctor has the same license number
+	ErrLicenseTaken = errors.New("license number already registered")
+	// ErrDoctorExists is returned when creating a profile for an account that has one
+	ErrDoctorExists = errors.New("doctor profile already exists")
+)
+
+// DoctorUpdate holds the fields an admin changes on a doctor; nil fields are
+// left as they are
+type DoctorUpdate struct {
+	Name           *string   `json:"name"`
+	Specialty      *string   `json:"specialty"`
+	LicenseNo      *string   `json:"license_no"`
+	Bio            *string   `json:"bio"`
+	Qualifications *[]string `json:"qualifications"`
+	Active         *bool     `json:"active"`
+}
+
+// ValidateDoctor normalizes a doctor profile and checks its required fields,
+// specialty and license number. Doctors may belong to a disabled specialty.
+func (cs *ChatService) ValidateDoctor(profile *models.DoctorProfile) error {
+	profile.Name = strings.TrimSpace(profile.Name)
+	profile.Specialty = strings.TrimSpace(profile.Specialty)
+	profile.LicenseNo = strings.TrimSpace(profile.LicenseNo)
+	profile.Bio = strings.TrimSpace(profile.Bio)
+
+	qualifications := make([]string, 0, len(profile.Qualifications))
+	for _, q := range profile.Qualifications {
+		if q = strings.TrimSpace(q); q != "" {
+			qualifications = append(qualifications, q)
+		}
+	}
+	profile.Qualifications = qualifications
+
+	switch {
+	case profile.Name == "":
+		return fmt.Errorf("%w: name is required", ErrInvalidDoctor)
+	case profile.Specialty == "":
+		return fmt.Errorf("%w: specialty is required", ErrInvalidDoctor)
+	case !slices.Contains(cs.specialties.IDs(), profile.Specialty):
+		return fmt.Errorf("%w: unknown specialty %q", ErrInvalidDoctor, profile.Specialty)
+	case !licensePattern.MatchString(profile.LicenseNo):
+		return fmt.Errorf("%w: license number must be 15 digits", ErrInvalidDoctor)
+	}
+	return nil
+}
+
+// CreateDoctor saves a new, active doctor profile for the account profile.ID
+func (cs *ChatService) CreateDoctor(profile *models.DoctorProfile) error {
+	if err := cs.ValidateDoctor(profile); err != nil {
+		return err
+	}
+	if _, err := cs.store.GetDoctor(profile.ID); err == nil {
+		return ErrDoctorExists
+	} else if !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+
+	profile.Active = true
+	return cs.saveDoctor(profile)
+}
+
+// UpdateDoctor applies an admin's changes to a doctor profile. Deactivating
+// a doctor also marks them unavailable. It returns the profile and whether
+// the doctor was activated or deactivated, which changes their role.
+func (cs *ChatService) UpdateDoctor(doctorID string, update DoctorUpdate) (*models.DoctorProfile, bool, error) {
+	profile, err := cs.store.GetDoctor(doctorID)
+	if err != nil {
+		return nil, false, err
+	}
+	wasActive := profile.Active
+
+	if update.Name != nil {
+		profile.Name = *update.Name
+	}
+	if update.Specialty != nil {
+		profile.Specialty = *update.Specialty
+	}
+	if update.LicenseNo != nil {
+		profile.LicenseNo = *update.LicenseNo
+	}
+	if update.Bio != nil {
+		profile.Bio = *update.Bio
+	}
+	if update.Qualifications != nil {
+		profile.Qualifications = *update.Qualifications
+	}
+	if update.Active != nil {
+		profile.Active = *update.Active
+	}
+	if !profile.Active {
+		profile.Available = false
+	}
+	if err := cs.ValidateDoctor(profile); err != nil {
+		return nil, false, err
+	}
+
+	if err := cs.saveDoctor(profile); err != nil {
+		return nil, false, err
+	}
+	return profile, profile.Active != wasActive, nil
+}
+
+// ListDoctors returns the doctors of a specialty (all if empty), ordered by name
+func (cs *ChatService) ListDoctors(specialty string, includeInactive bool) ([]*models.DoctorProfile, error) {
+	return cs.store.ListDoctors(store.DoctorFilter{
+		Specialty:       specialty,
+		IncludeInactive: includeInactive,
+	})
+}
+
+// GetDoctor returns a doctor profile by the doctor's user ID
+func (cs *ChatService) GetDoctor(doctorID string) (*models.DoctorProfile, error) {
+	return cs.store.GetDoctor(doctorID)
+}
+
+func (cs *ChatService) saveDoctor(profile *models.DoctorProfile) error {
+	err := cs.store.SaveDoctor(profile)
+	if errors.Is(err, store.ErrConflict) {
+		return ErrLicenseTaken
+	}
+	return err
+}
@@ -0,0 +1,181 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"medseek/internal/llm"
+	"medseek/internal/models"
+	"medseek/internal/store"
+)
+
+// validDoctor is a profile that passes ValidateDoctor, for a new doctor user
+func validDoctor(t *testing.T, st *store.MemoryStore) *models.DoctorProfile {
+	t.Helper()
+	user := &models.User{ID: uuid.New().String(), Name: "张医生", Role: "doctor"}
+	if err := st.CreateUser(user); err != nil {
+		t.Fatal(err)
+	}
+	return &models.DoctorProfile{
+		ID:        user.ID,
+		Name:      "张医生",
+		Specialty: "pediatrics",
+		LicenseNo: "110101199001011",
+	}
+}
+
+func TestValidateDoctor(t *testing.T) {
+	cs, st := newTestService(t, llm.NewScriptedProvider())
+
+	tests := []struct {
+		name  string
+		edit  func(*models.DoctorProfile)
+		valid bool
+	}{
+		{"valid", func(p *models.DoctorProfile) {}, true},
+		{"license with spaces", func(p *models.DoctorProfile) { p.LicenseNo = " 110101199001011 " }, true},
+		{"short license", func(p *models.DoctorProfile) { p.LicenseNo = "11010119900101" }, false},
+		{"long license", func(p *models.DoctorProfile) { p.LicenseNo = "1101011990010111" }, false},
+		{"license with letters", func(p *models.DoctorProfile) { p.LicenseNo = "11010119900101X" }, false},
+		{"full-width digits", func(p *models.DoctorProfile) { p.LicenseNo = "１１０１０１１９９００１０１１" }, false},
+		{"no license", func(p *models.DoctorProfile) { p.LicenseNo = "" }, false},
+		{"blank name", func(p *models.DoctorProfile) { p.Name = "  " }, false},
+		{"no specialty", func(p *models.DoctorProfile) { p.Specialty = "" }, false},
+		{"unknown specialty", func(p *models.DoctorProfile) { p.Specialty = "neurology" }, false},
+	}
+	for _, tt := range tests {
+		profile := validDoctor(t, st)
+		tt.edit(profile)
+		err := cs.ValidateDoctor(profile)
+		if tt.valid && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if !tt.valid && !errors.Is(err, ErrInvalidDoctor) {
+			t.Errorf("%s: err = %v, want ErrInvalidDoctor", tt.name, err)
+		}
+	}
+
+	profile := validDoctor(t, st)
+	profile.Name = " 张医生 "
+	profile.Qualifications = []string{" 主任医师 ", "", "  "}
+	if err := cs.ValidateDoctor(profile); err != nil {
+		t.Fatal(err)
+	}
+	if profile.Name != "张医生" || len(profile.Qualifications) != 1 || profile.Qualifications[0] != "主任医师" {
+		t.Errorf("profile not normalized: %+v", profile)
+	}
+}
+
+func TestCreateDoctor(t *testing.T) {
+	cs, st := newTestService(t, llm.NewScriptedProvider())
+
+	first := validDoctor(t, st)
+	if err := cs.CreateDoctor(first); err != nil {
+		t.Fatal(err)
+	}
+	if saved, err := cs.GetDoctor(first.ID); err != nil || !saved.Active || saved.Available {
+		t.Errorf("created doctor = %+v, %v, want active and not yet available", saved, err)
+	}
+
+	again := validDoctor(t, st)
+	again.ID = first.ID
+	again.LicenseNo = "110101199001012"
+	if err := cs.CreateDoctor(again); !errors.Is(err, ErrDoctorExists) {
+		t.Errorf("second profile: err = %v, want ErrDoctorExists", err)
+	}
+	if err := cs.CreateDoctor(validDoctor(t, st)); !errors.Is(err, ErrLicenseTaken) {
+		t.Errorf("same license: err = %v, want ErrLicenseTaken", err)
+	}
+}
+
+func TestUpdateDoctorActiveState(t *testing.T) {
+	cs, st := newTestService(t, llm.NewScriptedProvider())
+	profile := validDoctor(t, st)
+	if err := cs.CreateDoctor(profile); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.SetDoctorAvailable(&models.User{ID: profile.ID}, true); err != nil {
+		t.Fatal(err)
+	}
+
+	active, inactive := true, false
+	name := "张主任"
+	steps := []struct {
+		name          string
+		update        DoctorUpdate
+		wantActive    bool
+		wantAvailable bool
+		wantChanged   bool // the role follows
+	}{
+		{"edit", DoctorUpdate{Name: &name}, true, true, false},
+		{"deactivate", DoctorUpdate{Active: &inactive}, false, false, true},
+		{"deactivate again", DoctorUpdate{Active: &inactive}, false, false, false},
+		{"edit while inactive", DoctorUpdate{Name: &name}, false, false, false},
+		// Reactivated doctors set themselves available again
+		{"reactivate", DoctorUpdate{Active: &active}, true, false, true},
+	}
+	for _, step := range steps {
+		got, changed, err := cs.UpdateDoctor(profile.ID, step.update)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if got.Active != step.wantActive || got.Available != step.wantAvailable || changed != step.wantChanged {
+			t.Errorf("%s: active %v, available %v, changed %v, want %v, %v, %v",
+				step.name, got.Active, got.Available, changed, step.wantActive, step.wantAvailable, step.wantChanged)
+		}
+	}
+
+	// A rejected update changes nothing
+	bad := "123"
+	if _, changed, err := cs.UpdateDoctor(profile.ID, DoctorUpdate{LicenseNo: &bad, Active: &inactive}); !errors.Is(err, ErrInvalidDoctor) || changed {
+		t.Errorf("invalid update: changed %v, err = %v, want ErrInvalidDoctor", changed, err)
+	}
+	if saved, _ := cs.GetDoctor(profile.ID); !saved.Active || saved.LicenseNo != profile.LicenseNo {
+		t.Errorf("invalid update was saved: %+v", saved)
+	}
+}
+
+func TestSetDoctorAvailableNeedsAValidProfile(t *testing.T) {
+	cs, st := newTestService(t, llm.NewScriptedProvider())
+
+	stranger := &models.User{ID: uuid.New().String(), Name: "自称医生", Role: "doctor"}
+	if err := st.CreateUser(stranger); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.GetDoctorProfile(stranger); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("no profile: GetDoctorProfile err = %v, want ErrNotFound", err)
+	}
+	if _, err := cs.SetDoctorAvailable(stranger, true); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("no profile: SetDoctorAvailable err = %v, want ErrNotFound", err)
+	}
+	if _, err := st.GetDoctor(stranger.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("a profile was saved for a doctor nobody onboarded: %v", err)
+	}
+
+	// newTestDoctor saves a profile without a license number
+	unlicensed := newTestDoctor(t, st, "pediatrics", true, false)
+	if _, err := cs.SetDoctorAvailable(unlicensed, true); !errors.Is(err, ErrInvalidDoctor) {
+		t.Errorf("unlicensed: err = %v, want ErrInvalidDoctor", err)
+	}
+	if profile, _ := st.GetDoctor(unlicensed.ID); profile.Available {
+		t.Error("unlicensed doctor was made available")
+	}
+
+	profile := validDoctor(t, st)
+	if err := cs.CreateDoctor(profile); err != nil {
+		t.Fatal(err)
+	}
+	inactive := false
+	if _, _, err := cs.UpdateDoctor(profile.ID, DoctorUpdate{Active: &inactive}); err != nil {
+		t.Fatal(err)
+	}
+	doctor := &models.User{ID: profile.ID}
+	if _, err := cs.SetDoctorAvailable(doctor, true); !errors.Is(err, ErrDoctorUnavailable) {
+		t.Errorf("deactivated: err = %v, want ErrDoctorUnavailable", err)
+	}
+	if _, err := cs.SetDoctorAvailable(doctor, false); err != nil {
+		t.Errorf("deactivated doctors may still go off duty: %v", err)
+	}
+}
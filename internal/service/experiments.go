@@ -0,0 +1,131 @@
+package service
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"medseek/internal/models"
+	"medseek/internal/store"
+)
+
+// MaxRatingCommentLength caps the comment patients leave with a rating, in
+// characters
+const MaxRatingCommentLength = 500
+
+// ErrInvalidRating is returned for a rating outside 1-5 or an overlong comment
+var ErrInvalidRating = errors.New("invalid rating")
+
+// RateSession stores the patient's 1-5 rating of a session and an optional
+// comment. A session can be rated while active or after it is closed; a new
+// rating replaces the previous one.
+func (cs *ChatService) RateSession(sessionID string, rating int, comment string) (*models.ChatSession, error) {
+	comment = strings.TrimSpace(comment)
+	if rating < 1 || rating > 5 {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidRating, rating)
+	}
+	if utf8.RuneCountInString(comment) > MaxRatingCommentLength {
+		return nil, fmt.Errorf("%w: comment too long", ErrInvalidRating)
+	}
+
+	session, err := cs.store.GetSession(sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load session %s: %w", sessionID, err)
+	}
+	if session.Status == store.StatusArchived {
+		return nil, ErrSessionClosed
+	}
+
+	now := time.Now()
+	session.Rating = rating
+	session.RatingComment = comment
+	session.RatedAt = &now
+	if err := cs.store.SaveRating(session); err != nil {
+		return nil, fmt.Errorf("failed to save rating: %w", err)
+	}
+	return session, nil
+}
+
+// assignExperiment puts a session into the active prompt experiment on its
+// specialty, if there is one, or takes it out of any experiment
+func (cs *ChatService) assignExperiment(session *models.ChatSession) {
+	session.Experiment, session.Variant = cs.prompts.Assign(session.Specialty)
+	if session.Experiment != "" {
+		log.Printf("Session %s assigned to experiment %s, variant %s", session.ID, session.Experiment, session.Variant)
+	}
+}
+
+// VariantStats compares the sessions of one experiment variant
+type VariantStats struct {
+	Experiment string `json:"experiment"`
+	Specialty  string `json:"specialty"`
+	Variant    string `json:"variant"`
+	Sessions   int    `json:"sessions"`
+	// Escalated sessions asked for a human doctor, whether or not they went
+	// back to AI later
+	Escalated      int     `json:"escalated"`
+	EscalationRate float64 `json:"escalation_rate"`
+	Rated          int     `json:"rated"`
+	AvgRating      float64 `json:"avg_rating"` // 0 when no session was rated
+}
+
+// ExperimentStats compares the variants of each prompt experiment on the
+// sessions started since the given time. Variants still configured are listed
+// even without sessions.
+func (cs *ChatService) ExperimentStats(since time.Time) ([]VariantStats, error) {
+	sessions, err := cs.store.ListSessions(store.SessionFilter{Since: since})
+	if err != nil {
+		return nil, err
+	}
+
+	type key struct{ experiment, variant string }
+	stats := make(map[key]*VariantStats)
+	for _, e := range cs.prompts.Experiments() {
+		for _, v := range e.Variants {
+			stats[key{e.Name, v.Name}] = &VariantStats{Experiment: e.Name, Specialty: e.Specialty, Variant: v.Name}
+		}
+	}
+	ratings := make(map[key]int)
+	for _, session := range sessions {
+		if session.Experiment == "" {
+			continue
+		}
+		k := key{session.Experiment, session.Variant}
+		s, ok := stats[k]
+		if !ok {
+			s = &VariantStats{Experiment: session.Experiment, Specialty: session.Specialty, Variant: session.Variant}
+			stats[k] = s
+		}
+		s.Sessions++
+		if session.EscalatedAt != nil {
+			s.Escalated++
+		}
+		if session.Rating > 0 {
+			s.Rated++
+			ratings[k] += session.Rating
+		}
+	}
+
+	list := make([]VariantStats, 0, len(stats))
+	for k, s := range stats {
+		if s.Sessions > 0 {
+			s.EscalationRate = float64(s.Escalated) / float64(s.Sessions)
+		}
+		if s.Rated > 0 {
+			s.AvgRating = float64(ratings[k]) / float64(s.Rated)
+		}
+		list = append(list, *s)
+	}
+	slices.SortFunc(list, func(a, b VariantStats) int {
+		return cmp.Or(
+			cmp.Compare(a.Experiment, b.Experiment),
+			cmp.Compare(a.Variant, b.Variant),
+		)
+	})
+	return list, nil
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"medseek/internal/llm"
+	"medseek/internal/models"
+	"medseek/internal/store"
+)
+
+// newExperimentSession starts a session in an experiment arm
+func newExperimentSession(t *testing.T, cs *ChatService, st *store.MemoryStore, variant string) *models.ChatSession {
+	t.Helper()
+	session := newTestSession(t, cs, "pediatrics")
+	session.Experiment, session.Variant = "tone", variant
+	if err := st.SavePrompt(session); err != nil {
+		t.Fatal(err)
+	}
+	return session
+}
+
+func TestExperimentStatsCountsRequestedHandoffs(t *testing.T) {
+	cs, st := newTestService(t, llm.NewScriptedProvider())
+	doctor := newTestDoctor(t, st, "pediatrics", true, true)
+
+	// Asked for a doctor, who later handed the session back to AI
+	returned := newExperimentSession(t, cs, st, "warm")
+	if _, _, err := cs.RequestHandoff(returned.ID, HandoffReasonPatient); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.AssignDoctor(returned.ID, doctor.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.ReturnToAI(returned.ID, doctor.ID); err != nil {
+		t.Fatal(err)
+	}
+	// Taken over by a doctor nobody asked for
+	claimed := newExperimentSession(t, cs, st, "warm")
+	if _, err := cs.AssignDoctor(claimed.ID, doctor.ID); err != nil {
+		t.Fatal(err)
+	}
+	// A red-flag rule asked for a doctor
+	flagged := newExperimentSession(t, cs, st, "brief")
+	if _, _, err := cs.RequestHandoff(flagged.ID, "chest_pain"); err != nil {
+		t.Fatal(err)
+	}
+	newExperimentSession(t, cs, st, "brief")
+	if _, err := cs.RateSession(flagged.ID, 4, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := cs.ExperimentStats(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("stats = %+v, want two variants", stats)
+	}
+	for _, s := range stats {
+		if s.Sessions != 2 || s.Escalated != 1 || s.EscalationRate != 0.5 {
+			t.Errorf("%s: %d sessions, %d escalated (%.2f), want 2, 1 (0.50)", s.Variant, s.Sessions, s.Escalated, s.EscalationRate)
+		}
+	}
+	if brief := stats[0]; brief.Variant != "brief" || brief.Rated != 1 || brief.AvgRating != 4 {
+		t.Errorf("brief = %+v, want one rating of 4", brief)
+	}
+}
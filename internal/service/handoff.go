@@ -0,0 +1,135 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"medseek/internal/models"
+	"medseek/internal/store"
+)
+
+// HandoffReasonPatient marks a human doctor requested by the patient; red-flag
+// rules record their own name instead
+const HandoffReasonPatient = "patient"
+
+// HandoffReasonTransfer marks a session a doctor held when it was moved to
+// another specialty, and that waits for a doctor of that specialty
+const HandoffReasonTransfer = "transfer"
+
+var (
+	// ErrSessionClosed is returned when handing off a session that has ended
+	ErrSessionClosed = errors.New("session is closed")
+	// ErrAlreadyAssigned is returned when claiming a session another doctor has taken
+	ErrAlreadyAssigned = errors.New("session is assigned to another doctor")
+	// ErrNotAssigned is returned when a doctor acts on a session they have not claimed
+	ErrNotAssigned = errors.New("session is not assigned to this doctor")
+	// ErrDoctorUnavailable is returned when a doctor without an active profile,
+	// or not taking patients, claims a session
+	ErrDoctorUnavailable = errors.New("doctor is not available")
+)
+
+// RequestHandoff asks for a human doctor and pauses AI replies. It returns the
+// session and whether its mode changed; asking again while waiting for or
+// talking to a doctor changes nothing.
+func (cs *ChatService) RequestHandoff(sessionID, reason string) (*models.ChatSession, bool, error) {
+	cs.handoffMu.Lock()
+	defer cs.handoffMu.Unlock()
+
+	session, err := cs.activeSession(sessionID)
+	if err != nil {
+		return nil, false, err
+	}
+	if session.Mode != store.ModeAI {
+		return session, false, nil
+	}
+
+	now := time.Now()
+	session.Mode = store.ModeWaiting
+	session.HandoffReason = reason
+	session.HandoffRequestedAt = &now
+	if session.EscalatedAt == nil {
+		session.EscalatedAt = &now
+	}
+	if err := cs.store.SaveHandoff(session); err != nil {
+		return nil, false, err
+	}
+	log.Printf("Session %s waiting for a human doctor (%s)", sessionID, reason)
+	return session, true, nil
+}
+
+// AssignDoctor hands a session to a doctor, who replies instead of the model
+// until ReturnToAI. A doctor may take over an AI session without a request,
+// but only with an active profile and while marked available.
+func (cs *ChatService) AssignDoctor(sessionID, doctorID string) (*models.ChatSession, error) {
+	cs.handoffMu.Lock()
+	defer cs.handoffMu.Unlock()
+
+	session, err := cs.activeSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if session.Mode == store.ModeDoctor {
+		if session.DoctorID != doctorID {
+			return nil, ErrAlreadyAssigned
+		}
+		return session, nil
+	}
+
+	profile, err := cs.store.GetDoctor(doctorID)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return nil, fmt.Errorf("%w: no doctor profile", ErrDoctorUnavailable)
+	case err != nil:
+		return nil, fmt.Errorf("failed to load doctor %s: %w", doctorID, err)
+	case !profile.Active:
+		return nil, fmt.Errorf("%w: deactivated", ErrDoctorUnavailable)
+	case !profile.Available:
+		return nil, fmt.Errorf("%w: not taking patients", ErrDoctorUnavailable)
+	}
+
+	session.Mode = store.ModeDoctor
+	session.DoctorID = doctorID
+	if err := cs.store.SaveHandoff(session); err != nil {
+		return nil, err
+	}
+	log.Printf("Doctor %s took over session %s", doctorID, sessionID)
+	return session, nil
+}
+
+// ReturnToAI hands a session back to the model. The doctor stays recorded on
+// the session and can still read it.
+func (cs *ChatService) ReturnToAI(sessionID, doctorID string) (*models.ChatSession, error) {
+	cs.handoffMu.Lock()
+	defer cs.handoffMu.Unlock()
+
+	session, err := cs.activeSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if session.Mode != store.ModeDoctor || session.DoctorID != doctorID {
+		return nil, ErrNotAssigned
+	}
+
+	session.Mode = store.ModeAI
+	session.HandoffReason = ""
+	session.HandoffRequestedAt = nil
+	if err := cs.store.SaveHandoff(session); err != nil {
+		return nil, err
+	}
+	log.Printf("Doctor %s returned session %s to AI", doctorID, sessionID)
+	return session, nil
+}
+
+// activeSession loads a session that has not been closed
+func (cs *ChatService) activeSession(sessionID string) (*models.ChatSession, error) {
+	session, err := cs.store.GetSession(sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load session %s: %w", sessionID, err)
+	}
+	if session.Status != store.StatusActive {
+		return nil, ErrSessionClosed
+	}
+	return session, nil
+}
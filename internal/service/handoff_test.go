@@ -0,0 +1,108 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"medseek/internal/llm"
+	"medseek/internal/models"
+	"medseek/internal/store"
+)
+
+// newTestDoctor saves a doctor account and profile in a specialty
+func newTestDoctor(t *testing.T, st *store.MemoryStore, specialtyID string, active, available bool) *models.User {
+	t.Helper()
+	user := &models.User{ID: uuid.New().String(), Name: "李医生", Role: "doctor"}
+	if err := st.CreateUser(user); err != nil {
+		t.Fatal(err)
+	}
+	profile := &models.DoctorProfile{ID: user.ID, Name: user.Name, Specialty: specialtyID, Active: active, Available: available}
+	if err := st.SaveDoctor(profile); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestAssignDoctorChecksTheProfile(t *testing.T) {
+	cs, st := newTestService(t, llm.NewScriptedProvider())
+	session := newTestSession(t, cs, "pediatrics")
+
+	noProfile := &models.User{ID: uuid.New().String(), Role: "doctor"}
+	if err := st.CreateUser(noProfile); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		doctorID string
+	}{
+		{"no profile", noProfile.ID},
+		{"inactive", newTestDoctor(t, st, "pediatrics", false, false).ID},
+		{"unavailable", newTestDoctor(t, st, "pediatrics", true, false).ID},
+	}
+	for _, tt := range tests {
+		if _, err := cs.AssignDoctor(session.ID, tt.doctorID); !errors.Is(err, ErrDoctorUnavailable) {
+			t.Errorf("%s: err = %v, want ErrDoctorUnavailable", tt.name, err)
+		}
+	}
+
+	doctor := newTestDoctor(t, st, "pediatrics", true, true)
+	got, err := cs.AssignDoctor(session.ID, doctor.ID)
+	if err != nil || got.Mode != store.ModeDoctor || got.DoctorID != doctor.ID {
+		t.Fatalf("AssignDoctor = %+v, %v", got, err)
+	}
+	// Claiming again is a no-op; another doctor is turned away
+	if _, err := cs.AssignDoctor(session.ID, doctor.ID); err != nil {
+		t.Errorf("second claim: %v", err)
+	}
+	other := newTestDoctor(t, st, "pediatrics", true, true)
+	if _, err := cs.AssignDoctor(session.ID, other.ID); !errors.Is(err, ErrAlreadyAssigned) {
+		t.Errorf("other doctor: err = %v, want ErrAlreadyAssigned", err)
+	}
+}
+
+func TestCanObserve(t *testing.T) {
+	cs, st := newTestService(t, llm.NewScriptedProvider())
+	ownSpecialty := newTestSession(t, cs, "pediatrics")
+	otherSpecialty := newTestSession(t, cs, "dermatology")
+	waiting := newTestSession(t, cs, "dermatology")
+	if _, _, err := cs.RequestHandoff(waiting.ID, HandoffReasonPatient); err != nil {
+		t.Fatal(err)
+	}
+	waiting, _ = cs.GetSession(waiting.ID)
+
+	doctor := newTestDoctor(t, st, "pediatrics", true, false)
+	inactive := newTestDoctor(t, st, "pediatrics", false, false)
+	tests := []struct {
+		name    string
+		doctor  *models.User
+		session *models.ChatSession
+		want    bool
+	}{
+		{"own specialty", doctor, ownSpecialty, true},
+		{"other specialty", doctor, otherSpecialty, false},
+		{"waiting in another specialty", doctor, waiting, true},
+		{"inactive doctor", inactive, ownSpecialty, false},
+		{"no profile", &models.User{ID: "nobody", Role: "doctor"}, waiting, false},
+	}
+	for _, tt := range tests {
+		got, err := cs.CanObserve(tt.doctor, tt.session)
+		if err != nil || got != tt.want {
+			t.Errorf("%s: CanObserve = %v, %v, want %v", tt.name, got, err, tt.want)
+		}
+	}
+
+	// The queue lists the same sessions
+	queue, err := cs.DoctorQueue(doctor, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, entry := range queue {
+		seen[entry.Session.ID] = true
+	}
+	if len(queue) != 2 || !seen[ownSpecialty.ID] || !seen[waiting.ID] {
+		t.Errorf("queue has %d sessions, want the own specialty and the waiting one", len(queue))
+	}
+}
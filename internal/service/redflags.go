@@ -0,0 +1,104 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"medseek/internal/models"
+	"medseek/internal/redflag"
+)
+
+// Alert reports red-flag rules that fired on a message
+type Alert struct {
+	Session *models.ChatSession
+	Scope   string // redflag.ScopePatient or redflag.ScopeReply
+	Matches []redflag.Match
+}
+
+// Rules returns the names of the rules that fired
+func (a *Alert) Rules() []string {
+	rules := make([]string, len(a.Matches))
+	for i, m := range a.Matches {
+		rules[i] = m.Rule
+	}
+	return rules
+}
+
+// HandoffReason returns the first fired rule that asks for a human doctor,
+// or "" if none does
+func (a *Alert) HandoffReason() string {
+	for _, m := range a.Matches {
+		if m.Handoff {
+			return m.Rule
+		}
+	}
+	return ""
+}
+
+// Guidance returns the patient-facing advice of every fired rule, followed
+// by the emergency notice
+func (a *Alert) Guidance() string {
+	lines := make([]string, 0, len(a.Matches)+1)
+	for _, m := range a.Matches {
+		if m.Guidance != "" && !slices.Contains(lines, m.Guidance) {
+			lines = append(lines, m.Guidance)
+		}
+	}
+	lines = append(lines, redflag.EmergencyNotice)
+	return strings.Join(lines, "\n")
+}
+
+// Evidence returns the matched texts, for staff
+func (a *Alert) Evidence() string {
+	evidence := make([]string, 0, len(a.Matches))
+	for _, m := range a.Matches {
+		if !slices.Contains(evidence, m.Evidence) {
+			evidence = append(evidence, m.Evidence)
+		}
+	}
+	return strings.Join(evidence, "、")
+}
+
+// ScreenMessage checks a stored message against the red-flag rules of its
+// session's specialty: patient messages before the model answers, model
+// replies afterwards. Fired rules are recorded on the session. It returns nil
+// if no rule fired; doctor messages are never screened.
+func (cs *ChatService) ScreenMessage(msg *models.Message) (*Alert, error) {
+	var scope string
+	switch msg.Role {
+	case "user":
+		scope = redflag.ScopePatient
+	case "assistant":
+		scope = redflag.ScopeReply
+	default:
+		return nil, nil
+	}
+
+	session, err := cs.store.GetSession(msg.SessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load session %s: %w", msg.SessionID, err)
+	}
+
+	matches := cs.redflags.Check(session.Specialty, scope, msg.Content)
+	if len(matches) == 0 {
+		return nil, nil
+	}
+	alert := &Alert{Session: session, Scope: scope, Matches: matches}
+	log.Printf("Red flags in session %s (%s): %s [%s]", session.ID, scope, strings.Join(alert.Rules(), ","), alert.Evidence())
+
+	flags := slices.Clone(session.RedFlags)
+	for _, m := range matches {
+		if !slices.Contains(flags, m.Rule) {
+			flags = append(flags, m.Rule)
+		}
+	}
+	if len(flags) > len(session.RedFlags) {
+		session.RedFlags = flags
+		if err := cs.store.SaveRedFlags(session); err != nil {
+			return alert, fmt.Errorf("failed to tag session: %w", err)
+		}
+	}
+	return alert, nil
+}
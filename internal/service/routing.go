@@ -0,0 +1,240 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"medseek/internal/deepseek"
+	"medseek/internal/models"
+	"medseek/internal/routing"
+	"medseek/internal/store"
+)
+
+// RoutingConfig controls specialty recommendations
+type RoutingConfig struct {
+	// Model asks the model for recommendations; the keyword router answers
+	// when it is off or the call fails
+	Model bool
+	// SuggestConfidence is the confidence a better specialty needs before a
+	// session is offered a transfer after the patient's first message
+	SuggestConfidence float64
+	// UserHourly caps the model recommendations a user gets per hour before
+	// a session is created; past it the keyword router answers. 0 is no cap.
+	UserHourly int
+}
+
+// RoutingConfigFromEnv reads ROUTING_MODEL, ROUTING_SUGGEST_CONFIDENCE and
+// ROUTING_USER_HOURLY
+func RoutingConfigFromEnv() RoutingConfig {
+	cfg := RoutingConfig{SuggestConfidence: 0.5, UserHourly: 20}
+	if v, err := strconv.ParseBool(os.Getenv("ROUTING_MODEL")); err == nil {
+		cfg.Model = v
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("ROUTING_SUGGEST_CONFIDENCE"), 64); err == nil && v > 0 && v <= 1 {
+		cfg.SuggestConfidence = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("ROUTING_USER_HOURLY")); err == nil && v >= 0 {
+		cfg.UserHourly = v
+	}
+	return cfg
+}
+
+// RecommendSpecialties returns the enabled specialties suited to a user's
+// chief complaint, most confident first. It is empty when nothing in the
+// complaint points to one. Users past their hourly model recommendations
+// get the keyword router's.
+func (cs *ChatService) RecommendSpecialties(ctx context.Context, userID, complaint string) []routing.Recommendation {
+	if cs.routingCfg.Model && !cs.routingQuota.allow(userID, time.Now()) {
+		log.Printf("User %s is past the hourly model routing limit, using keywords", userID)
+		return cs.namedRecommendations(routing.Recommend(strings.TrimSpace(complaint), cs.specialties.List(true)))
+	}
+	return cs.recommend(ctx, caller{userID: userID}, complaint)
+}
+
+// recommend routes a complaint made by c
+func (cs *ChatService) recommend(ctx context.Context, c caller, complaint string) []routing.Recommendation {
+	complaint = strings.TrimSpace(complaint)
+	if complaint == "" {
+		return []routing.Recommendation{}
+	}
+
+	if cs.routingCfg.Model {
+		recs, err := cs.recommendWithModel(ctx, c, complaint)
+		if err == nil {
+			return cs.namedRecommendations(recs)
+		}
+		log.Printf("Model specialty routing failed, using keywords: %v", err)
+	}
+	return cs.namedRecommendations(routing.Recommend(complaint, cs.specialties.List(true)))
+}
+
+// userQuota counts each user's calls within a sliding hour
+type userQuota struct {
+	limit     int
+	calls     map[string][]time.Time // user_id -> calls in the last hour, oldest first
+	lastSweep time.Time
+	mu        sync.Mutex
+}
+
+func newUserQuota(limit int) *userQuota {
+	return &userQuota{limit: limit, calls: make(map[string][]time.Time)}
+}
+
+// allow records a call by userID and reports whether it is within the limit
+func (q *userQuota) allow(userID string, now time.Time) bool {
+	if q.limit <= 0 {
+		return true
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if now.Sub(q.lastSweep) >= time.Minute {
+		q.lastSweep = now
+		for id := range q.calls {
+			q.prune(id, now)
+		}
+	}
+	if len(q.prune(userID, now)) >= q.limit {
+		return false
+	}
+	q.calls[userID] = append(q.calls[userID], now)
+	return true
+}
+
+// prune drops calls older than an hour and returns the rest
+func (q *userQuota) prune(userID string, now time.Time) []time.Time {
+	calls := q.calls[userID]
+	i := 0
+	for i < len(calls) && now.Sub(calls[i]) >= time.Hour {
+		i++
+	}
+	calls = calls[i:]
+	if len(calls) == 0 {
+		delete(q.calls, userID)
+	} else {
+		q.calls[userID] = calls
+	}
+	return calls
+}
+
+// namedRecommendations drops the recommendations of disabled specialties and
+// fills in the names of the others
+func (cs *ChatService) namedRecommendations(recs []routing.Recommendation) []routing.Recommendation {
+	named := make([]routing.Recommendation, 0, len(recs))
+	for _, rec := range recs {
+		if cs.specialties.Enabled(rec.Specialty) {
+			rec.Name = cs.specialties.Name(rec.Specialty)
+			named = append(named, rec)
+		}
+	}
+	return named
+}
+
+// recommendWithModel asks the model to route a complaint
+func (cs *ChatService) recommendWithModel(ctx context.Context, c caller, complaint string) ([]routing.Recommendation, error) {
+	var choices strings.Builder
+	var enabled []string
+	for _, s := range cs.specialties.List(false) {
+		fmt.Fprintf(&choices, "%s: %s\n", s.ID, s.Name)
+		enabled = append(enabled, s.ID)
+	}
+
+	response, err := cs.complete(ctx, PurposeRouting, c, []models.DeepSeekMsg{
+		{Role: "system", Content: deepseek.GetRoutingPrompt(choices.String())},
+		{Role: "user", Content: complaint},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("routing call failed: %w", err)
+	}
+	return routing.ParseRecommendations(response.Content, enabled)
+}
+
+// SuggestTransfer checks the patient's first message, the chief complaint,
+// for a better suited specialty than the session's. It returns nil unless
+// one is recommended with at least the configured confidence and more
+// confidently than the session's own specialty.
+func (cs *ChatService) SuggestTransfer(ctx context.Context, msg *models.Message) (*routing.Recommendation, error) {
+	if msg.Role != "user" || msg.Seq != 1 {
+		return nil, nil
+	}
+	session, err := cs.store.GetSession(msg.SessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load session %s: %w", msg.SessionID, err)
+	}
+
+	recs := cs.recommend(ctx, caller{sessionID: msg.SessionID}, msg.Content)
+	if len(recs) == 0 {
+		return nil, nil
+	}
+	best := recs[0]
+	if best.Specialty == session.Specialty || best.Confidence < cs.routingCfg.SuggestConfidence {
+		return nil, nil
+	}
+	if i := slices.IndexFunc(recs, func(r routing.Recommendation) bool { return r.Specialty == session.Specialty }); i >= 0 && recs[i].Confidence >= best.Confidence {
+		return nil, nil
+	}
+	log.Printf("Suggesting transfer of session %s from %s to %s (%.2f)", session.ID, session.Specialty, best.Specialty, best.Confidence)
+	return &best, nil
+}
+
+// TransferSession moves an active session to another enabled specialty. The
+// history is kept; later model calls use the new specialty's system prompt,
+// and the session joins the experiment running on it, if any. The session
+// leaves its doctor: one holding it hands it to the new specialty's queue,
+// where it waits for a doctor, and one who had returned it to AI loses
+// access. It returns the session and whether its specialty changed.
+func (cs *ChatService) TransferSession(sessionID, specialty string) (*models.ChatSession, bool, error) {
+	if err := cs.checkSpecialty(specialty); err != nil {
+		return nil, false, err
+	}
+
+	cs.handoffMu.Lock()
+	defer cs.handoffMu.Unlock()
+
+	session, err := cs.activeSession(sessionID)
+	if err != nil {
+		return nil, false, err
+	}
+	if session.Specialty == specialty {
+		return session, false, nil
+	}
+
+	from := session.Specialty
+	if err := cs.store.SetSpecialty(sessionID, specialty); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, false, err
+		}
+		return nil, false, fmt.Errorf("failed to transfer session: %w", err)
+	}
+	session.Specialty = specialty
+	log.Printf("Session %s transferred from %s to %s", sessionID, from, specialty)
+	if session.DoctorID != "" {
+		if session.Mode == store.ModeDoctor {
+			now := time.Now()
+			session.Mode = store.ModeWaiting
+			session.HandoffRequestedAt = &now
+			if session.HandoffReason == "" {
+				session.HandoffReason = HandoffReasonTransfer
+			}
+		}
+		log.Printf("Doctor %s released session %s on transfer", session.DoctorID, sessionID)
+		session.DoctorID = ""
+		if err := cs.store.SaveHandoff(session); err != nil {
+			return nil, false, fmt.Errorf("failed to release doctor: %w", err)
+		}
+	}
+	// The experiment of the old specialty no longer applies
+	cs.assignExperiment(session)
+	if err := cs.store.SavePrompt(session); err != nil {
+		return nil, false, fmt.Errorf("failed to save prompt experiment: %w", err)
+	}
+	return session, true, nil
+}
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"medseek/internal/llm"
+	"medseek/internal/store"
+)
+
+func TestSuggestTransfer(t *testing.T) {
+	cs, _ := newTestService(t, llm.NewScriptedProvider())
+	// A single weak clue word scores 0.5
+	cs.routingCfg.SuggestConfidence = 0.6
+	session := newTestSession(t, cs, "pediatrics")
+
+	first, _ := cs.AddMessage(session.ID, session.UserID, "user", "脸上起了一片皮疹，很痒")
+	rec, err := cs.SuggestTransfer(context.Background(), first)
+	if err != nil || rec == nil || rec.Specialty != "dermatology" || rec.Name == "" {
+		t.Fatalf("SuggestTransfer = %+v, %v, want dermatology", rec, err)
+	}
+
+	// Only the chief complaint is routed
+	second, _ := cs.AddMessage(session.ID, session.UserID, "user", "耳鸣好几天了")
+	if rec, err := cs.SuggestTransfer(context.Background(), second); err != nil || rec != nil {
+		t.Errorf("second message: SuggestTransfer = %+v, %v, want nil", rec, err)
+	}
+
+	tests := []struct {
+		name, specialty, complaint string
+	}{
+		{"own specialty", "dermatology", "脸上起了一片皮疹，很痒"},
+		{"own specialty as confident", "pediatrics", "孩子起了皮疹"},
+		{"not confident enough", "pediatrics", "有点痒"},
+		{"no clue", "pediatrics", "今天天气不错"},
+	}
+	for _, tt := range tests {
+		session := newTestSession(t, cs, tt.specialty)
+		msg, _ := cs.AddMessage(session.ID, session.UserID, "user", tt.complaint)
+		if rec, err := cs.SuggestTransfer(context.Background(), msg); err != nil || rec != nil {
+			t.Errorf("%s: SuggestTransfer = %+v, %v, want nil", tt.name, rec, err)
+		}
+	}
+}
+
+func TestRecommendFallsBackToKeywords(t *testing.T) {
+	provider := llm.NewScriptedProvider(
+		`[{"specialty":"ent","confidence":0.9,"reason":"耳部症状"},{"specialty":"neurology","confidence":0.8}]`,
+		"我不确定",
+	)
+	cs, _ := newTestService(t, provider)
+	cs.routingCfg.Model = true
+
+	// The model's answer wins; specialties it made up are dropped
+	recs := cs.RecommendSpecialties(context.Background(), "u1", "脸上起了皮疹")
+	if len(recs) != 1 || recs[0].Specialty != "ent" || recs[0].Name == "" {
+		t.Errorf("model recommendations = %+v, want ent only", recs)
+	}
+
+	// An answer that is not a recommendation falls back to the keyword router
+	recs = cs.RecommendSpecialties(context.Background(), "u1", "脸上起了皮疹")
+	if len(recs) == 0 || recs[0].Specialty != "dermatology" {
+		t.Errorf("fallback recommendations = %+v, want dermatology", recs)
+	}
+	if n := len(provider.Requests()); n != 2 {
+		t.Errorf("model got %d calls, want 2", n)
+	}
+
+	if recs := cs.RecommendSpecialties(context.Background(), "u1", "  "); len(recs) != 0 {
+		t.Errorf("empty complaint got %+v", recs)
+	}
+}
+
+func TestRecommendCapsModelCallsPerUser(t *testing.T) {
+	answer := `[{"specialty":"ent","confidence":0.9}]`
+	provider := llm.NewScriptedProvider(answer, answer, answer)
+	cs, _ := newTestService(t, provider)
+	cs.routingCfg.Model = true
+	cs.routingQuota = newUserQuota(2)
+
+	for i := range 2 {
+		if recs := cs.RecommendSpecialties(context.Background(), "u1", "脸上起了皮疹"); len(recs) == 0 || recs[0].Specialty != "ent" {
+			t.Errorf("call %d: recommendations = %+v, want the model's", i+1, recs)
+		}
+	}
+	// Past the cap the keyword router answers without a model call
+	if recs := cs.RecommendSpecialties(context.Background(), "u1", "脸上起了皮疹"); len(recs) == 0 || recs[0].Specialty != "dermatology" {
+		t.Errorf("capped recommendations = %+v, want dermatology from keywords", recs)
+	}
+	if n := len(provider.Requests()); n != 2 {
+		t.Errorf("model got %d calls, want 2", n)
+	}
+	// Other users have their own allowance
+	if recs := cs.RecommendSpecialties(context.Background(), "u2", "脸上起了皮疹"); len(recs) == 0 || recs[0].Specialty != "ent" {
+		t.Errorf("another user's recommendations = %+v, want the model's", recs)
+	}
+
+	// Calls age out after an hour
+	q := newUserQuota(1)
+	now := time.Now()
+	if !q.allow("u1", now) || q.allow("u1", now.Add(59*time.Minute)) || !q.allow("u1", now.Add(time.Hour)) {
+		t.Error("quota did not slide over the hour")
+	}
+}
+
+func TestTransferSession(t *testing.T) {
+	cs, _ := newTestService(t, llm.NewScriptedProvider())
+	session := newTestSession(t, cs, "pediatrics")
+
+	if _, _, err := cs.TransferSession(session.ID, "neurology"); !errors.Is(err, ErrUnknownSpecialty) {
+		t.Errorf("unknown specialty: err = %v, want ErrUnknownSpecialty", err)
+	}
+	if _, changed, err := cs.TransferSession(session.ID, "pediatrics"); err != nil || changed {
+		t.Errorf("same specialty: changed = %v, %v", changed, err)
+	}
+
+	got, changed, err := cs.TransferSession(session.ID, "dermatology")
+	if err != nil || !changed || got.Specialty != "dermatology" || got.Mode != store.ModeAI {
+		t.Fatalf("TransferSession = %+v, %v, %v", got, changed, err)
+	}
+	if saved, _ := cs.GetSession(session.ID); saved.Specialty != "dermatology" {
+		t.Errorf("saved specialty = %q", saved.Specialty)
+	}
+
+	if err := cs.CloseSession(session.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := cs.TransferSession(session.ID, "ent"); !errors.Is(err, ErrSessionClosed) {
+		t.Errorf("closed session: err = %v, want ErrSessionClosed", err)
+	}
+}
+
+func TestTransferReleasesTheDoctor(t *testing.T) {
+	cs, st := newTestService(t, llm.NewScriptedProvider())
+	pediatrician := newTestDoctor(t, st, "pediatrics", true, true)
+	dermatologist := newTestDoctor(t, st, "dermatology", true, true)
+
+	held := newTestSession(t, cs, "pediatrics")
+	if _, err := cs.AssignDoctor(held.ID, pediatrician.ID); err != nil {
+		t.Fatal(err)
+	}
+	got, _, err := cs.TransferSession(held.ID, "dermatology")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Mode != store.ModeWaiting || got.DoctorID != "" || got.HandoffReason != HandoffReasonTransfer || got.HandoffRequestedAt == nil {
+		t.Errorf("held session after transfer = %+v, want waiting without a doctor", got)
+	}
+	// A doctor of the new specialty can take it over
+	if _, err := cs.AssignDoctor(held.ID, dermatologist.ID); err != nil {
+		t.Errorf("dermatologist claim: %v", err)
+	}
+
+	// A doctor who handed the session back to AI loses it too
+	returned := newTestSession(t, cs, "pediatrics")
+	if _, err := cs.AssignDoctor(returned.ID, pediatrician.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.ReturnToAI(returned.ID, pediatrician.ID); err != nil {
+		t.Fatal(err)
+	}
+	got, _, err = cs.TransferSession(returned.ID, "dermatology")
+	if err != nil || got.Mode != store.ModeAI || got.DoctorID != "" {
+		t.Errorf("returned session after transfer = %+v, %v, want AI without a doctor", got, err)
+	}
+	if ok, _ := cs.CanObserve(pediatrician, got); ok {
+		t.Error("the old specialty's doctor can still observe the session")
+	}
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+
+	"medseek/internal/contextbuilder"
+	"medseek/internal/llm"
+	"medseek/internal/models"
+	"medseek/internal/pricing"
+	"medseek/internal/prompts"
+	"medseek/internal/redflag"
+	"medseek/internal/specialty"
+	"medseek/internal/store"
+)
+
+// newTestService creates a chat service on an in-memory store with the
+// built-in rules, specialties, prompts and prices. Summaries, model triage
+// and model routing are off.
+func newTestService(t *testing.T, provider llm.Provider) (*ChatService, *store.MemoryStore) {
+	t.Helper()
+	st := store.NewMemoryStore()
+	redflags, err := redflag.New(redflag.DefaultRules())
+	if err != nil {
+		t.Fatal(err)
+	}
+	specialties, err := specialty.New(specialty.Defaults())
+	if err != nil {
+		t.Fatal(err)
+	}
+	library, err := prompts.New("", "", "", specialties.IDs(), specialties.Prompt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := NewChatService(provider, st, contextbuilder.New(contextbuilder.DefaultMaxTokens, contextbuilder.DefaultReserveTokens),
+		SummaryConfig{}, redflags, TriageConfig{}, RoutingConfig{SuggestConfidence: 0.5}, library, specialties, pricing.Defaults())
+	return cs, st
+}
+
+// newTestSession starts a session of a new patient in a specialty
+func newTestSession(t *testing.T, cs *ChatService, specialtyID string) *models.ChatSession {
+	t.Helper()
+	session, err := cs.CreateSession(uuid.New().String(), uuid.New().String(), specialtyID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return session
+}
@@ -0,0 +1,38 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"medseek/internal/specialty"
+)
+
+var (
+	// ErrUnknownSpecialty is returned for a specialty that is not in the registry
+	ErrUnknownSpecialty = errors.New("unknown specialty")
+	// ErrSpecialtyDisabled is returned for a specialty that is not taking patients
+	ErrSpecialtyDisabled = errors.New("specialty disabled")
+)
+
+// Specialties returns the registry's specialties in display order, with the
+// disabled ones only if includeDisabled is set
+func (cs *ChatService) Specialties(includeDisabled bool) []specialty.Specialty {
+	return cs.specialties.List(includeDisabled)
+}
+
+// SpecialtyName returns the display name of a specialty
+func (cs *ChatService) SpecialtyName(id string) string {
+	return cs.specialties.Name(id)
+}
+
+// checkSpecialty returns an error unless patients can consult the specialty
+func (cs *ChatService) checkSpecialty(id string) error {
+	s, ok := cs.specialties.Get(id)
+	switch {
+	case !ok:
+		return fmt.Errorf("%w: %q", ErrUnknownSpecialty, id)
+	case !s.Enabled:
+		return fmt.Errorf("%w: %s", ErrSpecialtyDisabled, id)
+	}
+	return nil
+}
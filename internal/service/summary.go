@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"medseek/internal/deepseek"
+	"medseek/internal/models"
+	"medseek/internal/store"
+)
+
+// SummaryConfig controls rolling summarization of long consultations
+type SummaryConfig struct {
+	// RecentTurns is how many recent patient/doctor exchanges are always sent
+	// verbatim; older turns are folded into the summary. Zero disables summaries.
+	RecentTurns int
+	// BatchTurns is how many turns must fall outside the recent window before
+	// the summary is refreshed, so we don't pay for a summary call every turn
+	BatchTurns int
+}
+
+// SummaryConfigFromEnv reads SUMMARY_RECENT_TURNS and SUMMARY_BATCH_TURNS
+func SummaryConfigFromEnv() SummaryConfig {
+	cfg := SummaryConfig{RecentTurns: 6, BatchTurns: 4}
+	if v, err := strconv.Atoi(os.Getenv("SUMMARY_RECENT_TURNS")); err == nil && v >= 0 {
+		cfg.RecentTurns = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("SUMMARY_BATCH_TURNS")); err == nil && v > 0 {
+		cfg.BatchTurns = v
+	}
+	return cfg
+}
+
+// GetSummary returns the running summary of a session. Sessions that have not
+// been summarized yet get an empty summary.
+func (cs *ChatService) GetSummary(sessionID string) (*models.SessionSummary, error) {
+	if _, err := cs.store.GetSession(sessionID); err != nil {
+		return nil, err
+	}
+
+	summary, err := cs.loadSummary(sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load summary: %w", err)
+	}
+	return summary, nil
+}
+
+// loadSummary returns the stored summary or an empty one
+func (cs *ChatService) loadSummary(sessionID string) (*models.SessionSummary, error) {
+	summary, err := cs.store.GetSummary(sessionID)
+	if errors.Is(err, store.ErrNotFound) {
+		return &models.SessionSummary{SessionID: sessionID}, nil
+	}
+	return summary, err
+}
+
+// scheduleSummary refreshes the session summary in the background once
+// enough turns have fallen outside the recent window. At most one refresh
+// runs per session at a time.
+func (cs *ChatService) scheduleSummary(sessionID string) {
+	if cs.summaryCfg.RecentTurns <= 0 {
+		return
+	}
+	if _, running := cs.summarizing.LoadOrStore(sessionID, true); running {
+		return
+	}
+
+	go func() {
+		defer cs.summarizing.Delete(sessionID)
+		if err := cs.updateSummary(sessionID); err != nil {
+			log.Printf("Failed to update summary for session %s: %v", sessionID, err)
+		}
+	}()
+}
+
+// updateSummary folds the turns older than the recent window into the summary
+func (cs *ChatService) updateSummary(sessionID string) error {
+	summary, err := cs.loadSummary(sessionID)
+	if err != nil {
+		return err
+	}
+	msgs, err := cs.store.ListMessages(sessionID)
+	if err != nil {
+		return err
+	}
+
+	end := len(msgs) - cs.summaryCfg.RecentTurns*2
+	// Keep the recent window starting on a patient turn
+	for end > summary.CoveredMessages && end < len(msgs) && msgs[end].Role != "user" {
+		end--
+	}
+	if end-summary.CoveredMessages < cs.summaryCfg.BatchTurns*2 {
+		return nil
+	}
+
+	var transcript strings.Builder
+	if summary.Content != "" {
+		transcript.WriteString("【已有摘要】\n")
+		transcript.WriteString(summary.Content)
+		transcript.WriteString("\n\n")
+	}
+	transcript.WriteString("【新增对话】\n")
+	for _, msg := range msgs[summary.CoveredMessages:end] {
+		speaker := "患者"
+		switch msg.Role {
+		case "assistant":
+			speaker = "医生"
+		case "doctor":
+			speaker = "人工医生"
+		}
+		fmt.Fprintf(&transcript, "%s: %s\n", speaker, msg.Content)
+	}
+
+	completion, err := cs.complete(context.Background(), PurposeSummary, caller{sessionID: sessionID}, []models.DeepSeekMsg{
+		{Role: "system", Content: deepseek.GetSummaryPrompt()},
+		{Role: "user", Content: transcript.String()},
+	})
+	if err != nil {
+		return fmt.Errorf("summary call failed: %w", err)
+	}
+
+	return cs.store.SaveSummary(&models.SessionSummary{
+		SessionID:       sessionID,
+		Content:         strings.TrimSpace(completion.Content),
+		CoveredMessages: end,
+		UpdatedAt:       time.Now(),
+	})
+}
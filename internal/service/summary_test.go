@@ -0,0 +1,191 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"medseek/internal/contextbuilder"
+	"medseek/internal/llm"
+	"medseek/internal/models"
+)
+
+// addTurns stores messages in a session; contents starting with 答 are AI
+// replies, the others patient messages
+func addTurns(t *testing.T, cs *ChatService, session *models.ChatSession, contents ...string) {
+	t.Helper()
+	for _, content := range contents {
+		var err error
+		if strings.HasPrefix(content, "答") {
+			_, err = cs.AddReply(session.ID, content, false, nil)
+		} else {
+			_, err = cs.AddMessage(session.ID, session.UserID, "user", content)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// lastTranscript returns the user message of the latest model call
+func lastTranscript(t *testing.T, provider *llm.ScriptedProvider) string {
+	t.Helper()
+	requests := provider.Requests()
+	if len(requests) == 0 {
+		t.Fatal("no model call")
+	}
+	last := requests[len(requests)-1]
+	return last[len(last)-1].Content
+}
+
+// newSummaryService has a recent window of two exchanges and refreshes the
+// summary once two more fall outside it
+func newSummaryService(t *testing.T, provider llm.Provider) (*ChatService, *models.ChatSession) {
+	t.Helper()
+	cs, _ := newTestService(t, provider)
+	cs.summaryCfg = SummaryConfig{RecentTurns: 2, BatchTurns: 2}
+	return cs, newTestSession(t, cs, "pediatrics")
+}
+
+func TestSummaryWaitsForABatch(t *testing.T) {
+	provider := llm.NewScriptedProvider("摘要一")
+	cs, session := newSummaryService(t, provider)
+
+	// Three exchanges leave one outside the window, short of a batch
+	addTurns(t, cs, session, "问一", "答一", "问二", "答二", "问三", "答三")
+	if err := cs.updateSummary(session.ID); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(provider.Requests()); n != 0 {
+		t.Fatalf("summarized with %d model calls before a batch was due", n)
+	}
+
+	addTurns(t, cs, session, "问四", "答四")
+	if err := cs.updateSummary(session.ID); err != nil {
+		t.Fatal(err)
+	}
+	summary, _ := cs.GetSummary(session.ID)
+	if summary.Content != "摘要一" || summary.CoveredMessages != 4 {
+		t.Fatalf("summary = %+v, want the first four messages covered", summary)
+	}
+	transcript := lastTranscript(t, provider)
+	if !strings.Contains(transcript, "患者: 问一") || !strings.Contains(transcript, "医生: 答二") || strings.Contains(transcript, "问三") {
+		t.Errorf("transcript = %q, want exactly the first two exchanges", transcript)
+	}
+}
+
+func TestSummaryWindowStartsOnAPatientTurn(t *testing.T) {
+	provider := llm.NewScriptedProvider("摘要")
+	cs, session := newSummaryService(t, provider)
+
+	// The cut would fall between two replies; it moves back to 问三
+	addTurns(t, cs, session, "问一", "答一", "问二", "答二", "问三", "答三", "答三补充", "问四", "答四")
+	if err := cs.updateSummary(session.ID); err != nil {
+		t.Fatal(err)
+	}
+	summary, _ := cs.GetSummary(session.ID)
+	if summary.CoveredMessages != 4 {
+		t.Fatalf("covered %d messages, want 4", summary.CoveredMessages)
+	}
+	if transcript := lastTranscript(t, provider); strings.Contains(transcript, "问三") || strings.Contains(transcript, "答三") {
+		t.Errorf("transcript = %q, cut inside the third exchange", transcript)
+	}
+}
+
+func TestSummaryFoldsInLaterBatches(t *testing.T) {
+	provider := llm.NewScriptedProvider("摘要一", "摘要二")
+	cs, session := newSummaryService(t, provider)
+
+	addTurns(t, cs, session, "问一", "答一", "问二", "答二", "问三", "答三", "问四", "答四")
+	if err := cs.updateSummary(session.ID); err != nil {
+		t.Fatal(err)
+	}
+	addTurns(t, cs, session, "问五", "答五")
+	if err := cs.updateSummary(session.ID); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(provider.Requests()); n != 1 {
+		t.Fatalf("model got %d summary calls, want 1 before the second batch", n)
+	}
+
+	addTurns(t, cs, session, "问六", "答六")
+	if err := cs.updateSummary(session.ID); err != nil {
+		t.Fatal(err)
+	}
+	summary, _ := cs.GetSummary(session.ID)
+	if summary.Content != "摘要二" || summary.CoveredMessages != 8 {
+		t.Fatalf("summary = %+v, want eight messages covered", summary)
+	}
+	transcript := lastTranscript(t, provider)
+	for _, want := range []string{"【已有摘要】\n摘要一", "患者: 问三", "医生: 答四"} {
+		if !strings.Contains(transcript, want) {
+			t.Errorf("transcript = %q, missing %q", transcript, want)
+		}
+	}
+	for _, folded := range []string{"问二", "答二", "问五"} {
+		if strings.Contains(transcript, folded) {
+			t.Errorf("transcript = %q, should not contain %q", transcript, folded)
+		}
+	}
+}
+
+func TestBuildMessagesSkipsSummarizedTurns(t *testing.T) {
+	provider := llm.NewScriptedProvider("摘要", "回复")
+	cs, session := newSummaryService(t, provider)
+
+	addTurns(t, cs, session, "问一", "答一", "问二", "答二", "问三", "答三", "问四", "答四")
+	if err := cs.updateSummary(session.ID); err != nil {
+		t.Fatal(err)
+	}
+	msg, _ := cs.AddMessage(session.ID, session.UserID, "user", "问五")
+	if _, _, err := cs.ProcessMessage(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := provider.Requests()
+	var got []string
+	for _, m := range requests[len(requests)-1][1:] {
+		got = append(got, m.Role+":"+m.Content)
+	}
+	want := []string{
+		"system:" + contextbuilder.SummaryPrefix + "摘要",
+		"user:问三", "assistant:答三", "user:问四", "assistant:答四",
+		"user:问五",
+	}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("messages after the system prompt = %q, want %q", got, want)
+	}
+}
+
+func TestBuildMessagesWhenTheSummaryPassedTheTurn(t *testing.T) {
+	provider := llm.NewScriptedProvider("回复")
+	cs, session := newSummaryService(t, provider)
+
+	// 问三 was queued while the patient kept talking, and a summary refresh
+	// covered it and the messages after it before the turn ran
+	addTurns(t, cs, session, "问一", "答一", "问二", "答二")
+	msg, _ := cs.AddMessage(session.ID, session.UserID, "user", "问三")
+	addTurns(t, cs, session, "问四", "答四")
+	if err := cs.store.SaveSummary(&models.SessionSummary{
+		SessionID:       session.ID,
+		Content:         "摘要",
+		CoveredMessages: 7,
+		UpdatedAt:       time.Now(),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := cs.ProcessMessage(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := provider.Requests()
+	var got []string
+	for _, m := range requests[len(requests)-1][1:] {
+		got = append(got, m.Role+":"+m.Content)
+	}
+	want := []string{"system:" + contextbuilder.SummaryPrefix + "摘要", "user:问三"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("messages after the system prompt = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,196 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"medseek/internal/deepseek"
+	"medseek/internal/models"
+	"medseek/internal/store"
+	"medseek/internal/triage"
+)
+
+// TriageConfig controls how sessions are triaged after each patient turn
+type TriageConfig struct {
+	// Model adds a classification call to the model; without it the level
+	// comes from red-flag rules alone and is routine when none fired
+	Model bool
+	// RecentMessages is how many of the latest messages the model sees,
+	// after the running summary
+	RecentMessages int
+}
+
+// TriageConfigFromEnv reads TRIAGE_MODEL and TRIAGE_RECENT_MESSAGES
+func TriageConfigFromEnv() TriageConfig {
+	cfg := TriageConfig{RecentMessages: 10}
+	if v, err := strconv.ParseBool(os.Getenv("TRIAGE_MODEL")); err == nil {
+		cfg.Model = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("TRIAGE_RECENT_MESSAGES")); err == nil && v > 0 {
+		cfg.RecentMessages = v
+	}
+	return cfg
+}
+
+// ScheduleTriage re-assesses a session in the background after a patient
+// turn and calls onChange if its level or reason changed. Turns that arrive
+// while an assessment runs are covered by one more run after it.
+func (cs *ChatService) ScheduleTriage(sessionID string, onChange func(*models.ChatSession)) {
+	cs.triageMu.Lock()
+	if _, running := cs.triaging[sessionID]; running {
+		cs.triaging[sessionID] = true
+		cs.triageMu.Unlock()
+		return
+	}
+	cs.triaging[sessionID] = false
+	cs.triageMu.Unlock()
+
+	go func() {
+		for {
+			session, changed, err := cs.Triage(context.Background(), sessionID)
+			if err != nil {
+				log.Printf("Failed to triage session %s: %v", sessionID, err)
+			} else if changed {
+				onChange(session)
+			}
+
+			cs.triageMu.Lock()
+			if !cs.triaging[sessionID] {
+				delete(cs.triaging, sessionID)
+				cs.triageMu.Unlock()
+				return
+			}
+			cs.triaging[sessionID] = false
+			cs.triageMu.Unlock()
+		}
+	}()
+}
+
+// Triage assesses a session: the most severe of the levels of the red-flag
+// rules that fired in it and, if enabled, the model's classification of the
+// conversation. A failed model call keeps the previous assessment. It returns
+// the session and whether its triage changed.
+func (cs *ChatService) Triage(ctx context.Context, sessionID string) (*models.ChatSession, bool, error) {
+	session, err := cs.store.GetSession(sessionID)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to load session %s: %w", sessionID, err)
+	}
+
+	level, reason := triage.Routine, "未发现危险征象"
+	if cs.triageCfg.Model {
+		c, err := cs.classify(ctx, sessionID)
+		switch {
+		case err == nil:
+			level, reason = c.Level, c.Reason
+		case session.Triage != "":
+			log.Printf("Model triage of session %s failed: %v", sessionID, err)
+			level, reason = session.Triage, session.TriageReason
+		default:
+			log.Printf("Model triage of session %s failed: %v", sessionID, err)
+		}
+	}
+
+	// Red flags can only raise the level
+	for _, flag := range session.RedFlags {
+		if flagLevel := cs.redflags.Level(flag); flagLevel != "" && triage.Higher(level, flagLevel) != level {
+			level, reason = flagLevel, "红旗规则: "+flag
+		}
+	}
+
+	if level == session.Triage && reason == session.TriageReason {
+		return session, false, nil
+	}
+	now := time.Now()
+	session.Triage = level
+	session.TriageReason = reason
+	session.TriagedAt = &now
+	if err := cs.store.SaveTriage(session); err != nil {
+		return nil, false, err
+	}
+	log.Printf("Session %s triaged as %s (%s)", sessionID, level, reason)
+	return session, true, nil
+}
+
+// classify asks the model for the triage level of a conversation
+func (cs *ChatService) classify(ctx context.Context, sessionID string) (triage.Classification, error) {
+	summary, err := cs.loadSummary(sessionID)
+	if err != nil {
+		return triage.Classification{}, fmt.Errorf("failed to load summary: %w", err)
+	}
+	msgs, err := cs.store.ListMessages(sessionID)
+	if err != nil {
+		return triage.Classification{}, err
+	}
+	if len(msgs) > cs.triageCfg.RecentMessages {
+		msgs = msgs[len(msgs)-cs.triageCfg.RecentMessages:]
+	}
+
+	var transcript strings.Builder
+	if summary.Content != "" {
+		transcript.WriteString("【既往问诊摘要】\n")
+		transcript.WriteString(summary.Content)
+		transcript.WriteString("\n\n")
+	}
+	transcript.WriteString("【最近对话】\n")
+	for _, msg := range msgs {
+		speaker := "患者"
+		switch msg.Role {
+		case "assistant":
+			speaker = "医生"
+		case "doctor":
+			speaker = "人工医生"
+		}
+		fmt.Fprintf(&transcript, "%s: %s\n", speaker, msg.Content)
+	}
+
+	response, err := cs.complete(ctx, PurposeTriage, caller{sessionID: sessionID}, []models.DeepSeekMsg{
+		{Role: "system", Content: deepseek.GetTriagePrompt()},
+		{Role: "user", Content: transcript.String()},
+	})
+	if err != nil {
+		return triage.Classification{}, fmt.Errorf("triage call failed: %w", err)
+	}
+	return triage.ParseClassification(response.Content)
+}
+
+// TriageCount is the number of sessions of one specialty at one triage level
+type TriageCount struct {
+	Specialty string `json:"specialty"`
+	Triage    string `json:"triage"`
+	Sessions  int    `json:"sessions"`
+}
+
+// TriageStats counts the sessions started since the given time by specialty
+// and triage level, the most severe level first within each specialty.
+// Sessions not assessed yet have an empty level.
+func (cs *ChatService) TriageStats(since time.Time) ([]TriageCount, error) {
+	sessions, err := cs.store.ListSessions(store.SessionFilter{Since: since})
+	if err != nil {
+		return nil, err
+	}
+
+	type key struct{ specialty, triage string }
+	counts := make(map[key]int)
+	for _, session := range sessions {
+		counts[key{session.Specialty, session.Triage}]++
+	}
+
+	stats := make([]TriageCount, 0, len(counts))
+	for k, n := range counts {
+		stats = append(stats, TriageCount{Specialty: k.specialty, Triage: k.triage, Sessions: n})
+	}
+	slices.SortFunc(stats, func(a, b TriageCount) int {
+		return cmp.Or(
+			cmp.Compare(a.Specialty, b.Specialty),
+			cmp.Compare(triage.Rank(a.Triage), triage.Rank(b.Triage)),
+		)
+	})
+	return stats, nil
+}
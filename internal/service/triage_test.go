@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"medseek/internal/llm"
+	"medseek/internal/models"
+	"medseek/internal/store"
+	"medseek/internal/triage"
+)
+
+// setRedFlags records fired red-flag rules on a session
+func setRedFlags(t *testing.T, st *store.MemoryStore, sessionID string, flags ...string) {
+	t.Helper()
+	session, err := st.GetSession(sessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.RedFlags = flags
+	if err := st.SaveRedFlags(session); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTriageMergesModelAndRedFlags(t *testing.T) {
+	tests := []struct {
+		name       string
+		response   string
+		flags      []string
+		wantLevel  string
+		wantReason string
+	}{
+		{"model only", `{"level":"self-care","reason":"普通感冒"}`, nil, triage.SelfCare, "普通感冒"},
+		{"red flag raises the level", `{"level":"routine","reason":"腹泻"}`, []string{"pediatrics_bleeding_dehydration"}, triage.Urgent, "红旗规则: pediatrics_bleeding_dehydration"},
+		{"most severe red flag wins", `{"level":"routine","reason":"腹泻"}`, []string{"pediatrics_bleeding_dehydration", "chest_pain"}, triage.Emergency, "红旗规则: chest_pain"},
+		{"red flag never lowers the level", `{"level":"emergency","reason":"抽搐"}`, []string{"pediatrics_bleeding_dehydration"}, triage.Emergency, "抽搐"},
+	}
+	for _, tt := range tests {
+		cs, st := newTestService(t, llm.NewScriptedProvider(tt.response))
+		cs.triageCfg.Model = true
+		session := newTestSession(t, cs, "pediatrics")
+		setRedFlags(t, st, session.ID, tt.flags...)
+
+		got, changed, err := cs.Triage(context.Background(), session.ID)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !changed || got.Triage != tt.wantLevel || got.TriageReason != tt.wantReason {
+			t.Errorf("%s: triage = %s (%s), changed %v, want %s (%s)", tt.name, got.Triage, got.TriageReason, changed, tt.wantLevel, tt.wantReason)
+		}
+	}
+}
+
+func TestTriageWithoutTheModel(t *testing.T) {
+	provider := llm.NewScriptedProvider()
+	cs, st := newTestService(t, provider)
+	session := newTestSession(t, cs, "pediatrics")
+
+	got, _, err := cs.Triage(context.Background(), session.ID)
+	if err != nil || got.Triage != triage.Routine {
+		t.Fatalf("triage = %+v, %v, want routine", got, err)
+	}
+	setRedFlags(t, st, session.ID, "pediatrics_bleeding_dehydration")
+	if got, changed, err := cs.Triage(context.Background(), session.ID); err != nil || !changed || got.Triage != triage.Urgent {
+		t.Errorf("triage = %+v, %v, %v, want urgent", got, changed, err)
+	}
+	if n := len(provider.Requests()); n != 0 {
+		t.Errorf("model got %d calls", n)
+	}
+}
+
+func TestTriageKeepsTheLevelOnMalformedModelAnswers(t *testing.T) {
+	cs, _ := newTestService(t, llm.NewScriptedProvider(`{"level":"urgent","reason":"持续高热"}`, "我无法判断", `{"level":"critical"}`))
+	cs.triageCfg.Model = true
+	session := newTestSession(t, cs, "pediatrics")
+
+	if got, _, err := cs.Triage(context.Background(), session.ID); err != nil || got.Triage != triage.Urgent {
+		t.Fatalf("triage = %+v, %v, want urgent", got, err)
+	}
+	for i := 0; i < 2; i++ {
+		got, changed, err := cs.Triage(context.Background(), session.ID)
+		if err != nil || changed || got.Triage != triage.Urgent || got.TriageReason != "持续高热" {
+			t.Errorf("after malformed answer %d: triage = %s (%s), changed %v, %v", i+1, got.Triage, got.TriageReason, changed, err)
+		}
+	}
+
+	// With no earlier assessment a malformed answer falls back to routine
+	cs, _ = newTestService(t, llm.NewScriptedProvider("```\n不是JSON\n```"))
+	cs.triageCfg.Model = true
+	session = newTestSession(t, cs, "pediatrics")
+	if got, _, err := cs.Triage(context.Background(), session.ID); err != nil || got.Triage != triage.Routine {
+		t.Errorf("triage = %+v, %v, want routine", got, err)
+	}
+}
+
+// blockingProvider is a scripted provider whose completions wait until the
+// test releases them; each call is announced on started
+type blockingProvider struct {
+	*llm.ScriptedProvider
+	started chan struct{}
+	release chan struct{}
+}
+
+func (p *blockingProvider) ChatCompletion(ctx context.Context, messages []models.DeepSeekMsg) (models.Completion, error) {
+	p.started <- struct{}{}
+	<-p.release
+	return p.ScriptedProvider.ChatCompletion(ctx, messages)
+}
+
+func TestScheduleTriageCoalescesTurns(t *testing.T) {
+	provider := &blockingProvider{
+		ScriptedProvider: llm.NewScriptedProvider(`{"level":"routine","reason":"咳嗽"}`),
+		started:          make(chan struct{}, 8),
+		release:          make(chan struct{}),
+	}
+	cs, _ := newTestService(t, provider)
+	cs.triageCfg.Model = true
+	session := newTestSession(t, cs, "pediatrics")
+
+	var mu sync.Mutex
+	var changes []string
+	onChange := func(s *models.ChatSession) {
+		mu.Lock()
+		defer mu.Unlock()
+		changes = append(changes, s.Triage)
+	}
+
+	cs.ScheduleTriage(session.ID, onChange)
+	select {
+	case <-provider.started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("triage did not start")
+	}
+	// Turns arriving during the assessment add a single run after it
+	for i := 0; i < 3; i++ {
+		cs.ScheduleTriage(session.ID, onChange)
+	}
+	close(provider.release)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		cs.triageMu.Lock()
+		_, running := cs.triaging[session.ID]
+		cs.triageMu.Unlock()
+		if !running {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("triage still running")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if n := len(provider.Requests()); n != 2 {
+		t.Errorf("model got %d triage calls, want 2", n)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(changes) != 1 || changes[0] != triage.Routine {
+		t.Errorf("onChange got %v, want one routine change", changes)
+	}
+}
@@ -0,0 +1,182 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"medseek/internal/models"
+)
+
+// Purposes of model calls, recorded with their usage
+const (
+	PurposeReply   = "reply"
+	PurposeSummary = "summary"
+	PurposeTriage  = "triage"
+	PurposeRouting = "routing"
+)
+
+// Ways to group usage in UsageStats
+const (
+	GroupBySession   = "session"
+	GroupByUser      = "user"
+	GroupBySpecialty = "specialty"
+	GroupByDay       = "day"
+)
+
+// ErrInvalidGrouping is returned for an unknown UsageStats grouping
+var ErrInvalidGrouping = errors.New("invalid grouping")
+
+// caller says whom a model call is made for, so its usage is accounted to
+// them. Either field may be empty; the session's user is filled in.
+type caller struct {
+	sessionID string
+	userID    string
+}
+
+// complete sends a non-streaming request and records its usage
+func (cs *ChatService) complete(ctx context.Context, purpose string, c caller, messages []models.DeepSeekMsg) (models.Completion, error) {
+	completion, err := cs.provider.ChatCompletion(ctx, messages)
+	if err == nil {
+		cs.recordUsage(purpose, c, messages, completion)
+	}
+	return completion, err
+}
+
+// recordUsage stores the usage of a model call. A call the API reported no
+// usage for, such as a stopped stream, is estimated from its text, since it
+// is billed all the same. Failures are logged: accounting never fails a turn.
+func (cs *ChatService) recordUsage(purpose string, c caller, messages []models.DeepSeekMsg, completion models.Completion) {
+	record := &models.UsageRecord{
+		SessionID: c.sessionID,
+		UserID:    c.userID,
+		Purpose:   purpose,
+		Model:     completion.Model,
+		Usage:     completion.Usage,
+		CreatedAt: time.Now(),
+	}
+	if record.TotalTokens == 0 {
+		for _, msg := range messages {
+			record.PromptTokens += cs.contextBuilder.Estimate(msg.Content)
+		}
+		record.CompletionTokens = cs.contextBuilder.Estimate(completion.Content)
+		record.TotalTokens = record.PromptTokens + record.CompletionTokens
+		record.Estimated = true
+	}
+	if c.sessionID != "" {
+		if session, err := cs.store.GetSession(c.sessionID); err == nil {
+			record.UserID = session.UserID
+			record.Specialty = session.Specialty
+		}
+	}
+
+	if err := cs.store.AddUsage(record); err != nil {
+		log.Printf("Failed to record %s usage of session %s: %v", purpose, c.sessionID, err)
+	}
+}
+
+// UsageFilter narrows UsageStats; empty fields match everything
+type UsageFilter struct {
+	Since     time.Time
+	SessionID string
+	UserID    string
+	Specialty string
+}
+
+// UsageStats is the token usage and estimated cost of one group of model calls
+type UsageStats struct {
+	// Key is the session ID, user ID, specialty or day (YYYY-MM-DD, server
+	// time) of the group; empty for calls outside any session or user
+	Key              string `json:"key"`
+	Calls            int    `json:"calls"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CacheHitTokens   int    `json:"cache_hit_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+	TotalTokens      int    `json:"total_tokens"`
+	// EstimatedCalls had no usage from the API and were estimated
+	EstimatedCalls int `json:"estimated_calls"`
+	// UnpricedCalls used a model missing from the price table and are not
+	// in Cost
+	UnpricedCalls int     `json:"unpriced_calls"`
+	Cost          float64 `json:"cost"`
+}
+
+// UsageReport groups usage and cost by one key, with the total of all groups
+type UsageReport struct {
+	Currency string       `json:"currency"`
+	GroupBy  string       `json:"group_by"`
+	Groups   []UsageStats `json:"groups"`
+	Total    UsageStats   `json:"total"`
+}
+
+// UsageStats adds up the usage matching filter by session, user, specialty
+// or day and prices it with the current price table. Days come in order;
+// other groups most expensive first.
+func (cs *ChatService) UsageStats(filter UsageFilter, groupBy string) (*UsageReport, error) {
+	key := map[string]func(*models.UsageRecord) string{
+		GroupBySession:   func(r *models.UsageRecord) string { return r.SessionID },
+		GroupByUser:      func(r *models.UsageRecord) string { return r.UserID },
+		GroupBySpecialty: func(r *models.UsageRecord) string { return r.Specialty },
+		GroupByDay:       func(r *models.UsageRecord) string { return r.CreatedAt.Local().Format(time.DateOnly) },
+	}[groupBy]
+	if key == nil {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidGrouping, groupBy)
+	}
+
+	records, err := cs.store.ListUsage(filter.Since)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &UsageReport{Currency: cs.prices.Currency, GroupBy: groupBy}
+	groups := make(map[string]*UsageStats)
+	for _, r := range records {
+		if (filter.SessionID != "" && r.SessionID != filter.SessionID) ||
+			(filter.UserID != "" && r.UserID != filter.UserID) ||
+			(filter.Specialty != "" && r.Specialty != filter.Specialty) {
+			continue
+		}
+		k := key(r)
+		g, ok := groups[k]
+		if !ok {
+			g = &UsageStats{Key: k}
+			groups[k] = g
+		}
+		cost, priced := cs.prices.Cost(r.Model, r.Usage)
+		g.add(r, cost, priced)
+		report.Total.add(r, cost, priced)
+	}
+
+	report.Groups = make([]UsageStats, 0, len(groups))
+	for _, g := range groups {
+		report.Groups = append(report.Groups, *g)
+	}
+	slices.SortFunc(report.Groups, func(a, b UsageStats) int {
+		if groupBy == GroupByDay {
+			return cmp.Compare(a.Key, b.Key)
+		}
+		return cmp.Or(cmp.Compare(b.Cost, a.Cost), cmp.Compare(a.Key, b.Key))
+	})
+	return report, nil
+}
+
+// add counts one call in the group
+func (s *UsageStats) add(r *models.UsageRecord, cost float64, priced bool) {
+	s.Calls++
+	s.PromptTokens += r.PromptTokens
+	s.CacheHitTokens += r.CacheHitTokens
+	s.CompletionTokens += r.CompletionTokens
+	s.TotalTokens += r.TotalTokens
+	if r.Estimated {
+		s.EstimatedCalls++
+	}
+	if priced {
+		s.Cost += cost
+	} else {
+		s.UnpricedCalls++
+	}
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"medseek/internal/llm"
+	"medseek/internal/models"
+	"medseek/internal/store"
+)
+
+// day returns noon of a day in October 2026, server time
+func day(d int) time.Time {
+	return time.Date(2026, 10, d, 12, 0, 0, 0, time.Local)
+}
+
+// addUsage stores a model call of a million prompt and a million completion tokens
+func addUsage(t *testing.T, st *store.MemoryStore, sessionID, userID, specialty, model string, at time.Time) {
+	t.Helper()
+	err := st.AddUsage(&models.UsageRecord{
+		SessionID: sessionID,
+		UserID:    userID,
+		Specialty: specialty,
+		Purpose:   PurposeReply,
+		Model:     model,
+		Usage:     models.Usage{PromptTokens: 1_000_000, CompletionTokens: 1_000_000, TotalTokens: 2_000_000},
+		CreatedAt: at,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newUsageService stores calls of two users in three sessions over three
+// days; deepseek-chat costs 2 + 3 per call, scripted is not priced
+func newUsageService(t *testing.T) *ChatService {
+	t.Helper()
+	cs, st := newTestService(t, llm.NewScriptedProvider())
+	addUsage(t, st, "s1", "u1", "pediatrics", "deepseek-chat", day(1))
+	addUsage(t, st, "s1", "u1", "pediatrics", "deepseek-chat", day(2))
+	addUsage(t, st, "s2", "u1", "dermatology", "deepseek-chat", day(2))
+	addUsage(t, st, "s3", "u2", "pediatrics", "deepseek-chat", day(3))
+	addUsage(t, st, "s3", "u2", "pediatrics", "deepseek-chat", day(3))
+	addUsage(t, st, "s3", "u2", "pediatrics", "deepseek-chat", day(3))
+	addUsage(t, st, "s3", "u2", "pediatrics", "scripted", day(3))
+	addUsage(t, st, "", "u2", "", "deepseek-chat", day(3))
+	return cs
+}
+
+// groupCosts maps each group's key to its cost
+func groupCosts(report *UsageReport) ([]string, map[string]float64) {
+	var keys []string
+	costs := make(map[string]float64)
+	for _, g := range report.Groups {
+		keys = append(keys, g.Key)
+		costs[g.Key] = g.Cost
+	}
+	return keys, costs
+}
+
+func TestUsageStatsGroups(t *testing.T) {
+	cs := newUsageService(t)
+
+	tests := []struct {
+		groupBy string
+		keys    []string // in report order
+		costs   []float64
+	}{
+		// Most expensive first
+		{GroupBySession, []string{"s3", "s1", "", "s2"}, []float64{15, 10, 5, 5}},
+		{GroupByUser, []string{"u2", "u1"}, []float64{20, 15}},
+		{GroupBySpecialty, []string{"pediatrics", "", "dermatology"}, []float64{25, 5, 5}},
+		// Days in order
+		{GroupByDay, []string{"2026-10-01", "2026-10-02", "2026-10-03"}, []float64{5, 10, 20}},
+	}
+	for _, tt := range tests {
+		report, err := cs.UsageStats(UsageFilter{}, tt.groupBy)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.groupBy, err)
+		}
+		keys, costs := groupCosts(report)
+		if len(keys) != len(tt.keys) {
+			t.Errorf("%s: groups %q, want %q", tt.groupBy, keys, tt.keys)
+			continue
+		}
+		for i, key := range tt.keys {
+			if keys[i] != key || costs[key] != tt.costs[i] {
+				t.Errorf("%s: group %d = %q costing %.2f, want %q costing %.2f", tt.groupBy, i, keys[i], costs[keys[i]], key, tt.costs[i])
+			}
+		}
+		if report.Currency != "CNY" || report.Total.Calls != 8 || report.Total.Cost != 35 || report.Total.UnpricedCalls != 1 {
+			t.Errorf("%s: total = %+v", tt.groupBy, report.Total)
+		}
+	}
+
+	if _, err := cs.UsageStats(UsageFilter{}, "model"); !errors.Is(err, ErrInvalidGrouping) {
+		t.Errorf("unknown grouping: err = %v, want ErrInvalidGrouping", err)
+	}
+}
+
+func TestUsageStatsFilters(t *testing.T) {
+	cs := newUsageService(t)
+
+	tests := []struct {
+		name   string
+		filter UsageFilter
+		calls  int
+		cost   float64
+	}{
+		{"since", UsageFilter{Since: day(2).Add(-time.Hour)}, 7, 30},
+		{"session", UsageFilter{SessionID: "s1"}, 2, 10},
+		{"user", UsageFilter{UserID: "u2"}, 5, 20},
+		{"specialty", UsageFilter{Specialty: "pediatrics"}, 6, 25},
+		{"user and specialty", UsageFilter{UserID: "u1", Specialty: "pediatrics"}, 2, 10},
+		{"no match", UsageFilter{UserID: "u3"}, 0, 0},
+	}
+	for _, tt := range tests {
+		report, err := cs.UsageStats(tt.filter, GroupByUser)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if report.Total.Calls != tt.calls || report.Total.Cost != tt.cost {
+			t.Errorf("%s: %d calls costing %.2f, want %d costing %.2f", tt.name, report.Total.Calls, report.Total.Cost, tt.calls, tt.cost)
+		}
+	}
+}
+
+func TestRecordUsageEstimatesStoppedStreams(t *testing.T) {
+	cs, st := newTestService(t, llm.NewScriptedProvider())
+	session := newTestSession(t, cs, "pediatrics")
+
+	// A stopped stream reports no usage
+	messages := []models.DeepSeekMsg{{Role: "system", Content: "你是儿科医生"}, {Role: "user", Content: "孩子发烧"}}
+	cs.recordUsage(PurposeReply, caller{sessionID: session.ID}, messages, models.Completion{Model: "deepseek-chat", Content: "请先"})
+
+	records, _ := st.ListUsage(time.Time{})
+	if len(records) != 1 {
+		t.Fatalf("stored %d usage records", len(records))
+	}
+	r := records[0]
+	if !r.Estimated || r.PromptTokens == 0 || r.CompletionTokens == 0 || r.TotalTokens != r.PromptTokens+r.CompletionTokens {
+		t.Errorf("record = %+v, want estimated tokens", r)
+	}
+	// The session's patient and specialty are filled in
+	if r.UserID != session.UserID || r.Specialty != "pediatrics" {
+		t.Errorf("record accounted to %q in %q", r.UserID, r.Specialty)
+	}
+
+	report, err := cs.UsageStats(UsageFilter{SessionID: session.ID}, GroupBySession)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Total.EstimatedCalls != 1 || report.Total.Cost <= 0 {
+		t.Errorf("total = %+v, want one estimated, priced call", report.Total)
+	}
+}
@@ -0,0 +1,171 @@
+package specialty
+
+import "medseek/internal/deepseek"
+
+// Defaults returns the built-in specialties, all enabled
+func Defaults() []Specialty {
+	return []Specialty{
+		{
+			ID:          "obstetrics",
+			Name:        "妇产科",
+			Emoji:       "👩‍⚕️",
+			Summary:     "妇科、产科、产后恢复",
+			Title:       "妇产科在线咨询",
+			DoctorRole:  "妇产科医生",
+			Welcome:     "欢迎来到妇产科在线咨询",
+			Description: "请描述您的症状或健康问题，医生将为您提供专业建议",
+			Guidelines: []string{
+				"详细描述您的症状、持续时间",
+				"提供相关病史、月经史、婚育史",
+				"如实告知身体状况",
+				"严重症状请及时到医院急诊",
+				"本服务提供初步诊疗建议，不能替代面诊",
+			},
+			Prompt: deepseek.ObstetricsPrompt(),
+			Clues: []Clue{
+				{"怀孕", 6}, {"孕", 2}, {"产后", 3}, {"月经", 2}, {"经期", 2}, {"白带", 2}, {"阴道", 2},
+				{"停经", 2}, {"痛经", 2}, {"备孕", 2}, {"子宫", 2}, {"卵巢", 2}, {"宫颈", 2}, {"下腹痛", 1},
+			},
+			Enabled: true,
+		},
+		{
+			ID:          "pediatrics",
+			Name:        "儿科",
+			Emoji:       "👶",
+			Summary:     "儿童疾病、生长发育",
+			Title:       "儿科在线咨询",
+			DoctorRole:  "儿科医生",
+			Welcome:     "欢迎来到儿科在线咨询",
+			Description: "请描述孩子的症状或健康问题，医生将为您提供专业建议",
+			Guidelines: []string{
+				"详细描述孩子的症状、发病时间",
+				"提供孩子的年龄、体重信息",
+				"说明既往病史和预防接种情况",
+				"严重症状请及时到医院急诊",
+				"本服务提供初步诊疗建议，不能替代面诊",
+			},
+			Prompt: deepseek.PediatricsPrompt(),
+			Clues: []Clue{
+				{"孩子", 6}, {"宝宝", 6}, {"小孩", 6}, {"婴儿", 6}, {"新生儿", 6}, {"儿子", 6}, {"女儿", 6},
+				{"幼儿", 6}, {"个月大", 2}, {"喂奶", 2}, {"奶粉", 1}, {"疫苗", 1},
+			},
+			Enabled: true,
+		},
+		{
+			ID:          "internal_medicine",
+			Name:        "内科",
+			Emoji:       "🫀",
+			Summary:     "内脏器官、代谢、感染",
+			Title:       "内科在线咨询",
+			DoctorRole:  "内科医生",
+			Welcome:     "欢迎来到内科在线咨询",
+			Description: "请描述您的症状或健康问题，医生将为您提供专业建议",
+			Guidelines: []string{
+				"详细描述您的症状、持续时间",
+				"提供既往病史和用药情况",
+				"告知最近的检查结果（如有）",
+				"如实告知身体状况",
+				"本服务提供初步诊疗建议，不能替代面诊",
+			},
+			Prompt: deepseek.InternalMedicinePrompt(),
+			Clues: []Clue{
+				{"发烧", 1}, {"发热", 1}, {"胃痛", 2}, {"胃疼", 2}, {"腹泻", 2}, {"拉肚子", 2}, {"恶心", 1},
+				{"呕吐", 1}, {"便秘", 2}, {"乏力", 1}, {"糖尿病", 2}, {"血糖", 2}, {"甲状腺", 2}, {"头晕", 1},
+				{"失眠", 1}, {"肚子痛", 1},
+			},
+			Enabled: true,
+		},
+		{
+			ID:          "dermatology",
+			Name:        "皮肤科",
+			Emoji:       "🩹",
+			Summary:     "皮肤病、痤疮、湿疹",
+			Title:       "皮肤科在线咨询",
+			DoctorRole:  "皮肤科医生",
+			Welcome:     "欢迎来到皮肤科在线咨询",
+			Description: "请描述您的皮肤问题，医生将为您提供专业建议",
+			Guidelines: []string{
+				"详细描述皮肤病变的位置、大小、颜色",
+				"说明发病时间和发展过程",
+				"提供既往皮肤病史",
+				"如有可能，上传患处照片（可通过其他方式）",
+				"本服务提供初步诊疗建议，不能替代面诊",
+			},
+			Prompt: deepseek.DermatologyPrompt(),
+			Clues: []Clue{
+				{"皮疹", 3}, {"湿疹", 3}, {"瘙痒", 2}, {"痒", 1}, {"痘", 2}, {"起疹", 3}, {"红斑", 2},
+				{"脱皮", 2}, {"荨麻疹", 3}, {"皮肤", 2}, {"脱发", 2}, {"水泡", 2}, {"斑", 1},
+			},
+			Enabled: true,
+		},
+		{
+			ID:          "ent",
+			Name:        "耳鼻喉科",
+			Emoji:       "👂",
+			Summary:     "鼻炎、喉咙痛、耳痛",
+			Title:       "耳鼻喉科在线咨询",
+			DoctorRole:  "耳鼻喉医生",
+			Welcome:     "欢迎来到耳鼻喉科在线咨询",
+			Description: "请描述您的耳鼻喉症状，医生将为您提供专业建议",
+			Guidelines: []string{
+				"详细描述症状的具体位置和表现",
+				"说明发病时间和诱发因素",
+				"提供既往耳鼻喉疾病史",
+				"如实告知用药和过敏情况",
+				"本服务提供初步诊疗建议，不能替代面诊",
+			},
+			Prompt: deepseek.ENTPrompt(),
+			Clues: []Clue{
+				{"耳朵", 3}, {"耳鸣", 3}, {"听力", 3}, {"鼻塞", 3}, {"流鼻涕", 2}, {"鼻炎", 3}, {"鼻血", 3},
+				{"嗓子", 2}, {"咽喉", 3}, {"喉咙", 2}, {"扁桃体", 3}, {"声音嘶哑", 3}, {"打鼾", 2}, {"吞咽", 2},
+			},
+			Enabled: true,
+		},
+		{
+			ID:          "cardiology",
+			Name:        "心脑血管科",
+			Emoji:       "🧠❤️",
+			Summary:     "胸痛、心悸、头晕、脑卒中",
+			Title:       "心脑血管科在线咨询",
+			DoctorRole:  "心脑血管医生",
+			Welcome:     "欢迎来到心脑血管科在线咨询",
+			Description: "请描述您的心脑血管症状，医生将为您提供专业建议",
+			Guidelines: []string{
+				"详细描述胸部或头部症状的位置和性质",
+				"说明发病时间和诱发因素",
+				"提供既往心脑血管病史、家族史",
+				"告知血压、血糖等指标",
+				"本服务提供初步诊疗建议，不能替代面诊。如有严重症状请立即就医",
+			},
+			Prompt: deepseek.CardiologyPrompt(),
+			Clues: []Clue{
+				{"胸痛", 3}, {"胸闷", 3}, {"心悸", 3}, {"心慌", 3}, {"心跳", 2}, {"血压", 3}, {"高血压", 3},
+				{"心脏", 3}, {"早搏", 3}, {"头痛", 1}, {"口眼歪斜", 3}, {"肢体麻木", 2}, {"晕厥", 2},
+			},
+			Enabled: true,
+		},
+		{
+			ID:          "respiratory",
+			Name:        "呼吸科",
+			Emoji:       "💨",
+			Summary:     "咳嗽、哮喘、呼吸困难",
+			Title:       "呼吸科在线咨询",
+			DoctorRole:  "呼吸科医生",
+			Welcome:     "欢迎来到呼吸科在线咨询",
+			Description: "请描述您的呼吸系统症状，医生将为您提供专业建议",
+			Guidelines: []string{
+				"详细描述咳嗽或呼吸困难的具体表现",
+				"说明发病时间和发展过程",
+				"描述咳痰情况（颜色、性质、量）",
+				"提供既往呼吸病史和吸烟史",
+				"本服务提供初步诊疗建议，不能替代面诊",
+			},
+			Prompt: deepseek.RespiratoryPrompt(),
+			Clues: []Clue{
+				{"咳嗽", 3}, {"咳痰", 3}, {"痰", 2}, {"气喘", 3}, {"喘", 2}, {"哮喘", 3}, {"呼吸困难", 3},
+				{"气短", 2}, {"咳血", 3}, {"肺", 2}, {"支气管", 3},
+			},
+			Enabled: true,
+		},
+	}
+}
@@ -0,0 +1,195 @@
+// Package specialty is the registry of the specialties patients can consult:
+// their display texts for the frontend, their system prompts, the words that
+// route complaints to them and whether they are open.
+package specialty
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Specialty is one consultable specialty
+type Specialty struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`  // 儿科
+	Emoji string `json:"emoji"` // shown before the name
+	// Summary lists what the specialty treats, shown when picking one
+	Summary     string   `json:"summary"`
+	Title       string   `json:"title"`       // header of the consultation screen
+	DoctorRole  string   `json:"doctor_role"` // label of the AI doctor's messages
+	Welcome     string   `json:"welcome"`
+	Description string   `json:"description"`
+	Guidelines  []string `json:"guidelines"`
+	// Prompt is the built-in system prompt; prompt templates can override it
+	Prompt string `json:"prompt,omitempty"`
+	// Clues are the words in a chief complaint that point to the specialty;
+	// specialties without any are only recommended by the model
+	Clues   []Clue `json:"clues,omitempty"`
+	Enabled bool   `json:"enabled"`
+}
+
+// Clue is a word in a complaint that points to a specialty, and how strongly.
+// Words naming who the patient is (a child, a pregnancy) outweigh any
+// symptom, since those patients belong to pediatrics and obstetrics whatever
+// their symptoms.
+type Clue struct {
+	Word   string  `json:"word"`
+	Weight float64 `json:"weight"`
+}
+
+// Override changes a built-in specialty or, with an unknown id, adds one.
+// Empty fields keep the built-in value.
+type Override struct {
+	Specialty
+	Enabled *bool `json:"enabled"`
+}
+
+// Registry holds the specialties in display order. It is not changed after
+// it is built, so it is safe for concurrent use.
+type Registry struct {
+	specialties []Specialty
+}
+
+// New builds a registry, rejecting specialties without an id, a name or a
+// prompt, clues without a word or a positive weight, and duplicate ids
+func New(specialties []Specialty) (*Registry, error) {
+	r := &Registry{}
+	for _, s := range specialties {
+		switch {
+		case s.ID == "":
+			return nil, fmt.Errorf("specialty without an id")
+		case s.Name == "":
+			return nil, fmt.Errorf("specialty %s has no name", s.ID)
+		case strings.TrimSpace(s.Prompt) == "":
+			return nil, fmt.Errorf("specialty %s has no prompt", s.ID)
+		}
+		for _, c := range s.Clues {
+			if strings.TrimSpace(c.Word) == "" || c.Weight <= 0 {
+				return nil, fmt.Errorf("specialty %s has an invalid clue %q (weight %v)", s.ID, c.Word, c.Weight)
+			}
+		}
+		if _, ok := r.Get(s.ID); ok {
+			return nil, fmt.Errorf("duplicate specialty %s", s.ID)
+		}
+		r.specialties = append(r.specialties, s)
+	}
+	return r, nil
+}
+
+// Load applies the JSON array of overrides in path to the built-in
+// specialties
+func Load(path string) (*Registry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read specialties: %w", err)
+	}
+	var overrides []Override
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, fmt.Errorf("failed to parse specialties: %w", err)
+	}
+	return New(apply(Defaults(), overrides))
+}
+
+// NewFromEnv loads the overrides in SPECIALTIES_FILE, or the built-in
+// specialties if it is unset
+func NewFromEnv() (*Registry, error) {
+	if path := os.Getenv("SPECIALTIES_FILE"); path != "" {
+		return Load(path)
+	}
+	return New(Defaults())
+}
+
+// apply merges overrides into specialties; new ids are appended, enabled
+// unless they say otherwise
+func apply(specialties []Specialty, overrides []Override) []Specialty {
+	for _, o := range overrides {
+		i := indexOf(specialties, o.ID)
+		if i < 0 {
+			added := o.Specialty
+			added.Enabled = o.Enabled == nil || *o.Enabled
+			specialties = append(specialties, added)
+			continue
+		}
+		s := &specialties[i]
+		for _, field := range []struct{ dst, src *string }{
+			{&s.Name, &o.Name}, {&s.Emoji, &o.Emoji}, {&s.Summary, &o.Summary},
+			{&s.Title, &o.Title}, {&s.DoctorRole, &o.DoctorRole}, {&s.Welcome, &o.Welcome},
+			{&s.Description, &o.Description}, {&s.Prompt, &o.Prompt},
+		} {
+			if *field.src != "" {
+				*field.dst = *field.src
+			}
+		}
+		if len(o.Guidelines) > 0 {
+			s.Guidelines = o.Guidelines
+		}
+		if len(o.Clues) > 0 {
+			s.Clues = o.Clues
+		}
+		if o.Enabled != nil {
+			s.Enabled = *o.Enabled
+		}
+	}
+	return specialties
+}
+
+func indexOf(specialties []Specialty, id string) int {
+	for i, s := range specialties {
+		if s.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// Get returns a specialty by id, enabled or not
+func (r *Registry) Get(id string) (Specialty, bool) {
+	if i := indexOf(r.specialties, id); i >= 0 {
+		return r.specialties[i], true
+	}
+	return Specialty{}, false
+}
+
+// Enabled reports whether id is a specialty patients can consult
+func (r *Registry) Enabled(id string) bool {
+	s, ok := r.Get(id)
+	return ok && s.Enabled
+}
+
+// Name returns the display name of a specialty, or the id if it is unknown
+func (r *Registry) Name(id string) string {
+	if s, ok := r.Get(id); ok {
+		return s.Name
+	}
+	return id
+}
+
+// Prompt returns the built-in system prompt of a specialty, or "" if it is
+// unknown
+func (r *Registry) Prompt(id string) string {
+	s, _ := r.Get(id)
+	return s.Prompt
+}
+
+// IDs returns the ids of every specialty, enabled or not, in display order
+func (r *Registry) IDs() []string {
+	ids := make([]string, len(r.specialties))
+	for i, s := range r.specialties {
+		ids[i] = s.ID
+	}
+	return ids
+}
+
+// List returns the specialties in display order, with the disabled ones
+// only if includeDisabled is set
+func (r *Registry) List(includeDisabled bool) []Specialty {
+	list := make([]Specialty, 0, len(r.specialties))
+	for _, s := range r.specialties {
+		if s.Enabled || includeDisabled {
+			list = append(list, s)
+		}
+	}
+	return list
+}
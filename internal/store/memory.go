@@ -0,0 +1,415 @@
+package store
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"medseek/internal/models"
+)
+
+// MemoryStore keeps users, sessions and messages in process memory.
+// Everything is lost when the server restarts.
+type MemoryStore struct {
+	users     map[string]*models.User
+	doctors   map[string]*models.DoctorProfile
+	sessions  map[string]*models.ChatSession
+	messages  map[string][]*models.Message
+	summaries map[string]*models.SessionSummary
+	usage     []*models.UsageRecord
+	mu        sync.RWMutex
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:     make(map[string]*models.User),
+		doctors:   make(map[string]*models.DoctorProfile),
+		sessions:  make(map[string]*models.ChatSession),
+		messages:  make(map[string][]*models.Message),
+		summaries: make(map[string]*models.SessionSummary),
+	}
+}
+
+// CreateUser saves a new user
+func (s *MemoryStore) CreateUser(user *models.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.users {
+		if (user.Email != "" && existing.Email == user.Email) || (user.Phone != "" && existing.Phone == user.Phone) {
+			return ErrConflict
+		}
+	}
+	stored := *user
+	s.users[user.ID] = &stored
+	return nil
+}
+
+// GetUser returns a copy of a user by ID
+func (s *MemoryStore) GetUser(userID string) (*models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	result := *user
+	return &result, nil
+}
+
+// GetUserByEmail returns a copy of a user by email
+func (s *MemoryStore) GetUserByEmail(email string) (*models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, user := range s.users {
+		if email != "" && user.Email == email {
+			result := *user
+			return &result, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// GetUserByPhone returns a copy of a user by phone number
+func (s *MemoryStore) GetUserByPhone(phone string) (*models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, user := range s.users {
+		if phone != "" && user.Phone == phone {
+			result := *user
+			return &result, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// SetUserRole changes the role of a user
+func (s *MemoryStore) SetUserRole(userID, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return ErrNotFound
+	}
+	user.Role = role
+	return nil
+}
+
+// GetDoctor returns a copy of a doctor profile
+func (s *MemoryStore) GetDoctor(doctorID string) (*models.DoctorProfile, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	profile, ok := s.doctors[doctorID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	result := *profile
+	result.Qualifications = slices.Clone(profile.Qualifications)
+	return &result, nil
+}
+
+// SaveDoctor creates or replaces the doctor profile of an existing user
+func (s *MemoryStore) SaveDoctor(profile *models.DoctorProfile) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[profile.ID]; !ok {
+		return ErrNotFound
+	}
+	for _, existing := range s.doctors {
+		if profile.LicenseNo != "" && existing.LicenseNo == profile.LicenseNo && existing.ID != profile.ID {
+			return ErrConflict
+		}
+	}
+	stored := *profile
+	stored.Qualifications = slices.Clone(profile.Qualifications)
+	if stored.Qualifications == nil {
+		stored.Qualifications = []string{}
+	}
+	s.doctors[profile.ID] = &stored
+	return nil
+}
+
+// ListDoctors returns copies of the doctors matching filter, ordered by name
+func (s *MemoryStore) ListDoctors(filter DoctorFilter) ([]*models.DoctorProfile, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	doctors := make([]*models.DoctorProfile, 0)
+	for _, profile := range s.doctors {
+		if filter.Specialty != "" && profile.Specialty != filter.Specialty {
+			continue
+		}
+		if !filter.IncludeInactive && !profile.Active {
+			continue
+		}
+		result := *profile
+		result.Qualifications = slices.Clone(profile.Qualifications)
+		doctors = append(doctors, &result)
+	}
+	slices.SortFunc(doctors, func(a, b *models.DoctorProfile) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return doctors, nil
+}
+
+// CreateSession saves a new session
+func (s *MemoryStore) CreateSession(session *models.ChatSession) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := *session
+	s.sessions[session.ID] = &stored
+	s.messages[session.ID] = make([]*models.Message, 0)
+	return nil
+}
+
+// GetSession returns a copy of a session by ID
+func (s *MemoryStore) GetSession(sessionID string) (*models.ChatSession, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, ok := s.sessions[sessionID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	result := *session
+	return &result, nil
+}
+
+// ListSessions returns copies of the sessions matching filter, oldest first
+func (s *MemoryStore) ListSessions(filter SessionFilter) ([]*models.ChatSession, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sessions := make([]*models.ChatSession, 0)
+	for _, session := range s.sessions {
+		if filter.Status != "" && session.Status != filter.Status {
+			continue
+		}
+		if filter.Specialty != "" && session.Specialty != filter.Specialty {
+			continue
+		}
+		if filter.Triage != "" && session.Triage != filter.Triage {
+			continue
+		}
+		if session.StartTime.Before(filter.Since) {
+			continue
+		}
+		result := *session
+		sessions = append(sessions, &result)
+	}
+	slices.SortFunc(sessions, func(a, b *models.ChatSession) int {
+		return a.StartTime.Compare(b.StartTime)
+	})
+	return sessions, nil
+}
+
+// SetSpecialty changes the specialty of a session
+func (s *MemoryStore) SetSpecialty(sessionID, specialty string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[sessionID]
+	if !ok {
+		return ErrNotFound
+	}
+	session.Specialty = specialty
+	return nil
+}
+
+// SaveHandoff stores the mode, doctor and handoff fields of a session
+func (s *MemoryStore) SaveHandoff(session *models.ChatSession) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.sessions[session.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	stored.Mode = session.Mode
+	stored.DoctorID = session.DoctorID
+	stored.HandoffReason = session.HandoffReason
+	stored.HandoffRequestedAt = session.HandoffRequestedAt
+	stored.EscalatedAt = session.EscalatedAt
+	return nil
+}
+
+// SaveRedFlags stores the red flags of a session
+func (s *MemoryStore) SaveRedFlags(session *models.ChatSession) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.sessions[session.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	stored.RedFlags = slices.Clone(session.RedFlags)
+	return nil
+}
+
+// SaveTriage stores the triage level, reason and time of a session
+func (s *MemoryStore) SaveTriage(session *models.ChatSession) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.sessions[session.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	stored.Triage = session.Triage
+	stored.TriageReason = session.TriageReason
+	stored.TriagedAt = session.TriagedAt
+	return nil
+}
+
+// SavePrompt stores the prompt version and experiment arm of a session
+func (s *MemoryStore) SavePrompt(session *models.ChatSession) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.sessions[session.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	stored.PromptVersion = session.PromptVersion
+	stored.Experiment = session.Experiment
+	stored.Variant = session.Variant
+	return nil
+}
+
+// SaveRating stores the patient's rating of a session
+func (s *MemoryStore) SaveRating(session *models.ChatSession) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.sessions[session.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	stored.Rating = session.Rating
+	stored.RatingComment = session.RatingComment
+	stored.RatedAt = session.RatedAt
+	return nil
+}
+
+// UpdateStatus moves a session to a new status
+func (s *MemoryStore) UpdateStatus(sessionID, status string, at time.Time) (*models.ChatSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[sessionID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	updated := *session
+	if err := applyTransition(&updated, status, at); err != nil {
+		return nil, err
+	}
+	*session = updated
+	return &updated, nil
+}
+
+// AddMessage appends a message to an existing session
+func (s *MemoryStore) AddMessage(msg *models.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.sessions[msg.SessionID]; !ok {
+		return ErrNotFound
+	}
+	msg.Seq = len(s.messages[msg.SessionID]) + 1
+	stored := copyMessage(msg)
+	s.messages[msg.SessionID] = append(s.messages[msg.SessionID], stored)
+	return nil
+}
+
+// ListMessages returns copies of all messages of a session
+func (s *MemoryStore) ListMessages(sessionID string) ([]*models.Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	msgs := make([]*models.Message, 0, len(s.messages[sessionID]))
+	for _, msg := range s.messages[sessionID] {
+		msgs = append(msgs, copyMessage(msg))
+	}
+	return msgs, nil
+}
+
+// CountMessages returns the number of messages in a session
+func (s *MemoryStore) CountMessages(sessionID string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.messages[sessionID]), nil
+}
+
+// GetSummary returns a copy of the running summary of a session
+func (s *MemoryStore) GetSummary(sessionID string) (*models.SessionSummary, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	summary, ok := s.summaries[sessionID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	result := *summary
+	return &result, nil
+}
+
+// SaveSummary creates or replaces the running summary of a session
+func (s *MemoryStore) SaveSummary(summary *models.SessionSummary) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := *summary
+	s.summaries[summary.SessionID] = &stored
+	return nil
+}
+
+// AddUsage records the token usage of a model call
+func (s *MemoryStore) AddUsage(record *models.UsageRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := *record
+	s.usage = append(s.usage, &stored)
+	return nil
+}
+
+// ListUsage returns copies of the usage recorded since the given time
+func (s *MemoryStore) ListUsage(since time.Time) ([]*models.UsageRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	records := make([]*models.UsageRecord, 0)
+	for _, record := range s.usage {
+		if !record.CreatedAt.Before(since) {
+			r := *record
+			records = append(records, &r)
+		}
+	}
+	return records, nil
+}
+
+// Close is a no-op for the in-memory store
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// copyMessage copies a message and its metadata
+func copyMessage(msg *models.Message) *models.Message {
+	m := *msg
+	if msg.Meta != nil {
+		meta := *msg.Meta
+		m.Meta = &meta
+	}
+	return &m
+}
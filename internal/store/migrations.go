@@ -0,0 +1,179 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// migrations are applied in order; never edit a migration once released,
+// append a new one instead
+var migrations = []string{
+	// 1: sessions and messages
+	`CREATE TABLE sessions (
+		id         TEXT PRIMARY KEY,
+		user_id    TEXT NOT NULL,
+		doctor_id  TEXT NOT NULL DEFAULT '',
+		specialty  TEXT NOT NULL DEFAULT '',
+		status     TEXT NOT NULL,
+		start_time INTEGER NOT NULL,
+		end_time   INTEGER
+	);
+	CREATE INDEX idx_sessions_user ON sessions(user_id);
+	CREATE TABLE messages (
+		seq        INTEGER PRIMARY KEY AUTOINCREMENT,
+		id         TEXT NOT NULL UNIQUE,
+		session_id TEXT NOT NULL REFERENCES sessions(id),
+		user_id    TEXT NOT NULL,
+		role       TEXT NOT NULL,
+		content    TEXT NOT NULL,
+		created_at INTEGER NOT NULL
+	);
+	CREATE INDEX idx_messages_session ON messages(session_id, seq);`,
+
+	// 2: running conversation summaries
+	`CREATE TABLE session_summaries (
+		session_id       TEXT PRIMARY KEY REFERENCES sessions(id),
+		content          TEXT NOT NULL,
+		covered_messages INTEGER NOT NULL,
+		updated_at       INTEGER NOT NULL
+	);`,
+
+	// 3: flag replies whose generation was stopped
+	`ALTER TABLE messages ADD COLUMN truncated INTEGER NOT NULL DEFAULT 0;`,
+
+	// 4: patient accounts
+	`CREATE TABLE users (
+		id            TEXT PRIMARY KEY,
+		email         TEXT NOT NULL UNIQUE,
+		name          TEXT NOT NULL DEFAULT '',
+		password_hash TEXT NOT NULL,
+		created_at    INTEGER NOT NULL
+	);`,
+
+	// 5: phone login; email and password become optional
+	`CREATE TABLE users_new (
+		id            TEXT PRIMARY KEY,
+		email         TEXT UNIQUE,
+		phone         TEXT UNIQUE,
+		name          TEXT NOT NULL DEFAULT '',
+		password_hash TEXT NOT NULL DEFAULT '',
+		created_at    INTEGER NOT NULL
+	);
+	INSERT INTO users_new (id, email, name, password_hash, created_at)
+		SELECT id, email, name, password_hash, created_at FROM users;
+	DROP TABLE users;
+	ALTER TABLE users_new RENAME TO users;`,
+
+	// 6: human doctor handoff and user roles
+	`ALTER TABLE sessions ADD COLUMN mode TEXT NOT NULL DEFAULT 'ai';
+	ALTER TABLE sessions ADD COLUMN handoff_reason TEXT NOT NULL DEFAULT '';
+	ALTER TABLE sessions ADD COLUMN handoff_requested_at INTEGER;
+	ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'patient';`,
+
+	// 7: doctor profiles and the doctor queue
+	`CREATE TABLE doctors (
+		id             TEXT PRIMARY KEY REFERENCES users(id),
+		name           TEXT NOT NULL DEFAULT '',
+		specialty      TEXT NOT NULL DEFAULT '',
+		license_no     TEXT NOT NULL DEFAULT '',
+		bio            TEXT NOT NULL DEFAULT '',
+		qualifications TEXT NOT NULL DEFAULT '[]',
+		available      INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX idx_sessions_status ON sessions(status, specialty);`,
+
+	// 8: doctor directory managed by admins
+	`ALTER TABLE doctors ADD COLUMN active INTEGER NOT NULL DEFAULT 1;
+	CREATE UNIQUE INDEX idx_doctors_license ON doctors(license_no) WHERE license_no != '';
+	CREATE INDEX idx_doctors_specialty ON doctors(specialty, active);`,
+
+	// 9: red-flag rules that fired in a session, as a JSON array
+	`ALTER TABLE sessions ADD COLUMN red_flags TEXT NOT NULL DEFAULT '[]';`,
+
+	// 10: triage level of each session
+	`ALTER TABLE sessions ADD COLUMN triage TEXT NOT NULL DEFAULT '';
+	ALTER TABLE sessions ADD COLUMN triage_reason TEXT NOT NULL DEFAULT '';
+	ALTER TABLE sessions ADD COLUMN triaged_at INTEGER;
+	CREATE INDEX idx_sessions_triage ON sessions(triage, start_time);`,
+
+	// 11: patient age given at session start, used in prompt templates
+	`ALTER TABLE sessions ADD COLUMN patient_age TEXT NOT NULL DEFAULT '';`,
+
+	// 12: prompt version of each reply and prompt experiment arm of each session
+	`ALTER TABLE sessions ADD COLUMN prompt_version TEXT NOT NULL DEFAULT '';
+	ALTER TABLE sessions ADD COLUMN experiment TEXT NOT NULL DEFAULT '';
+	ALTER TABLE sessions ADD COLUMN variant TEXT NOT NULL DEFAULT '';
+	ALTER TABLE messages ADD COLUMN prompt_version TEXT NOT NULL DEFAULT '';
+	CREATE INDEX idx_sessions_experiment ON sessions(experiment, variant);`,
+
+	// 13: patient rating of a consultation
+	`ALTER TABLE sessions ADD COLUMN rating INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE sessions ADD COLUMN rating_comment TEXT NOT NULL DEFAULT '';
+	ALTER TABLE sessions ADD COLUMN rated_at INTEGER;`,
+
+	// 14: model call metadata of AI replies
+	`ALTER TABLE messages ADD COLUMN model TEXT NOT NULL DEFAULT '';
+	ALTER TABLE messages ADD COLUMN prompt_tokens INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE messages ADD COLUMN completion_tokens INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE messages ADD COLUMN total_tokens INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE messages ADD COLUMN latency_ms INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE messages ADD COLUMN finish_reason TEXT NOT NULL DEFAULT '';
+	ALTER TABLE messages ADD COLUMN error TEXT NOT NULL DEFAULT '';`,
+
+	// 15: token usage of every model call, and context cache hits
+	`ALTER TABLE messages ADD COLUMN cache_hit_tokens INTEGER NOT NULL DEFAULT 0;
+	CREATE TABLE usage_records (
+		id                INTEGER PRIMARY KEY AUTOINCREMENT,
+		session_id        TEXT NOT NULL DEFAULT '',
+		user_id           TEXT NOT NULL DEFAULT '',
+		specialty         TEXT NOT NULL DEFAULT '',
+		purpose           TEXT NOT NULL,
+		model             TEXT NOT NULL,
+		prompt_tokens     INTEGER NOT NULL,
+		cache_hit_tokens  INTEGER NOT NULL,
+		completion_tokens INTEGER NOT NULL,
+		total_tokens      INTEGER NOT NULL,
+		estimated         INTEGER NOT NULL DEFAULT 0,
+		created_at        INTEGER NOT NULL
+	);
+	CREATE INDEX idx_usage_records_created ON usage_records(created_at);`,
+
+	// 16: when a human doctor was first requested, kept after returning to AI
+	`ALTER TABLE sessions ADD COLUMN escalated_at INTEGER;
+	UPDATE sessions SET escalated_at = handoff_requested_at WHERE handoff_requested_at IS NOT NULL;`,
+}
+
+// migrate applies every migration newer than the recorded schema version
+func migrate(db *sql.DB) error {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	var current int
+	if err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	for i := current; i < len(migrations); i++ {
+		version := i + 1
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("failed to begin migration %d: %w", version, err)
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to apply migration %d: %w", version, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version) VALUES (?)`, version); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record migration %d: %w", version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit migration %d: %w", version, err)
+		}
+		log.Printf("Applied database migration %d", version)
+	}
+
+	return nil
+}
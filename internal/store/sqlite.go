@@ -0,0 +1,612 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"medseek/internal/models"
+
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteStore persists sessions and messages in an embedded SQLite database
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// OpenSQLite opens (or creates) the database at path and runs pending migrations
+func OpenSQLite(path string) (*SQLiteStore, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)", path)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	// SQLite allows a single writer; serialize access through one connection
+	db.SetMaxOpenConns(1)
+
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLiteStore{db: db}, nil
+}
+
+// CreateUser saves a new user
+func (s *SQLiteStore) CreateUser(user *models.User) error {
+	_, err := s.db.Exec(
+		`INSERT INTO users (id, email, phone, name, role, password_hash, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		user.ID, toNullString(user.Email), toNullString(user.Phone), user.Name, user.Role, user.PasswordHash, toUnix(user.CreatedAt),
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrConflict
+		}
+		return fmt.Errorf("failed to insert user: %w", err)
+	}
+	return nil
+}
+
+// GetUser returns a user by ID
+func (s *SQLiteStore) GetUser(userID string) (*models.User, error) {
+	return s.scanUser(s.db.QueryRow(
+		`SELECT id, email, phone, name, role, password_hash, created_at FROM users WHERE id = ?`, userID))
+}
+
+// GetUserByEmail returns a user by email
+func (s *SQLiteStore) GetUserByEmail(email string) (*models.User, error) {
+	return s.scanUser(s.db.QueryRow(
+		`SELECT id, email, phone, name, role, password_hash, created_at FROM users WHERE email = ?`, email))
+}
+
+// GetUserByPhone returns a user by phone number
+func (s *SQLiteStore) GetUserByPhone(phone string) (*models.User, error) {
+	return s.scanUser(s.db.QueryRow(
+		`SELECT id, email, phone, name, role, password_hash, created_at FROM users WHERE phone = ?`, phone))
+}
+
+// SetUserRole changes the role of a user
+func (s *SQLiteStore) SetUserRole(userID, role string) error {
+	res, err := s.db.Exec(`UPDATE users SET role = ? WHERE id = ?`, role, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update role: %w", err)
+	}
+	return requireAffected(res)
+}
+
+func (s *SQLiteStore) scanUser(row *sql.Row) (*models.User, error) {
+	var user models.User
+	var email, phone sql.NullString
+	var created int64
+	err := row.Scan(&user.ID, &email, &phone, &user.Name, &user.Role, &user.PasswordHash, &created)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user: %w", err)
+	}
+	user.Email = email.String
+	user.Phone = phone.String
+	user.CreatedAt = fromUnix(created)
+	return &user, nil
+}
+
+// doctorColumns lists the columns read by scanDoctor
+const doctorColumns = `id, name, specialty, license_no, bio, qualifications, available, active`
+
+// scanDoctor reads a doctor selected with doctorColumns
+func scanDoctor(row rowScanner) (*models.DoctorProfile, error) {
+	var profile models.DoctorProfile
+	var qualifications string
+	err := row.Scan(&profile.ID, &profile.Name, &profile.Specialty, &profile.LicenseNo, &profile.Bio,
+		&qualifications, &profile.Available, &profile.Active)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(qualifications), &profile.Qualifications); err != nil {
+		return nil, fmt.Errorf("failed to decode qualifications: %w", err)
+	}
+	return &profile, nil
+}
+
+// GetDoctor returns the profile of a doctor
+func (s *SQLiteStore) GetDoctor(doctorID string) (*models.DoctorProfile, error) {
+	profile, err := scanDoctor(s.db.QueryRow(
+		`SELECT `+doctorColumns+` FROM doctors WHERE id = ?`, doctorID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load doctor: %w", err)
+	}
+	return profile, nil
+}
+
+// ListDoctors returns the doctors matching filter, ordered by name
+func (s *SQLiteStore) ListDoctors(filter DoctorFilter) ([]*models.DoctorProfile, error) {
+	query := `SELECT ` + doctorColumns + ` FROM doctors WHERE 1 = 1`
+	var args []any
+	if filter.Specialty != "" {
+		query += ` AND specialty = ?`
+		args = append(args, filter.Specialty)
+	}
+	if !filter.IncludeInactive {
+		query += ` AND active = 1`
+	}
+	query += ` ORDER BY name`
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list doctors: %w", err)
+	}
+	defer rows.Close()
+
+	doctors := make([]*models.DoctorProfile, 0)
+	for rows.Next() {
+		profile, err := scanDoctor(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan doctor: %w", err)
+		}
+		doctors = append(doctors, profile)
+	}
+	return doctors, rows.Err()
+}
+
+// SaveDoctor creates or replaces a doctor profile, or returns ErrConflict if
+// another doctor has the same license number
+func (s *SQLiteStore) SaveDoctor(profile *models.DoctorProfile) error {
+	qualifications, err := json.Marshal(profile.Qualifications)
+	if err != nil {
+		return fmt.Errorf("failed to encode qualifications: %w", err)
+	}
+	if profile.Qualifications == nil {
+		qualifications = []byte("[]")
+	}
+	_, err = s.db.Exec(
+		`INSERT INTO doctors (id, name, specialty, license_no, bio, qualifications, available, active)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		 ON CONFLICT(id) DO UPDATE SET
+		   name = excluded.name,
+		   specialty = excluded.specialty,
+		   license_no = excluded.license_no,
+		   bio = excluded.bio,
+		   qualifications = excluded.qualifications,
+		   available = excluded.available,
+		   active = excluded.active`,
+		profile.ID, profile.Name, profile.Specialty, profile.LicenseNo, profile.Bio,
+		string(qualifications), profile.Available, profile.Active,
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrConflict
+		}
+		if isForeignKeyViolation(err) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to save doctor: %w", err)
+	}
+	return nil
+}
+
+// CreateSession saves a new session
+func (s *SQLiteStore) CreateSession(session *models.ChatSession) error {
+	_, err := s.db.Exec(
+		`INSERT INTO sessions (id, user_id, doctor_id, specialty, patient_age, status, start_time, end_time, mode,
+		 experiment, variant)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		session.ID, session.UserID, session.DoctorID, session.Specialty, session.PatientAge, session.Status,
+		toUnix(session.StartTime), toNullUnix(session.EndTime), session.Mode,
+		session.Experiment, session.Variant,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert session: %w", err)
+	}
+	return nil
+}
+
+// sessionColumns lists the columns read by scanSession
+const sessionColumns = `id, user_id, doctor_id, specialty, patient_age, status, start_time, end_time,
+	mode, handoff_reason, handoff_requested_at, escalated_at, red_flags, triage, triage_reason, triaged_at,
+	prompt_version, experiment, variant, rating, rating_comment, rated_at`
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanSession reads a session selected with sessionColumns
+func scanSession(row rowScanner) (*models.ChatSession, error) {
+	var session models.ChatSession
+	var start int64
+	var end, requested, escalated, triaged, rated sql.NullInt64
+	var redFlags string
+	err := row.Scan(&session.ID, &session.UserID, &session.DoctorID, &session.Specialty, &session.PatientAge,
+		&session.Status, &start, &end, &session.Mode, &session.HandoffReason, &requested, &escalated, &redFlags,
+		&session.Triage, &session.TriageReason, &triaged,
+		&session.PromptVersion, &session.Experiment, &session.Variant,
+		&session.Rating, &session.RatingComment, &rated)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(redFlags), &session.RedFlags); err != nil {
+		return nil, fmt.Errorf("failed to decode red flags: %w", err)
+	}
+
+	session.StartTime = fromUnix(start)
+	session.EndTime = fromNullUnix(end)
+	session.HandoffRequestedAt = fromNullUnix(requested)
+	session.EscalatedAt = fromNullUnix(escalated)
+	session.TriagedAt = fromNullUnix(triaged)
+	session.RatedAt = fromNullUnix(rated)
+	return &session, nil
+}
+
+// GetSession returns a session by ID
+func (s *SQLiteStore) GetSession(sessionID string) (*models.ChatSession, error) {
+	session, err := scanSession(s.db.QueryRow(
+		`SELECT `+sessionColumns+` FROM sessions WHERE id = ?`, sessionID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load session: %w", err)
+	}
+	return session, nil
+}
+
+// ListSessions returns the sessions matching filter, oldest first
+func (s *SQLiteStore) ListSessions(filter SessionFilter) ([]*models.ChatSession, error) {
+	query := `SELECT ` + sessionColumns + ` FROM sessions WHERE 1 = 1`
+	var args []any
+	if filter.Status != "" {
+		query += ` AND status = ?`
+		args = append(args, filter.Status)
+	}
+	if filter.Specialty != "" {
+		query += ` AND specialty = ?`
+		args = append(args, filter.Specialty)
+	}
+	if filter.Triage != "" {
+		query += ` AND triage = ?`
+		args = append(args, filter.Triage)
+	}
+	if !filter.Since.IsZero() {
+		query += ` AND start_time >= ?`
+		args = append(args, toUnix(filter.Since))
+	}
+	query += ` ORDER BY start_time`
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := make([]*models.ChatSession, 0)
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// SaveHandoff stores the mode, doctor and handoff fields of a session
+func (s *SQLiteStore) SaveHandoff(session *models.ChatSession) error {
+	res, err := s.db.Exec(
+		`UPDATE sessions SET mode = ?, doctor_id = ?, handoff_reason = ?, handoff_requested_at = ?,
+		 escalated_at = ? WHERE id = ?`,
+		session.Mode, session.DoctorID, session.HandoffReason, toNullUnix(session.HandoffRequestedAt),
+		toNullUnix(session.EscalatedAt), session.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update handoff: %w", err)
+	}
+	return requireAffected(res)
+}
+
+// SaveRedFlags stores the red flags of a session
+func (s *SQLiteStore) SaveRedFlags(session *models.ChatSession) error {
+	redFlags, err := json.Marshal(session.RedFlags)
+	if err != nil {
+		return fmt.Errorf("failed to encode red flags: %w", err)
+	}
+	if session.RedFlags == nil {
+		redFlags = []byte("[]")
+	}
+	res, err := s.db.Exec(`UPDATE sessions SET red_flags = ? WHERE id = ?`, string(redFlags), session.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update red flags: %w", err)
+	}
+	return requireAffected(res)
+}
+
+// SaveTriage stores the triage level, reason and time of a session
+func (s *SQLiteStore) SaveTriage(session *models.ChatSession) error {
+	res, err := s.db.Exec(
+		`UPDATE sessions SET triage = ?, triage_reason = ?, triaged_at = ? WHERE id = ?`,
+		session.Triage, session.TriageReason, toNullUnix(session.TriagedAt), session.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update triage: %w", err)
+	}
+	return requireAffected(res)
+}
+
+// SavePrompt stores the prompt version and experiment arm of a session
+func (s *SQLiteStore) SavePrompt(session *models.ChatSession) error {
+	res, err := s.db.Exec(
+		`UPDATE sessions SET prompt_version = ?, experiment = ?, variant = ? WHERE id = ?`,
+		session.PromptVersion, session.Experiment, session.Variant, session.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update prompt: %w", err)
+	}
+	return requireAffected(res)
+}
+
+// SaveRating stores the patient's rating of a session
+func (s *SQLiteStore) SaveRating(session *models.ChatSession) error {
+	res, err := s.db.Exec(
+		`UPDATE sessions SET rating = ?, rating_comment = ?, rated_at = ? WHERE id = ?`,
+		session.Rating, session.RatingComment, toNullUnix(session.RatedAt), session.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update rating: %w", err)
+	}
+	return requireAffected(res)
+}
+
+// SetSpecialty changes the specialty of a session
+func (s *SQLiteStore) SetSpecialty(sessionID, specialty string) error {
+	res, err := s.db.Exec(`UPDATE sessions SET specialty = ? WHERE id = ?`, specialty, sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to update specialty: %w", err)
+	}
+	return requireAffected(res)
+}
+
+// UpdateStatus moves a session to a new status
+func (s *SQLiteStore) UpdateStatus(sessionID, status string, at time.Time) (*models.ChatSession, error) {
+	session, err := s.GetSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	previous := session.Status
+	if err := applyTransition(session, status, at); err != nil {
+		return nil, err
+	}
+
+	// Guard on the previous status so concurrent transitions cannot both succeed
+	res, err := s.db.Exec(
+		`UPDATE sessions SET status = ?, end_time = ? WHERE id = ? AND status = ?`,
+		session.Status, toNullUnix(session.EndTime), sessionID, previous,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update status: %w", err)
+	}
+	if err := requireAffected(res); err != nil {
+		return nil, fmt.Errorf("%w: session changed concurrently", ErrInvalidTransition)
+	}
+	return session, nil
+}
+
+// AddMessage appends a message to a session
+func (s *SQLiteStore) AddMessage(msg *models.Message) error {
+	var meta models.MessageMeta
+	if msg.Meta != nil {
+		meta = *msg.Meta
+	}
+	res, err := s.db.Exec(
+		`INSERT INTO messages (id, session_id, user_id, role, content, created_at, truncated, prompt_version,
+		 model, prompt_tokens, cache_hit_tokens, completion_tokens, total_tokens, latency_ms, finish_reason, error)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		msg.ID, msg.SessionID, msg.UserID, msg.Role, msg.Content, toUnix(msg.CreatedAt), msg.Truncated,
+		meta.PromptVersion, meta.Model, meta.Usage.PromptTokens, meta.Usage.CacheHitTokens, meta.Usage.CompletionTokens,
+		meta.Usage.TotalTokens, meta.LatencyMs, meta.FinishReason, meta.Error,
+	)
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to insert message: %w", err)
+	}
+
+	// Rows are numbered by insertion, so the position is fixed by the row's seq
+	// whatever is inserted concurrently
+	rowSeq, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to insert message: %w", err)
+	}
+	err = s.db.QueryRow(`SELECT COUNT(*) FROM messages WHERE session_id = ? AND seq <= ?`, msg.SessionID, rowSeq).Scan(&msg.Seq)
+	if err != nil {
+		return fmt.Errorf("failed to number message: %w", err)
+	}
+	return nil
+}
+
+// ListMessages returns all messages of a session in insertion order
+func (s *SQLiteStore) ListMessages(sessionID string) ([]*models.Message, error) {
+	rows, err := s.db.Query(
+		`SELECT id, session_id, user_id, role, content, created_at, truncated, prompt_version,
+		 model, prompt_tokens, cache_hit_tokens, completion_tokens, total_tokens, latency_ms, finish_reason, error
+		 FROM messages WHERE session_id = ? ORDER BY seq`, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query messages: %w", err)
+	}
+	defer rows.Close()
+
+	msgs := make([]*models.Message, 0)
+	for rows.Next() {
+		var msg models.Message
+		var meta models.MessageMeta
+		var created int64
+		if err := rows.Scan(&msg.ID, &msg.SessionID, &msg.UserID, &msg.Role, &msg.Content, &created, &msg.Truncated,
+			&meta.PromptVersion, &meta.Model, &meta.Usage.PromptTokens, &meta.Usage.CacheHitTokens, &meta.Usage.CompletionTokens,
+			&meta.Usage.TotalTokens, &meta.LatencyMs, &meta.FinishReason, &meta.Error); err != nil {
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		msg.CreatedAt = fromUnix(created)
+		// Replies stored before model metadata was recorded may still have a prompt version
+		if meta.Model != "" || meta.PromptVersion != "" {
+			msg.Meta = &meta
+		}
+		msg.Seq = len(msgs) + 1
+		msgs = append(msgs, &msg)
+	}
+	return msgs, rows.Err()
+}
+
+// CountMessages returns the number of messages in a session
+func (s *SQLiteStore) CountMessages(sessionID string) (int, error) {
+	var count int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM messages WHERE session_id = ?`, sessionID).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count messages: %w", err)
+	}
+	return count, nil
+}
+
+// GetSummary returns the running summary of a session
+func (s *SQLiteStore) GetSummary(sessionID string) (*models.SessionSummary, error) {
+	var summary models.SessionSummary
+	var updated int64
+	err := s.db.QueryRow(
+		`SELECT session_id, content, covered_messages, updated_at
+		 FROM session_summaries WHERE session_id = ?`, sessionID,
+	).Scan(&summary.SessionID, &summary.Content, &summary.CoveredMessages, &updated)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load summary: %w", err)
+	}
+	summary.UpdatedAt = fromUnix(updated)
+	return &summary, nil
+}
+
+// SaveSummary creates or replaces the running summary of a session
+func (s *SQLiteStore) SaveSummary(summary *models.SessionSummary) error {
+	_, err := s.db.Exec(
+		`INSERT INTO session_summaries (session_id, content, covered_messages, updated_at)
+		 VALUES (?, ?, ?, ?)
+		 ON CONFLICT(session_id) DO UPDATE SET
+		   content = excluded.content,
+		   covered_messages = excluded.covered_messages,
+		   updated_at = excluded.updated_at`,
+		summary.SessionID, summary.Content, summary.CoveredMessages, toUnix(summary.UpdatedAt),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save summary: %w", err)
+	}
+	return nil
+}
+
+// AddUsage records the token usage of a model call
+func (s *SQLiteStore) AddUsage(record *models.UsageRecord) error {
+	_, err := s.db.Exec(
+		`INSERT INTO usage_records (session_id, user_id, specialty, purpose, model,
+		 prompt_tokens, cache_hit_tokens, completion_tokens, total_tokens, estimated, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		record.SessionID, record.UserID, record.Specialty, record.Purpose, record.Model,
+		record.PromptTokens, record.CacheHitTokens, record.CompletionTokens, record.TotalTokens, record.Estimated,
+		toUnix(record.CreatedAt),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert usage: %w", err)
+	}
+	return nil
+}
+
+// ListUsage returns the usage recorded since the given time, oldest first
+func (s *SQLiteStore) ListUsage(since time.Time) ([]*models.UsageRecord, error) {
+	rows, err := s.db.Query(
+		`SELECT session_id, user_id, specialty, purpose, model,
+		 prompt_tokens, cache_hit_tokens, completion_tokens, total_tokens, estimated, created_at
+		 FROM usage_records WHERE created_at >= ? ORDER BY id`, toUnix(since))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query usage: %w", err)
+	}
+	defer rows.Close()
+
+	records := make([]*models.UsageRecord, 0)
+	for rows.Next() {
+		var r models.UsageRecord
+		var created int64
+		if err := rows.Scan(&r.SessionID, &r.UserID, &r.Specialty, &r.Purpose, &r.Model,
+			&r.PromptTokens, &r.CacheHitTokens, &r.CompletionTokens, &r.TotalTokens, &r.Estimated, &created); err != nil {
+			return nil, fmt.Errorf("failed to scan usage: %w", err)
+		}
+		r.CreatedAt = fromUnix(created)
+		records = append(records, &r)
+	}
+	return records, rows.Err()
+}
+
+// Close closes the database
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+// requireAffected returns ErrNotFound when an update matched no rows
+func requireAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// toNullString stores empty strings as NULL so UNIQUE columns may be left unset
+func toNullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// isUniqueViolation reports whether an insert failed on a UNIQUE constraint
+func isUniqueViolation(err error) bool {
+	return strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
+
+// isForeignKeyViolation reports whether a write referenced a missing row
+func isForeignKeyViolation(err error) bool {
+	return strings.Contains(err.Error(), "FOREIGN KEY constraint failed")
+}
+
+// toUnix stores times as Unix nanoseconds; the zero time is stored as 0
+func toUnix(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func toNullUnix(t *time.Time) sql.NullInt64 {
+	if t == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: toUnix(*t), Valid: true}
+}
+
+func fromNullUnix(n sql.NullInt64) *time.Time {
+	if !n.Valid {
+		return nil
+	}
+	t := fromUnix(n.Int64)
+	return &t
+}
+
+func fromUnix(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
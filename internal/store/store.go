@@ -0,0 +1,161 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"medseek/internal/models"
+)
+
+// Session status values
+const (
+	StatusActive   = "active"
+	StatusClosed   = "closed"
+	StatusArchived = "archived"
+)
+
+// Session modes: who answers the patient
+const (
+	ModeAI      = "ai"      // the model replies
+	ModeWaiting = "waiting" // a human doctor was requested; the model is paused
+	ModeDoctor  = "doctor"  // the assigned doctor replies; the model is paused
+)
+
+var (
+	// ErrNotFound is returned when a session does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrInvalidTransition is returned when a session status change is not allowed
+	ErrInvalidTransition = errors.New("invalid status transition")
+	// ErrConflict is returned when a record with the same unique key already exists
+	ErrConflict = errors.New("already exists")
+)
+
+// SessionFilter selects sessions in ListSessions; empty fields match everything
+type SessionFilter struct {
+	Status    string
+	Specialty string
+	Triage    string
+	// Since keeps sessions started at or after it
+	Since time.Time
+}
+
+// DoctorFilter selects doctors in ListDoctors; an empty Specialty matches all
+type DoctorFilter struct {
+	Specialty       string
+	IncludeInactive bool
+}
+
+// Store persists users, doctor profiles, chat sessions and their messages
+type Store interface {
+	// CreateUser saves a new user, or returns ErrConflict if the email or phone is taken
+	CreateUser(user *models.User) error
+	// GetUser returns a user by ID, or ErrNotFound
+	GetUser(userID string) (*models.User, error)
+	// GetUserByEmail returns a user by email, or ErrNotFound
+	GetUserByEmail(email string) (*models.User, error)
+	// GetUserByPhone returns a user by phone number, or ErrNotFound
+	GetUserByPhone(phone string) (*models.User, error)
+	// SetUserRole changes the role of a user
+	SetUserRole(userID, role string) error
+
+	// GetDoctor returns the profile of a doctor by user ID, or ErrNotFound
+	GetDoctor(doctorID string) (*models.DoctorProfile, error)
+	// SaveDoctor creates or replaces the doctor profile of a user. It returns
+	// ErrNotFound if the user does not exist and ErrConflict if another doctor
+	// has the same license number
+	SaveDoctor(profile *models.DoctorProfile) error
+	// ListDoctors returns the doctors matching filter, ordered by name
+	ListDoctors(filter DoctorFilter) ([]*models.DoctorProfile, error)
+
+	// CreateSession saves a new session
+	CreateSession(session *models.ChatSession) error
+	// GetSession returns a session by ID, or ErrNotFound
+	GetSession(sessionID string) (*models.ChatSession, error)
+	// ListSessions returns the sessions matching filter, oldest first
+	ListSessions(filter SessionFilter) ([]*models.ChatSession, error)
+	// SetSpecialty changes the specialty of a session
+	SetSpecialty(sessionID, specialty string) error
+	// SaveHandoff stores the mode, doctor and handoff fields of a session
+	SaveHandoff(session *models.ChatSession) error
+	// SaveRedFlags stores the red flags of a session
+	SaveRedFlags(session *models.ChatSession) error
+	// SaveTriage stores the triage level, reason and time of a session
+	SaveTriage(session *models.ChatSession) error
+	// SavePrompt stores the prompt version and experiment arm of a session
+	SavePrompt(session *models.ChatSession) error
+	// SaveRating stores the patient's rating of a session
+	SaveRating(session *models.ChatSession) error
+	// UpdateStatus moves a session to a new status, enforcing allowed transitions
+	UpdateStatus(sessionID, status string, at time.Time) (*models.ChatSession, error)
+	// AddMessage appends a message to a session and sets its Seq, or returns
+	// ErrNotFound if the session does not exist
+	AddMessage(msg *models.Message) error
+	// ListMessages returns all messages of a session in insertion order
+	ListMessages(sessionID string) ([]*models.Message, error)
+	// CountMessages returns the number of messages in a session
+	CountMessages(sessionID string) (int, error)
+	// GetSummary returns the running summary of a session, or ErrNotFound
+	GetSummary(sessionID string) (*models.SessionSummary, error)
+	// SaveSummary creates or replaces the running summary of a session
+	SaveSummary(summary *models.SessionSummary) error
+
+	// AddUsage records the token usage of a model call
+	AddUsage(record *models.UsageRecord) error
+	// ListUsage returns the usage recorded since the given time, oldest first
+	ListUsage(since time.Time) ([]*models.UsageRecord, error)
+	// Close releases any resources held by the store
+	Close() error
+}
+
+// allowedTransitions lists the statuses each status may move to
+var allowedTransitions = map[string][]string{
+	StatusActive:   {StatusClosed, StatusArchived},
+	StatusClosed:   {StatusArchived},
+	StatusArchived: {},
+}
+
+// CanTransition reports whether a session may move from one status to another
+func CanTransition(from, to string) bool {
+	for _, next := range allowedTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// applyTransition updates the session status and end time in place
+func applyTransition(session *models.ChatSession, status string, at time.Time) error {
+	if !CanTransition(session.Status, status) {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, session.Status, status)
+	}
+	session.Status = status
+	if session.EndTime == nil {
+		end := at
+		session.EndTime = &end
+	}
+	return nil
+}
+
+// Open creates the store named by driver ("sqlite" or "memory")
+func Open(driver, path string) (Store, error) {
+	switch driver {
+	case "sqlite", "":
+		return OpenSQLite(path)
+	case "memory":
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown store driver: %s", driver)
+	}
+}
+
+// OpenFromEnv opens the store configured by STORE_DRIVER and STORE_PATH
+func OpenFromEnv() (Store, error) {
+	path := os.Getenv("STORE_PATH")
+	if path == "" {
+		path = "medseek.db"
+	}
+	return Open(os.Getenv("STORE_DRIVER"), path)
+}
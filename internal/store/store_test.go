@@ -0,0 +1,453 @@
+package store
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"medseek/internal/models"
+)
+
+// eachStore runs a test against every store implementation, each starting
+// empty
+func eachStore(t *testing.T, test func(t *testing.T, s Store)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemoryStore())
+	})
+	t.Run("sqlite", func(t *testing.T) {
+		s, err := OpenSQLite(filepath.Join(t.TempDir(), "test.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+		test(t, s)
+	})
+}
+
+// at returns a fixed time offset by minutes, so ordering is deterministic
+func at(minutes int) time.Time {
+	return time.Date(2026, 10, 1, 9, 0, 0, 0, time.Local).Add(time.Duration(minutes) * time.Minute)
+}
+
+func mustCreateSession(t *testing.T, s Store, id string, start time.Time) *models.ChatSession {
+	t.Helper()
+	session := &models.ChatSession{
+		ID:        id,
+		UserID:    "u1",
+		Specialty: "pediatrics",
+		Status:    StatusActive,
+		Mode:      ModeAI,
+		StartTime: start,
+	}
+	if err := s.CreateSession(session); err != nil {
+		t.Fatalf("CreateSession(%s): %v", id, err)
+	}
+	return session
+}
+
+func mustCreateUser(t *testing.T, s Store, user *models.User) {
+	t.Helper()
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = at(0)
+	}
+	if user.Role == "" {
+		user.Role = "patient"
+	}
+	if err := s.CreateUser(user); err != nil {
+		t.Fatalf("CreateUser(%s): %v", user.ID, err)
+	}
+}
+
+func TestUsers(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		mustCreateUser(t, s, &models.User{ID: "u1", Email: "a@example.com", Name: "A"})
+		mustCreateUser(t, s, &models.User{ID: "u2", Phone: "13900000001"})
+
+		if err := s.CreateUser(&models.User{ID: "u3", Email: "a@example.com", CreatedAt: at(0)}); !errors.Is(err, ErrConflict) {
+			t.Errorf("duplicate email: err = %v, want ErrConflict", err)
+		}
+		if err := s.CreateUser(&models.User{ID: "u4", Phone: "13900000001", CreatedAt: at(0)}); !errors.Is(err, ErrConflict) {
+			t.Errorf("duplicate phone: err = %v, want ErrConflict", err)
+		}
+
+		user, err := s.GetUserByEmail("a@example.com")
+		if err != nil || user.ID != "u1" || user.Name != "A" || !user.CreatedAt.Equal(at(0)) {
+			t.Errorf("GetUserByEmail = %+v, %v", user, err)
+		}
+		if user, err := s.GetUserByPhone("13900000001"); err != nil || user.ID != "u2" {
+			t.Errorf("GetUserByPhone = %+v, %v", user, err)
+		}
+		for _, lookup := range []func() (*models.User, error){
+			func() (*models.User, error) { return s.GetUser("missing") },
+			func() (*models.User, error) { return s.GetUserByEmail("") },
+			func() (*models.User, error) { return s.GetUserByPhone("") },
+		} {
+			if _, err := lookup(); !errors.Is(err, ErrNotFound) {
+				t.Errorf("lookup of a missing user: err = %v, want ErrNotFound", err)
+			}
+		}
+
+		if err := s.SetUserRole("u2", "doctor"); err != nil {
+			t.Fatal(err)
+		}
+		if user, _ := s.GetUser("u2"); user.Role != "doctor" {
+			t.Errorf("role = %q, want doctor", user.Role)
+		}
+		if err := s.SetUserRole("missing", "doctor"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("SetUserRole(missing): err = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestDoctors(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		mustCreateUser(t, s, &models.User{ID: "d1", Phone: "13900000001"})
+		mustCreateUser(t, s, &models.User{ID: "d2", Phone: "13900000002"})
+
+		if err := s.SaveDoctor(&models.DoctorProfile{ID: "nobody", Name: "X"}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("SaveDoctor without a user: err = %v, want ErrNotFound", err)
+		}
+
+		zhang := &models.DoctorProfile{ID: "d1", Name: "张医生", Specialty: "pediatrics", LicenseNo: "110000000000001",
+			Qualifications: []string{"主治医师"}, Active: true}
+		li := &models.DoctorProfile{ID: "d2", Name: "李医生", Specialty: "dermatology", Active: true}
+		for _, p := range []*models.DoctorProfile{zhang, li} {
+			if err := s.SaveDoctor(p); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		got, err := s.GetDoctor("d1")
+		if err != nil || got.Name != "张医生" || !slices.Equal(got.Qualifications, []string{"主治医师"}) {
+			t.Errorf("GetDoctor = %+v, %v", got, err)
+		}
+		if got, _ := s.GetDoctor("d2"); got.Qualifications == nil {
+			t.Error("qualifications of a doctor without any are nil, want empty")
+		}
+		if _, err := s.GetDoctor("missing"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetDoctor(missing): err = %v, want ErrNotFound", err)
+		}
+
+		// Another doctor cannot take the license; the same one may keep it
+		li.LicenseNo = zhang.LicenseNo
+		if err := s.SaveDoctor(li); !errors.Is(err, ErrConflict) {
+			t.Errorf("duplicate license: err = %v, want ErrConflict", err)
+		}
+		zhang.Bio = "儿科"
+		if err := s.SaveDoctor(zhang); err != nil {
+			t.Errorf("resaving a doctor: %v", err)
+		}
+
+		li.LicenseNo = ""
+		li.Active = false
+		if err := s.SaveDoctor(li); err != nil {
+			t.Fatal(err)
+		}
+		active, _ := s.ListDoctors(DoctorFilter{})
+		if len(active) != 1 || active[0].ID != "d1" {
+			t.Errorf("active doctors = %v, want d1", ids(active))
+		}
+		all, _ := s.ListDoctors(DoctorFilter{IncludeInactive: true})
+		if got := ids(all); !slices.Equal(got, []string{"d1", "d2"}) {
+			t.Errorf("all doctors by name = %v, want [d1 d2]", got)
+		}
+		derm, _ := s.ListDoctors(DoctorFilter{Specialty: "dermatology", IncludeInactive: true})
+		if len(derm) != 1 || derm[0].ID != "d2" {
+			t.Errorf("dermatology doctors = %v, want d2", ids(derm))
+		}
+	})
+}
+
+func ids(doctors []*models.DoctorProfile) []string {
+	var out []string
+	for _, d := range doctors {
+		out = append(out, d.ID)
+	}
+	return out
+}
+
+func TestSessions(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		mustCreateSession(t, s, "s2", at(2))
+		mustCreateSession(t, s, "s1", at(1))
+
+		got, err := s.GetSession("s1")
+		if err != nil || got.UserID != "u1" || got.Mode != ModeAI || !got.StartTime.Equal(at(1)) || got.EndTime != nil {
+			t.Fatalf("GetSession = %+v, %v", got, err)
+		}
+		if _, err := s.GetSession("missing"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetSession(missing): err = %v, want ErrNotFound", err)
+		}
+
+		requested, triaged, rated := at(3), at(4), at(5)
+		got.Mode, got.DoctorID, got.HandoffReason, got.HandoffRequestedAt = ModeDoctor, "d1", "patient", &requested
+		got.EscalatedAt = &requested
+		got.RedFlags = []string{"stroke"}
+		got.Triage, got.TriageReason, got.TriagedAt = "emergency", "口角歪斜", &triaged
+		got.PromptVersion, got.Experiment, got.Variant = "abc", "exp", "concise"
+		got.Rating, got.RatingComment, got.RatedAt = 4, "好", &rated
+		for name, save := range map[string]func(*models.ChatSession) error{
+			"SaveHandoff":  s.SaveHandoff,
+			"SaveRedFlags": s.SaveRedFlags,
+			"SaveTriage":   s.SaveTriage,
+			"SavePrompt":   s.SavePrompt,
+			"SaveRating":   s.SaveRating,
+		} {
+			if err := save(got); err != nil {
+				t.Errorf("%s: %v", name, err)
+			}
+			if err := save(&models.ChatSession{ID: "missing"}); !errors.Is(err, ErrNotFound) {
+				t.Errorf("%s(missing): err = %v, want ErrNotFound", name, err)
+			}
+		}
+		if err := s.SetSpecialty("s1", "neurology"); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.SetSpecialty("missing", "neurology"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("SetSpecialty(missing): err = %v, want ErrNotFound", err)
+		}
+
+		saved, _ := s.GetSession("s1")
+		switch {
+		case saved.Mode != ModeDoctor || saved.DoctorID != "d1" || saved.HandoffReason != "patient" ||
+			saved.HandoffRequestedAt == nil || !saved.HandoffRequestedAt.Equal(requested) ||
+			saved.EscalatedAt == nil || !saved.EscalatedAt.Equal(requested):
+			t.Errorf("handoff not saved: %+v", saved)
+		case !slices.Equal(saved.RedFlags, []string{"stroke"}):
+			t.Errorf("red flags = %v", saved.RedFlags)
+		case saved.Triage != "emergency" || saved.TriageReason != "口角歪斜" || !saved.TriagedAt.Equal(triaged):
+			t.Errorf("triage not saved: %+v", saved)
+		case saved.PromptVersion != "abc" || saved.Experiment != "exp" || saved.Variant != "concise":
+			t.Errorf("prompt not saved: %+v", saved)
+		case saved.Rating != 4 || saved.RatingComment != "好" || !saved.RatedAt.Equal(rated):
+			t.Errorf("rating not saved: %+v", saved)
+		case saved.Specialty != "neurology":
+			t.Errorf("specialty = %q", saved.Specialty)
+		}
+
+		list, _ := s.ListSessions(SessionFilter{})
+		if len(list) != 2 || list[0].ID != "s1" || list[1].ID != "s2" {
+			t.Errorf("ListSessions is not oldest first: %v", list)
+		}
+		for _, tt := range []struct {
+			filter SessionFilter
+			want   string
+		}{
+			{SessionFilter{Specialty: "neurology"}, "s1"},
+			{SessionFilter{Triage: "emergency"}, "s1"},
+			{SessionFilter{Since: at(2)}, "s2"},
+		} {
+			list, _ := s.ListSessions(tt.filter)
+			if len(list) != 1 || list[0].ID != tt.want {
+				t.Errorf("ListSessions(%+v) = %v, want %s", tt.filter, list, tt.want)
+			}
+		}
+	})
+}
+
+func TestUpdateStatus(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		mustCreateSession(t, s, "s1", at(0))
+
+		closed, err := s.UpdateStatus("s1", StatusClosed, at(10))
+		if err != nil || closed.Status != StatusClosed || !closed.EndTime.Equal(at(10)) {
+			t.Fatalf("close = %+v, %v", closed, err)
+		}
+		if _, err := s.UpdateStatus("s1", StatusActive, at(11)); !errors.Is(err, ErrInvalidTransition) {
+			t.Errorf("reopen: err = %v, want ErrInvalidTransition", err)
+		}
+		// Archiving keeps the time the session ended
+		archived, err := s.UpdateStatus("s1", StatusArchived, at(12))
+		if err != nil || !archived.EndTime.Equal(at(10)) {
+			t.Errorf("archive = %+v, %v", archived, err)
+		}
+		if got, _ := s.GetSession("s1"); got.Status != StatusArchived {
+			t.Errorf("status = %q, want archived", got.Status)
+		}
+		if list, _ := s.ListSessions(SessionFilter{Status: StatusArchived}); len(list) != 1 {
+			t.Errorf("archived sessions = %d, want 1", len(list))
+		}
+		if _, err := s.UpdateStatus("missing", StatusClosed, at(0)); !errors.Is(err, ErrNotFound) {
+			t.Errorf("UpdateStatus(missing): err = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestMessages(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		mustCreateSession(t, s, "s1", at(0))
+		mustCreateSession(t, s, "s2", at(0))
+
+		if err := s.AddMessage(&models.Message{ID: "m0", SessionID: "missing", Role: "user", Content: "x", CreatedAt: at(1)}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("AddMessage to a missing session: err = %v, want ErrNotFound", err)
+		}
+
+		// Messages of two sessions interleave; each session is numbered on its own
+		meta := &models.MessageMeta{Model: "deepseek-chat", PromptVersion: "abc", LatencyMs: 120, FinishReason: "stop",
+			Usage: models.Usage{PromptTokens: 10, CacheHitTokens: 4, CompletionTokens: 5, TotalTokens: 15}}
+		added := []*models.Message{
+			{ID: "m1", SessionID: "s1", UserID: "u1", Role: "user", Content: "孩子发烧", CreatedAt: at(1)},
+			{ID: "m2", SessionID: "s2", UserID: "u1", Role: "user", Content: "other", CreatedAt: at(1)},
+			{ID: "m3", SessionID: "s1", UserID: "u1", Role: "assistant", Content: "几天了？", CreatedAt: at(2), Meta: meta},
+			{ID: "m4", SessionID: "s1", UserID: "u1", Role: "assistant", Content: "部分", CreatedAt: at(3), Truncated: true,
+				Meta: &models.MessageMeta{Model: "deepseek-chat", Error: "upstream_down"}},
+		}
+		wantSeq := []int{1, 1, 2, 3}
+		for i, msg := range added {
+			if err := s.AddMessage(msg); err != nil {
+				t.Fatal(err)
+			}
+			if msg.Seq != wantSeq[i] {
+				t.Errorf("AddMessage(%s) seq = %d, want %d", msg.ID, msg.Seq, wantSeq[i])
+			}
+		}
+
+		msgs, err := s.ListMessages("s1")
+		if err != nil || len(msgs) != 3 {
+			t.Fatalf("ListMessages = %v, %v", msgs, err)
+		}
+		for i, msg := range msgs {
+			want := []*models.Message{added[0], added[2], added[3]}[i]
+			if msg.ID != want.ID || msg.Seq != want.Seq || msg.Content != want.Content ||
+				msg.Truncated != want.Truncated || !msg.CreatedAt.Equal(want.CreatedAt) {
+				t.Errorf("message %d = %+v, want %+v", i, msg, want)
+			}
+		}
+		if msgs[0].Meta != nil {
+			t.Errorf("user message has meta %+v", msgs[0].Meta)
+		}
+		if got := msgs[1].Meta; got == nil || *got != *meta {
+			t.Errorf("meta = %+v, want %+v", got, meta)
+		}
+		if got := msgs[2].Meta; got == nil || got.Error != "upstream_down" {
+			t.Errorf("meta of the failed reply = %+v", got)
+		}
+
+		// Stored messages are not changed through the caller's copy
+		msgs[1].Meta.Model = "changed"
+		if again, _ := s.ListMessages("s1"); again[1].Meta.Model != "deepseek-chat" {
+			t.Error("ListMessages returned the stored meta")
+		}
+
+		for session, want := range map[string]int{"s1": 3, "s2": 1, "missing": 0} {
+			if n, err := s.CountMessages(session); err != nil || n != want {
+				t.Errorf("CountMessages(%s) = %d, %v, want %d", session, n, err, want)
+			}
+		}
+		if msgs, err := s.ListMessages("missing"); err != nil || len(msgs) != 0 {
+			t.Errorf("ListMessages(missing) = %v, %v", msgs, err)
+		}
+	})
+}
+
+func TestSummaries(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		mustCreateSession(t, s, "s1", at(0))
+
+		if _, err := s.GetSummary("s1"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetSummary before any: err = %v, want ErrNotFound", err)
+		}
+		for i, content := range []string{"first", "second"} {
+			err := s.SaveSummary(&models.SessionSummary{SessionID: "s1", Content: content, CoveredMessages: 4 * (i + 1), UpdatedAt: at(i)})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		got, err := s.GetSummary("s1")
+		if err != nil || got.Content != "second" || got.CoveredMessages != 8 || !got.UpdatedAt.Equal(at(1)) {
+			t.Errorf("GetSummary = %+v, %v", got, err)
+		}
+	})
+}
+
+func TestUsage(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		for i, purpose := range []string{"reply", "triage", "summary"} {
+			err := s.AddUsage(&models.UsageRecord{
+				SessionID: "s1", UserID: "u1", Specialty: "pediatrics", Purpose: purpose, Model: "deepseek-chat",
+				Usage:     models.Usage{PromptTokens: 100, CacheHitTokens: 60, CompletionTokens: 10, TotalTokens: 110},
+				Estimated: i == 2,
+				CreatedAt: at(i),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		records, err := s.ListUsage(at(1))
+		if err != nil || len(records) != 2 {
+			t.Fatalf("ListUsage = %v, %v", records, err)
+		}
+		first := records[0]
+		if first.Purpose != "triage" || first.Usage.CacheHitTokens != 60 || first.Estimated || !first.CreatedAt.Equal(at(1)) {
+			t.Errorf("first record = %+v", first)
+		}
+		if !records[1].Estimated {
+			t.Errorf("estimated flag lost: %+v", records[1])
+		}
+	})
+}
+
+func TestMigrationsAreIdempotent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	for i := 0; i < 2; i++ {
+		s, err := OpenSQLite(path)
+		if err != nil {
+			t.Fatalf("open %d: %v", i, err)
+		}
+		var version int
+		if err := s.db.QueryRow(`SELECT MAX(version) FROM schema_migrations`).Scan(&version); err != nil {
+			t.Fatal(err)
+		}
+		if version != len(migrations) {
+			t.Errorf("schema version = %d, want %d", version, len(migrations))
+		}
+		s.Close()
+	}
+}
+
+func TestMigrationsKeepData(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	s, err := OpenSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Roll the schema back to the first release and store data the way it did
+	s.db.Exec(`DROP TABLE schema_migrations`)
+	for _, table := range []string{"usage_records", "session_summaries", "messages", "doctors", "users", "sessions"} {
+		if _, err := s.db.Exec(`DROP TABLE ` + table); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.db.Exec(migrations[0]); err != nil {
+		t.Fatal(err)
+	}
+	s.db.Exec(`CREATE TABLE schema_migrations (version INTEGER PRIMARY KEY); INSERT INTO schema_migrations VALUES (1)`)
+	_, err = s.db.Exec(`INSERT INTO sessions (id, user_id, specialty, status, start_time) VALUES ('s1', 'u1', 'pediatrics', 'active', ?);
+		INSERT INTO messages (id, session_id, user_id, role, content, created_at) VALUES ('m1', 's1', 'u1', 'user', '发烧', ?)`,
+		toUnix(at(0)), toUnix(at(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s, err = OpenSQLite(path)
+	if err != nil {
+		t.Fatalf("upgrade: %v", err)
+	}
+	defer s.Close()
+
+	session, err := s.GetSession("s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Mode != ModeAI || session.RedFlags == nil || session.Triage != "" || session.Rating != 0 {
+		t.Errorf("upgraded session = %+v", session)
+	}
+	msgs, err := s.ListMessages("s1")
+	if err != nil || len(msgs) != 1 || msgs[0].Content != "发烧" || msgs[0].Seq != 1 || msgs[0].Meta != nil {
+		t.Errorf("upgraded messages = %v, %v", msgs, err)
+	}
+}
@@ -0,0 +1,76 @@
+// Package triage defines the urgency levels a consultation is sorted by and
+// parses the model's structured triage classification.
+package triage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Triage levels, most severe first
+const (
+	Emergency = "emergency" // may be life threatening: call 120 or go to the emergency department now
+	Urgent    = "urgent"    // should see a doctor within 24 hours
+	Routine   = "routine"   // needs an outpatient visit at a normal time
+	SelfCare  = "self-care" // can be managed at home
+)
+
+// Levels lists the triage levels, most severe first
+var Levels = []string{Emergency, Urgent, Routine, SelfCare}
+
+// Rank orders levels for sorting: Emergency is 0, and an empty or unknown
+// level ranks after every known one
+func Rank(level string) int {
+	for i, l := range Levels {
+		if l == level {
+			return i
+		}
+	}
+	return len(Levels)
+}
+
+// Valid reports whether level is a known triage level
+func Valid(level string) bool {
+	return Rank(level) < len(Levels)
+}
+
+// Higher returns the more severe of two levels
+func Higher(a, b string) string {
+	if Rank(b) < Rank(a) {
+		return b
+	}
+	return a
+}
+
+// Classification is the model's assessment of a consultation
+type Classification struct {
+	Level  string `json:"level"`
+	Reason string `json:"reason"`
+}
+
+// ErrInvalidClassification is returned when the model's answer is not a
+// classification
+var ErrInvalidClassification = errors.New("invalid triage classification")
+
+// ParseClassification reads the JSON object in a model response, tolerating
+// code fences or text around it
+func ParseClassification(response string) (Classification, error) {
+	var c Classification
+	start := strings.Index(response, "{")
+	end := strings.LastIndex(response, "}")
+	if start < 0 || end < start {
+		return c, fmt.Errorf("%w: no JSON object", ErrInvalidClassification)
+	}
+	if err := json.Unmarshal([]byte(response[start:end+1]), &c); err != nil {
+		return c, fmt.Errorf("%w: %v", ErrInvalidClassification, err)
+	}
+
+	c.Level = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(c.Level)), "_", "-")
+	c.Reason = strings.TrimSpace(c.Reason)
+	if !Valid(c.Level) {
+		return c, fmt.Errorf("%w: unknown level %q", ErrInvalidClassification, c.Level)
+	}
+	return c, nil
+}
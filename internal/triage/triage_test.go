@@ -0,0 +1,55 @@
+package triage
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseClassification(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		want     Classification
+	}{
+		{"plain", `{"level":"urgent","reason":"持续高热"}`, Classification{Urgent, "持续高热"}},
+		{"code fence", "```json\n{\"level\": \"routine\", \"reason\": \" 轻微咳嗽 \"}\n```", Classification{Routine, "轻微咳嗽"}},
+		{"text around", `分级结果如下：{"level":"EMERGENCY","reason":"胸痛"} 请尽快就医`, Classification{Emergency, "胸痛"}},
+		{"underscore", `{"level":"Self_Care","reason":"普通感冒"}`, Classification{SelfCare, "普通感冒"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseClassification(tt.response)
+		if err != nil || got != tt.want {
+			t.Errorf("%s: ParseClassification = %+v, %v, want %+v", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseClassificationRejectsMalformedAnswers(t *testing.T) {
+	for _, response := range []string{
+		"",
+		"无法判断",
+		"} {",
+		`{"level": "urgent", "reason":}`,
+		`{"level": "critical", "reason": "未知级别"}`,
+		`{"reason": "缺少级别"}`,
+	} {
+		if _, err := ParseClassification(response); !errors.Is(err, ErrInvalidClassification) {
+			t.Errorf("ParseClassification(%q) err = %v, want ErrInvalidClassification", response, err)
+		}
+	}
+}
+
+func TestHigher(t *testing.T) {
+	tests := []struct{ a, b, want string }{
+		{Routine, Urgent, Urgent},
+		{Emergency, Urgent, Emergency},
+		{SelfCare, SelfCare, SelfCare},
+		{"", Routine, Routine},
+		{Routine, "", Routine},
+	}
+	for _, tt := range tests {
+		if got := Higher(tt.a, tt.b); got != tt.want {
+			t.Errorf("Higher(%q, %q) = %q, want %q", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
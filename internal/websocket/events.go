@@ -0,0 +1,181 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"medseek/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// HTTP fallback transports for networks that break WebSocket upgrades. SSE and
+// long-poll subscribers are ordinary hub clients without a conn, so they sit
+// in the same session fan-out as WebSocket clients and receive the same frames.
+
+// newSubscriber creates an HTTP subscriber and attaches it to a session in the
+// background; the caller must start draining its send channel right away
+func (h *Hub) newSubscriber(clientID, sessionID, pollID string, lastSeq int) *Client {
+	client := h.newClient(nil, clientID, "", sessionID)
+	client.pollID = pollID
+
+	go h.attach(client, lastSeq)
+	return client
+}
+
+// ServeEvents streams a session's frames as Server-Sent Events until the
+// request ends. Each frame is one data line; stored messages carry their seq
+// as the event id, so a reconnecting EventSource resumes via Last-Event-ID.
+func (h *Hub) ServeEvents(w http.ResponseWriter, r *http.Request, clientID, sessionID string, lastSeq int) {
+	h.serveEvents(w, r, h.newClient(nil, clientID, "", sessionID), lastSeq)
+}
+
+// ServeQueueEvents streams QueueChannel to a doctor workstation as
+// Server-Sent Events, filtered by the doctor's profile like
+// HandleQueueConnection
+func (h *Hub) ServeQueueEvents(w http.ResponseWriter, r *http.Request, profile *models.DoctorProfile) {
+	client := h.newClient(nil, profile.ID, "", QueueChannel)
+	client.profile = profile
+	h.serveEvents(w, r, client, -1)
+}
+
+// serveEvents attaches an HTTP subscriber and writes its frames as events
+func (h *Hub) serveEvents(w http.ResponseWriter, r *http.Request, client *Client, lastSeq int) {
+	clientID, sessionID := client.ID, client.SessionID
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Stop nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	// Tell EventSource how long to wait before reconnecting
+	fmt.Fprint(w, "retry: 2000\n\n")
+	if err := rc.Flush(); err != nil {
+		log.Printf("SSE not supported for client %s: %v", clientID, err)
+		return
+	}
+
+	go h.attach(client, lastSeq)
+	defer func() {
+		close(client.closed)
+		h.unregister <- client
+	}()
+	log.Printf("SSE client %s subscribed to session %s", clientID, sessionID)
+
+	ticker := time.NewTicker(h.cfg.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case message, ok := <-client.send:
+			if !ok {
+				return
+			}
+			rc.SetWriteDeadline(time.Now().Add(h.cfg.WriteWait))
+			if err := writeEvent(w, message); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+
+		case <-ticker.C:
+			// A comment line keeps proxies from closing an idle stream
+			rc.SetWriteDeadline(time.Now().Add(h.cfg.WriteWait))
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+			client.touch()
+		}
+	}
+}
+
+// writeEvent writes one frame as an SSE event
+func writeEvent(w http.ResponseWriter, message []byte) error {
+	var frame struct {
+		Seq int `json:"seq"`
+	}
+	if err := json.Unmarshal(message, &frame); err == nil && frame.Seq > 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", frame.Seq); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "data: %s\n\n", message)
+	return err
+}
+
+// PollResponse is the body of a long-poll response
+type PollResponse struct {
+	PollID string            `json:"poll_id"`
+	Frames []json.RawMessage `json:"frames"`
+}
+
+// ErrPollNotOwned is returned when a poll ID belongs to another client or
+// session
+var ErrPollNotOwned = errors.New("poll id belongs to another subscriber")
+
+// Poll returns the frames queued for a long-poll subscriber, waiting up to
+// PollWait for the first one. The subscriber outlives the request so frames
+// sent between polls are kept; pass the returned poll ID on the next poll.
+// An unknown or expired poll ID starts a new subscriber from lastSeq, and one
+// that is not polled again within PongWait is removed by the reaper. A poll
+// ID is only good for the client and session it was issued to.
+func (h *Hub) Poll(r *http.Request, pollID, clientID, sessionID string, lastSeq int) (PollResponse, error) {
+	h.mu.RLock()
+	client, ok := h.polls[pollID]
+	h.mu.RUnlock()
+	if ok && (client.ID != clientID || client.SessionID != sessionID) {
+		return PollResponse{}, ErrPollNotOwned
+	}
+	if !ok {
+		pollID = uuid.New().String()
+		client = h.newSubscriber(clientID, sessionID, pollID, lastSeq)
+		h.mu.Lock()
+		h.polls[pollID] = client
+		h.mu.Unlock()
+		log.Printf("Long-poll client %s subscribed to session %s", clientID, sessionID)
+	}
+	client.touch()
+
+	resp := PollResponse{PollID: pollID, Frames: []json.RawMessage{}}
+
+	timer := time.NewTimer(h.cfg.PollWait)
+	defer timer.Stop()
+
+	select {
+	case message, ok := <-client.send:
+		if !ok {
+			return resp, nil
+		}
+		resp.Frames = append(resp.Frames, message)
+	case <-timer.C:
+		return resp, nil
+	case <-r.Context().Done():
+		return resp, nil
+	}
+
+	// Take whatever else is already queued without waiting
+	for {
+		select {
+		case message, ok := <-client.send:
+			if !ok {
+				return resp, nil
+			}
+			resp.Frames = append(resp.Frames, message)
+		default:
+			client.touch()
+			return resp, nil
+		}
+	}
+}
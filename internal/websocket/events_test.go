@@ -0,0 +1,195 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"medseek/internal/models"
+)
+
+// readEvents reads SSE events from a stream until n data events arrived,
+// returning their ids and frames
+func readEvents(t *testing.T, scanner *bufio.Scanner, n int) ([]string, []models.WebSocketMessage) {
+	t.Helper()
+	var ids []string
+	var frames []models.WebSocketMessage
+	id := ""
+	for len(frames) < n && scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			var frame models.WebSocketMessage
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &frame); err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, id)
+			frames = append(frames, frame)
+			id = ""
+		}
+	}
+	if len(frames) < n {
+		t.Fatalf("stream ended after %d events, want %d: %v", len(frames), n, scanner.Err())
+	}
+	return ids, frames
+}
+
+func TestEventsResumeFromLastEventID(t *testing.T) {
+	h, _ := newTestHub(t, newGatedProvider(), 3)
+	session, _ := newTestSession(t, h)
+	for _, content := range []string{"问一", "问二", "问三"} {
+		if _, err := h.chatSvc.AddMessage(session.ID, session.UserID, "user", content); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Like the handler, a reconnecting EventSource's Last-Event-ID is the seq to resume after
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastSeq := 0
+		if id := r.Header.Get("Last-Event-ID"); id != "" {
+			lastSeq, _ = strconv.Atoi(id)
+		}
+		h.ServeEvents(w, r, session.UserID, session.ID, lastSeq)
+	}))
+	defer srv.Close()
+
+	connect := func(lastEventID string) (*bufio.Scanner, context.CancelFunc) {
+		ctx, cancel := context.WithCancel(context.Background())
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+			t.Fatalf("Content-Type = %q", ct)
+		}
+		return bufio.NewScanner(resp.Body), func() {
+			cancel()
+			resp.Body.Close()
+		}
+	}
+
+	scanner, disconnect := connect("")
+	ids, frames := readEvents(t, scanner, 2)
+	disconnect()
+	if ids[0] != "1" || ids[1] != "2" || frames[1].Content != "问二" {
+		t.Fatalf("first stream = %v %+v, want seq 1 and 2", ids, frames)
+	}
+
+	scanner, disconnect = connect(ids[1])
+	defer disconnect()
+	ids, frames = readEvents(t, scanner, 1)
+	if ids[0] != "3" || frames[0].Content != "问三" {
+		t.Errorf("resumed stream starts with %s %+v, want seq 3", ids[0], frames[0])
+	}
+}
+
+// pollRequest returns a request that gives up after d
+func pollRequest(t *testing.T, d time.Duration) *http.Request {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "/api/sessions/events?transport=poll", nil)
+	return req
+}
+
+func TestPollReusesTheSubscriber(t *testing.T) {
+	h, _ := newTestHub(t, newGatedProvider(), 3)
+	session, _ := newTestSession(t, h)
+
+	first, err := h.Poll(pollRequest(t, 50*time.Millisecond), "", session.UserID, session.ID, -1)
+	if err != nil || first.PollID == "" || len(first.Frames) != 0 {
+		t.Fatalf("first poll = %+v, %v", first, err)
+	}
+
+	// Frames sent between polls wait for the next one
+	h.sendToSession(session.ID, models.WebSocketMessage{Type: FrameTyping, UserID: "assistant"})
+	second, err := h.Poll(pollRequest(t, time.Second), first.PollID, session.UserID, session.ID, -1)
+	if err != nil || second.PollID != first.PollID || len(second.Frames) != 1 {
+		t.Fatalf("second poll = %+v, %v, want the typing frame on poll %s", second, err, first.PollID)
+	}
+	h.mu.RLock()
+	polls := len(h.polls)
+	h.mu.RUnlock()
+	if polls != 1 {
+		t.Errorf("hub has %d poll subscribers, want 1", polls)
+	}
+}
+
+func TestPollRejectsAnotherClientsPollID(t *testing.T) {
+	h, _ := newTestHub(t, newGatedProvider(), 3)
+	session, _ := newTestSession(t, h)
+	other, _ := newTestSession(t, h)
+
+	first, err := h.Poll(pollRequest(t, 50*time.Millisecond), "", session.UserID, session.ID, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.sendToSession(session.ID, models.WebSocketMessage{Type: FrameTyping, UserID: "assistant"})
+
+	if _, err := h.Poll(pollRequest(t, 50*time.Millisecond), first.PollID, "intruder", session.ID, -1); !errors.Is(err, ErrPollNotOwned) {
+		t.Errorf("other client: err = %v, want ErrPollNotOwned", err)
+	}
+	if _, err := h.Poll(pollRequest(t, 50*time.Millisecond), first.PollID, session.UserID, other.ID, -1); !errors.Is(err, ErrPollNotOwned) {
+		t.Errorf("other session: err = %v, want ErrPollNotOwned", err)
+	}
+
+	// The owner's frame is still queued
+	resp, err := h.Poll(pollRequest(t, time.Second), first.PollID, session.UserID, session.ID, -1)
+	if err != nil || len(resp.Frames) != 1 {
+		t.Errorf("owner poll = %+v, %v, want the queued frame", resp, err)
+	}
+}
+
+func TestReaperExpiresIdlePolls(t *testing.T) {
+	h, _ := newTestHub(t, newGatedProvider(), 3)
+	session, _ := newTestSession(t, h)
+
+	resp, err := h.Poll(pollRequest(t, 50*time.Millisecond), "", session.UserID, session.ID, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.mu.RLock()
+	client := h.polls[resp.PollID]
+	h.mu.RUnlock()
+	waitRegistered(t, h, client)
+
+	// Polled recently: kept
+	h.reapStale(time.Now())
+	h.mu.RLock()
+	_, kept := h.polls[resp.PollID]
+	h.mu.RUnlock()
+	if !kept {
+		t.Fatal("reaper removed a poll subscriber within PongWait")
+	}
+
+	h.reapStale(time.Now().Add(h.cfg.PongWait + time.Second))
+	h.mu.RLock()
+	_, kept = h.polls[resp.PollID]
+	registered := h.clients[client]
+	h.mu.RUnlock()
+	if kept || registered {
+		t.Errorf("expired poll subscriber kept: in polls %v, registered %v", kept, registered)
+	}
+	if got := h.Stats().Reaped; got == 0 {
+		t.Error("reaped count not updated")
+	}
+
+	// The expired poll ID starts a new subscriber
+	next, err := h.Poll(pollRequest(t, 50*time.Millisecond), resp.PollID, session.UserID, session.ID, -1)
+	if err != nil || next.PollID == resp.PollID {
+		t.Errorf("poll after expiry = %+v, %v, want a new poll ID", next, err)
+	}
+}
@@ -2,50 +2,160 @@ package websocket
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"medseek/internal/models"
 	"medseek/internal/service"
+	"medseek/internal/store"
 
 	"github.com/gorilla/websocket"
 )
 
+// maxMessageSize limits a single client frame
+const maxMessageSize = 64 * 1024
+
+// QueueChannel is the pseudo-session doctor workstations subscribe to for
+// queue frames. It has no messages, so nothing is ever replayed on it.
+const QueueChannel = "doctor-queue"
+
 type Hub struct {
 	clients    map[*Client]bool
 	sessions   map[string]map[*Client]bool // session_id -> clients in that session
 	register   chan *Client
 	unregister chan *Client
+	turns      map[string]*sessionTurns // session_id -> queued and in-flight model calls
+	polls      map[string]*Client       // poll_id -> long-poll subscriber kept between requests
 	mu         sync.RWMutex
 	chatSvc    *service.ChatService
+	cfg        Config
+
+	// Connection counters, reported by Stats
+	reaped       atomic.Int64 // clients unregistered by the reaper
+	readTimeouts atomic.Int64 // connections closed because no pong arrived in time
 }
 
+// Client is one subscriber to a session's frames. WebSocket clients are served
+// by readPump and writePump; HTTP subscribers (SSE and long-poll, see
+// events.go) have no conn and are drained by their request handlers.
 type Client struct {
 	ID        string
 	SessionID string
-	conn      *websocket.Conn
+	conn      *websocket.Conn // nil for HTTP subscribers
 	send      chan []byte
 	hub       *Hub
+	protocol  int                   // negotiated protocol version
+	closed    chan struct{}         // closed when the client stops draining send
+	lastSeen  atomic.Int64          // unix nanos of the last frame or pong from the client
+	pollID    string                // set for long-poll subscribers
+	role      string                // message role of the client's frames: user, doctor or observer (read only)
+	profile   *models.DoctorProfile // set for doctor workstations on QueueChannel
+}
+
+// Config holds hub limits
+type Config struct {
+	// MaxQueuedTurns is how many messages may wait per session while a reply is generated
+	MaxQueuedTurns int
+	// PingInterval is how often the server pings each client
+	PingInterval time.Duration
+	// PongWait is how long a client may stay silent (no frame, no pong) before
+	// its connection is considered dead; it must exceed PingInterval
+	PongWait time.Duration
+	// WriteWait bounds a single write to a client
+	WriteWait time.Duration
+	// PollWait is how long a long-poll request waits for frames
+	PollWait time.Duration
+}
+
+// ConfigFromEnv reads the hub configuration from TURN_QUEUE_DEPTH,
+// WS_PING_INTERVAL, WS_PONG_WAIT, WS_WRITE_WAIT and POLL_WAIT
+func ConfigFromEnv() Config {
+	cfg := Config{
+		MaxQueuedTurns: 3,
+		PingInterval:   25 * time.Second,
+		PongWait:       60 * time.Second,
+		WriteWait:      10 * time.Second,
+		PollWait:       25 * time.Second,
+	}
+	if v, err := strconv.Atoi(os.Getenv("TURN_QUEUE_DEPTH")); err == nil && v >= 0 {
+		cfg.MaxQueuedTurns = v
+	}
+	if d, err := time.ParseDuration(os.Getenv("WS_PING_INTERVAL")); err == nil && d > 0 {
+		cfg.PingInterval = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("WS_PONG_WAIT")); err == nil && d > 0 {
+		cfg.PongWait = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("WS_WRITE_WAIT")); err == nil && d > 0 {
+		cfg.WriteWait = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("POLL_WAIT")); err == nil && d > 0 {
+		cfg.PollWait = d
+	}
+	if cfg.PongWait <= cfg.PingInterval {
+		cfg.PongWait = cfg.PingInterval * 2
+	}
+	return cfg
+}
+
+// Stats is a snapshot of the hub's connection counters
+type Stats struct {
+	Clients      int   `json:"clients"`
+	Sessions     int   `json:"sessions"`
+	Reaped       int64 `json:"reaped"`
+	ReadTimeouts int64 `json:"read_timeouts"`
+}
+
+// Stats returns the current connection counters
+func (h *Hub) Stats() Stats {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return Stats{
+		Clients:      len(h.clients),
+		Sessions:     len(h.sessions),
+		Reaped:       h.reaped.Load(),
+		ReadTimeouts: h.readTimeouts.Load(),
+	}
 }
 
 // NewHub creates a new WebSocket hub
-func NewHub(chatSvc *service.ChatService) *Hub {
+func NewHub(chatSvc *service.ChatService, cfg Config) *Hub {
 	return &Hub{
 		clients:    make(map[*Client]bool),
 		sessions:   make(map[string]map[*Client]bool),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
+		turns:      make(map[string]*sessionTurns),
+		polls:      make(map[string]*Client),
 		chatSvc:    chatSvc,
+		cfg:        cfg,
 	}
 }
 
 // Run starts the hub
 func (h *Hub) Run() {
+	// Check for stale clients a few times per pong window
+	reaper := time.NewTicker(h.cfg.PongWait / 2)
+	defer reaper.Stop()
+
 	for {
 		select {
 		case client := <-h.register:
+			// The client may have gone away while its history was replayed
+			select {
+			case <-client.closed:
+				continue
+			default:
+			}
 			h.mu.Lock()
 			h.clients[client] = true
 			// Add client to session-specific map
@@ -58,110 +168,387 @@ func (h *Hub) Run() {
 
 		case client := <-h.unregister:
 			h.mu.Lock()
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				// Remove from session-specific map
-				if sessionClients, ok := h.sessions[client.SessionID]; ok {
-					delete(sessionClients, client)
-					if len(sessionClients) == 0 {
-						delete(h.sessions, client.SessionID)
-					}
-				}
-				close(client.send)
-				h.mu.Unlock()
+			removed := h.removeClientLocked(client)
+			h.mu.Unlock()
+			if removed {
 				log.Printf("Client unregistered: %s from session: %s", client.ID, client.SessionID)
-			} else {
-				h.mu.Unlock()
+			}
+
+		case now := <-reaper.C:
+			h.reapStale(now)
+		}
+	}
+}
+
+// removeClientLocked drops a client from the hub and closes its send channel.
+// It returns false if the client was already removed. The caller must hold h.mu.
+func (h *Hub) removeClientLocked(client *Client) bool {
+	if _, ok := h.clients[client]; !ok {
+		return false
+	}
+	delete(h.clients, client)
+	if client.pollID != "" {
+		delete(h.polls, client.pollID)
+	}
+	// Remove from session-specific map
+	if sessionClients, ok := h.sessions[client.SessionID]; ok {
+		delete(sessionClients, client)
+		if len(sessionClients) == 0 {
+			delete(h.sessions, client.SessionID)
+			// Nobody is left to read the reply; stop paying for it
+			if h.dropTurnsLocked(client.SessionID) {
+				log.Printf("Last client left session %s, cancelling generation", client.SessionID)
 			}
 		}
 	}
+	close(client.send)
+	return true
 }
 
-// HandleConnection handles a new WebSocket connection
-func (h *Hub) HandleConnection(conn *websocket.Conn, clientID, sessionID string) {
+// reapStale unregisters clients that have not sent a frame or pong within
+// PongWait. Normally the read deadline closes such connections; the reaper
+// catches connections whose pumps are stuck.
+func (h *Hub) reapStale(now time.Time) {
+	cutoff := now.Add(-h.cfg.PongWait).UnixNano()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// Long-poll subscribers have nobody draining them between polls; closing
+	// closed also releases a replay still waiting for the next poll
+	for pollID, client := range h.polls {
+		if client.lastSeen.Load() >= cutoff {
+			continue
+		}
+		h.removeClientLocked(client)
+		delete(h.polls, pollID)
+		close(client.closed)
+		h.reaped.Add(1)
+		log.Printf("Expired long-poll client %s in session %s", client.ID, client.SessionID)
+	}
+
+	for client := range h.clients {
+		if client.lastSeen.Load() >= cutoff {
+			continue
+		}
+		if h.removeClientLocked(client) {
+			h.reaped.Add(1)
+			log.Printf("Reaped stale client %s in session %s", client.ID, client.SessionID)
+		}
+	}
+}
+
+// HandleConnection handles a new WebSocket connection. role is "user" for the
+// patient, "doctor" for the assigned doctor and "observer" for other doctors
+// watching the session. If lastSeq is not negative, every stored session
+// message after it is replayed first.
+func (h *Hub) HandleConnection(conn *websocket.Conn, clientID, role, sessionID string, lastSeq int) {
+	h.serveConn(h.newClient(conn, clientID, role, sessionID), lastSeq)
+}
+
+// HandleQueueConnection subscribes a doctor workstation to QueueChannel over
+// WebSocket. It only gets frames about sessions the doctor holds or, going by
+// profile, may observe.
+func (h *Hub) HandleQueueConnection(conn *websocket.Conn, profile *models.DoctorProfile) {
+	client := h.newClient(conn, profile.ID, "observer", QueueChannel)
+	client.profile = profile
+	h.serveConn(client, -1)
+}
+
+// newClient creates a client of a session; conn is nil for HTTP subscribers
+func (h *Hub) newClient(conn *websocket.Conn, clientID, role, sessionID string) *Client {
 	client := &Client{
 		ID:        clientID,
 		SessionID: sessionID,
 		conn:      conn,
 		send:      make(chan []byte, 256),
 		hub:       h,
+		protocol:  ProtocolVersion,
+		closed:    make(chan struct{}),
+		role:      role,
 	}
+	client.touch()
+	return client
+}
 
-	h.register <- client
-
+// serveConn starts the pumps of a WebSocket client and attaches it
+func (h *Hub) serveConn(client *Client, lastSeq int) {
 	go client.writePump()
+	h.attach(client, lastSeq)
 	go client.readPump()
 }
 
+// attach registers a client whose send channel is already being drained. If
+// lastSeq is not negative, messages stored after it are replayed first.
+func (h *Hub) attach(client *Client, lastSeq int) {
+	// Handoff frames are not stored; tell a reconnecting client who is answering
+	if session, err := h.chatSvc.GetSession(client.SessionID); err == nil && session.Mode != store.ModeAI {
+		client.queueFrame(handoffFrame(session))
+	}
+
+	// Replay before registering so missed messages arrive ahead of live frames
+	if lastSeq >= 0 {
+		lastSeq = client.replay(lastSeq, false)
+	}
+
+	h.register <- client
+
+	// Catch up on anything stored between the replay and registration
+	if lastSeq >= 0 {
+		client.replay(lastSeq, true)
+	}
+}
+
+// replay sends the session messages stored after afterSeq and returns the
+// last sequence number sent. Before registration frames are written straight
+// to the send channel; once registered they go through sendFrame.
+func (c *Client) replay(afterSeq int, registered bool) int {
+	msgs, err := c.hub.chatSvc.GetMessagesAfter(c.SessionID, afterSeq)
+	if err != nil {
+		log.Printf("Failed to load messages for replay in session %s: %v", c.SessionID, err)
+		return afterSeq
+	}
+
+	for _, msg := range msgs {
+		frame := messageFrame(msg, "")
+		if registered {
+			c.sendFrame(frame)
+		} else if !c.queueFrame(frame) {
+			return afterSeq
+		}
+		afterSeq = msg.Seq
+	}
+
+	if len(msgs) > 0 {
+		log.Printf("Replayed %d messages to client %s in session %s", len(msgs), c.ID, c.SessionID)
+	}
+	return afterSeq
+}
+
 // readPump reads messages from WebSocket
 func (c *Client) readPump() {
+	// Unregistering closes c.send; writePump then flushes any queued frames
+	// (such as a final error) and closes the connection
 	defer func() {
 		c.hub.unregister <- c
-		c.conn.Close()
 	}()
 
-	c.conn.SetReadDeadline(time.Time{}) // No deadline for reading
+	// Every pong or frame pushes the read deadline out; a client that stops
+	// answering pings (e.g. a suspended iOS Safari tab) times out
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(c.hub.cfg.PongWait))
+	c.conn.SetPongHandler(func(string) error {
+		c.touch()
+		return c.conn.SetReadDeadline(time.Now().Add(c.hub.cfg.PongWait))
+	})
 
 	for {
 		_, message, err := c.conn.ReadMessage()
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				c.hub.readTimeouts.Add(1)
+				log.Printf("WebSocket read timeout for client %s in session %s", c.ID, c.SessionID)
+			} else if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("WebSocket error: %v", err)
 			}
 			return
 		}
+		c.touch()
+		c.conn.SetReadDeadline(time.Now().Add(c.hub.cfg.PongWait))
 
 		var wsMsg models.WebSocketMessage
 		if err := json.Unmarshal(message, &wsMsg); err != nil {
 			log.Printf("Failed to unmarshal message: %v", err)
+			c.sendFrame(errorFrame(ErrCodeBadFrame, "消息格式错误", ""))
 			continue
 		}
 
 		wsMsg.UserID = c.ID
 		wsMsg.SessionID = c.SessionID
 
-		// Add user message to service
-		c.hub.chatSvc.AddMessage(c.SessionID, c.ID, "user", wsMsg.Content)
+		if !c.handleFrame(wsMsg) {
+			return
+		}
+	}
+}
+
+// handleFrame dispatches one client frame. It returns false if the
+// connection should be closed.
+func (c *Client) handleFrame(wsMsg models.WebSocketMessage) bool {
+	switch wsMsg.Type {
+	case FrameHello:
+		version, ok := negotiate(wsMsg.Versions)
+		if !ok {
+			c.sendFrame(errorFrame(ErrCodeUnsupportedVersion, "客户端版本过旧，请刷新页面", ""))
+			return false
+		}
+		c.protocol = version
+		c.sendFrame(models.WebSocketMessage{
+			Type:      FrameSystem,
+			Content:   "welcome",
+			Versions:  supportedVersions,
+			SessionID: c.SessionID,
+		})
+
+	case FrameMessage, FrameStop, FrameTyping, FrameHandoff, FrameTransfer:
+		if reply := c.hub.Submit(c.ID, c.role, c.SessionID, wsMsg); reply.Type != "" {
+			c.sendFrame(reply)
+		}
+
+	default:
+		c.sendFrame(errorFrame(ErrCodeUnknownType, fmt.Sprintf("不支持的消息类型: %s", wsMsg.Type), wsMsg.ClientMsgID))
+	}
 
-		// Send user message to clients in this session only
-		msgBytes, _ := json.Marshal(wsMsg)
-		c.hub.broadcastToSession(c.SessionID, msgBytes)
+	return true
+}
 
-		// Process message and get response
-		response, err := c.hub.chatSvc.ProcessMessage(c.SessionID, wsMsg.Content)
+// Submit handles a message, stop, typing, handoff or transfer frame from a client of a
+// session, whatever its transport. role is "user" for the patient, "doctor"
+// for the assigned doctor and "observer" for a read-only doctor. It returns
+// the frame owed to the sender only (an ack or an error), or a frame with an
+// empty Type if there is none.
+func (h *Hub) Submit(clientID, role, sessionID string, wsMsg models.WebSocketMessage) models.WebSocketMessage {
+	if role == "observer" {
+		return errorFrame(ErrCodeReadOnly, "旁观模式下不能发送消息", wsMsg.ClientMsgID)
+	}
+
+	switch wsMsg.Type {
+	case FrameMessage:
+		if strings.TrimSpace(wsMsg.Content) == "" {
+			return errorFrame(ErrCodeEmptyMessage, "消息内容不能为空", wsMsg.ClientMsgID)
+		}
+		session, err := h.chatSvc.GetSession(sessionID)
 		if err != nil {
-			log.Printf("Failed to process message: %v", err)
-			errMsg := models.WebSocketMessage{
-				Type:    "error",
-				Content: fmt.Sprintf("处理消息时出错: %v", err),
-			}
-			errBytes, _ := json.Marshal(errMsg)
-			c.hub.broadcastToSession(c.SessionID, errBytes)
-			continue
+			log.Printf("Failed to load session %s: %v", sessionID, err)
+			return errorFrame(ErrCodeInternal, "处理消息时出错，请稍后重试", wsMsg.ClientMsgID)
+		}
+		// A closed consultation takes no more messages, and never reaches the model
+		if session.Status != store.StatusActive {
+			return errorFrame(ErrCodeSessionClosed, "本次咨询已结束", wsMsg.ClientMsgID)
+		}
+		// Only the doctor currently holding the session may answer in it
+		if role == "doctor" && (session.Mode != store.ModeDoctor || session.DoctorID != clientID) {
+			return errorFrame(ErrCodeNotAssigned, "您未接诊该会话", wsMsg.ClientMsgID)
+		}
+		ahead, ok := h.enqueueTurn(turn{sessionID: sessionID, userID: clientID, role: role, msg: wsMsg})
+		if !ok {
+			return errorFrame(ErrCodeQueueFull, "发送的消息过多，请等待医生回复后再发送", wsMsg.ClientMsgID)
+		}
+		if ahead > 0 {
+			h.sendToSession(sessionID, models.WebSocketMessage{
+				Type:          FrameStatus,
+				ClientMsgID:   wsMsg.ClientMsgID,
+				Content:       fmt.Sprintf("消息已排队，前面还有%d条消息", ahead),
+				UserID:        clientID,
+				QueuePosition: ahead,
+			})
+		}
+		return models.WebSocketMessage{
+			Type:          FrameAck,
+			ClientMsgID:   wsMsg.ClientMsgID,
+			QueuePosition: ahead,
+		}
+
+	case FrameStop:
+		// Patient asked to stop the reply being generated
+		if !h.cancelTurn(sessionID) {
+			log.Printf("Stop requested in session %s with no generation in flight", sessionID)
 		}
 
-		// Add assistant message to service
-		c.hub.chatSvc.AddMessage(c.SessionID, "assistant", "assistant", response)
+	case FrameTyping:
+		h.sendToSession(sessionID, models.WebSocketMessage{
+			Type:   FrameTyping,
+			UserID: clientID,
+			Role:   role,
+		})
+
+	case FrameHandoff:
+		var session *models.ChatSession
+		changed := true
+		var err error
+		if role == "doctor" {
+			session, err = h.chatSvc.ReturnToAI(sessionID, clientID)
+		} else {
+			session, changed, err = h.chatSvc.RequestHandoff(sessionID, service.HandoffReasonPatient)
+		}
+		if err != nil {
+			return handoffErrorFrame(err, wsMsg.ClientMsgID)
+		}
+		if changed {
+			h.AnnounceHandoff(session)
+		}
 
-		// Send assistant response to this session only
-		respMsg := models.WebSocketMessage{
-			Type:    "message",
-			Content: response,
-			UserID:  "assistant",
+	case FrameTransfer:
+		// A doctor may only transfer a session they hold
+		if role == "doctor" {
+			session, err := h.chatSvc.GetSession(sessionID)
+			if err != nil {
+				log.Printf("Failed to load session %s: %v", sessionID, err)
+				return errorFrame(ErrCodeInternal, "处理消息时出错，请稍后重试", wsMsg.ClientMsgID)
+			}
+			if session.Mode != store.ModeDoctor || session.DoctorID != clientID {
+				return errorFrame(ErrCodeNotAssigned, "您未接诊该会话", wsMsg.ClientMsgID)
+			}
+		}
+		session, changed, err := h.chatSvc.TransferSession(sessionID, wsMsg.Specialty)
+		if err != nil {
+			return transferErrorFrame(err, wsMsg.ClientMsgID)
 		}
-		respBytes, _ := json.Marshal(respMsg)
-		c.hub.broadcastToSession(c.SessionID, respBytes)
+		if changed {
+			h.sendToSession(session.ID, transferFrame(session, h.chatSvc.SpecialtyName(session.Specialty)))
+			// A doctor holding the session left it on transfer
+			if session.Mode != store.ModeAI {
+				h.sendToSession(session.ID, handoffFrame(session))
+			}
+			h.NotifyQueue(session)
+		}
+
+	default:
+		return errorFrame(ErrCodeUnknownType, fmt.Sprintf("不支持的消息类型: %s", wsMsg.Type), wsMsg.ClientMsgID)
 	}
+
+	return models.WebSocketMessage{}
 }
 
-// writePump writes messages to WebSocket
+// AnnounceHandoff tells a session's clients that its mode changed. Leaving AI
+// mode stops the reply being generated; queued patient messages are still
+// stored but get no AI reply.
+func (h *Hub) AnnounceHandoff(session *models.ChatSession) {
+	if session.Mode != store.ModeAI && h.cancelTurn(session.ID) {
+		log.Printf("Stopped AI reply in session %s for handoff to a human doctor", session.ID)
+	}
+	h.sendToSession(session.ID, handoffFrame(session))
+	h.NotifyQueue(session)
+}
+
+// NotifyQueue tells doctor workstations that a session was opened, closed,
+// changed mode or was triaged
+func (h *Hub) NotifyQueue(session *models.ChatSession) {
+	h.sendToQueue(session, models.WebSocketMessage{
+		Type:      FrameQueue,
+		SessionID: session.ID,
+		Mode:      session.Mode,
+		DoctorID:  session.DoctorID,
+		Specialty: session.Specialty,
+		Triage:    session.Triage,
+		Content:   session.Status,
+	})
+}
+
+// writePump writes messages to WebSocket and pings the client
 func (c *Client) writePump() {
-	defer c.conn.Close()
+	ticker := time.NewTicker(c.hub.cfg.PingInterval)
+	defer func() {
+		ticker.Stop()
+		close(c.closed)
+		c.conn.Close()
+	}()
 
 	for {
 		select {
 		case message, ok := <-c.send:
-			c.conn.SetWriteDeadline(time.Time{})
+			c.conn.SetWriteDeadline(time.Now().Add(c.hub.cfg.WriteWait))
 			if !ok {
 				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
@@ -171,6 +558,40 @@ func (c *Client) writePump() {
 				return
 			}
 
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(c.hub.cfg.WriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// touch records activity from the client
+func (c *Client) touch() {
+	c.lastSeen.Store(time.Now().UnixNano())
+}
+
+// sendToQueue sends a frame about a session to the doctor workstations
+// allowed to see that session
+func (h *Hub) sendToQueue(session *models.ChatSession, msg models.WebSocketMessage) {
+	msgBytes, err := encodeFrame(msg)
+	if err != nil {
+		log.Printf("Failed to marshal message: %v", err)
+		return
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for client := range h.sessions[QueueChannel] {
+		if session.DoctorID != client.ID && !service.MayObserve(client.profile, session) {
+			continue
+		}
+		select {
+		case client.send <- msgBytes:
+		default:
+			log.Printf("Warning: Could not send message to client %s in session %s", client.ID, QueueChannel)
 		}
 	}
 }
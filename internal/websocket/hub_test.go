@@ -0,0 +1,355 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"medseek/internal/contextbuilder"
+	"medseek/internal/llm"
+	"medseek/internal/models"
+	"medseek/internal/pricing"
+	"medseek/internal/prompts"
+	"medseek/internal/redflag"
+	"medseek/internal/service"
+	"medseek/internal/specialty"
+	"medseek/internal/store"
+)
+
+// gatedProvider is a scripted provider whose streamed replies wait until the
+// test opens the gate, so turns can be queued and stopped while one is in
+// flight. Each streamed call is announced on started.
+type gatedProvider struct {
+	*llm.ScriptedProvider
+	started chan struct{}
+	gate    chan struct{}
+}
+
+func newGatedProvider(responses ...string) *gatedProvider {
+	return &gatedProvider{
+		ScriptedProvider: llm.NewScriptedProvider(responses...),
+		started:          make(chan struct{}, 16),
+		gate:             make(chan struct{}),
+	}
+}
+
+// open lets every waiting and later call through
+func (p *gatedProvider) open() { close(p.gate) }
+
+func (p *gatedProvider) ChatCompletionStream(ctx context.Context, messages []models.DeepSeekMsg, callback func(string) error) (models.Completion, error) {
+	p.started <- struct{}{}
+	select {
+	case <-p.gate:
+	case <-ctx.Done():
+		return models.Completion{Model: "scripted"}, ctx.Err()
+	}
+	return p.ScriptedProvider.ChatCompletionStream(ctx, messages, callback)
+}
+
+// waitStarted waits for the next streamed call to reach the provider
+func (p *gatedProvider) waitStarted(t *testing.T) {
+	t.Helper()
+	select {
+	case <-p.started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no model call started")
+	}
+}
+
+// newTestHub runs a hub over a chat service on an in-memory store
+func newTestHub(t *testing.T, provider llm.Provider, maxQueued int) (*Hub, *store.MemoryStore) {
+	t.Helper()
+	st := store.NewMemoryStore()
+	redflags, err := redflag.New(redflag.DefaultRules())
+	if err != nil {
+		t.Fatal(err)
+	}
+	specialties, err := specialty.New(specialty.Defaults())
+	if err != nil {
+		t.Fatal(err)
+	}
+	library, err := prompts.New("", "", "", specialties.IDs(), specialties.Prompt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chatSvc := service.NewChatService(provider, st, contextbuilder.New(contextbuilder.DefaultMaxTokens, contextbuilder.DefaultReserveTokens),
+		service.SummaryConfig{}, redflags, service.TriageConfig{}, service.RoutingConfig{SuggestConfidence: 0.5}, library, specialties, pricing.Defaults())
+
+	h := NewHub(chatSvc, Config{
+		MaxQueuedTurns: maxQueued,
+		PingInterval:   time.Minute,
+		PongWait:       2 * time.Minute,
+		WriteWait:      time.Second,
+		PollWait:       time.Second,
+	})
+	go h.Run()
+	return h, st
+}
+
+// newTestSession starts a pediatrics session and subscribes its patient
+func newTestSession(t *testing.T, h *Hub) (*models.ChatSession, *Client) {
+	t.Helper()
+	session, err := h.chatSvc.CreateSession(uuid.New().String(), uuid.New().String(), "pediatrics", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return session, subscribe(t, h, session.UserID, session.ID)
+}
+
+// subscribe adds a client to a session and waits until it is registered
+func subscribe(t *testing.T, h *Hub, clientID, sessionID string) *Client {
+	t.Helper()
+	return waitRegistered(t, h, h.newSubscriber(clientID, sessionID, "", -1))
+}
+
+// subscribeQueue adds a doctor workstation with the given profile to
+// QueueChannel and waits until it is registered
+func subscribeQueue(t *testing.T, h *Hub, profile *models.DoctorProfile) *Client {
+	t.Helper()
+	client := h.newClient(nil, profile.ID, "", QueueChannel)
+	client.profile = profile
+	go h.attach(client, -1)
+	return waitRegistered(t, h, client)
+}
+
+// waitRegistered waits until the hub has registered a client
+func waitRegistered(t *testing.T, h *Hub, client *Client) *Client {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		h.mu.RLock()
+		registered := h.clients[client]
+		h.mu.RUnlock()
+		if registered {
+			return client
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("client was not registered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// nextFrame returns the next frame of the given type sent to a client,
+// skipping the others
+func nextFrame(t *testing.T, c *Client, frameType string) models.WebSocketMessage {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case data, ok := <-c.send:
+			if !ok {
+				t.Fatalf("client closed waiting for a %s frame", frameType)
+			}
+			var frame models.WebSocketMessage
+			if err := json.Unmarshal(data, &frame); err != nil {
+				t.Fatal(err)
+			}
+			if frame.Type == frameType {
+				return frame
+			}
+		case <-timeout:
+			t.Fatalf("no %s frame", frameType)
+		}
+	}
+}
+
+// message is a patient chat frame
+func message(content, clientMsgID string) models.WebSocketMessage {
+	return models.WebSocketMessage{Type: FrameMessage, Content: content, ClientMsgID: clientMsgID}
+}
+
+// waitIdle waits until no turn of the session is queued or running
+func waitIdle(t *testing.T, h *Hub, sessionID string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		h.mu.RLock()
+		_, busy := h.turns[sessionID]
+		h.mu.RUnlock()
+		if !busy {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("turns still running")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSubmitToClosedSession(t *testing.T) {
+	provider := newGatedProvider("答")
+	provider.open()
+	h, st := newTestHub(t, provider, 3)
+	session, _ := newTestSession(t, h)
+	if err := h.chatSvc.CloseSession(session.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	reply := h.Submit(session.UserID, "user", session.ID, message("还在吗", "1"))
+	if reply.Type != FrameError || reply.Code != ErrCodeSessionClosed || reply.ClientMsgID != "1" {
+		t.Errorf("reply = %+v, want %s", reply, ErrCodeSessionClosed)
+	}
+	waitIdle(t, h, session.ID)
+	if msgs, _ := st.ListMessages(session.ID); len(msgs) != 0 {
+		t.Errorf("stored %d messages in a closed session", len(msgs))
+	}
+	if n := len(provider.Requests()); n != 0 {
+		t.Errorf("model got %d calls", n)
+	}
+}
+
+func TestQueuedTurnsOfAClosedSessionAreRejected(t *testing.T) {
+	provider := newGatedProvider("答一", "答二")
+	h, st := newTestHub(t, provider, 3)
+	session, client := newTestSession(t, h)
+
+	h.Submit(session.UserID, "user", session.ID, message("问一", "1"))
+	provider.waitStarted(t)
+	h.Submit(session.UserID, "user", session.ID, message("问二", "2"))
+	if err := h.chatSvc.CloseSession(session.ID); err != nil {
+		t.Fatal(err)
+	}
+	provider.open()
+
+	nextFrame(t, client, FrameDone)
+	if frame := nextFrame(t, client, FrameError); frame.Code != ErrCodeSessionClosed || frame.ClientMsgID != "2" {
+		t.Errorf("error = %+v, want %s for the queued message", frame, ErrCodeSessionClosed)
+	}
+	waitIdle(t, h, session.ID)
+	if n := len(provider.Requests()); n != 1 {
+		t.Errorf("model got %d calls, want 1", n)
+	}
+	if msgs, _ := st.ListMessages(session.ID); len(msgs) != 2 {
+		t.Errorf("stored %d messages, want the first exchange only", len(msgs))
+	}
+}
+
+func TestQueueFramesFollowTheDoctorsProfile(t *testing.T) {
+	h, _ := newTestHub(t, llm.NewScriptedProvider(), 3)
+	session, _ := newTestSession(t, h)
+	pediatrician := subscribeQueue(t, h, &models.DoctorProfile{ID: uuid.New().String(), Specialty: "pediatrics", Active: true})
+	dermatologist := subscribeQueue(t, h, &models.DoctorProfile{ID: uuid.New().String(), Specialty: "dermatology", Active: true})
+	inactive := subscribeQueue(t, h, &models.DoctorProfile{ID: uuid.New().String(), Specialty: "pediatrics"})
+
+	msg, err := h.chatSvc.AddMessage(session.ID, session.UserID, "user", "孩子体温39.8度")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.screenMessage(msg) == nil {
+		t.Fatal("no red flag fired")
+	}
+	h.NotifyQueue(session)
+
+	if frame := nextFrame(t, pediatrician, FrameEmergency); frame.SessionID != session.ID || frame.Content == "" {
+		t.Errorf("emergency = %+v, want the patient's words", frame)
+	}
+	if frame := nextFrame(t, pediatrician, FrameQueue); frame.SessionID != session.ID {
+		t.Errorf("queue = %+v, want session %s", frame, session.ID)
+	}
+
+	// Any active doctor may pick up a patient waiting for a doctor
+	waiting, _, err := h.chatSvc.RequestHandoff(session.ID, service.HandoffReasonPatient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.NotifyQueue(waiting)
+
+	// The dermatologist's first frame is about the waiting patient
+	select {
+	case data := <-dermatologist.send:
+		var frame models.WebSocketMessage
+		if err := json.Unmarshal(data, &frame); err != nil {
+			t.Fatal(err)
+		}
+		if frame.Type != FrameQueue || frame.Mode != store.ModeWaiting {
+			t.Errorf("dermatologist got %+v before the waiting session", frame)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("dermatologist got no frame for the waiting session")
+	}
+	select {
+	case data := <-dermatologist.send:
+		t.Errorf("dermatologist got %s", data)
+	case data := <-inactive.send:
+		t.Errorf("inactive doctor got %s", data)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestReconnectReplaysMessagesAfterLastSeq(t *testing.T) {
+	h, _ := newTestHub(t, llm.NewScriptedProvider(), 3)
+	session, _ := newTestSession(t, h)
+	for _, content := range []string{"问一", "问二", "问三"} {
+		if _, err := h.chatSvc.AddMessage(session.ID, session.UserID, "user", content); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client := waitRegistered(t, h, h.newSubscriber(session.UserID, session.ID, "", 1))
+	for _, want := range []struct {
+		seq     int
+		content string
+	}{{2, "问二"}, {3, "问三"}} {
+		if frame := nextFrame(t, client, FrameMessage); frame.Seq != want.seq || frame.Content != want.content {
+			t.Errorf("replayed %+v, want seq %d", frame, want.seq)
+		}
+	}
+
+	// Live frames follow the replay
+	h.sendToSession(session.ID, models.WebSocketMessage{Type: FrameTyping, UserID: "assistant"})
+	nextFrame(t, client, FrameTyping)
+	select {
+	case data := <-client.send:
+		t.Errorf("unexpected frame after replay: %s", data)
+	default:
+	}
+}
+
+func TestReconnectToAHandedOffSession(t *testing.T) {
+	h, _ := newTestHub(t, llm.NewScriptedProvider(), 3)
+	session, _ := newTestSession(t, h)
+	if _, _, err := h.chatSvc.RequestHandoff(session.ID, service.HandoffReasonPatient); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.chatSvc.AddMessage(session.ID, session.UserID, "user", "医生在吗"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The mode comes first, then the missed message
+	client := waitRegistered(t, h, h.newSubscriber(session.UserID, session.ID, "", 0))
+	if frame := nextFrame(t, client, FrameHandoff); frame.Mode != store.ModeWaiting {
+		t.Errorf("handoff = %+v, want waiting", frame)
+	}
+	if frame := nextFrame(t, client, FrameMessage); frame.Seq != 1 {
+		t.Errorf("replayed %+v, want seq 1", frame)
+	}
+}
+
+func TestReaperRemovesSilentClients(t *testing.T) {
+	h, _ := newTestHub(t, llm.NewScriptedProvider(), 3)
+	session, silent := newTestSession(t, h)
+	active := subscribe(t, h, session.UserID, session.ID)
+
+	// Only the silent client has been quiet for longer than PongWait
+	now := time.Now().Add(h.cfg.PongWait + time.Second)
+	silent.lastSeen.Store(time.Now().Add(-time.Hour).UnixNano())
+	active.lastSeen.Store(now.UnixNano())
+	h.reapStale(now)
+
+	h.mu.RLock()
+	silentKept, activeKept := h.clients[silent], h.clients[active]
+	h.mu.RUnlock()
+	if silentKept || !activeKept {
+		t.Errorf("after reaping: silent client kept %v, active client kept %v", silentKept, activeKept)
+	}
+	if _, ok := <-silent.send; ok {
+		t.Error("reaped client's send channel is still open")
+	}
+	if stats := h.Stats(); stats.Reaped != 1 || stats.Clients != 1 || stats.Sessions != 1 {
+		t.Errorf("stats = %+v, want one client reaped and one left", stats)
+	}
+}
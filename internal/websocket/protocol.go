@@ -0,0 +1,290 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"medseek/internal/llm"
+	"medseek/internal/models"
+	"medseek/internal/routing"
+	"medseek/internal/service"
+	"medseek/internal/store"
+
+	"github.com/google/uuid"
+)
+
+// ProtocolVersion is the WebSocket protocol version spoken by this server.
+// Clients that never send a hello frame are assumed to speak version 1.
+const ProtocolVersion = 1
+
+// supportedVersions lists every protocol version the server accepts
+var supportedVersions = []int{1}
+
+// Frame types sent by clients
+const (
+	FrameHello   = "hello"   // protocol negotiation, carries versions
+	FrameMessage = "message" // patient chat message, also echoed back once stored
+	FrameStop    = "stop"    // cancel the reply being generated
+	FrameTyping  = "typing"  // patient is typing; relayed to the session
+	// FrameHandoff from a patient asks for a human doctor; from the assigned
+	// doctor it hands the session back to AI. The server sends it to the
+	// session whenever the mode changes.
+	FrameHandoff = "handoff"
+	// FrameTransfer from the patient or the assigned doctor moves the session
+	// to the specialty it carries. The server sends it to the session once the
+	// specialty changed.
+	FrameTransfer = "transfer"
+)
+
+// Frame types sent by the server
+const (
+	FrameSystem = "system" // negotiation result and connection notices
+	FrameAck    = "ack"    // a client message was accepted
+	FrameStatus = "status" // queue position of a waiting message
+	FrameDelta  = "delta"  // streamed chunk of a reply
+	FrameDone   = "done"   // complete reply, stored
+	FrameError  = "error"  // carries one of the error codes below
+	// FrameQueue tells doctor workstations that a session in the queue was
+	// opened, closed or changed mode; they refetch the queue over HTTP
+	FrameQueue = "queue"
+	// FrameEmergency carries emergency guidance when red-flag rules fire on a
+	// patient message. Doctor workstations get one for every fired rule,
+	// including rules screening the model's replies.
+	FrameEmergency = "emergency"
+	// FrameRoute suggests a better suited specialty after the patient's first
+	// message; the client may answer with a transfer frame
+	FrameRoute = "route"
+)
+
+// Error codes carried by error frames
+const (
+	ErrCodeBadFrame           = "bad_frame"
+	ErrCodeUnknownType        = "unknown_type"
+	ErrCodeUnsupportedVersion = "unsupported_version"
+	ErrCodeEmptyMessage       = "empty_message"
+	ErrCodeQueueFull          = "queue_full"
+	ErrCodeStorageFailed      = "storage_failed"
+	ErrCodeNotAssigned        = "not_assigned"
+	ErrCodeSessionClosed      = "session_closed"
+	ErrCodeReadOnly           = "read_only"
+	ErrCodeUnknownSpecialty   = "unknown_specialty"
+	ErrCodeRateLimited        = "rate_limited"
+	ErrCodeContextTooLong     = "context_too_long"
+	ErrCodeAuthFailed         = "upstream_auth_failed"
+	ErrCodeUpstreamDown       = "upstream_unavailable"
+	ErrCodeInternal           = "internal"
+)
+
+// negotiate picks the highest protocol version offered by the client that the
+// server supports. An empty offer means version 1.
+func negotiate(offered []int) (int, bool) {
+	if len(offered) == 0 {
+		return ProtocolVersion, true
+	}
+	best := 0
+	for _, v := range offered {
+		for _, s := range supportedVersions {
+			if v == s && v > best {
+				best = v
+			}
+		}
+	}
+	return best, best > 0
+}
+
+// errorFrame builds an error frame for a code and patient-facing text
+func errorFrame(code, content, clientMsgID string) models.WebSocketMessage {
+	return models.WebSocketMessage{
+		Type:        FrameError,
+		Code:        code,
+		Content:     content,
+		ClientMsgID: clientMsgID,
+	}
+}
+
+// handoffFrame tells a session's clients who is answering now
+func handoffFrame(session *models.ChatSession) models.WebSocketMessage {
+	frame := models.WebSocketMessage{
+		Type:      FrameHandoff,
+		SessionID: session.ID,
+		Mode:      session.Mode,
+	}
+	switch session.Mode {
+	case store.ModeWaiting:
+		frame.Content = "正在为您转接人工医生，请稍候"
+	case store.ModeDoctor:
+		frame.Content = "医生已接诊，接下来将由医生为您解答"
+		frame.DoctorID = session.DoctorID
+	default:
+		frame.Content = "医生已结束接诊，AI医生助手将继续为您服务"
+	}
+	return frame
+}
+
+// emergencyFrame tells a session's clients, or with staff set the doctor
+// workstations, that red-flag rules fired
+func emergencyFrame(alert *service.Alert, staff bool) models.WebSocketMessage {
+	frame := models.WebSocketMessage{
+		Type:      FrameEmergency,
+		SessionID: alert.Session.ID,
+		Specialty: alert.Session.Specialty,
+		Mode:      alert.Session.Mode,
+		RedFlags:  alert.Rules(),
+		Content:   alert.Guidance(),
+	}
+	if staff {
+		frame.Content = alert.Evidence()
+	}
+	return frame
+}
+
+// handoffErrorFrame turns a failed mode change into an error frame
+func handoffErrorFrame(err error, clientMsgID string) models.WebSocketMessage {
+	switch {
+	case errors.Is(err, service.ErrNotAssigned), errors.Is(err, service.ErrAlreadyAssigned):
+		return errorFrame(ErrCodeNotAssigned, "您未接诊该会话", clientMsgID)
+	case errors.Is(err, service.ErrSessionClosed):
+		return errorFrame(ErrCodeSessionClosed, "本次咨询已结束", clientMsgID)
+	default:
+		log.Printf("Failed to change session mode: %v", err)
+		return errorFrame(ErrCodeInternal, "转接失败，请稍后重试", clientMsgID)
+	}
+}
+
+// routeFrame suggests moving a session to a recommended specialty, named by
+// the recommendation
+func routeFrame(sessionID string, rec *routing.Recommendation) models.WebSocketMessage {
+	return models.WebSocketMessage{
+		Type:       FrameRoute,
+		SessionID:  sessionID,
+		Specialty:  rec.Specialty,
+		Confidence: rec.Confidence,
+		Content:    fmt.Sprintf("根据您的描述，建议转至%s咨询", rec.Name),
+	}
+}
+
+// transferFrame tells a session's clients that it moved to another
+// specialty, called name
+func transferFrame(session *models.ChatSession, name string) models.WebSocketMessage {
+	return models.WebSocketMessage{
+		Type:      FrameTransfer,
+		SessionID: session.ID,
+		Specialty: session.Specialty,
+		Mode:      session.Mode,
+		Content:   fmt.Sprintf("已为您转至%s，接下来将由%s医生助手为您解答", name, name),
+	}
+}
+
+// transferErrorFrame turns a failed transfer into an error frame
+func transferErrorFrame(err error, clientMsgID string) models.WebSocketMessage {
+	switch {
+	case errors.Is(err, service.ErrUnknownSpecialty):
+		return errorFrame(ErrCodeUnknownSpecialty, "不支持该科室", clientMsgID)
+	case errors.Is(err, service.ErrSpecialtyDisabled):
+		return errorFrame(ErrCodeUnknownSpecialty, "该科室暂停服务", clientMsgID)
+	case errors.Is(err, service.ErrSessionClosed):
+		return errorFrame(ErrCodeSessionClosed, "本次咨询已结束", clientMsgID)
+	default:
+		log.Printf("Failed to transfer session: %v", err)
+		return errorFrame(ErrCodeInternal, "转科失败，请稍后重试", clientMsgID)
+	}
+}
+
+// modelErrorFrame turns a model error into an error frame suitable for patients
+func modelErrorFrame(err error, clientMsgID string) models.WebSocketMessage {
+	switch {
+	case errors.Is(err, llm.ErrRateLimited):
+		return errorFrame(ErrCodeRateLimited, "当前咨询人数较多，请稍等片刻后再发送", clientMsgID)
+	case errors.Is(err, llm.ErrContextTooLong):
+		return errorFrame(ErrCodeContextTooLong, "本次咨询对话过长，请结束后重新发起咨询", clientMsgID)
+	case errors.Is(err, llm.ErrAuthFailed):
+		return errorFrame(ErrCodeAuthFailed, "医生服务暂时不可用，我们正在处理，请稍后再试", clientMsgID)
+	case errors.Is(err, llm.ErrUpstreamDown), errors.Is(err, context.DeadlineExceeded):
+		return errorFrame(ErrCodeUpstreamDown, "医生服务响应超时，请稍后重新发送您的问题", clientMsgID)
+	default:
+		return errorFrame(ErrCodeInternal, "处理消息时出错，请稍后重试", clientMsgID)
+	}
+}
+
+// messageFrame carries a stored message, with its creation time and, for AI
+// replies, the metadata of the model call
+func messageFrame(msg *models.Message, clientMsgID string) models.WebSocketMessage {
+	return models.WebSocketMessage{
+		Type:        FrameMessage,
+		ID:          msg.ID,
+		ClientMsgID: clientMsgID,
+		Seq:         msg.Seq,
+		Content:     msg.Content,
+		UserID:      msg.UserID,
+		SessionID:   msg.SessionID,
+		Truncated:   msg.Truncated,
+		Role:        msg.Role,
+		CreatedAt:   &msg.CreatedAt,
+		Meta:        msg.Meta,
+	}
+}
+
+// StampFrame sets the protocol version and, if missing, a server frame ID
+func StampFrame(msg models.WebSocketMessage) models.WebSocketMessage {
+	msg.Version = ProtocolVersion
+	if msg.ID == "" {
+		msg.ID = uuid.New().String()
+	}
+	return msg
+}
+
+// encodeFrame stamps a frame and marshals it
+func encodeFrame(msg models.WebSocketMessage) ([]byte, error) {
+	return json.Marshal(StampFrame(msg))
+}
+
+// sendToSession sends a frame to all clients in a session
+func (h *Hub) sendToSession(sessionID string, msg models.WebSocketMessage) {
+	msgBytes, err := encodeFrame(msg)
+	if err != nil {
+		log.Printf("Failed to marshal message: %v", err)
+		return
+	}
+	h.broadcastToSession(sessionID, msgBytes)
+}
+
+// queueFrame writes a frame straight to the send channel of a client that is
+// not registered yet. It returns false if the client went away.
+func (c *Client) queueFrame(msg models.WebSocketMessage) bool {
+	msgBytes, err := encodeFrame(msg)
+	if err != nil {
+		log.Printf("Failed to marshal message: %v", err)
+		return true
+	}
+	select {
+	case c.send <- msgBytes:
+		return true
+	case <-c.closed:
+		return false
+	}
+}
+
+// sendFrame sends a frame to this client only
+func (c *Client) sendFrame(msg models.WebSocketMessage) {
+	msgBytes, err := encodeFrame(msg)
+	if err != nil {
+		log.Printf("Failed to marshal message: %v", err)
+		return
+	}
+
+	c.hub.mu.RLock()
+	defer c.hub.mu.RUnlock()
+
+	// The send channel is closed once the client is unregistered
+	if !c.hub.clients[c] {
+		return
+	}
+	select {
+	case c.send <- msgBytes:
+	default:
+		log.Printf("Warning: Could not send message to client %s in session %s", c.ID, c.SessionID)
+	}
+}
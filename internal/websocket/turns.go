@@ -0,0 +1,292 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"medseek/internal/models"
+	"medseek/internal/redflag"
+	"medseek/internal/service"
+	"medseek/internal/store"
+)
+
+// turn is a patient message waiting for a model reply, or a doctor message
+// waiting to be stored and relayed
+type turn struct {
+	sessionID string
+	userID    string
+	role      string // user or doctor
+	msg       models.WebSocketMessage
+}
+
+// sessionTurns serializes the turns of one session: at most one model call
+// runs at a time and later messages wait in pending
+type sessionTurns struct {
+	pending []turn
+	cancel  context.CancelFunc // cancels the model call in progress
+}
+
+// enqueueTurn queues a patient message for its session and starts a worker if
+// none is running. It returns the number of turns ahead of this one, or false
+// if the session's queue is full.
+func (h *Hub) enqueueTurn(t turn) (int, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	st, running := h.turns[t.sessionID]
+	if !running {
+		st = &sessionTurns{}
+		h.turns[t.sessionID] = st
+		st.pending = append(st.pending, t)
+		go h.processTurns(t.sessionID)
+		return 0, true
+	}
+
+	if len(st.pending) >= h.cfg.MaxQueuedTurns {
+		return 0, false
+	}
+	st.pending = append(st.pending, t)
+	// The turn in progress is ahead of everything pending
+	return len(st.pending), true
+}
+
+// processTurns runs the queued turns of a session one at a time until the
+// queue is empty
+func (h *Hub) processTurns(sessionID string) {
+	for {
+		h.mu.Lock()
+		st := h.turns[sessionID]
+		if st == nil || len(st.pending) == 0 {
+			delete(h.turns, sessionID)
+			h.mu.Unlock()
+			return
+		}
+		next := st.pending[0]
+		st.pending = st.pending[1:]
+		ctx, cancel := context.WithCancel(context.Background())
+		st.cancel = cancel
+		h.mu.Unlock()
+
+		h.runTurn(ctx, next)
+
+		h.mu.Lock()
+		cancel()
+		st.cancel = nil
+		h.mu.Unlock()
+	}
+}
+
+// cancelTurn cancels the in-flight model call of a session, if any. Queued
+// turns still run afterwards.
+func (h *Hub) cancelTurn(sessionID string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	st, ok := h.turns[sessionID]
+	if !ok || st.cancel == nil {
+		return false
+	}
+	st.cancel()
+	return true
+}
+
+// DropTurns cancels the in-flight call of a session and discards its queued
+// turns, as when the session is closed. It returns false if nothing was
+// queued or running.
+func (h *Hub) DropTurns(sessionID string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.dropTurnsLocked(sessionID)
+}
+
+// dropTurnsLocked cancels the in-flight call of a session and discards its
+// queued turns. The caller must hold h.mu.
+func (h *Hub) dropTurnsLocked(sessionID string) bool {
+	st, ok := h.turns[sessionID]
+	if !ok {
+		return false
+	}
+	if len(st.pending) > 0 {
+		log.Printf("Discarding %d queued messages in session %s", len(st.pending), sessionID)
+		st.pending = nil
+	}
+	if st.cancel != nil {
+		st.cancel()
+	}
+	return true
+}
+
+// runTurn stores the patient's message, streams the model's reply to the
+// session and stores the reply with the metadata of the model call. If ctx is
+// cancelled, or the call fails part way, the partial reply is stored and
+// flagged as truncated. Doctor messages, and patient messages while
+// a human doctor has the session, are only stored and relayed. Patient
+// messages are screened for red flags before the model is asked.
+func (h *Hub) runTurn(ctx context.Context, t turn) {
+	// The session may have been closed while the message waited in the queue
+	if session, err := h.chatSvc.GetSession(t.sessionID); err == nil && session.Status != store.StatusActive {
+		h.sendToSession(t.sessionID, errorFrame(ErrCodeSessionClosed, "本次咨询已结束", t.msg.ClientMsgID))
+		return
+	}
+
+	// Add user message to service
+	userMsg, err := h.chatSvc.AddMessage(t.sessionID, t.userID, t.role, t.msg.Content)
+	if err != nil {
+		log.Printf("Failed to store user message: %v", err)
+		h.sendToSession(t.sessionID, errorFrame(ErrCodeStorageFailed, "消息保存失败，请稍后重试", t.msg.ClientMsgID))
+		return
+	}
+
+	// Send the stored user message to clients in this session only
+	h.sendToSession(t.sessionID, messageFrame(userMsg, t.msg.ClientMsgID))
+	if t.role == "doctor" {
+		return
+	}
+
+	// Emergency guidance does not depend on the model's answer, or on whether
+	// the model answers at all
+	if alert := h.screenMessage(userMsg); alert != nil {
+		if reason := alert.HandoffReason(); reason != "" {
+			// The patient still gets this reply; a doctor is asked for after it
+			defer h.redFlagHandoff(t.sessionID, reason)
+		}
+	}
+	h.chatSvc.ScheduleTriage(t.sessionID, h.NotifyQueue)
+	go h.suggestTransfer(userMsg)
+
+	// AI replies are paused while waiting for or talking to a human doctor
+	session, err := h.chatSvc.GetSession(t.sessionID)
+	if err != nil {
+		log.Printf("Failed to load session %s: %v", t.sessionID, err)
+		h.sendToSession(t.sessionID, errorFrame(ErrCodeInternal, "处理消息时出错，请稍后重试", t.msg.ClientMsgID))
+		return
+	}
+	if session.Mode != store.ModeAI {
+		return
+	}
+
+	h.sendToSession(t.sessionID, models.WebSocketMessage{
+		Type:        FrameTyping,
+		ClientMsgID: t.msg.ClientMsgID,
+		UserID:      "assistant",
+	})
+
+	// Stream the response to this session as it is generated
+	response, meta, err := h.chatSvc.ProcessMessageStream(ctx, userMsg, func(delta string) error {
+		h.sendToSession(t.sessionID, models.WebSocketMessage{
+			Type:        FrameDelta,
+			ClientMsgID: t.msg.ClientMsgID,
+			Content:     delta,
+			UserID:      "assistant",
+		})
+		return nil
+	})
+
+	truncated := false
+	var errFrame *models.WebSocketMessage
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			log.Printf("Generation stopped in session %s after %d bytes", t.sessionID, len(response))
+		} else {
+			log.Printf("Failed to process message: %v", err)
+			frame := modelErrorFrame(err, t.msg.ClientMsgID)
+			if response == "" {
+				h.sendToSession(t.sessionID, frame)
+				return
+			}
+			// The part already streamed is kept, marked with the error, which
+			// follows the done frame
+			meta.Error = frame.Code
+			errFrame = &frame
+		}
+		truncated = true
+		if response == "" {
+			h.sendToSession(t.sessionID, models.WebSocketMessage{
+				Type:        FrameDone,
+				ClientMsgID: t.msg.ClientMsgID,
+				UserID:      "assistant",
+				Truncated:   true,
+			})
+			return
+		}
+	}
+
+	// Add assistant message to service
+	replyMsg, err := h.chatSvc.AddReply(t.sessionID, response, truncated, meta)
+	done := models.WebSocketMessage{
+		Type:        FrameDone,
+		ClientMsgID: t.msg.ClientMsgID,
+		Content:     response,
+		UserID:      "assistant",
+		Truncated:   truncated,
+		Role:        "assistant",
+		Meta:        meta,
+	}
+	if err != nil {
+		log.Printf("Failed to store assistant message: %v", err)
+	} else {
+		done.ID = replyMsg.ID
+		done.Seq = replyMsg.Seq
+		done.CreatedAt = &replyMsg.CreatedAt
+	}
+
+	// Send the assembled response so clients can finalize the streamed text
+	h.sendToSession(t.sessionID, done)
+	if errFrame != nil {
+		h.sendToSession(t.sessionID, *errFrame)
+	}
+
+	// A red flag in the reply can raise the session's triage level
+	if replyMsg != nil && h.screenMessage(replyMsg) != nil {
+		h.chatSvc.ScheduleTriage(t.sessionID, h.NotifyQueue)
+	}
+}
+
+// screenMessage checks a stored message for red flags. Guidance for a
+// patient message goes to the session at once; doctor workstations that may
+// see the session hear of every alert. It returns nil if no rule fired.
+func (h *Hub) screenMessage(msg *models.Message) *service.Alert {
+	alert, err := h.chatSvc.ScreenMessage(msg)
+	if err != nil {
+		log.Printf("Failed to screen message %s: %v", msg.ID, err)
+	}
+	if alert == nil {
+		return nil
+	}
+
+	if alert.Scope == redflag.ScopePatient {
+		h.sendToSession(msg.SessionID, emergencyFrame(alert, false))
+	}
+	h.sendToQueue(alert.Session, emergencyFrame(alert, true))
+	return alert
+}
+
+// suggestTransfer offers the session a better suited specialty if the
+// patient's first message points to one. It runs beside the turn: the reply
+// comes from the current specialty until the patient accepts.
+func (h *Hub) suggestTransfer(msg *models.Message) {
+	rec, err := h.chatSvc.SuggestTransfer(context.Background(), msg)
+	if err != nil {
+		log.Printf("Failed to route session %s: %v", msg.SessionID, err)
+		return
+	}
+	if rec != nil {
+		h.sendToSession(msg.SessionID, routeFrame(msg.SessionID, rec))
+	}
+}
+
+// redFlagHandoff asks for a human doctor because of a red-flag rule. It runs
+// at the end of the turn, so unlike AnnounceHandoff there is no reply to stop.
+func (h *Hub) redFlagHandoff(sessionID, reason string) {
+	session, changed, err := h.chatSvc.RequestHandoff(sessionID, reason)
+	if err != nil {
+		log.Printf("Failed to request a doctor for session %s: %v", sessionID, err)
+		return
+	}
+	if changed {
+		h.sendToSession(session.ID, handoffFrame(session))
+		h.NotifyQueue(session)
+	}
+}
@@ -0,0 +1,161 @@
+package websocket
+
+import (
+	"strings"
+	"testing"
+
+	"medseek/internal/models"
+)
+
+func TestTurnsRunInOrder(t *testing.T) {
+	provider := newGatedProvider("答一", "答二", "答三")
+	h, st := newTestHub(t, provider, 3)
+	session, client := newTestSession(t, h)
+
+	for i, content := range []string{"问一", "问二", "问三"} {
+		ack := h.Submit(session.UserID, "user", session.ID, message(content, content))
+		if ack.Type != FrameAck || ack.QueuePosition != i {
+			t.Fatalf("ack of %s = %+v, want position %d", content, ack, i)
+		}
+		if i == 0 {
+			provider.waitStarted(t)
+		}
+	}
+	provider.open()
+
+	for _, want := range []string{"答一", "答二", "答三"} {
+		if done := nextFrame(t, client, FrameDone); done.Content != want || done.Truncated {
+			t.Errorf("done = %+v, want %s", done, want)
+		}
+	}
+	waitIdle(t, h, session.ID)
+
+	msgs, _ := st.ListMessages(session.ID)
+	var got []string
+	for _, msg := range msgs {
+		got = append(got, msg.Content)
+	}
+	if want := "问一 答一 问二 答二 问三 答三"; strings.Join(got, " ") != want {
+		t.Errorf("stored = %q, want %q", got, want)
+	}
+}
+
+func TestTurnQueueFull(t *testing.T) {
+	provider := newGatedProvider("答")
+	h, _ := newTestHub(t, provider, 1)
+	session, _ := newTestSession(t, h)
+
+	h.Submit(session.UserID, "user", session.ID, message("问一", "1"))
+	provider.waitStarted(t)
+	if ack := h.Submit(session.UserID, "user", session.ID, message("问二", "2")); ack.Type != FrameAck {
+		t.Errorf("second message = %+v, want an ack", ack)
+	}
+	if reply := h.Submit(session.UserID, "user", session.ID, message("问三", "3")); reply.Type != FrameError || reply.Code != ErrCodeQueueFull {
+		t.Errorf("third message = %+v, want %s", reply, ErrCodeQueueFull)
+	}
+	provider.open()
+	waitIdle(t, h, session.ID)
+}
+
+func TestStopCancelsOnlyTheCurrentTurn(t *testing.T) {
+	provider := newGatedProvider("答二")
+	h, st := newTestHub(t, provider, 3)
+	session, client := newTestSession(t, h)
+
+	h.Submit(session.UserID, "user", session.ID, message("问一", "1"))
+	provider.waitStarted(t)
+	h.Submit(session.UserID, "user", session.ID, message("问二", "2"))
+	h.Submit(session.UserID, "user", session.ID, models.WebSocketMessage{Type: FrameStop})
+
+	// The stopped turn ends with an empty truncated reply, which is not stored
+	if done := nextFrame(t, client, FrameDone); done.ClientMsgID != "1" || !done.Truncated || done.Content != "" {
+		t.Errorf("stopped done = %+v", done)
+	}
+
+	// The queued turn still runs
+	provider.waitStarted(t)
+	provider.open()
+	if done := nextFrame(t, client, FrameDone); done.ClientMsgID != "2" || done.Content != "答二" {
+		t.Errorf("next done = %+v", done)
+	}
+	waitIdle(t, h, session.ID)
+
+	msgs, _ := st.ListMessages(session.ID)
+	if len(msgs) != 3 || msgs[0].Content != "问一" || msgs[1].Content != "问二" || msgs[2].Content != "答二" {
+		t.Errorf("stored %d messages: %+v", len(msgs), msgs)
+	}
+}
+
+func TestLastClientLeavingDropsTurns(t *testing.T) {
+	provider := newGatedProvider("答")
+	h, st := newTestHub(t, provider, 3)
+	session, client := newTestSession(t, h)
+
+	h.Submit(session.UserID, "user", session.ID, message("问一", "1"))
+	provider.waitStarted(t)
+	h.Submit(session.UserID, "user", session.ID, message("问二", "2"))
+	h.Submit(session.UserID, "user", session.ID, message("问三", "3"))
+
+	h.unregister <- client
+	waitIdle(t, h, session.ID)
+
+	// The call in flight was cancelled before the model answered and the
+	// queued messages were discarded unstored
+	if n := len(provider.Requests()); n != 0 {
+		t.Errorf("model answered %d calls", n)
+	}
+	msgs, _ := st.ListMessages(session.ID)
+	if len(msgs) != 1 || msgs[0].Content != "问一" {
+		t.Errorf("stored = %+v, want only the first message", msgs)
+	}
+	if len(provider.started) != 0 {
+		t.Error("a dropped turn reached the model")
+	}
+}
+
+func TestOtherClientsKeepTheTurnRunning(t *testing.T) {
+	provider := newGatedProvider("答")
+	h, _ := newTestHub(t, provider, 3)
+	session, first := newTestSession(t, h)
+	second := subscribe(t, h, session.UserID, session.ID)
+
+	h.Submit(session.UserID, "user", session.ID, message("问", "1"))
+	provider.waitStarted(t)
+
+	h.unregister <- first
+	provider.open()
+	if done := nextFrame(t, second, FrameDone); done.Content != "答" || done.Truncated {
+		t.Errorf("done = %+v", done)
+	}
+}
+
+func TestDropTurnsOnClose(t *testing.T) {
+	provider := newGatedProvider("答")
+	h, st := newTestHub(t, provider, 3)
+	session, client := newTestSession(t, h)
+
+	h.Submit(session.UserID, "user", session.ID, message("问一", "1"))
+	provider.waitStarted(t)
+	h.Submit(session.UserID, "user", session.ID, message("问二", "2"))
+
+	if err := h.chatSvc.CloseSession(session.ID); err != nil {
+		t.Fatal(err)
+	}
+	if !h.DropTurns(session.ID) {
+		t.Fatal("DropTurns found no turns")
+	}
+	if done := nextFrame(t, client, FrameDone); done.ClientMsgID != "1" || !done.Truncated {
+		t.Errorf("done = %+v, want the stopped reply", done)
+	}
+	waitIdle(t, h, session.ID)
+
+	if n := len(provider.Requests()); n != 0 {
+		t.Errorf("model answered %d calls", n)
+	}
+	if msgs, _ := st.ListMessages(session.ID); len(msgs) != 1 {
+		t.Errorf("stored %d messages, want only the first", len(msgs))
+	}
+	if h.DropTurns(session.ID) {
+		t.Error("DropTurns on an idle session reported turns")
+	}
+}